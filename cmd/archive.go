@@ -74,6 +74,9 @@ An archive is a fully self-contained test run, and can be executed identically e
 			return err
 		}
 		opts := cliOpts.Apply(fileConf.Options).Apply(r.GetOptions()).Apply(envConf.Options).Apply(cliOpts)
+		if opts, err = loadOptionFiles(fs, opts, pwd); err != nil {
+			return err
+		}
 		r.SetOptions(opts)
 
 		// Archive.
@@ -82,6 +82,9 @@ This will execute the test on the Load Impact cloud service. Use "k6 login cloud
 			return err
 		}
 		conf := cliConf.Apply(fileConf).Apply(Config{Options: r.GetOptions()}).Apply(envConf).Apply(cliConf)
+		if conf.Options, err = loadOptionFiles(fs, conf.Options, pwd); err != nil {
+			return err
+		}
 		r.SetOptions(conf.Options)
 
 		// Cloud config
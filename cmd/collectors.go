@@ -29,6 +29,7 @@ import (
 	"github.com/loadimpact/k6/stats/cloud"
 	"github.com/loadimpact/k6/stats/influxdb"
 	jsonc "github.com/loadimpact/k6/stats/json"
+	"github.com/loadimpact/k6/stats/multi"
 	"github.com/pkg/errors"
 	"github.com/spf13/afero"
 )
@@ -64,13 +65,26 @@ func newCollector(t, arg string, src *lib.SourceData, conf Config) (lib.Collecto
 
 	switch t {
 	case collectorJSON:
-		return jsonc.New(afero.NewOsFs(), arg)
+		collector, err := jsonc.New(afero.NewOsFs(), arg)
+		if err != nil {
+			return nil, err
+		}
+		collector.TypedValues = conf.TypedMetricValues.Bool
+		return collector, nil
 	case collectorInfluxDB:
 		config := conf.Collectors.InfluxDB
 		if err := loadConfig(&config); err != nil {
 			return nil, err
 		}
-		return influxdb.New(config)
+		if config.Precision == "" && conf.Options.TimestampPrecision.Valid {
+			config.Precision = conf.Options.TimestampPrecision.String
+		}
+		collector, err := influxdb.New(config)
+		if err != nil {
+			return nil, err
+		}
+		collector.TypedValues = conf.TypedMetricValues.Bool
+		return collector, nil
 	case collectorCloud:
 		config := conf.Collectors.Cloud
 		if err := loadConfig(&config); err != nil {
@@ -81,3 +95,36 @@ func newCollector(t, arg string, src *lib.SourceData, conf Config) (lib.Collecto
 		return nil, errors.Errorf("unknown output type: %s", t)
 	}
 }
+
+// Returns every output a run should send samples to: the one given to --out, if any, followed by
+// those in the outputs option.
+func configuredOutputs(conf Config) []lib.OutputConfig {
+	var outputs []lib.OutputConfig
+	if conf.Out.Valid {
+		t, arg := parseCollector(conf.Out.String)
+		outputs = append(outputs, lib.OutputConfig{Type: t, Arg: arg})
+	}
+	return append(outputs, conf.Outputs...)
+}
+
+// Creates a collector for the given outputs; if there's more than one, samples are fanned out to
+// all of them. Returns nil if there are none.
+func newCollectors(outputs []lib.OutputConfig, src *lib.SourceData, conf Config) (lib.Collector, error) {
+	collectors := make([]lib.Collector, 0, len(outputs))
+	for _, output := range outputs {
+		collector, err := newCollector(output.Type, output.Arg, src, conf)
+		if err != nil {
+			return nil, err
+		}
+		collectors = append(collectors, collector)
+	}
+
+	switch len(collectors) {
+	case 0:
+		return nil, nil
+	case 1:
+		return collectors[0], nil
+	default:
+		return multi.New(collectors...), nil
+	}
+}
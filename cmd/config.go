@@ -21,14 +21,18 @@
 package cmd
 
 import (
+	"bytes"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/kelseyhightower/envconfig"
 	"github.com/loadimpact/k6/lib"
 	"github.com/loadimpact/k6/stats/cloud"
 	"github.com/loadimpact/k6/stats/influxdb"
+	"github.com/pkg/errors"
 	"github.com/shibukawa/configdir"
 	"github.com/spf13/afero"
 	"github.com/spf13/pflag"
@@ -47,6 +51,7 @@ func configFlagSet() *pflag.FlagSet {
 	flags.BoolP("linger", "l", false, "keep the API server alive past test end")
 	flags.Bool("no-usage-report", false, "don't send anonymous stats to the developers")
 	flags.Bool("no-thresholds", false, "don't run thresholds")
+	flags.String("dump-options", "", "write the consolidated options to `file` at run start, \"-\" for stdout")
 	flags.StringVarP(&configFile, "config", "c", configFile, "specify config file to read")
 	return flags
 }
@@ -57,7 +62,7 @@ type Config struct {
 	Out           null.String `json:"out" envconfig:"out"`
 	Linger        null.Bool   `json:"linger" envconfig:"linger"`
 	NoUsageReport null.Bool   `json:"noUsageReport" envconfig:"no_usage_report"`
-	NoThresholds  null.Bool   `json:"noThresholds" envconfig:"no_thresholds"`
+	DumpOptions   null.String `json:"dumpOptions" envconfig:"dump_options"`
 
 	Collectors struct {
 		InfluxDB influxdb.Config `json:"influxdb"`
@@ -76,8 +81,8 @@ func (c Config) Apply(cfg Config) Config {
 	if cfg.NoUsageReport.Valid {
 		c.NoUsageReport = cfg.NoUsageReport
 	}
-	if cfg.NoThresholds.Valid {
-		c.NoThresholds = cfg.NoThresholds
+	if cfg.DumpOptions.Valid {
+		c.DumpOptions = cfg.DumpOptions
 	}
 	c.Collectors.InfluxDB = c.Collectors.InfluxDB.Apply(cfg.Collectors.InfluxDB)
 	c.Collectors.Cloud = c.Collectors.Cloud.Apply(cfg.Collectors.Cloud)
@@ -90,12 +95,13 @@ func getConfig(flags *pflag.FlagSet) (Config, error) {
 	if err != nil {
 		return Config{}, err
 	}
+	opts.NoThresholds = getNullBool(flags, "no-thresholds")
 	return Config{
 		Options:       opts,
 		Out:           getNullString(flags, "out"),
 		Linger:        getNullBool(flags, "linger"),
 		NoUsageReport: getNullBool(flags, "no-usage-report"),
-		NoThresholds:  getNullBool(flags, "no-thresholds"),
+		DumpOptions:   getNullString(flags, "dump-options"),
 	}, nil
 }
 
@@ -107,7 +113,10 @@ func readDiskConfig(fs afero.Fs) (Config, *configdir.Config, error) {
 			return Config{}, nil, err
 		}
 		var conf Config
-		err = json.Unmarshal(data, &conf)
+		if err := json.Unmarshal(data, &conf); err != nil {
+			return conf, nil, err
+		}
+		conf.Options, err = loadOptionFiles(fs, conf.Options, filepath.Dir(configFile))
 		return conf, nil, err
 	}
 
@@ -120,10 +129,175 @@ func readDiskConfig(fs afero.Fs) (Config, *configdir.Config, error) {
 		return Config{}, cdir, err
 	}
 	var conf Config
-	err = json.Unmarshal(data, &conf)
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return conf, cdir, err
+	}
+	conf.Options, err = loadOptionFiles(fs, conf.Options, cdir.Path)
 	return conf, cdir, err
 }
 
+// Writes the options as JSON to the file at path, or to stdout if path is "-"; this is the same
+// format config files and archives use, so the result can be fed back into another run. Inline
+// TLS certificates and keys are redacted, and have to be put back (or passed as files) for that.
+func dumpOptions(fs afero.Fs, stdout io.Writer, path string, opts lib.Options) error {
+	// Don't escape HTML, or the placeholder for redacted secrets comes out as "\u003credacted\u003e".
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(opts.Redacted()); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	if path == "-" {
+		_, err := stdout.Write(data)
+		return err
+	}
+	return afero.WriteFile(fs, path, data, 0644)
+}
+
+// Reads any files referenced by the options (StagesFile, BodyDataFile, TagDataFile, ReplayFile)
+// into them.
+// Relative paths are resolved against dir.
+func loadOptionFiles(fs afero.Fs, opts lib.Options, dir string) (lib.Options, error) {
+	opts, err := loadStagesFile(fs, opts, dir)
+	if err != nil {
+		return opts, err
+	}
+	if opts, err = loadBodyDataFile(fs, opts, dir); err != nil {
+		return opts, err
+	}
+	if opts, err = loadTagDataFile(fs, opts, dir); err != nil {
+		return opts, err
+	}
+	if opts, err = resolveBodyFile(fs, opts, dir); err != nil {
+		return opts, err
+	}
+	return loadReplayFile(fs, opts, dir)
+}
+
+// Reads stages from the options' StagesFile, if set. Relative paths are resolved against dir.
+func loadStagesFile(fs afero.Fs, opts lib.Options, dir string) (lib.Options, error) {
+	if !opts.StagesFile.Valid {
+		return opts, nil
+	}
+
+	filename := opts.StagesFile.String
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(dir, filename)
+	}
+	f, err := fs.Open(filename)
+	if err != nil {
+		return opts, err
+	}
+	defer func() { _ = f.Close() }()
+
+	stages, err := lib.ReadStagesCSV(f)
+	if err != nil {
+		return opts, errors.Wrapf(err, "stages file %s", filename)
+	}
+	opts.Stages = stages
+	opts.StagesFile = null.String{}
+	return opts, nil
+}
+
+// Reads body data from the options' BodyDataFile, if set. Relative paths are resolved against dir.
+func loadBodyDataFile(fs afero.Fs, opts lib.Options, dir string) (lib.Options, error) {
+	if !opts.BodyDataFile.Valid {
+		return opts, nil
+	}
+
+	filename := opts.BodyDataFile.String
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(dir, filename)
+	}
+	f, err := fs.Open(filename)
+	if err != nil {
+		return opts, err
+	}
+	defer func() { _ = f.Close() }()
+
+	data, err := lib.ReadBodyData(f)
+	if err != nil {
+		return opts, errors.Wrapf(err, "body data file %s", filename)
+	}
+	opts.BodyData = data
+	opts.BodyDataFile = null.String{}
+	return opts, nil
+}
+
+// Resolves the options' BodyFile against dir, if it's relative, and checks that it exists. It's
+// kept as a path, since it's streamed from disk by every request that uses it.
+func resolveBodyFile(fs afero.Fs, opts lib.Options, dir string) (lib.Options, error) {
+	if !opts.BodyFile.Valid {
+		return opts, nil
+	}
+
+	filename := opts.BodyFile.String
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(dir, filename)
+	}
+	info, err := fs.Stat(filename)
+	if err != nil {
+		return opts, err
+	}
+	if info.IsDir() {
+		return opts, errors.Errorf("body file %s is a directory", filename)
+	}
+	opts.BodyFile = null.StringFrom(filename)
+	return opts, nil
+}
+
+// Reads tag data from the options' TagDataFile, if set. Relative paths are resolved against dir.
+func loadTagDataFile(fs afero.Fs, opts lib.Options, dir string) (lib.Options, error) {
+	if !opts.TagDataFile.Valid {
+		return opts, nil
+	}
+
+	filename := opts.TagDataFile.String
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(dir, filename)
+	}
+	f, err := fs.Open(filename)
+	if err != nil {
+		return opts, err
+	}
+	defer func() { _ = f.Close() }()
+
+	rows, err := lib.ReadTagDataCSV(f)
+	if err != nil {
+		return opts, errors.Wrapf(err, "tag data file %s", filename)
+	}
+	opts.TagData = rows
+	opts.TagDataFile = null.String{}
+	return opts, nil
+}
+
+// Reads a replay schedule from the options' ReplayFile, if set. Relative paths are resolved against dir.
+func loadReplayFile(fs afero.Fs, opts lib.Options, dir string) (lib.Options, error) {
+	if !opts.ReplayFile.Valid {
+		return opts, nil
+	}
+
+	filename := opts.ReplayFile.String
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(dir, filename)
+	}
+	f, err := fs.Open(filename)
+	if err != nil {
+		return opts, err
+	}
+	defer func() { _ = f.Close() }()
+
+	reqs, err := lib.ReadReplaySchedule(f)
+	if err != nil {
+		return opts, errors.Wrapf(err, "replay file %s", filename)
+	}
+	opts.Replay = reqs
+	opts.ReplayFile = null.String{}
+	return opts, nil
+}
+
 // Writes configuration back to disk.
 func writeDiskConfig(fs afero.Fs, cdir *configdir.Config, conf Config) error {
 	data, err := json.MarshalIndent(conf, "", "  ")
@@ -39,6 +39,7 @@ func optionFlagSet() *pflag.FlagSet {
 	flags.Int64P("iterations", "i", 0, "script iteration limit")
 	flags.StringSliceP("stage", "s", nil, "add a `stage`, as `[duration]:[target]`")
 	flags.BoolP("paused", "p", false, "start the test in a paused state")
+	flags.Bool("dry-run", false, "validate the script and options, then exit without running it")
 	flags.Int64("max-redirects", 10, "follow at most n redirects")
 	flags.Int64("batch", 10, "max parallel batch reqs")
 	flags.Int64("batch-per-host", 0, "max parallel batch reqs per host")
@@ -61,6 +62,7 @@ func getOptions(flags *pflag.FlagSet) (lib.Options, error) {
 		Duration:              getNullDuration(flags, "duration"),
 		Iterations:            getNullInt64(flags, "iterations"),
 		Paused:                getNullBool(flags, "paused"),
+		DryRun:                getNullBool(flags, "dry-run"),
 		MaxRedirects:          getNullInt64(flags, "max-redirects"),
 		Batch:                 getNullInt64(flags, "batch"),
 		RPS:                   getNullInt64(flags, "rps"),
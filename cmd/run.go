@@ -43,6 +43,7 @@ import (
 	"github.com/loadimpact/k6/js"
 	"github.com/loadimpact/k6/lib"
 	"github.com/loadimpact/k6/loader"
+	"github.com/loadimpact/k6/stats"
 	"github.com/loadimpact/k6/ui"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -86,7 +87,10 @@ a commandline interface for interacting with it.`,
   k6 run -o influxdb=http://1.2.3.4:8086/k6`[1:],
 	Args: exactArgsWithMsg(1, "arg should either be \"-\", if reading script from stdin, or a path to a script file"),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		_, _ = BannerColor.Fprint(stdout, Banner+"\n\n")
+		// Hold on to the banner and init progress until the options are known: with the ndjson
+		// stdoutFormat they go to stderr instead, leaving stdout to the metrics.
+		var early bytes.Buffer
+		_, _ = BannerColor.Fprint(&early, Banner+"\n\n")
 
 		initBar := ui.ProgressBar{
 			Width: 60,
@@ -94,7 +98,7 @@ a commandline interface for interacting with it.`,
 		}
 
 		// Create the Runner.
-		fmt.Fprintf(stdout, "%s runner\r", initBar.String())
+		fmt.Fprintf(&early, "%s runner\r", initBar.String())
 		pwd, err := os.Getwd()
 		if err != nil {
 			return err
@@ -113,7 +117,7 @@ a commandline interface for interacting with it.`,
 		// Assemble options; start with the CLI-provided options to get shadowed (non-Valid)
 		// defaults in there, override with Runner-provided ones, then merge the CLI opts in
 		// on top to give them priority.
-		fmt.Fprintf(stdout, "%s options\r", initBar.String())
+		fmt.Fprintf(&early, "%s options\r", initBar.String())
 		cliConf, err := getConfig(cmd.Flags())
 		if err != nil {
 			return err
@@ -127,6 +131,17 @@ a commandline interface for interacting with it.`,
 			return err
 		}
 		conf := cliConf.Apply(fileConf).Apply(Config{Options: r.GetOptions()}).Apply(envConf).Apply(cliConf)
+		if conf.Options, err = loadOptionFiles(fs, conf.Options, pwd); err != nil {
+			return err
+		}
+
+		if err := lib.ValidateStdoutFormat(conf.StdoutFormat.String); err != nil {
+			return err
+		}
+		if conf.StdoutFormat.String == lib.StdoutFormatNDJSON {
+			stdout, stdoutTTY = stderr, stderrTTY
+		}
+		_, _ = early.WriteTo(stdout)
 
 		// If -m/--max isn't specified, figure out the max that should be needed.
 		if !conf.VUsMax.Valid {
@@ -137,8 +152,33 @@ a commandline interface for interacting with it.`,
 				}
 			}
 		}
+		// Pin the number of CPUs we can use, if requested.
+		if conf.MaxCPUs.Int64 < 0 {
+			return errors.New("maxCPUs can't be negative")
+		} else if conf.MaxCPUs.Int64 > 0 {
+			runtime.GOMAXPROCS(int(conf.MaxCPUs.Int64))
+		}
+		// Make sure we're not going to run out of file descriptors halfway through the test.
+		if !conf.CheckFDLimit.Valid || conf.CheckFDLimit.Bool {
+			if err := lib.CheckFDLimit(conf.Options); err != nil {
+				if conf.CheckFDLimit.Bool {
+					return err
+				}
+				log.WithError(err).Warn("Open file limit may be insufficient")
+			}
+		}
+		// A "vu" tag makes every VU a separate time series, which adds up quickly.
+		if conf.HasSystemTag(lib.SystemTagVU) && conf.VUsMax.Int64 > 100 {
+			log.WithField("vus_max", conf.VUsMax.Int64).Warn(
+				"The vu system tag is enabled; expect a very large number of distinct time series")
+		}
+		// A replay is over once every recorded request has been sent, one per iteration.
+		if len(conf.Replay) > 0 && !conf.Iterations.Valid {
+			conf.Iterations = null.IntFrom(int64(len(conf.Replay)))
+		}
 		// If -d/--duration, -i/--iterations and -s/--stage are all unset, run to one iteration.
-		if !conf.Duration.Valid && !conf.Iterations.Valid && conf.Stages == nil {
+		// A per-VU iteration cap is enough to end the test on its own, though.
+		if !conf.Duration.Valid && !conf.Iterations.Valid && conf.Stages == nil && !conf.MaxIterationsPerVU.Valid {
 			conf.Iterations = null.IntFrom(1)
 		}
 		// If duration is explicitly set to 0, it means run forever.
@@ -150,49 +190,94 @@ a commandline interface for interacting with it.`,
 			ui.UpdateTrendColumns(conf.SummaryTrendStats)
 		}
 
+		// Shuffle the stages up front, so the summary lists them in the order they're run in.
+		if conf.StageShuffle != nil && len(conf.Stages) > 1 {
+			shuffle := *conf.StageShuffle
+			if shuffle.Seed == 0 {
+				shuffle.Seed = time.Now().UnixNano()
+			}
+			conf.Stages = shuffle.Shuffle(conf.Stages)
+			conf.StageShuffle = &shuffle
+			log.WithField("seed", shuffle.Seed).Info("Shuffled the stages")
+		}
+
+		// Dump the final options, if asked to, so it's clear which layer won for each one.
+		if conf.DumpOptions.Valid && conf.DumpOptions.String != "" {
+			if err := dumpOptions(fs, stdout, conf.DumpOptions.String, conf.Options); err != nil {
+				return err
+			}
+		}
+
+		// Check the options before any VUs are made for them.
+		if err := conf.Options.Validate(); err != nil {
+			return err
+		}
+
 		// Write options back to the runner too.
 		r.SetOptions(conf.Options)
 
 		// Create an engine with a local executor, wrapping the Runner.
 		fmt.Fprintf(stdout, "%s   engine\r", initBar.String())
+		if conf.DryRun.Bool {
+			return dryRun(stdout, r, conf, filename)
+		}
 		engine, err := core.NewEngine(local.New(r), conf.Options)
 		if err != nil {
 			return err
 		}
 
-		// Configure the engine.
-		if conf.NoThresholds.Valid {
-			engine.NoThresholds = conf.NoThresholds.Bool
+		// Resolve and dial the prewarm hosts, so the first requests don't pay for it.
+		if p, ok := r.(lib.Prewarmer); ok && len(conf.Prewarm) > 0 {
+			fmt.Fprintf(stdout, "%s  prewarm\r", initBar.String())
+			if err := p.Prewarm(context.Background()); err != nil {
+				return err
+			}
 		}
 
 		// Create a collector and assign it to the engine if requested.
 		fmt.Fprintf(stdout, "%s   collector\r", initBar.String())
-		if conf.Out.Valid {
-			t, arg := parseCollector(conf.Out.String)
-			collector, err := newCollector(t, arg, src, conf)
-			if err != nil {
-				return err
-			}
+		outputs := configuredOutputs(conf)
+		if conf.StdoutFormat.String == lib.StdoutFormatNDJSON {
+			outputs = append(outputs, lib.OutputConfig{Type: collectorJSON, Arg: "-"})
+		}
+		collector, err := newCollectors(outputs, src, conf)
+		if err != nil {
+			return err
+		}
+		if collector != nil {
 			if err := collector.Init(); err != nil {
 				return err
 			}
 			engine.Collector = collector
 		}
 
-		// Create an API server.
-		fmt.Fprintf(stdout, "%s   server\r", initBar.String())
-		go func() {
-			if err := api.ListenAndServe(address, engine); err != nil {
-				log.WithError(err).Warn("Error from API server")
-			}
-		}()
+		// Create an API server, unless it's been disabled with an empty address.
+		apiAddress := address
+		if conf.APIAddress.Valid && !RootCmd.PersistentFlags().Changed("address") {
+			apiAddress = conf.APIAddress.String
+		}
+		if err := lib.ValidateAPIAddress(apiAddress); err != nil {
+			return err
+		}
+		if apiAddress != "" {
+			fmt.Fprintf(stdout, "%s   server\r", initBar.String())
+			go func() {
+				if err := api.ListenAndServe(apiAddress, engine); err != nil {
+					log.WithError(err).Warn("Error from API server")
+				}
+			}()
+		}
 
 		// Write the big banner.
 		{
 			out := "-"
 			link := ""
 			if engine.Collector != nil {
-				out = conf.Out.String
+				names := make([]string, len(outputs))
+				for i, output := range outputs {
+					names[i] = output.String()
+				}
+				out = strings.Join(names, ", ")
 				if l := engine.Collector.Link(); l != "" {
 					link = " (" + l + ")"
 				}
@@ -237,6 +322,17 @@ a commandline interface for interacting with it.`,
 		signal.Notify(sigC, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 		defer signal.Stop(sigC)
 
+		// If asked to, toggle pausing on SIGUSR1. Notify with no signals would relay all of them.
+		pauseC := make(chan os.Signal, 1)
+		if conf.PauseSignal.Bool {
+			if len(pauseSignals) > 0 {
+				signal.Notify(pauseC, pauseSignals...)
+				defer signal.Stop(pauseC)
+			} else {
+				log.Warn("pauseSignal isn't supported on this platform")
+			}
+		}
+
 		// If the user hasn't opted out: report usage.
 		if !conf.NoUsageReport.Bool {
 			go func() {
@@ -357,6 +453,10 @@ a commandline interface for interacting with it.`,
 			case sig := <-sigC:
 				log.WithField("sig", sig).Debug("Exiting in response to signal")
 				cancel()
+			case sig := <-pauseC:
+				paused := !engine.Executor.IsPaused()
+				log.WithFields(log.Fields{"sig": sig, "paused": paused}).Info("Toggling pause in response to signal")
+				engine.Executor.SetPaused(paused)
 			}
 		}
 		if quiet || !stdoutTTY {
@@ -379,18 +479,74 @@ a commandline interface for interacting with it.`,
 			log.Warn("No data generated, because no script iterations finished, consider making the test duration longer")
 		}
 
+		// Warn about thresholds that never had anything to check.
+		if !engine.NoThresholds {
+			engine.MetricsLock.RLock()
+			unknown := lib.UnknownThresholdMetrics(conf.ThresholdDefinitions(), engine.Metrics)
+			engine.MetricsLock.RUnlock()
+			for _, name := range unknown {
+				log.WithField("threshold", name).Warn("No samples were collected for a threshold's metric")
+			}
+		}
+
 		// Print the end-of-test summary.
+		summary := ui.SummaryData{
+			Opts:    conf.Options,
+			Root:    engine.Executor.GetRunner().GetDefaultGroup(),
+			Metrics: engine.Metrics,
+			Time:    engine.Executor.GetTime(),
+		}
 		if !quiet {
 			fmt.Fprintf(stdout, "\n")
-			ui.Summarize(stdout, "", ui.SummaryData{
-				Opts:    conf.Options,
-				Root:    engine.Executor.GetRunner().GetDefaultGroup(),
-				Metrics: engine.Metrics,
-				Time:    engine.Executor.GetTime(),
-			})
+			ui.Summarize(stdout, "", summary)
 			fmt.Fprintf(stdout, "\n")
 		}
 
+		// Export trend histograms, if requested.
+		if filename := conf.HistogramExport.String; filename != "" {
+			engine.MetricsLock.RLock()
+			err := writeHistograms(afero.NewOsFs(), filename, engine.Metrics)
+			engine.MetricsLock.RUnlock()
+			if err != nil {
+				log.WithError(err).Error("Couldn't export histograms")
+			}
+		}
+
+		// Export the outcome of each threshold, if requested.
+		if filename := conf.ThresholdResults.String; filename != "" && !engine.NoThresholds {
+			engine.MetricsLock.RLock()
+			results := lib.ThresholdResults(conf.ThresholdDefinitions(), engine.Metrics)
+			engine.MetricsLock.RUnlock()
+			if err := writeJSON(afero.NewOsFs(), filename, results); err != nil {
+				log.WithError(err).Error("Couldn't export threshold results")
+			}
+		}
+
+		// Export the options and results for comparing against other runs, if requested.
+		if filename := conf.RunExport.String; filename != "" {
+			engine.MetricsLock.RLock()
+			err := writeRunExport(afero.NewOsFs(), filename, conf.Options, lib.Summary{
+				Root:    summary.Root,
+				Metrics: summary.Metrics,
+				Time:    summary.Time,
+			})
+			engine.MetricsLock.RUnlock()
+			if err != nil {
+				log.WithError(err).Error("Couldn't export the run")
+			}
+		}
+
+		// Write out recorded HTTP traffic, if requested.
+		if hr, ok := r.(lib.HARRunner); ok && hr.GetHARRecorder() != nil {
+			har := hr.GetHARRecorder()
+			if err := writeHAR(afero.NewOsFs(), har.Options.Path, har); err != nil {
+				log.WithError(err).Error("Couldn't export HAR")
+			}
+			if dropped := har.Dropped(); dropped > 0 {
+				log.WithField("dropped", dropped).Warn("HAR export is missing requests that didn't fit; raise harExport.maxBytes to keep them")
+			}
+		}
+
 		if conf.Linger.Bool {
 			log.Info("Linger set; waiting for Ctrl+C...")
 			<-sigC
@@ -399,6 +555,14 @@ a commandline interface for interacting with it.`,
 		if engine.IsTainted() {
 			return ExitCode{errors.New("some thresholds have failed"), 99}
 		}
+		if conf.FailOnCheckFailure.Bool && engine.ChecksFailed() {
+			return ExitCode{errors.New("some checks have failed"), 99}
+		}
+		if min := conf.MinCheckPassRate; min.Valid {
+			if rate, ok := engine.CheckPassRate(); ok && rate < min.Float64 {
+				return ExitCode{errors.Errorf("only %.2f%% of checks passed, below the minimum of %.2f%%", rate*100, min.Float64*100), 99}
+			}
+		}
 		return nil
 	},
 }
@@ -428,6 +592,81 @@ func readSource(src, pwd string, fs afero.Fs, stdin io.Reader) (*lib.SourceData,
 	return loader.Load(fs, pwd, src)
 }
 
+// Writes histograms of all trend metrics to a file as JSON, by metric name.
+func writeHistograms(fs afero.Fs, filename string, metrics map[string]*stats.Metric) error {
+	histograms := make(map[string]stats.Histogram)
+	for name, m := range metrics {
+		if sink, ok := m.Sink.(*stats.TrendSink); ok {
+			histograms[name] = stats.NewHistogram(sink.Values, stats.DefaultHistogramPrecision)
+		}
+	}
+	return writeJSON(fs, filename, histograms)
+}
+
+// Writes a run's options and results to a file, as a lib.RunExport.
+func writeRunExport(fs afero.Fs, filename string, opts lib.Options, summary lib.Summary) error {
+	data, err := lib.ExportRun(opts, summary)
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(fs, filename, data, 0644)
+}
+
+// Writes a value to a file as indented JSON.
+func writeJSON(fs afero.Fs, filename string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(fs, filename, data, 0644)
+}
+
+// Writes HTTP traffic recorded for the harExport option to a file.
+func writeHAR(fs afero.Fs, filename string, har *lib.HARRecorder) error {
+	f, err := fs.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := har.Write(f, Version); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Validates the options and initializes the VUs, the same as a real run, then reports on it
+// instead of generating any load.
+func dryRun(w io.Writer, r lib.Runner, conf Config, filename string) error {
+	if err := conf.Options.Validate(); err != nil {
+		return err
+	}
+	if _, err := core.NewEngine(local.New(r), conf.Options); err != nil {
+		return err
+	}
+	printDryRunReport(w, conf, filename)
+	return nil
+}
+
+// Prints what a dry run validated, and what a real run with the same options would do.
+func printDryRunReport(w io.Writer, conf Config, filename string) {
+	duration := ui.GrayColor.Sprint("-")
+	iterations := ui.GrayColor.Sprint("-")
+	if conf.Duration.Valid {
+		duration = ui.ValueColor.Sprint(conf.Duration.Duration)
+	}
+	if conf.Iterations.Valid {
+		iterations = ui.ValueColor.Sprint(conf.Iterations.Int64)
+	}
+
+	fmt.Fprintf(w, "     dry run: %s\n", ui.ValueColor.Sprint("script and options are valid, no load generated"))
+	fmt.Fprintf(w, "      script: %s\n", ui.ValueColor.Sprint(filename))
+	fmt.Fprintf(w, "    duration: %s, iterations: %s\n", duration, iterations)
+	fmt.Fprintf(w, "         vus: %s, max: %s (all initialized)\n",
+		ui.ValueColor.Sprint(conf.VUs.Int64), ui.ValueColor.Sprint(conf.VUsMax.Int64))
+	fmt.Fprintf(w, "      stages: %s\n", ui.ValueColor.Sprint(len(conf.Stages)))
+	fmt.Fprintf(w, "  thresholds: %s\n", ui.ValueColor.Sprint(len(conf.ThresholdDefinitions())))
+}
+
 // Creates a new runner.
 func newRunner(src *lib.SourceData, typ string, fs afero.Fs) (lib.Runner, error) {
 	switch typ {
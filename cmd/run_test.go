@@ -0,0 +1,123 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/loadimpact/k6/lib"
+	"github.com/loadimpact/k6/stats"
+	"github.com/loadimpact/k6/ui"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestWriteRunExport(t *testing.T) {
+	root, err := lib.NewGroup("", nil)
+	assert.NoError(t, err)
+	check, err := root.Check("my check")
+	assert.NoError(t, err)
+	check.Passes = 2
+
+	reqs := stats.New("http_reqs", stats.Counter)
+	reqs.Sink.Add(stats.Sample{Value: 3})
+
+	// The same data that's printed as the end-of-test summary.
+	summary := ui.SummaryData{
+		Opts:    lib.Options{VUs: null.IntFrom(5), RunExport: null.StringFrom("/path/to/run.json")},
+		Root:    root,
+		Metrics: map[string]*stats.Metric{"http_reqs": reqs},
+		Time:    10 * time.Second,
+	}
+	var buf bytes.Buffer
+	ui.Summarize(&buf, "", summary)
+	assert.Contains(t, buf.String(), "http_reqs")
+
+	fs := afero.NewMemMapFs()
+	assert.NoError(t, writeRunExport(fs, summary.Opts.RunExport.String, summary.Opts, lib.Summary{
+		Root:    summary.Root,
+		Metrics: summary.Metrics,
+		Time:    summary.Time,
+	}))
+	data, err := afero.ReadFile(fs, "/path/to/run.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var export lib.RunExport
+	assert.NoError(t, json.Unmarshal(data, &export))
+	assert.Equal(t, lib.RunExportVersion, export.Version)
+	assert.Equal(t, null.IntFrom(5), export.Options.VUs)
+	assert.Equal(t, lib.Duration(10*time.Second), export.Duration)
+	assert.Equal(t, 3.0, export.Metrics["http_reqs"].Values["count"])
+	assert.Equal(t, lib.RunExportCheck{Passes: 2}, export.Checks["::my check"])
+}
+
+// Counts the VUs it makes.
+type countingRunner struct {
+	lib.RunnerFunc
+	vus int
+}
+
+func (r *countingRunner) NewVU() (lib.VU, error) {
+	r.vus++
+	return r.RunnerFunc.NewVU()
+}
+
+func TestDryRun(t *testing.T) {
+	fn := func(ctx context.Context) ([]stats.Sample, error) {
+		t.Error("a dry run shouldn't run any iterations")
+		return nil, nil
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		r := &countingRunner{RunnerFunc: fn}
+		conf := Config{Options: lib.Options{
+			VUs:        null.IntFrom(1),
+			VUsMax:     null.IntFrom(2),
+			Iterations: null.IntFrom(10),
+			DryRun:     null.BoolFrom(true),
+		}}
+		var buf bytes.Buffer
+		assert.NoError(t, dryRun(&buf, r, conf, "script.js"))
+		assert.Equal(t, 2, r.vus)
+		assert.Contains(t, buf.String(), "script and options are valid")
+		assert.Contains(t, buf.String(), "script: script.js")
+		assert.Contains(t, buf.String(), "iterations: 10")
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		r := &countingRunner{RunnerFunc: fn}
+		conf := Config{Options: lib.Options{
+			VUsMax: null.IntFrom(2),
+			Stages: []lib.Stage{{Duration: lib.NullDurationFrom(10 * time.Second), Target: null.IntFrom(5)}},
+			DryRun: null.BoolFrom(true),
+		}}
+		var buf bytes.Buffer
+		assert.EqualError(t, dryRun(&buf, r, conf, "script.js"), "stage 0 targets more vus (5) than the vu cap (2)")
+		assert.Equal(t, 0, r.vus)
+		assert.Empty(t, buf.String())
+	})
+}
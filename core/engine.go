@@ -22,6 +22,11 @@ package core
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -41,6 +46,13 @@ const (
 	ThresholdsRate  = 2 * time.Second
 	ShutdownTimeout = 10 * time.Second
 
+	MemoryLimitRate     = 1 * time.Second
+	MemoryLimitCooldown = 10 * time.Second
+
+	// Shed VUs are only given back once memory use is this far under the limit, so the engine
+	// doesn't flap between shedding and restoring right at the limit.
+	MemoryLimitRestoreRatio = 0.9
+
 	BackoffAmount = 50 * time.Millisecond
 	BackoffMax    = 10 * time.Second
 )
@@ -65,6 +77,45 @@ type Engine struct {
 
 	// Are thresholds tainted?
 	thresholdsTainted bool
+
+	// Resolution sample timestamps are truncated to; 0 = untouched.
+	timestampPrecision time.Duration
+
+	// Prefixed copies of metrics, by unprefixed name, for collectors. Guarded by MetricsLock.
+	metricPrefix    string
+	prefixedMetrics map[string]*stats.Metric
+
+	// Gauges to reset once they're not set for a metrics interval, if gaugeReset has any.
+	// Guarded by MetricsLock.
+	gaugeResetter *stats.GaugeResetter
+
+	// Distinct values seen for tags with a cardinality limit, by tag name. Guarded by MetricsLock.
+	tagValues map[string]map[string]bool
+
+	// http_req_conn_reused's submetrics by host, if connReuseMetrics is set, and how many hosts
+	// get their own before the rest share one. Guarded by MetricsLock.
+	hostSubmetrics     map[string]*stats.Submetric
+	hostSubmetricLimit int64
+
+	// Queue between the executor and processSamples(), if maxInFlightSamples is set.
+	sampleBuffer *sampleBuffer
+
+	// Tags run_start and run_end samples with the runMarkers option; empty without it.
+	runID string
+
+	// Trend samples since percentiles were last sent, by metric name, if percentileWindow is
+	// "interval"; and the gauges they're sent as, by name. Guarded by MetricsLock.
+	percentileSinks   map[string]*stats.TrendSink
+	percentileMetrics map[string]*stats.Metric
+
+	// Reads memory use in bytes, for the memoryLimit option; when its policy last acted.
+	memoryUsage   func() uint64
+	memoryActedAt time.Time
+
+	// Whether VUs have been shed under the memoryLimit, and the VU limit and count from before.
+	memoryShed      bool
+	memoryShedLimit null.Int
+	memoryShedVUs   int64
 }
 
 func NewEngine(ex lib.Executor, o lib.Options) (*Engine, error) {
@@ -79,18 +130,91 @@ func NewEngine(ex lib.Executor, o lib.Options) (*Engine, error) {
 	}
 	e.SetLogger(log.StandardLogger())
 
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	ex.SetPreAllocatedVUs(o.PreAllocatedVUs)
+	e.memoryUsage = readMemoryUsage
+	ex.SetVUInitConcurrency(o.VUInitConcurrency)
 	if err := ex.SetVUsMax(o.VUsMax.Int64); err != nil {
 		return nil, err
 	}
 	if err := ex.SetVUs(o.VUs.Int64); err != nil {
 		return nil, err
 	}
+	if o.VUsMax.Valid && o.VUsMaxPolicy.String == lib.VUsMaxClamp {
+		for i, stage := range o.Stages {
+			if stage.Target.Valid && stage.Target.Int64 > o.VUsMax.Int64 {
+				e.logger.WithFields(log.Fields{"stage": i, "target": stage.Target.Int64, "vus_max": o.VUsMax.Int64}).Warn(
+					"Stage targets more VUs than the vu cap; they'll be held to it")
+			}
+		}
+	}
+	ex.SetVUsMaxPolicy(o.VUsMaxPolicy.String)
 	ex.SetPaused(o.Paused.Bool)
 	ex.SetStages(o.Stages)
+	ex.SetVUStartJitter(o.VUStartJitter)
+	ex.SetThinkTime(o.ThinkTime)
 	ex.SetEndTime(o.Duration)
+	ex.SetDeadline(o.EndTime)
 	ex.SetEndIterations(o.Iterations)
+	ex.SetMaxIterationsPerVU(o.MaxIterationsPerVU)
+	ex.SetPaceIterations(o.PaceIterations.Bool)
+	ex.SetVUCancellation(o.VUCancellation.String)
 
-	e.thresholds = o.Thresholds
+	if o.TimestampPrecision.Valid {
+		precision, err := lib.ParseTimestampPrecision(o.TimestampPrecision.String)
+		if err != nil {
+			return nil, err
+		}
+		e.timestampPrecision = precision
+	}
+	if o.MetricPrefix.Valid {
+		e.metricPrefix = o.MetricPrefix.String
+		e.prefixedMetrics = make(map[string]*stats.Metric)
+	}
+	for _, mode := range o.GaugeReset {
+		if mode == stats.GaugeResetInterval {
+			e.gaugeResetter = stats.NewGaugeResetter()
+			break
+		}
+	}
+	for tag := range o.TagCardinalityLimits {
+		if e.tagValues == nil {
+			e.tagValues = make(map[string]map[string]bool, len(o.TagCardinalityLimits))
+		}
+		e.tagValues[tag] = make(map[string]bool)
+	}
+	if o.ConnReuseMetrics.Bool {
+		e.hostSubmetrics = make(map[string]*stats.Submetric)
+		e.hostSubmetricLimit = maxHostSubmetrics
+		if limit, ok := o.TagCardinalityLimits["host"]; ok {
+			e.hostSubmetricLimit = limit
+		}
+	}
+	if o.RunMarkers.Bool {
+		e.runID = o.RunID.String
+		if e.runID == "" {
+			var id [16]byte
+			if _, err := rand.Read(id[:]); err != nil {
+				return nil, err
+			}
+			e.runID = hex.EncodeToString(id[:])
+		}
+	}
+	if o.PercentileInterval.Valid {
+		e.percentileMetrics = make(map[string]*stats.Metric)
+	}
+	if o.PercentileInterval.Valid && o.PercentileWindow.String == lib.PercentileWindowInterval {
+		e.percentileSinks = make(map[string]*stats.TrendSink)
+	}
+
+	// Without thresholds, there's also no need for submetrics.
+	e.NoThresholds = o.NoThresholds.Bool
+	if !e.NoThresholds {
+		e.thresholds = o.Thresholds
+	}
 	e.submetrics = make(map[string][]*stats.Submetric)
 	for name := range e.thresholds {
 		if !strings.Contains(name, "{") {
@@ -100,10 +224,42 @@ func NewEngine(ex lib.Executor, o lib.Options) (*Engine, error) {
 		parent, sm := stats.NewSubmetric(name)
 		e.submetrics[parent] = append(e.submetrics[parent], sm)
 	}
+	if o.SummaryPerStage.Bool {
+		for i := range o.Stages {
+			for _, name := range lib.StageSummaryMetrics {
+				if _, ok := e.thresholds[lib.StageSubmetricName(name, i)]; ok {
+					continue
+				}
+				parent, sm := stats.NewSubmetric(lib.StageSubmetricName(name, i))
+				e.submetrics[parent] = append(e.submetrics[parent], sm)
+			}
+		}
+	}
+
+	if o.MaxInFlightSamples.Valid {
+		e.sampleBuffer = newSampleBuffer(
+			int(o.MaxInFlightSamples.Int64), o.InFlightSamplesPolicy.String, e.thresholdsNeed,
+		)
+	}
 
 	return e, nil
 }
 
+// Returns true if a threshold depends on the sample's metric, or one of its submetrics.
+func (e *Engine) thresholdsNeed(sample stats.Sample) bool {
+	_, ok := e.thresholds[sample.Metric.Name]
+	return ok || len(e.submetrics[sample.Metric.Name]) > 0
+}
+
+// Hands samples from the executor off to the sample buffer, or processes them right away.
+func (e *Engine) handleSamples(samples []stats.Sample) {
+	if e.sampleBuffer != nil {
+		e.sampleBuffer.push(samples)
+		return
+	}
+	e.processSamples(samples...)
+}
+
 func (e *Engine) Run(ctx context.Context) error {
 	e.runLock.Lock()
 	defer e.runLock.Unlock()
@@ -124,6 +280,9 @@ func (e *Engine) Run(ctx context.Context) error {
 	if endTime := e.Executor.GetEndTime(); endTime.Valid {
 		fields["time"] = endTime.Duration
 	}
+	if deadline := e.Executor.GetDeadline(); deadline.Valid {
+		fields["deadline"] = deadline.Time
+	}
 	if endIter := e.Executor.GetEndIterations(); endIter.Valid {
 		fields["iter"] = endIter.Int64
 	}
@@ -139,6 +298,10 @@ func (e *Engine) Run(ctx context.Context) error {
 		}()
 	}
 
+	if e.runID != "" {
+		e.processSamples(e.runMarker(metrics.RunStart))
+	}
+
 	subctx, subcancel := context.WithCancel(context.Background())
 	subwg := sync.WaitGroup{}
 
@@ -160,6 +323,43 @@ func (e *Engine) Run(ctx context.Context) error {
 		}()
 	}
 
+	// Send percentiles, if there's an interval for them.
+	if e.percentileMetrics != nil {
+		subwg.Add(1)
+		go func() {
+			e.runPercentiles(subctx)
+			e.logger.Debug("Engine: Percentiles terminated")
+			subwg.Done()
+		}()
+	}
+
+	// Watch memory use, if there's a limit.
+	if e.Options.MemoryLimit.Valid {
+		subwg.Add(1)
+		go func() {
+			e.runMemoryLimit(subctx)
+			e.logger.Debug("Engine: Memory limit terminated")
+			subwg.Done()
+		}()
+	}
+
+	// Process buffered samples, if buffering is enabled.
+	bufferDone := make(chan struct{})
+	if e.sampleBuffer != nil {
+		go func() {
+			for {
+				samples, ok := e.sampleBuffer.pop()
+				if !ok {
+					break
+				}
+				e.processSamples(samples...)
+			}
+			close(bufferDone)
+		}()
+	} else {
+		close(bufferDone)
+	}
+
 	// Run the executor.
 	out := make(chan []stats.Sample)
 	errC := make(chan error)
@@ -185,17 +385,28 @@ func (e *Engine) Run(ctx context.Context) error {
 			close(out)
 		}()
 		for samples := range out {
-			e.processSamples(samples...)
+			e.handleSamples(samples)
 		}
+		if e.sampleBuffer != nil {
+			e.sampleBuffer.close()
+		}
+		<-bufferDone
 
 		// Emit final metrics.
 		e.emitMetrics()
+		if e.percentileMetrics != nil {
+			e.processSamples(e.percentileSamples(time.Now())...)
+		}
 
 		// Process final thresholds.
 		if !e.NoThresholds {
 			e.processThresholds()
 		}
 
+		if e.runID != "" {
+			e.processSamples(e.runMarker(metrics.RunEnd))
+		}
+
 		// Finally, shut down collector.
 		collectorcancel()
 		collectorwg.Wait()
@@ -204,7 +415,7 @@ func (e *Engine) Run(ctx context.Context) error {
 	for {
 		select {
 		case samples := <-out:
-			e.processSamples(samples...)
+			e.handleSamples(samples)
 		case err := <-errC:
 			errC = nil
 			if err != nil {
@@ -224,6 +435,35 @@ func (e *Engine) IsTainted() bool {
 	return e.thresholdsTainted
 }
 
+// Returns true if any checks have failed so far.
+func (e *Engine) ChecksFailed() bool {
+	e.MetricsLock.RLock()
+	defer e.MetricsLock.RUnlock()
+
+	m, ok := e.Metrics[metrics.Checks.Name]
+	if !ok {
+		return false
+	}
+	sink, ok := m.Sink.(*stats.RateSink)
+	return ok && sink.Trues < sink.Total
+}
+
+// Returns the fraction of checks that have passed so far, and false if none have run.
+func (e *Engine) CheckPassRate() (float64, bool) {
+	e.MetricsLock.RLock()
+	defer e.MetricsLock.RUnlock()
+
+	m, ok := e.Metrics[metrics.Checks.Name]
+	if !ok {
+		return 0, false
+	}
+	sink, ok := m.Sink.(*stats.RateSink)
+	if !ok || sink.Total == 0 {
+		return 0, false
+	}
+	return float64(sink.Trues) / float64(sink.Total), true
+}
+
 func (e *Engine) SetLogger(l *log.Logger) {
 	e.logger = l
 	e.Executor.SetLogger(l)
@@ -247,18 +487,196 @@ func (e *Engine) runMetricsEmission(ctx context.Context) {
 
 func (e *Engine) emitMetrics() {
 	t := time.Now()
-	e.processSamples(
-		stats.Sample{
+	samples := []stats.Sample{
+		{
 			Time:   t,
 			Metric: metrics.VUs,
 			Value:  float64(e.Executor.GetVUs()),
 		},
-		stats.Sample{
+		{
 			Time:   t,
 			Metric: metrics.VUsMax,
 			Value:  float64(e.Executor.GetVUsMax()),
 		},
-	)
+	}
+	if e.gaugeResetter != nil {
+		e.MetricsLock.Lock()
+		samples = append(samples, e.gaugeResetter.Stale(t)...)
+		e.MetricsLock.Unlock()
+	}
+	e.processSamples(samples...)
+}
+
+func (e *Engine) runPercentiles(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(e.Options.PercentileInterval.Duration))
+	for {
+		select {
+		case t := <-ticker.C:
+			e.handleSamples(e.percentileSamples(t))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Returns samples of the lib.PeriodicPercentiles of trend metrics (but not submetrics), and
+// starts the next interval if percentileWindow is "interval". They go through processSamples()
+// for their tags like any other, but only to the collector.
+func (e *Engine) percentileSamples(t time.Time) []stats.Sample {
+	e.MetricsLock.Lock()
+	defer e.MetricsLock.Unlock()
+
+	var samples []stats.Sample
+	for name, m := range e.Metrics {
+		if m.Type != stats.Trend || m.Sub.Parent != "" {
+			continue
+		}
+		sink, _ := m.Sink.(*stats.TrendSink)
+		if e.percentileSinks != nil {
+			sink = e.percentileSinks[name]
+		}
+		if sink == nil || sink.Count == 0 {
+			continue
+		}
+		sink.Calc()
+		for _, p := range lib.PeriodicPercentiles {
+			pm, ok := e.percentileMetrics[name+p.Suffix]
+			if !ok {
+				pm = stats.New(name+p.Suffix, stats.Gauge, m.Contains)
+				e.percentileMetrics[name+p.Suffix] = pm
+			}
+			samples = append(samples, stats.Sample{Time: t, Metric: pm, Value: sink.P(p.Pct)})
+		}
+	}
+	if e.percentileSinks != nil {
+		e.percentileSinks = make(map[string]*stats.TrendSink)
+	}
+	return samples
+}
+
+// Returns a run_start or run_end sample for the runMarkers option.
+func (e *Engine) runMarker(m *stats.Metric) stats.Sample {
+	summary, err := json.Marshal(struct {
+		VUs        null.Int         `json:"vus"`
+		VUsMax     null.Int         `json:"vusMax"`
+		Duration   lib.NullDuration `json:"duration"`
+		Iterations null.Int         `json:"iterations"`
+		Stages     []lib.Stage      `json:"stages"`
+	}{e.Options.VUs, e.Options.VUsMax, e.Options.Duration, e.Options.Iterations, e.Options.Stages})
+	if err != nil {
+		e.logger.WithError(err).Warn("Couldn't summarize the options for a run marker")
+	}
+	return stats.Sample{
+		Time:   time.Now(),
+		Metric: m,
+		Tags:   map[string]string{"run_id": e.runID, "options": string(summary)},
+		Value:  1,
+	}
+}
+
+func (e *Engine) runMemoryLimit(ctx context.Context) {
+	ticker := time.NewTicker(MemoryLimitRate)
+	for {
+		select {
+		case t := <-ticker.C:
+			e.checkMemoryLimit(t)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Acts on the memoryLimitPolicy if memory use is over the memoryLimit, or gives back shed VUs
+// once it's comfortably under it again, unless it acted recently.
+func (e *Engine) checkMemoryLimit(t time.Time) {
+	used, limit := e.memoryUsage(), uint64(e.Options.MemoryLimit.Int64)
+	if t.Sub(e.memoryActedAt) < MemoryLimitCooldown {
+		return
+	}
+	if used <= limit {
+		if e.memoryShed && float64(used) <= float64(limit)*MemoryLimitRestoreRatio {
+			e.memoryActedAt = t
+			e.restoreShedVUs(e.logger.WithFields(log.Fields{"memory": used, "limit": limit}))
+		}
+		return
+	}
+	e.memoryActedAt = t
+
+	policy := e.Options.MemoryLimitPolicy.String
+	if policy == "" {
+		policy = lib.MemoryLimitShedVUs
+	}
+	logger := e.logger.WithFields(log.Fields{"memory": used, "limit": limit, "policy": policy})
+	switch policy {
+	case lib.MemoryLimitShedVUs:
+		vus := e.Executor.GetVUs()
+		shed := vus / 10
+		if shed < 1 {
+			shed = 1
+		}
+		if vus-shed < 1 {
+			logger.Warn("Memory limit exceeded, but there are no VUs left to shed")
+			break
+		}
+		vuLimit := e.Executor.GetVULimit()
+		if err := e.Executor.SetVULimit(null.IntFrom(vus - shed)); err != nil {
+			logger.WithError(err).Warn("Memory limit exceeded, but VUs couldn't be shed")
+			break
+		}
+		if !e.memoryShed {
+			e.memoryShed, e.memoryShedLimit, e.memoryShedVUs = true, vuLimit, vus
+		}
+		logger.WithField("vus", vus-shed).Warn("Memory limit exceeded; shedding VUs")
+	case lib.MemoryLimitAbort:
+		logger.Error("Memory limit exceeded; ending the test")
+		e.Executor.SetEndTime(lib.NullDurationFrom(e.Executor.GetTime()))
+	default:
+		logger.Warn("Memory limit exceeded")
+	}
+	e.processSamples(stats.Sample{
+		Time:   t,
+		Metric: metrics.MemoryLimitExceeded,
+		Tags:   map[string]string{"policy": policy},
+		Value:  1,
+	})
+}
+
+// Raises the VU limit by 10%, and the VUs along with it, until they're back to where they were
+// before any were shed; then puts back the original VU limit.
+func (e *Engine) restoreShedVUs(logger *log.Entry) {
+	limit := e.Executor.GetVULimit().Int64
+	step := limit / 10
+	if step < 1 {
+		step = 1
+	}
+	next, restored := limit+step, false
+	if next >= e.memoryShedVUs {
+		next, restored = e.memoryShedVUs, true
+	}
+
+	vuLimit := null.IntFrom(next)
+	if restored {
+		vuLimit = e.memoryShedLimit
+	}
+	if err := e.Executor.SetVULimit(vuLimit); err != nil {
+		logger.WithError(err).Warn("Memory use is back under the limit, but VUs couldn't be restored")
+		return
+	}
+	if e.Executor.GetVUs() < next {
+		if err := e.Executor.SetVUs(next); err != nil {
+			logger.WithError(err).Warn("Memory use is back under the limit, but VUs couldn't be restored")
+			return
+		}
+	}
+	e.memoryShed = !restored
+	logger.WithField("vus", next).Info("Memory use is back under the limit; restoring VUs")
+}
+
+// Returns how many bytes of heap and stacks are in use.
+func readMemoryUsage() uint64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapInuse + ms.StackInuse
 }
 
 func (e *Engine) runThresholds(ctx context.Context) {
@@ -300,6 +718,85 @@ func (e *Engine) processThresholds() {
 	}
 }
 
+// Returns a copy of m named with the metric prefix, for collectors. MetricsLock must be held.
+func (e *Engine) prefixedMetric(m *stats.Metric) *stats.Metric {
+	pm, ok := e.prefixedMetrics[m.Name]
+	if !ok {
+		pm = &stats.Metric{Name: e.metricPrefix + m.Name, Type: m.Type, Contains: m.Contains, Sink: m.Sink}
+		e.prefixedMetrics[m.Name] = pm
+	}
+	return pm
+}
+
+// Returns a copy of tags with values over their tag's cardinality limit replaced with
+// lib.TagOverflowValue, or false if none are. MetricsLock must be held.
+func (e *Engine) capTagCardinality(tags map[string]string) (map[string]string, bool) {
+	var capped map[string]string
+	for tag, seen := range e.tagValues {
+		value, ok := tags[tag]
+		if !ok || seen[value] {
+			continue
+		}
+		limit := e.Options.TagCardinalityLimits[tag]
+		if int64(len(seen)) < limit {
+			seen[value] = true
+			continue
+		}
+		if capped == nil {
+			capped = make(map[string]string, len(tags))
+			for k, v := range tags {
+				capped[k] = v
+			}
+		}
+		capped[tag] = lib.TagOverflowValue
+		if !seen[lib.TagOverflowValue] {
+			// Doesn't count towards the limit; just remembers that we've warned about it.
+			seen[lib.TagOverflowValue] = true
+			e.logger.WithField("tag", tag).Warnf(
+				"Tag has more than %d distinct values; bucketing the rest into %q", limit, lib.TagOverflowValue)
+		}
+	}
+	return capped, capped != nil
+}
+
+// How many hosts connReuseMetrics breaks http_req_conn_reused down by, unless the host tag has a
+// TagCardinalityLimits entry of its own.
+const maxHostSubmetrics = 100
+
+// Returns the submetric of a metric broken down by host for the given host, making it the first
+// time the host is seen; hosts past the limit share one for lib.TagOverflowValue. Returns nil if
+// there's nothing to add the sample to here, or if a threshold's submetric already covers the host.
+// MetricsLock must be held.
+func (e *Engine) hostSubmetric(m *stats.Metric, host string) *stats.Submetric {
+	if host == "" {
+		return nil
+	}
+	if sm, ok := e.hostSubmetrics[host]; ok {
+		return sm
+	}
+	if host != lib.TagOverflowValue && int64(len(e.hostSubmetrics)) >= e.hostSubmetricLimit {
+		if _, ok := e.hostSubmetrics[lib.TagOverflowValue]; !ok {
+			e.logger.WithField("metric", m.Name).Warnf(
+				"More than %d hosts; bucketing the rest into %q", e.hostSubmetricLimit, lib.TagOverflowValue)
+		}
+		return e.hostSubmetric(m, lib.TagOverflowValue)
+	}
+
+	name := m.Name + "{host:" + host + "}"
+	for _, sm := range m.Submetrics {
+		if sm.Name == name {
+			e.hostSubmetrics[host] = nil
+			return nil
+		}
+	}
+	_, sm := stats.NewSubmetric(name)
+	sm.Metric = stats.New(sm.Name, m.Type, m.Contains)
+	sm.Metric.Sub = *sm
+	e.Metrics[sm.Name] = sm.Metric
+	e.hostSubmetrics[host] = sm
+	return sm
+}
+
 func (e *Engine) processSamples(samples ...stats.Sample) {
 	if len(samples) == 0 {
 		return
@@ -308,15 +805,92 @@ func (e *Engine) processSamples(samples ...stats.Sample) {
 	e.MetricsLock.Lock()
 	defer e.MetricsLock.Unlock()
 
-	for _, sample := range samples {
+	// Samples from the warmup period are tagged and collected, but kept out of the sinks. Both
+	// that and the stage go by when a sample was taken, not when it's processed, so ones that
+	// were buffered across a boundary still land on the right side of it.
+	warmup := e.Options.WarmupDuration
+	variant := e.Options.Variant
+	perStage := e.Options.SummaryPerStage.Bool
+	var stages []lib.Stage
+	if perStage {
+		stages = e.Executor.GetStages()
+	}
+	now, elapsed := time.Now(), e.Executor.GetTime()
+
+	for i, sample := range samples {
+		at := elapsed
+		if !sample.Time.IsZero() {
+			at -= now.Sub(sample.Time)
+		}
+		inWarmup := warmup.Valid && at < time.Duration(warmup.Duration)
+		stage := ""
+		if perStage {
+			if n := lib.StageIndexAt(stages, at); n >= 0 {
+				stage = strconv.Itoa(n)
+			}
+		}
+
 		m, ok := e.Metrics[sample.Metric.Name]
-		if !ok {
+		percentile := e.percentileMetrics != nil && e.percentileMetrics[sample.Metric.Name] == sample.Metric
+		if percentile {
+			m = sample.Metric
+		} else if !ok {
 			m = sample.Metric
 			m.Thresholds = e.thresholds[m.Name]
 			m.Submetrics = e.submetrics[m.Name]
 			e.Metrics[m.Name] = m
 		}
+		if e.gaugeResetter != nil && m.Type == stats.Gauge && e.Options.GaugeReset[m.Name] == stats.GaugeResetInterval {
+			e.gaugeResetter.Add(sample)
+		}
+		if e.timestampPrecision > 0 {
+			sample.Time = sample.Time.Truncate(e.timestampPrecision)
+			samples[i].Time = sample.Time
+		}
+		if e.metricPrefix != "" {
+			samples[i].Metric = e.prefixedMetric(m)
+		}
+		if e.tagValues != nil {
+			if tags, ok := e.capTagCardinality(sample.Tags); ok {
+				sample.Tags = tags
+				samples[i].Tags = tags
+			}
+		}
+		if inWarmup || variant.Valid || stage != "" {
+			tags := make(map[string]string, len(sample.Tags)+3)
+			for k, v := range sample.Tags {
+				tags[k] = v
+			}
+			if variant.Valid {
+				tags["variant"] = variant.String
+			}
+			if stage != "" {
+				tags["stage"] = stage
+			}
+			if inWarmup {
+				tags["warmup"] = "true"
+			}
+			sample.Tags = tags
+			samples[i].Tags = tags
+		}
+		if inWarmup || percentile {
+			continue
+		}
 		m.Sink.Add(sample)
+		if e.percentileSinks != nil && m.Type == stats.Trend {
+			sink, ok := e.percentileSinks[m.Name]
+			if !ok {
+				sink = &stats.TrendSink{}
+				e.percentileSinks[m.Name] = sink
+			}
+			sink.Add(sample)
+		}
+
+		if e.hostSubmetrics != nil && m.Name == metrics.HTTPReqConnReused.Name {
+			if sm := e.hostSubmetric(m, sample.Tags["host"]); sm != nil {
+				sm.Metric.Sink.Add(sample)
+			}
+		}
 
 		for _, sm := range m.Submetrics {
 			passing := true
@@ -22,11 +22,15 @@ package core
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/loadimpact/k6/core/local"
 	"github.com/loadimpact/k6/lib"
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/loadimpact/k6/lib/netext"
 	"github.com/loadimpact/k6/stats"
 	"github.com/loadimpact/k6/stats/dummy"
 	log "github.com/sirupsen/logrus"
@@ -57,6 +61,14 @@ func newTestEngine(ex lib.Executor, opts lib.Options) (*Engine, error, *logtest.
 	return e, nil, hook
 }
 
+// An executor that's always at the same point in the test.
+type timedExecutor struct {
+	lib.Executor
+	time time.Duration
+}
+
+func (e timedExecutor) GetTime() time.Duration { return e.time }
+
 func L(r lib.Runner) lib.Executor {
 	return local.New(r)
 }
@@ -71,6 +83,300 @@ func TestNewEngine(t *testing.T) {
 }
 
 func TestNewEngineOptions(t *testing.T) {
+	t.Run("MaxIterationsPerVU", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, lib.Options{MaxIterationsPerVU: null.IntFrom(10)})
+		assert.NoError(t, err)
+		assert.Equal(t, null.IntFrom(10), e.Executor.GetMaxIterationsPerVU())
+	})
+	t.Run("PaceIterations", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, lib.Options{
+			Iterations:     null.IntFrom(100),
+			Duration:       lib.NullDurationFrom(10 * time.Second),
+			PaceIterations: null.BoolFrom(true),
+		})
+		if assert.NoError(t, err) {
+			assert.True(t, e.Executor.GetPaceIterations())
+		}
+
+		_, err, _ = newTestEngine(nil, lib.Options{
+			Iterations:     null.IntFrom(100),
+			PaceIterations: null.BoolFrom(true),
+		})
+		assert.EqualError(t, err, "paceIterations needs both iterations and duration")
+	})
+	t.Run("PreAllocatedVUs", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, lib.Options{
+			VUsMax:          null.IntFrom(10),
+			PreAllocatedVUs: null.IntFrom(5),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, null.IntFrom(5), e.Executor.GetPreAllocatedVUs())
+
+		t.Run("TooHigh", func(t *testing.T) {
+			_, err, _ := newTestEngine(nil, lib.Options{
+				VUsMax:          null.IntFrom(10),
+				PreAllocatedVUs: null.IntFrom(20),
+			})
+			assert.EqualError(t, err, "can't preallocate more vus (20) than the vu cap (10)")
+		})
+		t.Run("Negative", func(t *testing.T) {
+			_, err, _ := newTestEngine(nil, lib.Options{PreAllocatedVUs: null.IntFrom(-1)})
+			assert.EqualError(t, err, "preallocated vus can't be negative")
+		})
+	})
+	t.Run("MemoryLimit", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{MemoryLimit: null.IntFrom(0)})
+		assert.EqualError(t, err, "memory limit must be positive")
+
+		_, err, _ = newTestEngine(nil, lib.Options{
+			MemoryLimit:       null.IntFrom(1 << 30),
+			MemoryLimitPolicy: null.StringFrom("swap"),
+		})
+		assert.EqualError(t, err, "unknown memory limit policy: swap")
+	})
+	t.Run("PercentileInterval", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{PercentileInterval: lib.NullDurationFrom(0)})
+		assert.EqualError(t, err, "percentile interval must be positive")
+
+		_, err, _ = newTestEngine(nil, lib.Options{
+			PercentileInterval: lib.NullDurationFrom(10 * time.Second),
+			PercentileWindow:   null.StringFrom("sliding"),
+		})
+		assert.EqualError(t, err, "unknown percentile window: sliding")
+	})
+	t.Run("VUInitConcurrency", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, lib.Options{VUInitConcurrency: null.IntFrom(4)})
+		assert.NoError(t, err)
+		assert.Equal(t, null.IntFrom(4), e.Executor.GetVUInitConcurrency())
+
+		_, err, _ = newTestEngine(nil, lib.Options{VUInitConcurrency: null.IntFrom(0)})
+		assert.EqualError(t, err, "vu init concurrency must be positive")
+	})
+	t.Run("MetricPrefix", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{MetricPrefix: null.StringFrom("my-test.")})
+		assert.EqualError(t, err, `invalid metric prefix: "my-test."`)
+	})
+	t.Run("MaxInFlightSamples", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, lib.Options{MaxInFlightSamples: null.IntFrom(100)})
+		assert.NoError(t, err)
+		assert.NotNil(t, e.sampleBuffer)
+
+		_, err, _ = newTestEngine(nil, lib.Options{MaxInFlightSamples: null.IntFrom(0)})
+		assert.EqualError(t, err, "maxInFlightSamples must be positive")
+
+		_, err, _ = newTestEngine(nil, lib.Options{
+			MaxInFlightSamples:    null.IntFrom(100),
+			InFlightSamplesPolicy: null.StringFrom("spill"),
+		})
+		assert.EqualError(t, err, "unknown in-flight samples policy: spill")
+	})
+	t.Run("MethodRPS", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{MethodRPS: map[string]int64{"POST": 0}})
+		assert.EqualError(t, err, "methodRPS for POST must be positive")
+	})
+	t.Run("Variant", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{Variant: null.StringFrom("a b")})
+		assert.EqualError(t, err, `invalid variant: "a b"`)
+	})
+	t.Run("NoThresholds", func(t *testing.T) {
+		ths, err := stats.NewThresholds([]string{"1+1==2"})
+		assert.NoError(t, err)
+		e, err, _ := newTestEngine(nil, lib.Options{
+			NoThresholds: null.BoolFrom(true),
+			Thresholds:   map[string]stats.Thresholds{"my_metric{a:1}": ths},
+		})
+		assert.NoError(t, err)
+		assert.True(t, e.NoThresholds)
+		assert.Empty(t, e.thresholds)
+		assert.Empty(t, e.submetrics)
+	})
+	t.Run("NoChecks", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{NoChecks: null.BoolFrom(true)})
+		assert.NoError(t, err)
+
+		_, err, _ = newTestEngine(nil, lib.Options{
+			NoChecks:           null.BoolFrom(true),
+			FailOnCheckFailure: null.BoolFrom(true),
+		})
+		assert.EqualError(t, err, "failOnCheckFailure can't be used with noChecks")
+	})
+	t.Run("MinCheckPassRate", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{MinCheckPassRate: null.FloatFrom(0.99)})
+		assert.NoError(t, err)
+
+		_, err, _ = newTestEngine(nil, lib.Options{MinCheckPassRate: null.FloatFrom(1.5)})
+		assert.EqualError(t, err, "min check pass rate must be between 0 and 1")
+
+		_, err, _ = newTestEngine(nil, lib.Options{
+			NoChecks:         null.BoolFrom(true),
+			MinCheckPassRate: null.FloatFrom(0.99),
+		})
+		assert.EqualError(t, err, "minCheckPassRate can't be used with noChecks")
+	})
+	t.Run("GaugeReset", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, lib.Options{GaugeReset: map[string]string{"a": "iteration"}})
+		assert.NoError(t, err)
+		assert.Nil(t, e.gaugeResetter)
+
+		e, err, _ = newTestEngine(nil, lib.Options{GaugeReset: map[string]string{"a": "iteration", "b": "interval"}})
+		assert.NoError(t, err)
+		assert.NotNil(t, e.gaugeResetter)
+
+		_, err, _ = newTestEngine(nil, lib.Options{GaugeReset: map[string]string{"a": "never"}})
+		assert.EqualError(t, err, "unknown gauge reset mode for a: never")
+	})
+	t.Run("TagCardinalityLimits", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, lib.Options{TagCardinalityLimits: map[string]int64{"url": 10}})
+		assert.NoError(t, err)
+		assert.Contains(t, e.tagValues, "url")
+
+		_, err, _ = newTestEngine(nil, lib.Options{TagCardinalityLimits: map[string]int64{"url": 0}})
+		assert.EqualError(t, err, "tag cardinality limit for url must be positive")
+	})
+	t.Run("EndTime", func(t *testing.T) {
+		end := time.Now().Add(1 * time.Hour)
+		e, err, _ := newTestEngine(nil, lib.Options{EndTime: lib.NullTimeFrom(end)})
+		assert.NoError(t, err)
+		assert.True(t, end.Equal(e.Executor.GetDeadline().Time))
+
+		past := time.Date(2018, 3, 1, 12, 30, 0, 0, time.UTC)
+		_, err, _ = newTestEngine(nil, lib.Options{EndTime: lib.NullTimeFrom(past)})
+		assert.EqualError(t, err, "end time 2018-03-01T12:30:00Z is in the past")
+	})
+	t.Run("VUsMaxPolicy", func(t *testing.T) {
+		stages := []lib.Stage{
+			{Duration: lib.NullDurationFrom(10 * time.Second), Target: null.IntFrom(5)},
+			{Duration: lib.NullDurationFrom(10 * time.Second), Target: null.IntFrom(20)},
+		}
+		_, err, _ := newTestEngine(nil, lib.Options{
+			VUsMax:       null.IntFrom(10),
+			Stages:       stages,
+			VUsMaxPolicy: null.StringFrom(lib.VUsMaxError),
+		})
+		assert.EqualError(t, err, "stage 1 targets more vus (20) than the vu cap (10)")
+
+		_, err, _ = newTestEngine(nil, lib.Options{VUsMax: null.IntFrom(10), Stages: stages})
+		assert.EqualError(t, err, "stage 1 targets more vus (20) than the vu cap (10)")
+
+		e, err, _ := newTestEngine(nil, lib.Options{
+			VUsMax:       null.IntFrom(10),
+			Stages:       stages,
+			VUsMaxPolicy: null.StringFrom(lib.VUsMaxClamp),
+		})
+		if assert.NoError(t, err) {
+			assert.Equal(t, lib.VUsMaxClamp, e.Executor.GetVUsMaxPolicy())
+		}
+
+		_, err, _ = newTestEngine(nil, lib.Options{VUsMaxPolicy: null.StringFrom("ignore")})
+		assert.EqualError(t, err, "unknown vus max policy: ignore")
+	})
+	t.Run("VUCancellation", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, lib.Options{VUCancellation: null.StringFrom(lib.VUCancellationFinishRequest)})
+		assert.NoError(t, err)
+		assert.Equal(t, lib.VUCancellationFinishRequest, e.Executor.GetVUCancellation())
+
+		_, err, _ = newTestEngine(nil, lib.Options{VUCancellation: null.StringFrom("never")})
+		assert.EqualError(t, err, "unknown vu cancellation mode: never")
+	})
+	t.Run("TagData", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{TagData: []map[string]string{{"customer_tier": "gold"}}})
+		assert.EqualError(t, err, "tagData needs tagDataTags to pick which fields become tags")
+	})
+	t.Run("TLSMinVersionStrict", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{TLSMinVersionStrict: null.BoolFrom(true)})
+		assert.EqualError(t, err, "tlsMinVersionStrict needs a minimum tlsVersion")
+
+		_, err, _ = newTestEngine(nil, lib.Options{
+			TLSMinVersionStrict: null.BoolFrom(true),
+			TLSVersion:          &lib.TLSVersions{Min: tls.VersionTLS12},
+		})
+		assert.NoError(t, err)
+	})
+	t.Run("LocalPortRange", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{LocalPortRange: null.StringFrom("50000-40000")})
+		assert.EqualError(t, err, `invalid port range: "50000-40000"`)
+	})
+	t.Run("BodyFile", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{
+			BodyFile:       null.StringFrom("upload.bin"),
+			RequestSigning: &lib.RequestSigning{Algorithm: lib.RequestSigningAWSSigV4},
+		})
+		assert.EqualError(t, err, "bodyFile can't be used with requestSigning")
+	})
+	t.Run("BindInterface", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{BindInterface: null.StringFrom("nonexistent0")})
+		assert.EqualError(t, err, `unknown network interface "nonexistent0"`)
+	})
+	t.Run("IdleConnTimeout", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{IdleConnTimeout: lib.NullDurationFrom(-time.Second)})
+		assert.EqualError(t, err, "idle connection timeout can't be negative")
+	})
+	t.Run("MaxResponseHeaderBytes", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{MaxResponseHeaderBytes: null.IntFrom(4096)})
+		assert.NoError(t, err)
+
+		_, err, _ = newTestEngine(nil, lib.Options{MaxResponseHeaderBytes: null.IntFrom(0)})
+		assert.EqualError(t, err, "max response header bytes must be positive")
+
+		for name, opts := range map[string]lib.Options{
+			"HTTPVersion":      {HTTPVersion: null.StringFrom("2")},
+			"HostHTTPVersions": {HostHTTPVersions: map[string]string{"example.com": "2"}},
+			"TLSNextProtos":    {TLSNextProtos: []string{"h2", "http/1.1"}},
+			"PerHostTLS":       {PerHostTLS: map[string]lib.HostTLSConfig{"example.com": {NextProtos: []string{"h2"}}}},
+		} {
+			opts.MaxResponseHeaderBytes = null.IntFrom(1024)
+			_, err, _ := newTestEngine(nil, opts)
+			assert.EqualError(t, err, "maxResponseHeaderBytes can't be used with HTTP/2", name)
+		}
+	})
+	t.Run("RequestTimeout", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{RequestTimeout: lib.NullDurationFrom(0)})
+		assert.EqualError(t, err, "request timeout must be positive")
+
+		_, err, _ = newTestEngine(nil, lib.Options{
+			HostRequestTimeouts: map[string]lib.Duration{"*.example.com": lib.Duration(-time.Second)},
+		})
+		assert.EqualError(t, err, "request timeout for *.example.com must be positive")
+	})
+	t.Run("SummaryTrendPrecision", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{SummaryTrendPrecision: null.IntFrom(0)})
+		assert.NoError(t, err)
+
+		_, err, _ = newTestEngine(nil, lib.Options{SummaryTrendPrecision: null.IntFrom(-1)})
+		assert.EqualError(t, err, "summary trend precision can't be negative")
+	})
+	t.Run("ResponseSizeAlert", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{ResponseSizeAlert: null.IntFrom(0)})
+		assert.NoError(t, err)
+
+		_, err, _ = newTestEngine(nil, lib.Options{ResponseSizeAlert: null.IntFrom(-1)})
+		assert.EqualError(t, err, "response size alert can't be negative")
+	})
+	t.Run("HTTPVersion", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{
+			HTTPVersion:      null.StringFrom("2"),
+			HostHTTPVersions: map[string]string{"*.example.com": "1.1"},
+		})
+		assert.NoError(t, err)
+
+		_, err, _ = newTestEngine(nil, lib.Options{HTTPVersion: null.StringFrom("3")})
+		assert.EqualError(t, err, "unknown HTTP version: 3")
+
+		_, err, _ = newTestEngine(nil, lib.Options{HostHTTPVersions: map[string]string{"example.com": "h2"}})
+		assert.EqualError(t, err, "hostHTTPVersions[example.com]: unknown HTTP version: h2")
+	})
+	t.Run("SystemTags", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{SystemTags: []string{"pid"}})
+		assert.EqualError(t, err, "unknown system tag: pid")
+	})
+	t.Run("ExpectedStatuses", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{ExpectedStatuses: []string{"2xx"}})
+		assert.EqualError(t, err, `invalid status range: "2xx"`)
+	})
+	t.Run("TimestampPrecision", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, lib.Options{TimestampPrecision: null.StringFrom("s")})
+		assert.EqualError(t, err, "unknown timestamp precision: s")
+	})
 	t.Run("Duration", func(t *testing.T) {
 		e, err, _ := newTestEngine(nil, lib.Options{
 			Duration: lib.NullDurationFrom(10 * time.Second),
@@ -283,6 +589,211 @@ func TestEngineRun(t *testing.T) {
 	})
 }
 
+func TestEngineMemoryLimit(t *testing.T) {
+	newEngine := func(t *testing.T, policy string) *Engine {
+		e, err, _ := newTestEngine(nil, lib.Options{
+			VUs:               null.IntFrom(20),
+			VUsMax:            null.IntFrom(20),
+			MemoryLimit:       null.IntFrom(1000),
+			MemoryLimitPolicy: null.NewString(policy, policy != ""),
+		})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		e.memoryUsage = func() uint64 { return 2000 }
+		return e
+	}
+	// The metric and its sink are shared between engines, so count from a baseline.
+	exceeded := func() float64 {
+		return metrics.MemoryLimitExceeded.Sink.(*stats.CounterSink).Value
+	}
+
+	t.Run("ShedVUs", func(t *testing.T) {
+		e, base := newEngine(t, ""), exceeded()
+		now := time.Now()
+		e.checkMemoryLimit(now)
+		assert.Equal(t, int64(18), e.Executor.GetVUs())
+		assert.Equal(t, null.IntFrom(18), e.Executor.GetVULimit())
+		assert.Equal(t, 1.0, exceeded()-base)
+
+		e.checkMemoryLimit(now.Add(MemoryLimitCooldown / 2))
+		assert.Equal(t, int64(18), e.Executor.GetVUs())
+
+		e.checkMemoryLimit(now.Add(MemoryLimitCooldown))
+		assert.Equal(t, int64(17), e.Executor.GetVUs())
+		assert.Equal(t, 2.0, exceeded()-base)
+	})
+	t.Run("Restore", func(t *testing.T) {
+		e := newEngine(t, "")
+		now := time.Now()
+		e.checkMemoryLimit(now)
+		now = now.Add(MemoryLimitCooldown)
+		e.checkMemoryLimit(now)
+		assert.Equal(t, int64(17), e.Executor.GetVUs())
+
+		// Just under the limit isn't enough to give VUs back.
+		e.memoryUsage = func() uint64 { return 950 }
+		now = now.Add(MemoryLimitCooldown)
+		e.checkMemoryLimit(now)
+		assert.Equal(t, int64(17), e.Executor.GetVUs())
+		assert.Equal(t, null.IntFrom(17), e.Executor.GetVULimit())
+
+		e.memoryUsage = func() uint64 { return 500 }
+		now = now.Add(MemoryLimitCooldown)
+		e.checkMemoryLimit(now)
+		assert.Equal(t, int64(18), e.Executor.GetVUs())
+		assert.Equal(t, null.IntFrom(18), e.Executor.GetVULimit())
+
+		e.checkMemoryLimit(now.Add(MemoryLimitCooldown / 2))
+		assert.Equal(t, int64(18), e.Executor.GetVUs())
+
+		now = now.Add(MemoryLimitCooldown)
+		e.checkMemoryLimit(now)
+		now = now.Add(MemoryLimitCooldown)
+		e.checkMemoryLimit(now)
+		assert.Equal(t, int64(20), e.Executor.GetVUs())
+		assert.Equal(t, null.Int{}, e.Executor.GetVULimit())
+		assert.False(t, e.memoryShed)
+	})
+	t.Run("UnderLimit", func(t *testing.T) {
+		e, base := newEngine(t, ""), exceeded()
+		e.memoryUsage = func() uint64 { return 1000 }
+		e.checkMemoryLimit(time.Now())
+		assert.Equal(t, int64(20), e.Executor.GetVUs())
+		assert.Equal(t, 0.0, exceeded()-base)
+	})
+	t.Run("Warn", func(t *testing.T) {
+		e, base := newEngine(t, lib.MemoryLimitWarn), exceeded()
+		e.checkMemoryLimit(time.Now())
+		assert.Equal(t, int64(20), e.Executor.GetVUs())
+		assert.Equal(t, 1.0, exceeded()-base)
+	})
+	t.Run("Abort", func(t *testing.T) {
+		e, base := newEngine(t, lib.MemoryLimitAbort), exceeded()
+		e.checkMemoryLimit(time.Now())
+		assert.Equal(t, lib.NullDurationFrom(0), e.Executor.GetEndTime())
+		assert.Equal(t, 1.0, exceeded()-base)
+	})
+}
+
+func TestEngineRunMarkers(t *testing.T) {
+	e, err, _ := newTestEngine(LF(func(ctx context.Context) ([]stats.Sample, error) {
+		return nil, nil
+	}), lib.Options{
+		VUs:        null.IntFrom(1),
+		VUsMax:     null.IntFrom(1),
+		Iterations: null.IntFrom(1),
+		RunMarkers: null.BoolFrom(true),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, e.runID, 32)
+	assert.NoError(t, e.Run(context.Background()))
+	for _, m := range []*stats.Metric{metrics.RunStart, metrics.RunEnd} {
+		if assert.Contains(t, e.Metrics, m.Name) {
+			assert.Equal(t, 1.0, e.Metrics[m.Name].Sink.(*stats.CounterSink).Value, m.Name)
+		}
+	}
+
+	t.Run("RunID", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, lib.Options{
+			VUs:        null.IntFrom(2),
+			VUsMax:     null.IntFrom(5),
+			RunMarkers: null.BoolFrom(true),
+			RunID:      null.StringFrom("my-run"),
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+		sample := e.runMarker(metrics.RunStart)
+		assert.Equal(t, metrics.RunStart, sample.Metric)
+		assert.Equal(t, "my-run", sample.Tags["run_id"])
+		assert.JSONEq(t,
+			`{"vus":2,"vusMax":5,"duration":null,"iterations":null,"stages":null}`,
+			sample.Tags["options"])
+	})
+	t.Run("Disabled", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, lib.Options{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, "", e.runID)
+		}
+	})
+}
+
+func TestEnginePercentiles(t *testing.T) {
+	percentiles := func(samples []stats.Sample) map[string]float64 {
+		values := make(map[string]float64)
+		for _, s := range samples {
+			values[s.Metric.Name] = s.Value
+		}
+		return values
+	}
+
+	testdata := map[string]struct {
+		window string
+		second map[string]float64
+	}{
+		"cumulative": {lib.PercentileWindowCumulative, map[string]float64{
+			"my_trend_p50": 101, "my_trend_p90": 181, "my_trend_p95": 191, "my_trend_p99": 199,
+		}},
+		"interval": {lib.PercentileWindowInterval, map[string]float64{
+			"my_trend_p50": 151, "my_trend_p90": 191, "my_trend_p95": 196, "my_trend_p99": 200,
+		}},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			e, err, _ := newTestEngine(nil, lib.Options{
+				PercentileInterval: lib.NullDurationFrom(10 * time.Second),
+				PercentileWindow:   null.StringFrom(data.window),
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+			metric := stats.New("my_trend", stats.Trend, stats.Time)
+			add := func(from, to int) {
+				for v := from; v <= to; v++ {
+					e.processSamples(stats.Sample{Metric: metric, Value: float64(v)})
+				}
+			}
+			add(1, 100)
+			assert.Equal(t, map[string]float64{
+				"my_trend_p50": 51, "my_trend_p90": 91, "my_trend_p95": 96, "my_trend_p99": 100,
+			}, percentiles(e.percentileSamples(time.Now())))
+
+			add(101, 200)
+			assert.Equal(t, data.second, percentiles(e.percentileSamples(time.Now())))
+			assert.IsType(t, &stats.GaugeSink{}, e.percentileMetrics["my_trend_p95"].Sink)
+			assert.NotContains(t, e.Metrics, "my_trend_p95")
+		})
+	}
+
+	t.Run("Collected", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, lib.Options{
+			PercentileInterval: lib.NullDurationFrom(10 * time.Second),
+			Variant:            null.StringFrom("b"),
+			TimestampPrecision: null.StringFrom(lib.TimestampPrecisionMilliseconds),
+			MetricPrefix:       null.StringFrom("k6_"),
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+		e.processSamples(stats.Sample{Metric: stats.New("my_trend", stats.Trend), Value: 1})
+
+		// processSamples() updates the samples it's given to what the collector gets.
+		samples := e.percentileSamples(time.Unix(10, int64(1500*time.Microsecond)))
+		e.processSamples(samples...)
+		if assert.Len(t, samples, len(lib.PeriodicPercentiles)) {
+			for _, sample := range samples {
+				assert.Equal(t, time.Unix(10, int64(time.Millisecond)), sample.Time)
+				assert.Equal(t, map[string]string{"variant": "b"}, sample.Tags)
+				assert.Contains(t, sample.Metric.Name, "k6_my_trend_p")
+			}
+		}
+		assert.NotContains(t, e.Metrics, "my_trend_p95")
+	})
+}
+
 func TestEngineAtTime(t *testing.T) {
 	e, err, _ := newTestEngine(nil, lib.Options{})
 	assert.NoError(t, err)
@@ -292,6 +803,40 @@ func TestEngineAtTime(t *testing.T) {
 	assert.NoError(t, e.Run(ctx))
 }
 
+func TestEngineSampleBuffer(t *testing.T) {
+	for _, policy := range []string{lib.InFlightSamplesBlock, lib.InFlightSamplesDrop} {
+		t.Run(policy, func(t *testing.T) {
+			testMetric := stats.New("test_metric", stats.Counter)
+			e, err, _ := newTestEngine(LF(func(ctx context.Context) ([]stats.Sample, error) {
+				return []stats.Sample{{Metric: testMetric, Time: time.Now(), Value: 1}}, nil
+			}), lib.Options{
+				VUs:                   null.IntFrom(2),
+				VUsMax:                null.IntFrom(2),
+				Iterations:            null.IntFrom(100),
+				MaxInFlightSamples:    null.IntFrom(10),
+				InFlightSamplesPolicy: null.StringFrom(policy),
+			})
+			assert.NoError(t, err)
+
+			// Builtin metrics (and their sinks) are shared by every engine in the process.
+			droppedBefore := metrics.DroppedSamples.Sink.(*stats.CounterSink).Value
+			assert.NoError(t, e.Run(context.Background()))
+
+			var processed float64
+			if m := e.Metrics["test_metric"]; assert.NotNil(t, m) {
+				processed = m.Sink.(*stats.CounterSink).Value
+			}
+			dropped := metrics.DroppedSamples.Sink.(*stats.CounterSink).Value - droppedBefore
+			if policy == lib.InFlightSamplesBlock {
+				assert.Equal(t, float64(100), processed)
+				assert.Zero(t, dropped)
+			} else {
+				assert.Equal(t, float64(100), processed+dropped)
+			}
+		})
+	}
+}
+
 func TestEngineCollector(t *testing.T) {
 	testMetric := stats.New("test_metric", stats.Trend)
 
@@ -358,6 +903,337 @@ func TestEngine_processSamples(t *testing.T) {
 		assert.IsType(t, &stats.GaugeSink{}, e.Metrics["my_metric"].Sink)
 		assert.IsType(t, &stats.GaugeSink{}, e.Metrics["my_metric{a:1}"].Sink)
 	})
+	t.Run("warmup", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, lib.Options{WarmupDuration: lib.NullDurationFrom(10 * time.Second)})
+		assert.NoError(t, err)
+
+		warmupMetric := stats.New("my_warmup_metric", stats.Gauge)
+		tags := map[string]string{"a": "1"}
+		samples := []stats.Sample{{Metric: warmupMetric, Value: 1.25, Tags: tags}}
+		e.processSamples(samples...)
+
+		assert.Equal(t, float64(0), e.Metrics["my_warmup_metric"].Sink.(*stats.GaugeSink).Value)
+		assert.Equal(t, map[string]string{"a": "1", "warmup": "true"}, samples[0].Tags)
+		assert.Equal(t, map[string]string{"a": "1"}, tags)
+	})
+	t.Run("warmup by sample time", func(t *testing.T) {
+		ex := timedExecutor{local.New(nil), 12 * time.Second}
+		e, err, _ := newTestEngine(ex, lib.Options{WarmupDuration: lib.NullDurationFrom(10 * time.Second)})
+		assert.NoError(t, err)
+
+		warmupMetric := stats.New("my_late_warmup_metric", stats.Counter)
+		now := time.Now()
+		samples := []stats.Sample{
+			{Metric: warmupMetric, Time: now.Add(-5 * time.Second), Value: 1},
+			{Metric: warmupMetric, Time: now, Value: 2},
+		}
+		e.processSamples(samples...)
+
+		assert.Equal(t, "true", samples[0].Tags["warmup"])
+		assert.NotContains(t, samples[1].Tags, "warmup")
+		assert.Equal(t, 2.0, e.Metrics["my_late_warmup_metric"].Sink.(*stats.CounterSink).Value)
+	})
+	t.Run("variant", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, lib.Options{
+			Variant:    null.StringFrom("canary"),
+			Thresholds: map[string]stats.Thresholds{"my_variant_metric{variant:canary}": {}},
+		})
+		assert.NoError(t, err)
+
+		variantMetric := stats.New("my_variant_metric", stats.Gauge)
+		tags := map[string]string{"a": "1"}
+		samples := []stats.Sample{{Metric: variantMetric, Value: 1.25, Tags: tags}}
+		e.processSamples(samples...)
+
+		assert.Equal(t, map[string]string{"a": "1", "variant": "canary"}, samples[0].Tags)
+		assert.Equal(t, map[string]string{"a": "1"}, tags)
+		if assert.Contains(t, e.Metrics, "my_variant_metric{variant:canary}") {
+			sink := e.Metrics["my_variant_metric{variant:canary}"].Sink.(*stats.GaugeSink)
+			assert.Equal(t, 1.25, sink.Value)
+		}
+	})
+	t.Run("stage", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, lib.Options{
+			SummaryPerStage: null.BoolFrom(true),
+			Stages: []lib.Stage{
+				{Duration: lib.NullDurationFrom(10 * time.Second), Target: null.IntFrom(10)},
+				{Duration: lib.NullDurationFrom(10 * time.Second), Target: null.IntFrom(20)},
+			},
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, e.submetrics["http_reqs"], 2) {
+			assert.Equal(t, "http_reqs{stage:1}", e.submetrics["http_reqs"][1].Name)
+		}
+
+		reqs := stats.New("http_reqs", stats.Counter)
+		samples := []stats.Sample{{Metric: reqs, Value: 1, Tags: map[string]string{"a": "1"}}}
+		e.processSamples(samples...)
+
+		assert.Equal(t, map[string]string{"a": "1", "stage": "0"}, samples[0].Tags)
+		if assert.Contains(t, e.Metrics, "http_reqs{stage:0}") {
+			assert.Equal(t, 1.0, e.Metrics["http_reqs{stage:0}"].Sink.(*stats.CounterSink).Value)
+		}
+		assert.NotContains(t, e.Metrics, "http_reqs{stage:1}")
+
+		t.Run("sample time", func(t *testing.T) {
+			ex := timedExecutor{local.New(nil), 12 * time.Second}
+			e, err, _ := newTestEngine(ex, lib.Options{
+				SummaryPerStage: null.BoolFrom(true),
+				Stages: []lib.Stage{
+					{Duration: lib.NullDurationFrom(10 * time.Second), Target: null.IntFrom(10)},
+					{Duration: lib.NullDurationFrom(10 * time.Second), Target: null.IntFrom(20)},
+				},
+			})
+			assert.NoError(t, err)
+
+			now := time.Now()
+			samples := []stats.Sample{
+				{Metric: reqs, Time: now.Add(-5 * time.Second), Value: 1},
+				{Metric: reqs, Time: now, Value: 1},
+			}
+			e.processSamples(samples...)
+
+			assert.Equal(t, "0", samples[0].Tags["stage"])
+			assert.Equal(t, "1", samples[1].Tags["stage"])
+		})
+	})
+	t.Run("metric prefix", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, lib.Options{MetricPrefix: null.StringFrom("test_")})
+		assert.NoError(t, err)
+
+		prefixMetric := stats.New("my_prefix_metric", stats.Gauge)
+		samples := []stats.Sample{{Metric: prefixMetric, Value: 1.25}}
+		e.processSamples(samples...)
+
+		assert.Equal(t, "test_my_prefix_metric", samples[0].Metric.Name)
+		assert.Equal(t, "my_prefix_metric", prefixMetric.Name)
+		assert.Equal(t, 1.25, e.Metrics["my_prefix_metric"].Sink.(*stats.GaugeSink).Value)
+
+		samples2 := []stats.Sample{{Metric: prefixMetric, Value: 2}}
+		e.processSamples(samples2...)
+		assert.True(t, samples[0].Metric == samples2[0].Metric, "prefixed metric wasn't reused")
+	})
+	t.Run("gauge reset", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, lib.Options{GaugeReset: map[string]string{"my_reset_metric": "interval"}})
+		assert.NoError(t, err)
+
+		resetMetric := stats.New("my_reset_metric", stats.Gauge)
+		keptMetric := stats.New("my_kept_metric", stats.Gauge)
+		e.processSamples(
+			stats.Sample{Metric: resetMetric, Value: 5, Tags: map[string]string{"a": "1"}},
+			stats.Sample{Metric: keptMetric, Value: 5},
+		)
+		resetSink := e.Metrics["my_reset_metric"].Sink.(*stats.GaugeSink)
+		keptSink := e.Metrics["my_kept_metric"].Sink.(*stats.GaugeSink)
+
+		// Set during the first interval, so it's only reset after the second one.
+		e.emitMetrics()
+		assert.Equal(t, float64(5), resetSink.Value)
+		e.emitMetrics()
+		assert.Equal(t, float64(0), resetSink.Value)
+		assert.Equal(t, float64(5), keptSink.Value)
+
+		// Setting it again starts over.
+		e.processSamples(stats.Sample{Metric: resetMetric, Value: 3, Tags: map[string]string{"a": "1"}})
+		e.emitMetrics()
+		assert.Equal(t, float64(3), resetSink.Value)
+		e.emitMetrics()
+		assert.Equal(t, float64(0), resetSink.Value)
+	})
+	t.Run("tag cardinality limits", func(t *testing.T) {
+		e, err, hook := newTestEngine(nil, lib.Options{TagCardinalityLimits: map[string]int64{"url": 2}})
+		assert.NoError(t, err)
+
+		capMetric := stats.New("my_capped_metric", stats.Counter)
+		var samples []stats.Sample
+		for _, url := range []string{"/a", "/b", "/a", "/c", "/d", "/b"} {
+			tags := map[string]string{"url": url, "method": "GET"}
+			samples = append(samples, stats.Sample{Metric: capMetric, Value: 1, Tags: tags})
+		}
+		e.processSamples(samples...)
+
+		var urls []string
+		for _, sample := range samples {
+			urls = append(urls, sample.Tags["url"])
+			assert.Equal(t, "GET", sample.Tags["method"])
+		}
+		assert.Equal(t, []string{"/a", "/b", "/a", lib.TagOverflowValue, lib.TagOverflowValue, "/b"}, urls)
+
+		var warnings int
+		for _, entry := range hook.Entries {
+			if entry.Level == log.WarnLevel {
+				warnings++
+			}
+		}
+		assert.Equal(t, 1, warnings)
+	})
+	t.Run("timestamp precision", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, lib.Options{TimestampPrecision: null.StringFrom("ms")})
+		assert.NoError(t, err)
+
+		now := time.Date(2017, 1, 1, 12, 0, 0, 123456789, time.UTC)
+		samples := []stats.Sample{{Metric: metric, Value: 1.25, Time: now}}
+		e.processSamples(samples...)
+		assert.Equal(t, time.Date(2017, 1, 1, 12, 0, 0, 123000000, time.UTC), samples[0].Time)
+	})
+}
+
+// Compares the cost of processing samples for a metric with a few thresholds on submetrics, with
+// and without NoThresholds.
+func BenchmarkEngineProcessSamples(b *testing.B) {
+	ths, err := stats.NewThresholds([]string{`1+1==2`})
+	assert.NoError(b, err)
+	thresholds := map[string]stats.Thresholds{
+		"my_bench_metric":                ths,
+		"my_bench_metric{status:200}":    ths,
+		"my_bench_metric{status:500}":    ths,
+		"my_bench_metric{method:GET}":    ths,
+		"my_bench_metric{name:http://x}": ths,
+	}
+	for _, noThresholds := range []bool{false, true} {
+		b.Run(fmt.Sprintf("noThresholds=%v", noThresholds), func(b *testing.B) {
+			e, err, _ := newTestEngine(nil, lib.Options{
+				Thresholds:   thresholds,
+				NoThresholds: null.BoolFrom(noThresholds),
+			})
+			assert.NoError(b, err)
+
+			metric := stats.New("my_bench_metric", stats.Trend)
+			tags := map[string]string{"status": "200", "method": "GET", "name": "http://x"}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				e.processSamples(stats.Sample{Metric: metric, Value: float64(i), Tags: tags})
+			}
+		})
+	}
+}
+
+func TestEngineChecksFailed(t *testing.T) {
+	e, err, _ := newTestEngine(nil, lib.Options{})
+	assert.NoError(t, err)
+	assert.False(t, e.ChecksFailed())
+
+	checks := stats.New(metrics.Checks.Name, stats.Rate)
+	e.processSamples(stats.Sample{Metric: checks, Value: 1})
+	assert.False(t, e.ChecksFailed())
+
+	e.processSamples(stats.Sample{Metric: checks, Value: 0})
+	assert.True(t, e.ChecksFailed())
+}
+
+func TestEngineConnReuseMetrics(t *testing.T) {
+	e, err, _ := newTestEngine(nil, lib.Options{ConnReuseMetrics: null.BoolFrom(true)})
+	if !assert.NoError(t, err) {
+		return
+	}
+	reused := stats.New(metrics.HTTPReqConnReused.Name, stats.Rate)
+	for _, s := range []struct {
+		host  string
+		value float64
+	}{{"a.example.com", 0}, {"a.example.com", 1}, {"a.example.com", 1}, {"b.example.com", 0}} {
+		e.processSamples(stats.Sample{Metric: reused, Tags: map[string]string{"host": s.host}, Value: s.value})
+	}
+
+	rates := map[string][2]int64{
+		"http_req_conn_reused":                     {2, 4},
+		"http_req_conn_reused{host:a.example.com}": {2, 3},
+		"http_req_conn_reused{host:b.example.com}": {0, 1},
+	}
+	for name, rate := range rates {
+		if assert.Contains(t, e.Metrics, name) {
+			sink := e.Metrics[name].Sink.(*stats.RateSink)
+			assert.Equal(t, rate, [2]int64{sink.Trues, sink.Total}, name)
+		}
+	}
+	assert.Len(t, e.hostSubmetrics, 2)
+	assert.Empty(t, e.Metrics["http_req_conn_reused"].Submetrics)
+
+	t.Run("Limit", func(t *testing.T) {
+		for name, limits := range map[string]map[string]int64{
+			"Default":              nil,
+			"TagCardinalityLimits": {"host": 2},
+		} {
+			t.Run(name, func(t *testing.T) {
+				e, err, hook := newTestEngine(nil, lib.Options{
+					ConnReuseMetrics:     null.BoolFrom(true),
+					TagCardinalityLimits: limits,
+				})
+				if !assert.NoError(t, err) {
+					return
+				}
+				if limits == nil {
+					e.hostSubmetricLimit = 2
+				}
+				reused := stats.New(metrics.HTTPReqConnReused.Name, stats.Rate)
+				for _, host := range []string{"a", "b", "c", "d", "a"} {
+					e.processSamples(stats.Sample{Metric: reused, Tags: map[string]string{"host": host}, Value: 1})
+				}
+
+				assert.Len(t, e.hostSubmetrics, 3)
+				for name, total := range map[string]int64{
+					"http_req_conn_reused{host:a}":         2,
+					"http_req_conn_reused{host:b}":         1,
+					"http_req_conn_reused{host:__other__}": 2,
+				} {
+					if assert.Contains(t, e.Metrics, name) {
+						assert.Equal(t, total, e.Metrics[name].Sink.(*stats.RateSink).Total, name)
+					}
+				}
+				assert.NotContains(t, e.Metrics, "http_req_conn_reused{host:c}")
+				assert.Len(t, hook.Entries, 1)
+			})
+		}
+	})
+}
+
+func TestEngineCheckPassRate(t *testing.T) {
+	e, err, _ := newTestEngine(nil, lib.Options{})
+	assert.NoError(t, err)
+	_, ok := e.CheckPassRate()
+	assert.False(t, ok)
+
+	checks := stats.New(metrics.Checks.Name, stats.Rate)
+	for _, v := range []float64{1, 1, 1, 0} {
+		e.processSamples(stats.Sample{Metric: checks, Value: v})
+	}
+	rate, ok := e.CheckPassRate()
+	assert.True(t, ok)
+	assert.Equal(t, 0.75, rate)
+}
+
+func TestEngineThresholdsHTTPReqWaiting(t *testing.T) {
+	testdata := map[string]struct {
+		pass bool
+		ths  map[string][]string
+	}{
+		"passing":                 {true, map[string][]string{"http_req_waiting": {"p(95)<500"}}},
+		"failing":                 {false, map[string][]string{"http_req_waiting": {"p(95)<100"}}},
+		"submetric,match,failing": {false, map[string][]string{"http_req_waiting{status:200}": {"max<100"}}},
+		"submetric,nomatch":       {true, map[string][]string{"http_req_waiting{status:404}": {"max<100"}}},
+	}
+
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			thresholds := make(map[string]stats.Thresholds, len(data.ths))
+			for m, srcs := range data.ths {
+				ths, err := stats.NewThresholds(srcs)
+				assert.NoError(t, err)
+				thresholds[m] = ths
+			}
+
+			e, err, _ := newTestEngine(nil, lib.Options{Thresholds: thresholds})
+			assert.NoError(t, err)
+
+			trail := netext.Trail{
+				Duration: 300 * time.Millisecond,
+				Waiting:  250 * time.Millisecond,
+			}
+			e.processSamples(trail.Samples(map[string]string{"status": "200"})...)
+			e.processThresholds()
+
+			assert.Equal(t, data.pass, !e.IsTainted())
+		})
+	}
 }
 
 func TestEngine_processThresholds(t *testing.T) {
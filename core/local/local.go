@@ -23,6 +23,7 @@ package local
 import (
 	"context"
 	"fmt"
+	"math/rand"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -36,20 +37,44 @@ import (
 )
 
 var _ lib.Executor = &Executor{}
+var _ lib.RunClock = &Executor{}
 
 type vuHandle struct {
 	sync.RWMutex
 	vu     lib.VU
 	ctx    context.Context
 	cancel context.CancelFunc
+
+	// Has the VU stopped after running its maximum number of iterations?
+	done bool
 }
 
-func (h *vuHandle) run(logger *log.Logger, flow <-chan int64, out chan<- []stats.Sample) {
+func (h *vuHandle) run(logger *log.Logger, flow <-chan int64, out chan<- []stats.Sample, delay time.Duration, maxIters int64, think *lib.ThinkTime, thinkRand *rand.Rand) {
 	h.RLock()
 	ctx := h.ctx
 	h.RUnlock()
 
-	for {
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+
+	for iters := int64(0); maxIters < 0 || iters < maxIters; iters++ {
+		if iters > 0 && think != nil {
+			timer := time.NewTimer(think.Delay(thinkRand))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+
 		select {
 		case _, ok := <-flow:
 			if !ok {
@@ -77,6 +102,10 @@ func (h *vuHandle) run(logger *log.Logger, flow <-chan int64, out chan<- []stats
 		}
 		out <- samples
 	}
+
+	h.Lock()
+	h.done = true
+	h.Unlock()
 }
 
 type Executor struct {
@@ -91,22 +120,44 @@ type Executor struct {
 	numVUs    int64
 	numVUsMax int64
 	nextVUID  int64
+	vuLimit   int64 // Hold numVUs to at most this many, if not negative
 
-	iters     int64 // Completed iterations
-	partIters int64 // Partial, incomplete iterations
-	endIters  int64 // End test at this many iterations
+	iters           int64 // Completed iterations
+	partIters       int64 // Partial, incomplete iterations
+	endIters        int64 // End test at this many iterations
+	maxItersPerVU   int64 // Stop each VU after this many iterations
+	preAllocVUs     int64 // Initialise only this many VUs up front
+	initConcurrency int64 // Initialise at most this many VUs at the same time
 
-	time    int64 // Current time
-	endTime int64 // End test at this timestamp
+	time     int64 // Current time
+	endTime  int64 // End test at this timestamp
+	deadline int64 // End test at this wall-clock time, in Unix nanoseconds
 
 	pauseLock sync.RWMutex
 	pause     chan interface{}
 
 	stages []lib.Stage
 
-	// Lock for: ctx, flow, out
+	// Random delay for starting VUs; the RNG is guarded by vusLock.
+	startJitter *lib.VUStartJitter
+	jitterRand  *rand.Rand
+
+	// Random pause between iterations; each VU gets its own RNG, seeded from this one.
+	thinkTime *lib.ThinkTime
+	thinkRand *rand.Rand
+
+	// Lock for: ctx, flow, out, vuCancellation, vusMaxPolicy, paceIters
 	lock sync.RWMutex
 
+	// How VUs react to the test being stopped; see lib.Options.VUCancellation.
+	vuCancellation string
+
+	// What happens when a stage targets more VUs than the cap; see lib.Options.VUsMaxPolicy.
+	vusMaxPolicy string
+
+	// Whether to spread endIters evenly up to endTime; see lib.Options.PaceIterations.
+	paceIters bool
+
 	// Current context, nil if a test isn't running right now.
 	ctx context.Context
 
@@ -119,10 +170,14 @@ type Executor struct {
 
 func New(r lib.Runner) *Executor {
 	return &Executor{
-		Runner:   r,
-		Logger:   log.StandardLogger(),
-		endIters: -1,
-		endTime:  -1,
+		Runner:        r,
+		Logger:        log.StandardLogger(),
+		endIters:      -1,
+		maxItersPerVU: -1,
+		preAllocVUs:   -1,
+		vuLimit:       -1,
+		endTime:       -1,
+		deadline:      -1,
 	}
 }
 
@@ -130,7 +185,7 @@ func (e *Executor) Run(parent context.Context, out chan<- []stats.Sample) error
 	e.runLock.Lock()
 	defer e.runLock.Unlock()
 
-	ctx, cancel := context.WithCancel(parent)
+	ctx, cancel := context.WithCancel(lib.WithRunClock(parent, e))
 	vuOut := make(chan []stats.Sample)
 	vuFlow := make(chan int64)
 
@@ -138,6 +193,7 @@ func (e *Executor) Run(parent context.Context, out chan<- []stats.Sample) error
 	e.ctx = ctx
 	e.out = vuOut
 	e.flow = vuFlow
+	finishRequests := e.vuCancellation == lib.VUCancellationFinishRequest
 	e.lock.Unlock()
 
 	var cutoff time.Time
@@ -162,7 +218,8 @@ func (e *Executor) Run(parent context.Context, out chan<- []stats.Sample) error
 			select {
 			case ss := <-vuOut:
 				for _, s := range ss {
-					if cutoff.IsZero() || s.Time.Before(cutoff) {
+					// VUs allowed to finish their requests may legitimately report them late.
+					if finishRequests || cutoff.IsZero() || s.Time.Before(cutoff) {
 						samples = append(samples, s)
 					}
 				}
@@ -188,6 +245,10 @@ func (e *Executor) Run(parent context.Context, out chan<- []stats.Sample) error
 	ticker := time.NewTicker(1 * time.Millisecond)
 	defer ticker.Stop()
 
+	// Whether to hold stage targets to the vu cap, and whether we've warned about doing so.
+	clamp, clamped := e.GetVUsMaxPolicy() == lib.VUsMaxClamp, false
+	pace := e.GetPaceIterations()
+
 	lastTick := time.Now()
 	for {
 		// If the test is paused, sleep until either the pause or the test ends.
@@ -198,10 +259,25 @@ func (e *Executor) Run(parent context.Context, out chan<- []stats.Sample) error
 		if pause != nil {
 			e.Logger.Debug("Local: Pausing!")
 			leftovers := time.Since(lastTick)
+
+			// The deadline is wall-clock time, so it can pass while paused.
+			var deadlineTimer *time.Timer
+			var deadlineC <-chan time.Time
+			if deadline := atomic.LoadInt64(&e.deadline); deadline >= 0 {
+				deadlineTimer = time.NewTimer(time.Until(time.Unix(0, deadline)))
+				deadlineC = deadlineTimer.C
+			}
+
 			select {
 			case <-pause:
 				e.Logger.Debug("Local: No longer paused")
 				lastTick = time.Now().Add(-leftovers)
+				if deadlineTimer != nil {
+					deadlineTimer.Stop()
+				}
+			case <-deadlineC:
+				e.Logger.Debug("Local: Hit deadline while in paused state")
+				return nil
 			case <-ctx.Done():
 				e.Logger.Debug("Local: Terminated while in paused state")
 				return nil
@@ -216,6 +292,12 @@ func (e *Executor) Run(parent context.Context, out chan<- []stats.Sample) error
 		partials := atomic.LoadInt64(&e.partIters)
 		if end >= 0 && partials >= end {
 			flow = nil
+		} else if endTime := atomic.LoadInt64(&e.endTime); pace && end > 0 && endTime > 0 {
+			// Hold off the next iteration until it's due; the ticker below re-checks.
+			due := time.Duration(float64(partials) * float64(endTime) / float64(end))
+			if time.Duration(atomic.LoadInt64(&e.time)) < due {
+				flow = nil
+			}
 		}
 
 		select {
@@ -236,6 +318,11 @@ func (e *Executor) Run(parent context.Context, out chan<- []stats.Sample) error
 				cutoff = time.Now()
 				return nil
 			}
+			if deadline := atomic.LoadInt64(&e.deadline); deadline >= 0 && t.UnixNano() >= deadline {
+				e.Logger.WithField("deadline", time.Unix(0, deadline)).Debug("Local: Hit deadline")
+				cutoff = time.Now()
+				return nil
+			}
 
 			stages := e.stages
 			if stages != nil {
@@ -245,11 +332,24 @@ func (e *Executor) Run(parent context.Context, out chan<- []stats.Sample) error
 					cutoff = time.Now()
 					return nil
 				}
+				if max := atomic.LoadInt64(&e.numVUsMax); clamp && vus.Valid && vus.Int64 > max {
+					if !clamped {
+						e.Logger.WithFields(log.Fields{"target": vus.Int64, "vus_max": max}).Warn(
+							"Stage targets more VUs than the vu cap; holding them to it")
+						clamped = true
+					}
+					vus.Int64 = max
+				}
 				if vus.Valid {
 					if err := e.SetVUs(vus.Int64); err != nil {
 						return err
 					}
 				}
+			} else if e.allVUsDone() {
+				// Without stages, there's nothing that's going to start any more VUs.
+				e.Logger.WithField("at", at).Debug("Local: All VUs hit their iteration limit")
+				cutoff = time.Now()
+				return nil
 			}
 		case samples := <-vuOut:
 			// Every iteration ends with a write to vuOut. Check if we've hit the end point.
@@ -298,21 +398,46 @@ func (e *Executor) scale(ctx context.Context, num int64) error {
 
 		if i < int(num) {
 			if cancel == nil {
+				// VUs that weren't preallocated are initialised the first time they're needed.
+				// The handle isn't marked as running until its VU is ready, so one that fails
+				// here stays stopped, and is picked up again by the next scale.
+				if handle.vu == nil && e.Runner != nil {
+					vu, err := e.Runner.NewVU()
+					if err != nil {
+						return err
+					}
+					handle.Lock()
+					handle.vu = vu
+					handle.Unlock()
+				}
+				if handle.vu != nil {
+					if err := handle.vu.Reconfigure(atomic.AddInt64(&e.nextVUID, 1)); err != nil {
+						return err
+					}
+				}
+
 				vuctx, cancel := context.WithCancel(ctx)
 				handle.Lock()
 				handle.ctx = vuctx
 				handle.cancel = cancel
+				handle.done = false
 				handle.Unlock()
 
-				if handle.vu != nil {
-					if err := handle.vu.Reconfigure(atomic.AddInt64(&e.nextVUID, 1)); err != nil {
-						return err
-					}
+				var delay time.Duration
+				if e.startJitter != nil {
+					delay = e.startJitter.Delay(e.jitterRand)
 				}
 
+				var vuThinkRand *rand.Rand
+				if e.thinkTime != nil {
+					vuThinkRand = rand.New(rand.NewSource(e.thinkRand.Int63()))
+				}
+
+				maxIters := atomic.LoadInt64(&e.maxItersPerVU)
+				think := e.thinkTime
 				e.wg.Add(1)
 				go func() {
-					handle.run(e.Logger, flow, out)
+					handle.run(e.Logger, flow, out, delay, maxIters, think, vuThinkRand)
 					e.wg.Done()
 				}()
 			}
@@ -328,6 +453,63 @@ func (e *Executor) scale(ctx context.Context, num int64) error {
 	return nil
 }
 
+// Initialises a VU for each of the handles, at most initConcurrency at a time. If any fail, the first
+// error is returned once all the others are done.
+func (e *Executor) initVUs(handles []*vuHandle) error {
+	concurrency := atomic.LoadInt64(&e.initConcurrency)
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	slots := make(chan struct{}, concurrency)
+	errs := make(chan error, len(handles))
+	var wg sync.WaitGroup
+	for _, handle := range handles {
+		handle := handle
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			vu, err := e.Runner.NewVU()
+			if err != nil {
+				errs <- err
+				return
+			}
+			handle.vu = vu
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// Returns true if every active VU has stopped after running its maximum number of iterations.
+func (e *Executor) allVUsDone() bool {
+	if atomic.LoadInt64(&e.maxItersPerVU) < 0 {
+		return false
+	}
+
+	e.vusLock.RLock()
+	defer e.vusLock.RUnlock()
+
+	num := atomic.LoadInt64(&e.numVUs)
+	if num <= 0 || num > int64(len(e.vus)) {
+		return false
+	}
+	for _, handle := range e.vus[:num] {
+		handle.RLock()
+		done := handle.done
+		handle.RUnlock()
+		if !done {
+			return false
+		}
+	}
+	return true
+}
+
 func (e *Executor) IsRunning() bool {
 	e.lock.RLock()
 	defer e.lock.RUnlock()
@@ -354,6 +536,40 @@ func (e *Executor) SetStages(s []lib.Stage) {
 	e.stages = s
 }
 
+func (e *Executor) GetVUStartJitter() *lib.VUStartJitter {
+	e.vusLock.RLock()
+	defer e.vusLock.RUnlock()
+	return e.startJitter
+}
+
+func (e *Executor) SetVUStartJitter(j *lib.VUStartJitter) {
+	e.vusLock.Lock()
+	defer e.vusLock.Unlock()
+
+	e.startJitter = j
+	e.jitterRand = nil
+	if j != nil {
+		e.jitterRand = j.NewRand()
+	}
+}
+
+func (e *Executor) GetThinkTime() *lib.ThinkTime {
+	e.vusLock.RLock()
+	defer e.vusLock.RUnlock()
+	return e.thinkTime
+}
+
+func (e *Executor) SetThinkTime(t *lib.ThinkTime) {
+	e.vusLock.Lock()
+	defer e.vusLock.Unlock()
+
+	e.thinkTime = t
+	e.thinkRand = nil
+	if t != nil {
+		e.thinkRand = t.NewRand()
+	}
+}
+
 func (e *Executor) GetIterations() int64 {
 	return atomic.LoadInt64(&e.iters)
 }
@@ -374,6 +590,90 @@ func (e *Executor) SetEndIterations(i null.Int) {
 	atomic.StoreInt64(&e.endIters, i.Int64)
 }
 
+func (e *Executor) GetMaxIterationsPerVU() null.Int {
+	v := atomic.LoadInt64(&e.maxItersPerVU)
+	if v < 0 {
+		return null.Int{}
+	}
+	return null.IntFrom(v)
+}
+
+func (e *Executor) SetMaxIterationsPerVU(i null.Int) {
+	if !i.Valid {
+		i.Int64 = -1
+	}
+	e.Logger.WithField("i", i.Int64).Debug("Local: Setting max iterations per VU")
+	atomic.StoreInt64(&e.maxItersPerVU, i.Int64)
+}
+
+func (e *Executor) GetPaceIterations() bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.paceIters
+}
+
+func (e *Executor) SetPaceIterations(pace bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.paceIters = pace
+}
+
+func (e *Executor) GetVUCancellation() string {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.vuCancellation
+}
+
+func (e *Executor) SetVUCancellation(mode string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.vuCancellation = mode
+}
+
+func (e *Executor) GetVUsMaxPolicy() string {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.vusMaxPolicy
+}
+
+func (e *Executor) SetVUsMaxPolicy(policy string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.vusMaxPolicy = policy
+}
+
+func (e *Executor) GetPreAllocatedVUs() null.Int {
+	v := atomic.LoadInt64(&e.preAllocVUs)
+	if v < 0 {
+		return null.Int{}
+	}
+	return null.IntFrom(v)
+}
+
+func (e *Executor) SetPreAllocatedVUs(n null.Int) {
+	if !n.Valid {
+		n.Int64 = -1
+	}
+	e.Logger.WithField("n", n.Int64).Debug("Local: Setting preallocated VUs")
+	atomic.StoreInt64(&e.preAllocVUs, n.Int64)
+}
+
+func (e *Executor) GetVUInitConcurrency() null.Int {
+	v := atomic.LoadInt64(&e.initConcurrency)
+	if v <= 0 {
+		return null.Int{}
+	}
+	return null.IntFrom(v)
+}
+
+func (e *Executor) SetVUInitConcurrency(n null.Int) {
+	if !n.Valid {
+		n.Int64 = 0
+	}
+	e.Logger.WithField("n", n.Int64).Debug("Local: Setting VU init concurrency")
+	atomic.StoreInt64(&e.initConcurrency, n.Int64)
+}
+
 func (e *Executor) GetTime() time.Duration {
 	return time.Duration(atomic.LoadInt64(&e.time))
 }
@@ -394,6 +694,41 @@ func (e *Executor) SetEndTime(t lib.NullDuration) {
 	atomic.StoreInt64(&e.endTime, int64(t.Duration))
 }
 
+func (e *Executor) GetDeadline() lib.NullTime {
+	v := atomic.LoadInt64(&e.deadline)
+	if v < 0 {
+		return lib.NullTime{}
+	}
+	return lib.NullTimeFrom(time.Unix(0, v))
+}
+
+func (e *Executor) SetDeadline(t lib.NullTime) {
+	v := int64(-1)
+	if t.Valid {
+		v = t.UnixNano()
+	}
+	e.Logger.WithField("t", t.Time).Debug("Local: Setting deadline")
+	atomic.StoreInt64(&e.deadline, v)
+}
+
+// Returns the time left until the end time, the end of the stages or the deadline, whichever
+// comes first. Time spent paused isn't accounted for.
+func (e *Executor) GetTimeLeft() (time.Duration, bool) {
+	at := e.GetTime()
+	var left time.Duration
+	ok := false
+	if end := e.GetEndTime(); end.Valid {
+		left, ok = time.Duration(end.Duration)-at, true
+	}
+	if end := lib.SumStages(e.GetStages()); end.Valid && (!ok || time.Duration(end.Duration)-at < left) {
+		left, ok = time.Duration(end.Duration)-at, true
+	}
+	if deadline := e.GetDeadline(); deadline.Valid && (!ok || time.Until(deadline.Time) < left) {
+		left, ok = time.Until(deadline.Time), true
+	}
+	return left, ok
+}
+
 func (e *Executor) IsPaused() bool {
 	e.pauseLock.RLock()
 	defer e.pauseLock.RUnlock()
@@ -422,6 +757,10 @@ func (e *Executor) SetVUs(num int64) error {
 	if num < 0 {
 		return errors.New("vu count can't be negative")
 	}
+	if limit := atomic.LoadInt64(&e.vuLimit); limit >= 0 && num > limit {
+		e.Logger.WithField("limit", limit).Debug("Local: Holding VUs to the VU limit")
+		num = limit
+	}
 
 	if atomic.LoadInt64(&e.numVUs) == num {
 		return nil
@@ -442,6 +781,28 @@ func (e *Executor) SetVUs(num int64) error {
 	return nil
 }
 
+func (e *Executor) GetVULimit() null.Int {
+	v := atomic.LoadInt64(&e.vuLimit)
+	if v < 0 {
+		return null.Int{}
+	}
+	return null.IntFrom(v)
+}
+
+func (e *Executor) SetVULimit(n null.Int) error {
+	if !n.Valid {
+		n.Int64 = -1
+	} else if n.Int64 < 0 {
+		return errors.New("vu limit can't be negative")
+	}
+	e.Logger.WithField("n", n.Int64).Debug("Local: Setting VU limit")
+	atomic.StoreInt64(&e.vuLimit, n.Int64)
+	if n.Valid && e.GetVUs() > n.Int64 {
+		return e.SetVUs(n.Int64)
+	}
+	return nil
+}
+
 func (e *Executor) GetVUsMax() int64 {
 	return atomic.LoadInt64(&e.numVUsMax)
 }
@@ -471,17 +832,18 @@ func (e *Executor) SetVUsMax(max int64) error {
 	e.vusLock.Lock()
 	defer e.vusLock.Unlock()
 
+	preAlloc := atomic.LoadInt64(&e.preAllocVUs)
 	vus := e.vus
+	var preAllocated []*vuHandle
 	for i := numVUsMax; i < max; i++ {
-		var handle vuHandle
-		if e.Runner != nil {
-			vu, err := e.Runner.NewVU()
-			if err != nil {
-				return err
-			}
-			handle.vu = vu
+		handle := &vuHandle{}
+		if e.Runner != nil && (preAlloc < 0 || i < preAlloc) {
+			preAllocated = append(preAllocated, handle)
 		}
-		vus = append(vus, &handle)
+		vus = append(vus, handle)
+	}
+	if err := e.initVUs(preAllocated); err != nil {
+		return err
 	}
 	e.vus = vus
 
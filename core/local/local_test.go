@@ -22,6 +22,7 @@ package local
 
 import (
 	"context"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -30,6 +31,7 @@ import (
 	"github.com/loadimpact/k6/lib/metrics"
 	"github.com/loadimpact/k6/stats"
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	null "gopkg.in/guregu/null.v3"
@@ -89,6 +91,40 @@ func TestExecutorStages(t *testing.T) {
 	}
 }
 
+func TestExecutorVUsMaxPolicy(t *testing.T) {
+	stages := []lib.Stage{{Duration: lib.NullDurationFrom(50 * time.Millisecond), Target: null.IntFrom(5)}}
+
+	t.Run("Clamp", func(t *testing.T) {
+		logger, hook := logtest.NewNullLogger()
+		e := New(nil)
+		e.SetLogger(logger)
+		assert.NoError(t, e.SetVUsMax(2))
+		e.SetStages(stages)
+		e.SetVUsMaxPolicy(lib.VUsMaxClamp)
+		assert.Equal(t, lib.VUsMaxClamp, e.GetVUsMaxPolicy())
+		assert.NoError(t, e.Run(context.Background(), nil))
+		assert.Equal(t, int64(2), e.GetVUs())
+
+		warnings := 0
+		for _, entry := range hook.AllEntries() {
+			if entry.Level == log.WarnLevel {
+				warnings++
+				assert.Equal(t, int64(2), entry.Data["vus_max"])
+			}
+		}
+		assert.Equal(t, 1, warnings)
+	})
+	t.Run("Unset", func(t *testing.T) {
+		e := New(nil)
+		assert.NoError(t, e.SetVUsMax(2))
+		e.SetStages(stages)
+		err := e.Run(context.Background(), nil)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "above vu cap (2)")
+		}
+	})
+}
+
 func TestExecutorEndTime(t *testing.T) {
 	e := New(nil)
 	assert.NoError(t, e.SetVUsMax(10))
@@ -143,6 +179,70 @@ func TestExecutorEndTime(t *testing.T) {
 	})
 }
 
+func TestExecutorDeadline(t *testing.T) {
+	e := New(nil)
+	assert.NoError(t, e.SetVUsMax(10))
+	assert.NoError(t, e.SetVUs(10))
+	assert.Equal(t, lib.NullTime{}, e.GetDeadline())
+
+	deadline := time.Now().Add(200 * time.Millisecond)
+	e.SetDeadline(lib.NullTimeFrom(deadline))
+	assert.True(t, deadline.Equal(e.GetDeadline().Time))
+
+	t.Run("Duration First", func(t *testing.T) {
+		e.SetEndTime(lib.NullDurationFrom(100 * time.Millisecond))
+		startTime := time.Now()
+		assert.NoError(t, e.Run(context.Background(), nil))
+		assert.True(t, time.Now().Before(deadline), "test ran until the deadline")
+		assert.True(t, time.Now().After(startTime.Add(100*time.Millisecond)), "test did not take 100ms")
+	})
+	t.Run("Deadline First", func(t *testing.T) {
+		e.SetEndTime(lib.NullDurationFrom(10 * time.Second))
+		assert.NoError(t, e.Run(context.Background(), nil))
+		assert.True(t, time.Now().After(deadline), "test ended before the deadline")
+		assert.True(t, time.Now().Before(deadline.Add(1*time.Second)), "test ran past the deadline")
+	})
+	t.Run("Paused", func(t *testing.T) {
+		deadline := time.Now().Add(100 * time.Millisecond)
+		e.SetDeadline(lib.NullTimeFrom(deadline))
+		e.SetPaused(true)
+		assert.NoError(t, e.Run(context.Background(), nil))
+		assert.True(t, time.Now().After(deadline), "test ended before the deadline")
+	})
+}
+
+func TestExecutorGetTimeLeft(t *testing.T) {
+	e := New(nil)
+	_, ok := e.GetTimeLeft()
+	assert.False(t, ok)
+
+	e.SetEndTime(lib.NullDurationFrom(10 * time.Second))
+	left, ok := e.GetTimeLeft()
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Second, left)
+
+	e.SetStages([]lib.Stage{{Duration: lib.NullDurationFrom(5 * time.Second)}})
+	left, _ = e.GetTimeLeft()
+	assert.Equal(t, 5*time.Second, left)
+
+	e.SetDeadline(lib.NullTimeFrom(time.Now().Add(1 * time.Second)))
+	left, _ = e.GetTimeLeft()
+	assert.True(t, left > 0 && left <= 1*time.Second, "wrong time left: %s", left)
+
+	t.Run("Context", func(t *testing.T) {
+		var clock lib.RunClock
+		e := New(lib.RunnerFunc(func(ctx context.Context) ([]stats.Sample, error) {
+			clock = lib.GetRunClock(ctx)
+			return nil, nil
+		}))
+		assert.NoError(t, e.SetVUsMax(1))
+		assert.NoError(t, e.SetVUs(1))
+		e.SetEndIterations(null.IntFrom(1))
+		assert.NoError(t, e.Run(context.Background(), nil))
+		assert.Equal(t, e, clock)
+	})
+}
+
 func TestExecutorEndIterations(t *testing.T) {
 	metric := &stats.Metric{Name: "test_metric"}
 
@@ -175,6 +275,155 @@ func TestExecutorEndIterations(t *testing.T) {
 	}
 }
 
+func TestExecutorMaxIterationsPerVU(t *testing.T) {
+	var i int64
+	e := New(lib.RunnerFunc(func(ctx context.Context) ([]stats.Sample, error) {
+		atomic.AddInt64(&i, 1)
+		return nil, nil
+	}))
+	assert.NoError(t, e.SetVUsMax(5))
+	assert.NoError(t, e.SetVUs(5))
+	e.SetMaxIterationsPerVU(null.IntFrom(3))
+	assert.Equal(t, null.IntFrom(3), e.GetMaxIterationsPerVU())
+
+	t.Run("All VUs Done", func(t *testing.T) {
+		atomic.StoreInt64(&i, 0)
+		assert.NoError(t, e.Run(context.Background(), nil))
+		assert.Equal(t, int64(15), atomic.LoadInt64(&i))
+	})
+
+	t.Run("End Iterations", func(t *testing.T) {
+		atomic.StoreInt64(&i, 0)
+		e := New(e.Runner)
+		assert.NoError(t, e.SetVUsMax(5))
+		assert.NoError(t, e.SetVUs(5))
+		e.SetMaxIterationsPerVU(null.IntFrom(3))
+		e.SetEndIterations(null.IntFrom(4))
+		assert.NoError(t, e.Run(context.Background(), nil))
+		assert.Equal(t, int64(4), e.GetIterations())
+	})
+
+	t.Run("Unset", func(t *testing.T) {
+		e.SetMaxIterationsPerVU(null.Int{})
+		assert.Equal(t, null.Int{}, e.GetMaxIterationsPerVU())
+	})
+}
+
+func TestExecutorPaceIterations(t *testing.T) {
+	var lock sync.Mutex
+	var starts []time.Duration
+	start := time.Now()
+	e := New(lib.RunnerFunc(func(ctx context.Context) ([]stats.Sample, error) {
+		lock.Lock()
+		starts = append(starts, time.Since(start))
+		lock.Unlock()
+		return nil, nil
+	}))
+	assert.NoError(t, e.SetVUsMax(2))
+	assert.NoError(t, e.SetVUs(2))
+	e.SetEndIterations(null.IntFrom(5))
+	e.SetEndTime(lib.NullDurationFrom(250 * time.Millisecond))
+	e.SetPaceIterations(true)
+	assert.True(t, e.GetPaceIterations())
+
+	assert.NoError(t, e.Run(context.Background(), nil))
+	assert.Equal(t, int64(5), e.GetIterations())
+	if assert.Len(t, starts, 5) {
+		// Iterations are due every 50ms; without pacing, these would all start right away.
+		for i, at := range starts {
+			assert.True(t, at >= time.Duration(i)*50*time.Millisecond, "iteration %d started at %s", i, at)
+		}
+		assert.True(t, starts[4] < 250*time.Millisecond, "last iteration started at %s", starts[4])
+	}
+}
+
+func TestExecutorVUStartJitter(t *testing.T) {
+	jitter := &lib.VUStartJitter{Duration: lib.Duration(100 * time.Millisecond), Seed: 1}
+	delay := jitter.Delay(jitter.NewRand())
+
+	var first int64
+	e := New(lib.RunnerFunc(func(ctx context.Context) ([]stats.Sample, error) {
+		atomic.CompareAndSwapInt64(&first, 0, time.Now().UnixNano())
+		return nil, nil
+	}))
+	e.SetVUStartJitter(jitter)
+	assert.Equal(t, jitter, e.GetVUStartJitter())
+	assert.NoError(t, e.SetVUsMax(1))
+	assert.NoError(t, e.SetVUs(1))
+	e.SetEndIterations(null.IntFrom(1))
+
+	startTime := time.Now()
+	assert.NoError(t, e.Run(context.Background(), nil))
+	assert.True(t, time.Unix(0, atomic.LoadInt64(&first)).Sub(startTime) >= delay,
+		"first iteration started before the %s jitter delay", delay)
+}
+
+func TestExecutorThinkTime(t *testing.T) {
+	think := &lib.ThinkTime{Min: lib.Duration(50 * time.Millisecond), Max: lib.Duration(50 * time.Millisecond)}
+
+	var lock sync.Mutex
+	var starts []time.Time
+	e := New(lib.RunnerFunc(func(ctx context.Context) ([]stats.Sample, error) {
+		lock.Lock()
+		starts = append(starts, time.Now())
+		lock.Unlock()
+		return nil, nil
+	}))
+	e.SetThinkTime(think)
+	assert.Equal(t, think, e.GetThinkTime())
+	assert.NoError(t, e.SetVUsMax(1))
+	assert.NoError(t, e.SetVUs(1))
+	e.SetEndIterations(null.IntFrom(3))
+	assert.NoError(t, e.Run(context.Background(), nil))
+
+	lock.Lock()
+	defer lock.Unlock()
+	if assert.Len(t, starts, 3) {
+		for i := 1; i < len(starts); i++ {
+			assert.True(t, starts[i].Sub(starts[i-1]) >= 50*time.Millisecond,
+				"iteration %d started before the think time was up", i)
+		}
+	}
+}
+
+func TestExecutorVUCancellation(t *testing.T) {
+	metric := stats.New("my_metric", stats.Counter)
+	testdata := map[string]int{
+		"":                              0,
+		lib.VUCancellationImmediate:     0,
+		lib.VUCancellationFinishRequest: 1,
+	}
+	for mode, count := range testdata {
+		t.Run(mode, func(t *testing.T) {
+			// Reports a sample a little while after being told to stop, like a finishing request.
+			e := New(lib.RunnerFunc(func(ctx context.Context) ([]stats.Sample, error) {
+				<-ctx.Done()
+				time.Sleep(20 * time.Millisecond)
+				return []stats.Sample{{Time: time.Now(), Metric: metric, Value: 1}}, nil
+			}))
+			e.SetVUCancellation(mode)
+			assert.Equal(t, mode, e.GetVUCancellation())
+			assert.NoError(t, e.SetVUsMax(1))
+			assert.NoError(t, e.SetVUs(1))
+			e.SetEndTime(lib.NullDurationFrom(50 * time.Millisecond))
+
+			out := make(chan []stats.Sample, 10)
+			assert.NoError(t, e.Run(context.Background(), out))
+			close(out)
+
+			n := 0
+			for samples := range out {
+				for _, s := range samples {
+					if s.Metric == metric {
+						n++
+					}
+				}
+			}
+			assert.Equal(t, count, n)
+		})
+	}
+}
+
 func TestExecutorIsRunning(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	e := New(nil)
@@ -189,6 +438,130 @@ func TestExecutorIsRunning(t *testing.T) {
 	assert.NoError(t, <-err)
 }
 
+func TestExecutorPreAllocatedVUs(t *testing.T) {
+	countAllocated := func(e *Executor) int {
+		e.vusLock.RLock()
+		defer e.vusLock.RUnlock()
+
+		n := 0
+		for _, handle := range e.vus {
+			handle.RLock()
+			if handle.vu != nil {
+				n++
+			}
+			handle.RUnlock()
+		}
+		return n
+	}
+
+	e := New(lib.RunnerFunc(func(ctx context.Context) ([]stats.Sample, error) {
+		return nil, nil
+	}))
+	e.SetPreAllocatedVUs(null.IntFrom(2))
+	assert.Equal(t, null.IntFrom(2), e.GetPreAllocatedVUs())
+	assert.NoError(t, e.SetVUsMax(5))
+	assert.Equal(t, 2, countAllocated(e))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := make(chan error)
+	go func() { err <- e.Run(ctx, nil) }()
+	for !e.IsRunning() {
+	}
+	assert.NoError(t, e.SetVUs(4))
+	assert.Equal(t, 4, countAllocated(e))
+	cancel()
+	assert.NoError(t, <-err)
+
+	t.Run("Unset", func(t *testing.T) {
+		e := New(lib.RunnerFunc(nil))
+		e.SetPreAllocatedVUs(null.Int{})
+		assert.Equal(t, null.Int{}, e.GetPreAllocatedVUs())
+		assert.NoError(t, e.SetVUsMax(5))
+		assert.Equal(t, 5, countAllocated(e))
+	})
+
+	t.Run("InitError", func(t *testing.T) {
+		e := New(&slowInitRunner{failAfter: 1})
+		e.SetPreAllocatedVUs(null.IntFrom(1))
+		assert.NoError(t, e.SetVUsMax(2))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		err := make(chan error)
+		go func() { err <- e.Run(ctx, nil) }()
+		for !e.IsRunning() {
+		}
+		assert.EqualError(t, e.SetVUs(2), "init failed")
+		e.vusLock.RLock()
+		handle := e.vus[1]
+		e.vusLock.RUnlock()
+		handle.RLock()
+		assert.Nil(t, handle.vu)
+		assert.Nil(t, handle.cancel, "a VU that couldn't be made is marked as running")
+		handle.RUnlock()
+		cancel()
+		assert.NoError(t, <-err)
+	})
+}
+
+// A runner that takes a while to create VUs, and keeps track of how many it's creating at once.
+type slowInitRunner struct {
+	lib.RunnerFunc
+
+	mutex        sync.Mutex
+	active, peak int
+	failAfter, n int
+}
+
+func (r *slowInitRunner) NewVU() (lib.VU, error) {
+	r.mutex.Lock()
+	r.active++
+	if r.active > r.peak {
+		r.peak = r.active
+	}
+	r.n++
+	fail := r.failAfter > 0 && r.n > r.failAfter
+	r.mutex.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	r.mutex.Lock()
+	r.active--
+	r.mutex.Unlock()
+	if fail {
+		return nil, errors.New("init failed")
+	}
+	return r.RunnerFunc.NewVU()
+}
+
+func TestExecutorVUInitConcurrency(t *testing.T) {
+	for name, data := range map[string]struct {
+		concurrency null.Int
+		peak        int
+	}{
+		"Unset": {null.Int{}, 1},
+		"3":     {null.IntFrom(3), 3},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := &slowInitRunner{}
+			e := New(r)
+			e.SetVUInitConcurrency(data.concurrency)
+			assert.Equal(t, data.concurrency, e.GetVUInitConcurrency())
+			assert.NoError(t, e.SetVUsMax(9))
+			assert.Equal(t, data.peak, r.peak)
+			for _, handle := range e.vus {
+				assert.NotNil(t, handle.vu)
+			}
+		})
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		e := New(&slowInitRunner{failAfter: 2})
+		e.SetVUInitConcurrency(null.IntFrom(2))
+		assert.EqualError(t, e.SetVUsMax(4), "init failed")
+		assert.Equal(t, int64(0), e.GetVUsMax())
+	})
+}
+
 func TestExecutorSetVUsMax(t *testing.T) {
 	t.Run("Negative", func(t *testing.T) {
 		assert.EqualError(t, New(nil).SetVUsMax(-1), "vu cap can't be negative")
@@ -223,6 +596,25 @@ func TestExecutorSetVUsMax(t *testing.T) {
 	})
 }
 
+func TestExecutorSetVULimit(t *testing.T) {
+	e := New(nil)
+	assert.Equal(t, null.Int{}, e.GetVULimit())
+	assert.EqualError(t, e.SetVULimit(null.IntFrom(-1)), "vu limit can't be negative")
+
+	assert.NoError(t, e.SetVUsMax(10))
+	assert.NoError(t, e.SetVUs(8))
+	assert.NoError(t, e.SetVULimit(null.IntFrom(5)))
+	assert.Equal(t, null.IntFrom(5), e.GetVULimit())
+	assert.Equal(t, int64(5), e.GetVUs())
+
+	assert.NoError(t, e.SetVUs(10))
+	assert.Equal(t, int64(5), e.GetVUs())
+
+	assert.NoError(t, e.SetVULimit(null.Int{}))
+	assert.NoError(t, e.SetVUs(10))
+	assert.Equal(t, int64(10), e.GetVUs())
+}
+
 func TestExecutorSetVUs(t *testing.T) {
 	t.Run("Negative", func(t *testing.T) {
 		assert.EqualError(t, New(nil).SetVUs(-1), "vu count can't be negative")
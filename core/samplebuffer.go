@@ -0,0 +1,115 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"sync"
+
+	"github.com/loadimpact/k6/lib"
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/loadimpact/k6/stats"
+)
+
+// A sampleBuffer holds samples between the executor and the engine, so that VUs don't have to
+// wait for every batch to be processed, while bounding how many samples can pile up.
+type sampleBuffer struct {
+	max  int
+	drop bool
+
+	// Returns true for samples that must not be dropped, eg. because thresholds depend on them.
+	needed func(stats.Sample) bool
+
+	lock   sync.Mutex
+	cond   *sync.Cond
+	queue  []stats.Sample
+	closed bool
+}
+
+func newSampleBuffer(max int, policy string, needed func(stats.Sample) bool) *sampleBuffer {
+	b := &sampleBuffer{
+		max:    max,
+		drop:   policy == lib.InFlightSamplesDrop,
+		needed: needed,
+	}
+	b.cond = sync.NewCond(&b.lock)
+	return b
+}
+
+// Adds samples to the buffer. If it's full, this either blocks until there's room, or drops
+// the samples that aren't needed, and records how many were dropped in a counter sample.
+// A batch is always let in if the buffer is empty, even if it exceeds the limit by itself.
+func (b *sampleBuffer) push(samples []stats.Sample) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.drop {
+		if len(b.queue)+len(samples) > b.max {
+			kept := make([]stats.Sample, 0, len(samples))
+			for _, s := range samples {
+				if b.needed(s) {
+					kept = append(kept, s)
+				}
+			}
+			if dropped := len(samples) - len(kept); dropped > 0 {
+				kept = append(kept, stats.Sample{
+					Time:   samples[len(samples)-1].Time,
+					Metric: metrics.DroppedSamples,
+					Value:  float64(dropped),
+				})
+			}
+			samples = kept
+		}
+	} else {
+		for len(b.queue) > 0 && len(b.queue)+len(samples) > b.max && !b.closed {
+			b.cond.Wait()
+		}
+	}
+
+	b.queue = append(b.queue, samples...)
+	b.cond.Broadcast()
+}
+
+// Takes everything currently in the buffer, blocking until there's something to take. Returns
+// false once the buffer has been closed and emptied.
+func (b *sampleBuffer) pop() ([]stats.Sample, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for len(b.queue) == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if len(b.queue) == 0 {
+		return nil, false
+	}
+	samples := b.queue
+	b.queue = nil
+	b.cond.Broadcast()
+	return samples, true
+}
+
+// Closes the buffer; samples still in it can be popped, but pushes no longer block.
+func (b *sampleBuffer) close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.closed = true
+	b.cond.Broadcast()
+}
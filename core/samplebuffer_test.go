@@ -0,0 +1,114 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/loadimpact/k6/lib"
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/loadimpact/k6/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleBuffer(t *testing.T) {
+	kept := stats.New("kept", stats.Counter)
+	other := stats.New("other", stats.Counter)
+	needed := func(s stats.Sample) bool { return s.Metric == kept }
+	batch := func(n int) []stats.Sample {
+		samples := make([]stats.Sample, 0, n*2)
+		for i := 0; i < n; i++ {
+			samples = append(samples, stats.Sample{Metric: kept, Value: 1}, stats.Sample{Metric: other, Value: 1})
+		}
+		return samples
+	}
+
+	t.Run("Drop", func(t *testing.T) {
+		b := newSampleBuffer(4, lib.InFlightSamplesDrop, needed)
+		b.push(batch(2))
+		b.push(batch(2))
+
+		samples, ok := b.pop()
+		assert.True(t, ok)
+		if assert.Len(t, samples, 7) {
+			for _, s := range samples[4:6] {
+				assert.Equal(t, kept, s.Metric)
+			}
+			assert.Equal(t, metrics.DroppedSamples, samples[6].Metric)
+			assert.Equal(t, float64(2), samples[6].Value)
+		}
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		b := newSampleBuffer(4, lib.InFlightSamplesBlock, needed)
+		b.push(batch(2))
+
+		pushed := make(chan struct{})
+		go func() {
+			b.push(batch(1))
+			close(pushed)
+		}()
+		select {
+		case <-pushed:
+			t.Fatal("push didn't block on a full buffer")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		samples, ok := b.pop()
+		assert.True(t, ok)
+		assert.Len(t, samples, 4)
+		<-pushed
+
+		samples, ok = b.pop()
+		assert.True(t, ok)
+		assert.Len(t, samples, 2)
+	})
+
+	t.Run("Oversized", func(t *testing.T) {
+		b := newSampleBuffer(1, lib.InFlightSamplesBlock, needed)
+		b.push(batch(5))
+		samples, ok := b.pop()
+		assert.True(t, ok)
+		assert.Len(t, samples, 10)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		b := newSampleBuffer(4, lib.InFlightSamplesBlock, needed)
+		b.push(batch(1))
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			samples, ok := b.pop()
+			assert.True(t, ok)
+			assert.Len(t, samples, 2)
+
+			_, ok = b.pop()
+			assert.False(t, ok)
+		}()
+		time.Sleep(10 * time.Millisecond)
+		b.close()
+		wg.Wait()
+	})
+}
@@ -22,6 +22,7 @@ package common
 
 import (
 	"context"
+	"time"
 
 	"github.com/dop251/goja"
 )
@@ -56,3 +57,17 @@ func GetRuntime(ctx context.Context) *goja.Runtime {
 	}
 	return v.(*goja.Runtime)
 }
+
+// A context that carries its parent's values, but is never cancelled.
+type uncancelledCtx struct {
+	context.Context
+}
+
+func (uncancelledCtx) Deadline() (deadline time.Time, ok bool) { return }
+func (uncancelledCtx) Done() <-chan struct{}                   { return nil }
+func (uncancelledCtx) Err() error                              { return nil }
+
+// Returns a copy of ctx that keeps its values, but isn't cancelled along with it.
+func WithoutCancel(ctx context.Context) context.Context {
+	return uncancelledCtx{ctx}
+}
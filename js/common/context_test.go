@@ -42,6 +42,17 @@ func TestContextRuntime(t *testing.T) {
 	assert.Equal(t, rt, GetRuntime(WithRuntime(context.Background(), rt)))
 }
 
+func TestContextWithoutCancel(t *testing.T) {
+	st := &State{}
+	ctx, cancel := context.WithCancel(WithState(context.Background(), st))
+	uncancelled := WithoutCancel(ctx)
+	cancel()
+
+	assert.Equal(t, st, GetState(uncancelled))
+	assert.Nil(t, uncancelled.Done())
+	assert.NoError(t, uncancelled.Err())
+}
+
 func TestContextRuntimeNil(t *testing.T) {
 	assert.Nil(t, GetRuntime(context.Background()))
 }
@@ -51,9 +51,21 @@ type State struct {
 	// Rate limits.
 	RPSLimit *rate.Limiter
 
+	// The VU's own rate limit, applied before RPSLimit.
+	VURPSLimit *rate.Limiter
+
+	// Per-method rate limits, by uppercased method name. Applied on top of RPSLimit.
+	MethodRPSLimits map[string]*rate.Limiter
+
+	// Statuses that don't count as failed requests; nil means lib.DefaultExpectedStatuses.
+	ExpectedStatuses []lib.StatusRange
+
 	// Sample buffer, emitted at the end of the iteration.
 	Samples []stats.Sample
 
+	// Shared between VUs, records requests for the harExport option; nil without it.
+	HAR *lib.HARRecorder
+
 	// Bytes sent and received during this iteration. Use `sync/atomic`.
 	BytesRead, BytesWritten int64
 
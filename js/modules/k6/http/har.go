@@ -0,0 +1,128 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package http
+
+import (
+	"net"
+	"net/http"
+	"sort"
+
+	"github.com/loadimpact/k6/lib"
+	"github.com/loadimpact/k6/lib/netext"
+	"github.com/loadimpact/k6/stats"
+)
+
+// Builds an entry for the harExport option from a request, its response and trail. The response
+// is nil if the request failed, in which case resErr is recorded instead.
+func newHAREntry(req *http.Request, reqBody string, res *http.Response, resBody string, resErr error, trail *netext.Trail, includeBodies bool) lib.HAREntry {
+	if res != nil && res.Request != nil {
+		req = res.Request
+	}
+
+	timings := lib.HARTimings{
+		Blocked: stats.D(trail.Blocked),
+		DNS:     -1,
+		Connect: stats.D(trail.Connecting + trail.TLSHandshaking),
+		Send:    stats.D(trail.Sending),
+		Wait:    stats.D(trail.Waiting),
+		Receive: stats.D(trail.Receiving),
+		SSL:     -1,
+	}
+	if trail.TLSHandshaking > 0 {
+		timings.SSL = stats.D(trail.TLSHandshaking)
+	}
+	entry := lib.HAREntry{
+		StartedDateTime: trail.StartTime,
+		Time:            timings.Blocked + timings.Connect + timings.Send + timings.Wait + timings.Receive,
+		Timings:         timings,
+		Request: lib.HARRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: req.Proto,
+			Cookies:     harCookies(req.Cookies()),
+			Headers:     harHeaders(req.Header),
+			QueryString: []lib.HARNameValue{},
+			HeadersSize: -1,
+			BodySize:    len(reqBody),
+		},
+		Response: lib.HARResponse{
+			Cookies:     []lib.HARNameValue{},
+			Headers:     []lib.HARNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+	}
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			entry.Request.QueryString = append(entry.Request.QueryString, lib.HARNameValue{Name: name, Value: value})
+		}
+	}
+	sort.SliceStable(entry.Request.QueryString, func(i, j int) bool {
+		return entry.Request.QueryString[i].Name < entry.Request.QueryString[j].Name
+	})
+	if includeBodies && reqBody != "" {
+		entry.Request.PostData = &lib.HARPostData{MimeType: req.Header.Get("Content-Type"), Text: reqBody}
+	}
+	if trail.ConnRemoteAddr != nil {
+		entry.ServerIPAddress, _, _ = net.SplitHostPort(trail.ConnRemoteAddr.String())
+	}
+
+	if resErr != nil {
+		entry.Error = resErr.Error()
+	}
+	if res != nil {
+		entry.Response.Status = res.StatusCode
+		entry.Response.StatusText = http.StatusText(res.StatusCode)
+		entry.Response.HTTPVersion = res.Proto
+		entry.Response.Cookies = harCookies(res.Cookies())
+		entry.Response.Headers = harHeaders(res.Header)
+		entry.Response.RedirectURL = res.Header.Get("Location")
+		entry.Response.Content = lib.HARContent{Size: len(resBody), MimeType: res.Header.Get("Content-Type")}
+		if includeBodies {
+			entry.Response.Content.Text = resBody
+		}
+	}
+	return entry
+}
+
+// Lists headers by name, multiple values as separate entries.
+func harHeaders(header http.Header) []lib.HARNameValue {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := []lib.HARNameValue{}
+	for _, name := range names {
+		for _, value := range header[name] {
+			list = append(list, lib.HARNameValue{Name: name, Value: value})
+		}
+	}
+	return list
+}
+
+func harCookies(cookies []*http.Cookie) []lib.HARNameValue {
+	list := make([]lib.HARNameValue, len(cookies))
+	for i, c := range cookies {
+		list[i] = lib.HARNameValue{Name: c.Name, Value: c.Value}
+	}
+	return list
+}
@@ -134,8 +134,10 @@ func (*HTTP) CookieJar(ctx context.Context) *HTTPCookieJar {
 
 func (*HTTP) mergeCookies(req *http.Request, jar *cookiejar.Jar, reqCookies map[string]*HTTPRequestCookie) map[string][]*HTTPRequestCookie {
 	allCookies := make(map[string][]*HTTPRequestCookie)
-	for _, c := range jar.Cookies(req.URL) {
-		allCookies[c.Name] = append(allCookies[c.Name], &HTTPRequestCookie{Name: c.Name, Value: c.Value})
+	if jar != nil {
+		for _, c := range jar.Cookies(req.URL) {
+			allCookies[c.Name] = append(allCookies[c.Name], &HTTPRequestCookie{Name: c.Name, Value: c.Value})
+		}
 	}
 	for key, reqCookie := range reqCookies {
 		if jc := allCookies[key]; jc != nil && reqCookie.Replace {
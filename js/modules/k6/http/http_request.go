@@ -30,7 +30,9 @@ import (
 	"net"
 	"net/http"
 	"net/http/cookiejar"
+	"net/http/httptrace"
 	neturl "net/url"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -38,9 +40,13 @@ import (
 
 	"github.com/dop251/goja"
 	"github.com/loadimpact/k6/js/common"
+	"github.com/loadimpact/k6/lib"
+	"github.com/loadimpact/k6/lib/metrics"
 	"github.com/loadimpact/k6/lib/netext"
 	"github.com/loadimpact/k6/stats"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 	null "gopkg.in/guregu/null.v3"
 )
 
@@ -97,6 +103,9 @@ func (http *HTTP) Request(ctx context.Context, method string, url goja.Value, ar
 	return res, err
 }
 
+// Headers net/http drops when following a redirect to another host.
+var redirectSensitiveHeaders = []string{"Authorization", "Www-Authenticate", "Cookie", "Cookie2"}
+
 func (h *HTTP) request(ctx context.Context, rt *goja.Runtime, state *common.State, method string, url URL, args ...goja.Value) (*HTTPResponse, []stats.Sample, error) {
 	var bodyBuf *bytes.Buffer
 	var contentType string
@@ -112,7 +121,7 @@ func (h *HTTP) request(ctx context.Context, rt *goja.Runtime, state *common.Stat
 			bodyBuf = bytes.NewBufferString(bodyQuery.Encode())
 			contentType = "application/x-www-form-urlencoded"
 		} else {
-			bodyBuf = bytes.NewBufferString(args[0].String())
+			bodyBuf = bytes.NewBufferString(state.Options.ExpandBody(args[0].String(), state.Iteration))
 		}
 	}
 
@@ -133,7 +142,11 @@ func (h *HTTP) request(ctx context.Context, rt *goja.Runtime, state *common.Stat
 	if contentType != "" {
 		req.Header.Set("Content-Type", contentType)
 	}
-	if userAgent := state.Options.UserAgent; userAgent.String != "" {
+	host := url.URL.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if userAgent := state.Options.UserAgentFor(host); userAgent.String != "" {
 		req.Header.Set("User-Agent", userAgent.String)
 	}
 
@@ -143,12 +156,35 @@ func (h *HTTP) request(ctx context.Context, rt *goja.Runtime, state *common.Stat
 		"method": method,
 		"url":    url.URLString,
 		"name":   url.Name,
-		"group":  state.Group.Path,
-		"vu":     strconv.FormatInt(state.Vu, 10),
 		"iter":   strconv.FormatInt(state.Iteration, 10),
 	}
+	if state.Options.HasSystemTag(lib.SystemTagGroup) {
+		tags["group"] = state.Group.Path
+	}
+	if state.Options.HasSystemTag(lib.SystemTagVU) {
+		tags["vu"] = strconv.FormatInt(state.Vu, 10)
+	}
+	if url.Name == url.URLString {
+		if name, ok := state.Options.TagNameFor(url.URLString); ok {
+			tags["name"] = name
+		}
+	}
+
+	// Inject tracing headers before params are parsed, so that scripts can override them.
+	var trace lib.Trace
+	if tracing := state.Options.Tracing; tracing != nil {
+		var err error
+		if trace, err = tracing.NewTrace(); err != nil {
+			return nil, nil, err
+		}
+		tracing.Inject(req.Header, trace)
+		if tracing.TagMetrics && trace.Sampled {
+			tags["trace_id"] = trace.TraceID
+		}
+	}
+
 	redirects := state.Options.MaxRedirects
-	timeout := 60 * time.Second
+	timeout := state.Options.RequestTimeoutFor(host)
 	throw := state.Options.Throw.Bool
 
 	var activeJar *cookiejar.Jar
@@ -156,6 +192,8 @@ func (h *HTTP) request(ctx context.Context, rt *goja.Runtime, state *common.Stat
 		activeJar = state.CookieJar
 	}
 	reqCookies := make(map[string]*HTTPRequestCookie)
+	var bodyFile string
+	expectContinue := false
 
 	if len(args) > 1 {
 		paramsV := args[1]
@@ -238,40 +276,146 @@ func (h *HTTP) request(ctx context.Context, rt *goja.Runtime, state *common.Stat
 					timeout = time.Duration(params.Get(k).ToFloat() * float64(time.Millisecond))
 				case "throw":
 					throw = params.Get(k).ToBoolean()
+				case "expectContinue":
+					expectContinue = params.Get(k).ToBoolean()
+				case "bodyFile":
+					bodyFileV := params.Get(k)
+					if goja.IsUndefined(bodyFileV) || goja.IsNull(bodyFileV) {
+						continue
+					}
+					bodyFile = bodyFileV.String()
 				}
 			}
 		}
 	}
 
+	// File bodies are streamed from disk as they're sent, rather than read into memory; the file
+	// is only opened right before sending, and closed by the transport once it's done with it.
+	if bodyFile == "" && bodyBuf == nil && (method == "POST" || method == "PUT" || method == "PATCH") {
+		bodyFile = state.Options.BodyFile.String
+	}
+	if bodyFile != "" {
+		if bodyBuf != nil {
+			return nil, nil, errors.New("a request can't have both a body and a bodyFile")
+		}
+		if state.Options.RequestSigning != nil {
+			return nil, nil, errors.New("bodyFile can't be used with requestSigning")
+		}
+		info, err := os.Stat(bodyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		req.ContentLength = info.Size()
+		req.GetBody = func() (io.ReadCloser, error) { return os.Open(bodyFile) }
+	}
+	if expectContinue && (req.Body != nil || req.GetBody != nil) {
+		req.Header.Set("Expect", "100-continue")
+	}
+
+	// The script sees the body it passed in as res.request.body; this is what's actually sent.
+	reqBody := []byte(respReq.Body)
+	if state.Options.GzipMultipart.Bool && bodyBuf != nil {
+		body, ok, err := gzipMultipartBody(req.Header, reqBody)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			reqBody = body
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+			req.ContentLength = int64(len(body))
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+	}
+
 	if activeJar != nil {
-		mergedCookies := h.mergeCookies(req, activeJar, reqCookies)
+		mergedCookies := h.mergeCookies(req, jarForURL(state, activeJar, req.URL), reqCookies)
 		respReq.Cookies = mergedCookies
 		h.setRequestCookies(req, mergedCookies)
 	}
 
-	// Check rate limit *after* we've prepared a request; no need to wait with that part.
-	if rpsLimit := state.RPSLimit; rpsLimit != nil {
-		if err := rpsLimit.Wait(ctx); err != nil {
+	// Check rate limit *after* we've prepared a request; no need to wait with that part. Every
+	// attempt at sending it, retries included, takes its own token.
+	methodLimit := state.MethodRPSLimits[strings.ToUpper(method)]
+	limited := state.VURPSLimit != nil || state.RPSLimit != nil || methodLimit != nil
+	var limiterWait time.Duration
+	waitLimits := func() error {
+		limiterStart := time.Now()
+		defer func() { limiterWait += time.Since(limiterStart) }()
+		for _, limit := range []*rate.Limiter{state.VURPSLimit, state.RPSLimit, methodLimit} {
+			if limit == nil {
+				continue
+			}
+			if err := limit.Wait(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := waitLimits(); err != nil {
+		return nil, nil, err
+	}
+
+	// Sign the request last, so that the signature covers its final headers.
+	if signing := state.Options.RequestSigning; signing != nil {
+		if err := signing.Sign(req, reqBody, time.Now()); err != nil {
 			return nil, nil, err
 		}
 	}
 
 	respReq.Headers = req.Header
 
-	resp := &HTTPResponse{ctx: ctx, URL: url.URLString, Request: *respReq}
+	resp := &HTTPResponse{ctx: ctx, URL: url.URLString, Request: *respReq, TraceID: trace.TraceID}
+
+	// With testEndDeadline, don't let the request outlive the test.
+	deadlineCapped := false
+	if state.Options.TestEndDeadline.Bool {
+		if clock := lib.GetRunClock(ctx); clock != nil {
+			if left, ok := clock.GetTimeLeft(); ok && left < timeout {
+				timeout, deadlineCapped = left, true
+			}
+		}
+	}
+	if deadlineCapped && timeout <= 0 {
+		err := errors.New("request not sent, the test is ending")
+		resp.Error = err.Error()
+		tags["error"] = resp.Error
+		samples := []stats.Sample{{Metric: metrics.HTTPReqDeadlineExceeded, Time: time.Now(), Tags: tags, Value: 1}}
+		if throw {
+			return nil, samples, err
+		}
+		return resp, samples, nil
+	}
+
 	client := http.Client{
 		Transport: state.HTTPTransport,
 		Timeout:   timeout,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			h.debugResponse(state, req.Response, "RedirectResponse")
 
+			if !lib.RedirectAllowed(state.Options.RedirectAllowlist, req.URL) {
+				return errors.Errorf("redirect to %s is not in redirectAllowlist", req.URL)
+			}
+
+			// Put back the sensitive headers Go strips on cross-host redirects, if asked to.
+			if state.Options.RedirectSensitiveHeaders.Bool && len(via) > 0 {
+				for _, name := range redirectSensitiveHeaders {
+					if activeJar != nil && (name == "Cookie" || name == "Cookie2") {
+						continue
+					}
+					if _, ok := req.Header[name]; !ok && len(via[0].Header[name]) > 0 {
+						req.Header[name] = via[0].Header[name]
+					}
+				}
+			}
+
 			// Update active jar with cookies found in "Set-Cookie" header(s) of redirect response
 			if activeJar != nil {
-				if respCookies := req.Response.Cookies(); len(respCookies) > 0 {
-					activeJar.SetCookies(req.URL, respCookies)
+				jar := jarForURL(state, activeJar, req.URL)
+				if respCookies := req.Response.Cookies(); len(respCookies) > 0 && jar != nil {
+					jar.SetCookies(req.URL, respCookies)
 				}
 				req.Header.Del("Cookie")
-				mergedCookies := h.mergeCookies(req, activeJar, reqCookies)
+				mergedCookies := h.mergeCookies(req, jar, reqCookies)
 
 				h.setRequestCookies(req, mergedCookies)
 			}
@@ -292,16 +436,76 @@ func (h *HTTP) request(ctx context.Context, rt *goja.Runtime, state *common.Stat
 		},
 	}
 
+	if req.GetBody != nil && req.Body == nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Body = body
+	}
+
 	tracer := netext.Tracer{}
 	h.debugRequest(state, req, "Request")
-	res, resErr := client.Do(req.WithContext(netext.WithTracer(ctx, &tracer)))
+	// Unless configured otherwise, stopping the test aborts in-flight requests.
+	reqCtx := ctx
+	if state.Options.VUCancellation.String == lib.VUCancellationFinishRequest {
+		reqCtx = common.WithoutCancel(ctx)
+	}
+	reqCtx = netext.WithTracer(reqCtx, &tracer)
+	var poolTracker *netext.ConnPoolTracker
+	if state.Dialer != nil && state.Dialer.ConnPool != nil {
+		poolTracker = state.Dialer.ConnPool.Tracker()
+		reqCtx = httptrace.WithClientTrace(reqCtx, poolTracker.Trace())
+	}
+	res, resErr := client.Do(req.WithContext(reqCtx))
+	var resets []time.Time
+	for resErr != nil && netext.IsConnReset(resErr) {
+		resets = append(resets, time.Now())
+		if int64(len(resets)) > state.Options.ConnResetRetries.Int64 {
+			break
+		}
+		// Throw away the failed attempt's timings and rewind the body before trying again.
+		_ = tracer.Done()
+		if bodyBuf != nil {
+			req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+		} else if req.GetBody != nil {
+			if req.Body, resErr = req.GetBody(); resErr != nil {
+				break
+			}
+		}
+		if resErr = waitLimits(); resErr != nil {
+			break
+		}
+		h.debugRequest(state, req, "RetryRequest")
+		res, resErr = client.Do(req.WithContext(reqCtx))
+	}
 	h.debugResponse(state, res, "Response")
+	var compressedBuf *bytes.Buffer
 	if resErr == nil && res != nil {
-		switch res.Header.Get("Content-Encoding") {
+		// If requested, hang on to the compressed body as it's being decompressed.
+		var body io.Reader = res.Body
+		encoding := res.Header.Get("Content-Encoding")
+		if state.Options.KeepCompressedBody.Bool && (encoding == "deflate" || encoding == "gzip") {
+			compressedBuf = state.BPool.Get()
+			compressedBuf.Reset()
+			defer state.BPool.Put(compressedBuf)
+			body = io.TeeReader(res.Body, compressedBuf)
+		}
+
+		var decompressed io.ReadCloser
+		switch encoding {
 		case "deflate":
-			res.Body, resErr = zlib.NewReader(res.Body)
+			decompressed, resErr = zlib.NewReader(body)
 		case "gzip":
-			res.Body, resErr = gzip.NewReader(res.Body)
+			decompressed, resErr = gzip.NewReader(body)
+		default:
+			decompressed = res.Body
+		}
+		if resErr == nil {
+			res.Body = struct {
+				io.Reader
+				io.Closer
+			}{decompressed, res.Body}
 		}
 	}
 	if resErr == nil && res != nil {
@@ -316,6 +520,10 @@ func (h *HTTP) request(ctx context.Context, rt *goja.Runtime, state *common.Stat
 		_ = res.Body.Close()
 	}
 	trail := tracer.Done()
+	var poolStats map[string]netext.ConnPoolStats
+	if poolTracker != nil {
+		poolStats = poolTracker.Release()
+	}
 	if trail.ConnRemoteAddr != nil {
 		remoteHost, remotePortStr, _ := net.SplitHostPort(trail.ConnRemoteAddr.String())
 		remotePort, _ := strconv.Atoi(remotePortStr)
@@ -332,13 +540,22 @@ func (h *HTTP) request(ctx context.Context, rt *goja.Runtime, state *common.Stat
 		Receiving:      stats.D(trail.Receiving),
 	}
 
+	tlsFailed := state.Options.TLSFailureMetric.Bool && trail.TLSHandshakeError != nil
+	if tlsFailed {
+		tags["tls_error"] = lib.TLSFailureReason(trail.TLSHandshakeError)
+	}
+	if tlsVersion := state.Options.TLSVersionFor(host); resErr != nil && state.Options.TLSMinVersionStrict.Bool && tlsVersion != nil {
+		if err := lib.TLSDowngradeError(resErr, tlsVersion.Min); err != nil {
+			resErr, throw = err, true
+		}
+	}
 	if resErr != nil {
 		resp.Error = resErr.Error()
 		tags["error"] = resp.Error
 	} else {
-		if activeJar != nil {
+		if jar := jarForURL(state, activeJar, res.Request.URL); jar != nil {
 			if rc := res.Cookies(); len(rc) > 0 {
-				activeJar.SetCookies(res.Request.URL, rc)
+				jar.SetCookies(res.Request.URL, rc)
 			}
 		}
 
@@ -351,6 +568,9 @@ func (h *HTTP) request(ctx context.Context, rt *goja.Runtime, state *common.Stat
 
 		if res.TLS != nil {
 			resp.setTLSInfo(res.TLS)
+			if state.Options.CaptureTLSDetails.Bool {
+				resp.setTLSPeerCertificates(res.TLS.PeerCertificates)
+			}
 			tags["tls_version"] = resp.TLSVersion
 			tags["ocsp_status"] = resp.OCSP.Status
 		}
@@ -360,6 +580,19 @@ func (h *HTTP) request(ctx context.Context, rt *goja.Runtime, state *common.Stat
 			resp.Headers[k] = strings.Join(vs, ", ")
 		}
 
+		// Trailers are only filled in once the body has been read in full.
+		if state.Options.CaptureTrailers.Bool {
+			resp.Trailers = make(map[string]string, len(res.Trailer))
+			for k, vs := range res.Trailer {
+				resp.Trailers[k] = strings.Join(vs, ", ")
+			}
+		}
+		for _, name := range state.Options.TrailerTags {
+			if v := res.Trailer.Get(name); v != "" {
+				tags[strings.ToLower(name)] = v
+			}
+		}
+
 		resCookies := res.Cookies()
 		resp.Cookies = make(map[string][]*HTTPCookie, len(resCookies))
 		for _, c := range resCookies {
@@ -376,6 +609,15 @@ func (h *HTTP) request(ctx context.Context, rt *goja.Runtime, state *common.Stat
 		}
 	}
 
+	if har := state.HAR; har != nil && har.Sample() {
+		har.Add(newHAREntry(req, respReq.Body, res, resp.Body, resErr, &trail, har.Options.IncludeBodies))
+	}
+
+	var deadlineSamples []stats.Sample
+	if netErr, ok := resErr.(net.Error); ok && netErr.Timeout() && deadlineCapped {
+		deadlineSamples = []stats.Sample{{Metric: metrics.HTTPReqDeadlineExceeded, Time: trail.EndTime, Tags: tags, Value: 1}}
+	}
+
 	if resErr != nil {
 		// Do *not* log errors about the contex being cancelled.
 		select {
@@ -385,10 +627,101 @@ func (h *HTTP) request(ctx context.Context, rt *goja.Runtime, state *common.Stat
 		}
 
 		if throw {
-			return nil, nil, resErr
+			return nil, deadlineSamples, resErr
+		}
+	}
+	samples := append(trail.Samples(tags), deadlineSamples...)
+	if tracing := state.Options.Tracing; tracing != nil && tracing.Exemplars && trace.Sampled {
+		for i := range samples {
+			if samples[i].Metric.Type == stats.Trend {
+				samples[i].TraceID = trace.TraceID
+			}
+		}
+	}
+	failed := 0.0
+	if resErr != nil || !lib.StatusExpected(state.ExpectedStatuses, resp.Status) {
+		failed = 1
+	}
+	samples = append(samples, stats.Sample{
+		Metric: metrics.HTTPReqFailed,
+		Time:   trail.EndTime,
+		Tags:   tags,
+		Value:  failed,
+	})
+	if tlsFailed {
+		samples = append(samples, stats.Sample{
+			Metric: metrics.HTTPReqTLSFailures,
+			Time:   trail.EndTime,
+			Tags:   tags,
+			Value:  1,
+		})
+	}
+	if schema := state.Options.ResponseSchemaFor(url.URLString); schema != nil && resErr == nil {
+		invalid := 0.0
+		if err := schema.ValidateJSON([]byte(resp.Body)); err != nil {
+			resp.SchemaError = err.Error()
+			invalid = 1
+		}
+		samples = append(samples, stats.Sample{
+			Metric: metrics.HTTPRespSchemaFailed,
+			Time:   trail.EndTime,
+			Tags:   tags,
+			Value:  invalid,
+		})
+	}
+	if limit := state.Options.ResponseSizeAlert; limit.Int64 > 0 && int64(len(resp.Body)) > limit.Int64 {
+		samples = append(samples, stats.Sample{
+			Metric: metrics.HTTPRespOversized,
+			Time:   trail.EndTime,
+			Tags:   tags,
+			Value:  1,
+		})
+	}
+	for _, t := range resets {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPConnResets, Time: t, Tags: tags, Value: 1})
+	}
+	if limited && state.Options.LimiterWaitMetric.Bool {
+		samples = append(samples, stats.Sample{
+			Metric: metrics.HTTPReqLimiterWaiting,
+			Time:   trail.EndTime,
+			Tags:   tags,
+			Value:  stats.D(limiterWait),
+		})
+	}
+	if state.Options.ConnReuseMetrics.Bool && trail.ConnRemoteAddr != nil {
+		reuseTags := make(map[string]string, len(tags)+1)
+		for k, v := range tags {
+			reuseTags[k] = v
 		}
+		reuseTags["host"] = host
+		reused := 0.0
+		if trail.ConnReused {
+			reused = 1
+		}
+		samples = append(samples, stats.Sample{
+			Metric: metrics.HTTPReqConnReused,
+			Time:   trail.EndTime,
+			Tags:   reuseTags,
+			Value:  reused,
+		})
+	}
+	for addr, s := range poolStats {
+		poolTags := map[string]string{"host": addr}
+		samples = append(samples,
+			stats.Sample{Metric: metrics.HTTPConnsActive, Time: trail.EndTime, Tags: poolTags, Value: float64(s.Active)},
+			stats.Sample{Metric: metrics.HTTPConnsIdle, Time: trail.EndTime, Tags: poolTags, Value: float64(s.Idle)},
+		)
+	}
+	if compressedBuf != nil && resErr == nil {
+		resp.CompressedBody = compressedBuf.String()
+		samples = append(samples, stats.Sample{
+			Metric: metrics.HTTPRespCompressedSize,
+			Time:   trail.EndTime,
+			Tags:   tags,
+			Value:  float64(len(resp.CompressedBody)),
+		})
 	}
-	return resp, trail.Samples(tags), nil
+	return resp, samples, nil
 }
 
 func (http *HTTP) Batch(ctx context.Context, reqsV goja.Value) (goja.Value, error) {
@@ -479,3 +812,12 @@ func (http *HTTP) Batch(ctx context.Context, reqsV goja.Value) (goja.Value, erro
 	}
 	return retval, err
 }
+
+// Returns the cookie jar to use for the given URL; nil if there's none, or if the cookieJarHosts
+// and cookieJarExcludeHosts options leave its host out.
+func jarForURL(state *common.State, jar *cookiejar.Jar, u *neturl.URL) *cookiejar.Jar {
+	if jar == nil || !state.Options.CookieJarAllowed(u.Hostname()) {
+		return nil
+	}
+	return jar
+}
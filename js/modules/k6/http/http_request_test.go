@@ -21,14 +21,23 @@
 package http
 
 import (
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -42,6 +51,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
 	null "gopkg.in/guregu/null.v3"
 )
 
@@ -91,6 +101,10 @@ func assertRequestMetricsEmitted(t *testing.T, samples []stats.Sample, method, u
 	assert.True(t, seenReceiving, "url %s didn't emit Receiving", url)
 }
 
+type fixedRunClock time.Duration
+
+func (c fixedRunClock) GetTimeLeft() (time.Duration, bool) { return time.Duration(c), true }
+
 func TestRequestAndBatch(t *testing.T) {
 	root, err := lib.NewGroup("", nil)
 	assert.NoError(t, err)
@@ -180,6 +194,22 @@ func TestRequestAndBatch(t *testing.T) {
 			`)
 			assert.NoError(t, err)
 		})
+		t.Run("RedirectAllowlist", func(t *testing.T) {
+			oldOpts := state.Options
+			defer func() { state.Options = oldOpts }()
+			state.Options.RedirectAllowlist = []string{"https://httpbin.org"}
+
+			_, err := common.RunString(rt, `
+			let res = http.get("https://httpbin.org/redirect/1");
+			if (res.status != 200) { throw new Error("wrong status: " + res.status) }
+
+			res = http.get("https://httpbin.org/redirect-to?url=http://httpbin.org/get");
+			if (res.error.indexOf("redirect to http://httpbin.org/get is not in redirectAllowlist") == -1) {
+				throw new Error("unexpected error: " + res.error)
+			}
+			`)
+			assert.NoError(t, err)
+		})
 	})
 	t.Run("Timeout", func(t *testing.T) {
 		t.Run("10s", func(t *testing.T) {
@@ -232,6 +262,20 @@ func TestRequestAndBatch(t *testing.T) {
 			`)
 			assert.NoError(t, err)
 		})
+
+		t.Run("Host", func(t *testing.T) {
+			oldOpts := state.Options
+			defer func() { state.Options = oldOpts }()
+			state.Options.HostUserAgents = map[string]string{"*.org": "OrgUserAgent", "httpbin.org": "HostUserAgent"}
+
+			_, err := common.RunString(rt, `
+				let res = http.get("http://httpbin.org/user-agent");
+				if (res.json()['user-agent'] != "HostUserAgent") {
+					throw new Error("incorrect user agent: " + res.json()['user-agent'])
+				}
+			`)
+			assert.NoError(t, err)
+		})
 	})
 	t.Run("Compression", func(t *testing.T) {
 		t.Run("gzip", func(t *testing.T) {
@@ -253,6 +297,644 @@ func TestRequestAndBatch(t *testing.T) {
 			assert.NoError(t, err)
 		})
 	})
+	t.Run("Tracing", func(t *testing.T) {
+		oldOpts := state.Options
+		defer func() { state.Options = oldOpts }()
+		state.Options.Tracing = &lib.Tracing{Sampling: 1, TagMetrics: true}
+
+		state.Samples = nil
+		_, err := common.RunString(rt, `
+			let res = http.get("http://httpbin.org/headers");
+			if (res.trace_id.length != 32) { throw new Error("wrong trace ID: " + res.trace_id); }
+			let traceparent = res.json().headers["Traceparent"];
+			if (traceparent.indexOf("00-" + res.trace_id + "-") != 0) {
+				throw new Error("wrong traceparent: " + traceparent);
+			}
+		`)
+		assert.NoError(t, err)
+		for _, sample := range state.Samples {
+			assert.Len(t, sample.Tags["trace_id"], 32)
+		}
+
+		t.Run("Exemplars", func(t *testing.T) {
+			state.Options.Tracing = &lib.Tracing{Sampling: 1, Exemplars: true}
+
+			state.Samples = nil
+			_, err := common.RunString(rt, `http.get("http://httpbin.org/get");`)
+			assert.NoError(t, err)
+			if !assert.NotEmpty(t, state.Samples) {
+				return
+			}
+			for _, sample := range state.Samples {
+				assert.NotContains(t, sample.Tags, "trace_id")
+				if sample.Metric.Type == stats.Trend {
+					assert.Len(t, sample.TraceID, 32, sample.Metric.Name)
+				} else {
+					assert.Equal(t, "", sample.TraceID, sample.Metric.Name)
+				}
+			}
+		})
+	})
+	t.Run("RequestSigning", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+		}))
+		defer srv.Close()
+		rt.Set("signingServerURL", srv.URL)
+
+		for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+			defer os.Setenv(name, os.Getenv(name))
+			_ = os.Setenv(name, "example")
+		}
+		oldOpts := state.Options
+		defer func() { state.Options = oldOpts }()
+		state.Options.RequestSigning = &lib.RequestSigning{
+			Algorithm: lib.RequestSigningAWSSigV4, Region: "us-east-1", Service: "execute-api",
+		}
+
+		_, err := common.RunString(rt, `
+			let res = http.post(signingServerURL, "body");
+			if (res.body.indexOf("AWS4-HMAC-SHA256 Credential=example/") != 0) {
+				throw new Error("wrong authorization: " + res.body);
+			}
+			if (res.request.headers["X-Amz-Date"] == undefined) { throw new Error("missing X-Amz-Date"); }
+		`)
+		assert.NoError(t, err)
+	})
+	t.Run("BodyFile", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			_, _ = fmt.Fprintf(w, "%d %s", r.ContentLength, body)
+		}))
+		defer srv.Close()
+		rt.Set("uploadServerURL", srv.URL)
+
+		f, err := ioutil.TempFile("", "k6-body-file")
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer func() { _ = os.Remove(f.Name()) }()
+		_, _ = f.WriteString("file contents")
+		_ = f.Close()
+		rt.Set("uploadFile", f.Name())
+
+		_, err = common.RunString(rt, `
+			let res = http.post(uploadServerURL, null, { bodyFile: uploadFile });
+			if (res.body != "13 file contents") { throw new Error("wrong upload: " + res.body); }
+		`)
+		assert.NoError(t, err)
+
+		t.Run("Default", func(t *testing.T) {
+			oldOpts := state.Options
+			defer func() { state.Options = oldOpts }()
+			state.Options.BodyFile = null.StringFrom(f.Name())
+
+			_, err := common.RunString(rt, `
+				let res = http.put(uploadServerURL);
+				if (res.body != "13 file contents") { throw new Error("wrong upload: " + res.body); }
+				res = http.post(uploadServerURL, "inline");
+				if (res.body != "6 inline") { throw new Error("wrong body: " + res.body); }
+				res = http.get(uploadServerURL);
+				if (res.body != "0 ") { throw new Error("wrong GET: " + res.body); }
+			`)
+			assert.NoError(t, err)
+		})
+		t.Run("Both", func(t *testing.T) {
+			_, err := common.RunString(rt, `http.post(uploadServerURL, "inline", { bodyFile: uploadFile });`)
+			assert.Error(t, err)
+		})
+		t.Run("Missing", func(t *testing.T) {
+			_, err := common.RunString(rt, `http.post(uploadServerURL, null, { bodyFile: uploadFile + ".nope" });`)
+			assert.Error(t, err)
+		})
+	})
+	t.Run("RedirectSensitiveHeaders", func(t *testing.T) {
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+		}))
+		defer target.Close()
+		// Redirect to "localhost" rather than "127.0.0.1", so that it's treated as another host.
+		targetURL := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, targetURL, http.StatusFound)
+		}))
+		defer srv.Close()
+		rt.Set("authRedirectURL", srv.URL)
+
+		oldOpts := state.Options
+		defer func() { state.Options = oldOpts }()
+
+		script := `
+			let res = http.get(authRedirectURL, { headers: { "Authorization": "Bearer secret" } });
+			if (res.body != expected) { throw new Error("wrong Authorization: " + res.body); }
+		`
+		rt.Set("expected", "")
+		_, err := common.RunString(rt, script)
+		assert.NoError(t, err)
+
+		state.Options.RedirectSensitiveHeaders = null.BoolFrom(true)
+		rt.Set("expected", "Bearer secret")
+		_, err = common.RunString(rt, script)
+		assert.NoError(t, err)
+	})
+	t.Run("TLSMinVersionStrict", func(t *testing.T) {
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		srv.TLS = &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS10}
+		srv.StartTLS()
+		defer srv.Close()
+		rt.Set("tls10ServerURL", srv.URL)
+
+		oldOpts := state.Options
+		defer func() { state.Options = oldOpts }()
+		state.Options.Throw = null.BoolFrom(false)
+		state.Options.TLSVersion = &lib.TLSVersions{Min: tls.VersionTLS12}
+		state.Options.TLSMinVersionStrict = null.BoolFrom(true)
+
+		_, err := common.RunString(rt, `http.get(tls10ServerURL);`)
+		if assert.Error(t, err) {
+			// Depending on the Go version, the server either picks tls1.0 or refuses the handshake.
+			assert.Contains(t, err.Error(), "below configured minimum tls1.2")
+		}
+	})
+	t.Run("TLSFailureMetric", func(t *testing.T) {
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		srv.TLS = &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS10}
+		srv.StartTLS()
+		defer srv.Close()
+		rt.Set("tlsFailureServerURL", srv.URL)
+
+		oldOpts := state.Options
+		defer func() { state.Options = oldOpts }()
+		state.Options.Throw = null.BoolFrom(false)
+		state.Options.TLSVersion = &lib.TLSVersions{Min: tls.VersionTLS12}
+		state.Options.TLSFailureMetric = null.BoolFrom(true)
+
+		state.Samples = nil
+		_, err := common.RunString(rt, `
+			let res = http.get(tlsFailureServerURL);
+			if (!res.error) { throw new Error("handshake didn't fail"); }
+		`)
+		assert.NoError(t, err)
+
+		seen := false
+		for _, sample := range state.Samples {
+			if sample.Metric == metrics.HTTPReqTLSFailures {
+				seen = true
+				assert.Equal(t, 1.0, sample.Value)
+				assert.Equal(t, "version", sample.Tags["tls_error"])
+			}
+		}
+		assert.True(t, seen, "didn't emit http_req_tls_failures")
+	})
+	t.Run("GzipMultipart", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Content-Encoding") != "gzip" {
+				w.WriteHeader(400)
+				return
+			}
+			gr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			r.Body = gr
+			_, _ = w.Write([]byte(r.FormValue("a")))
+		}))
+		defer srv.Close()
+		rt.Set("multipartServerURL", srv.URL)
+
+		oldOpts := state.Options
+		defer func() { state.Options = oldOpts }()
+		state.Options.GzipMultipart = null.BoolFrom(true)
+
+		_, err := common.RunString(rt, `
+			let body = "--b\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nhello\r\n--b--\r\n";
+			let res = http.post(multipartServerURL, body, {
+				headers: { "Content-Type": "multipart/form-data; boundary=b" },
+			});
+			if (res.status != 200 || res.body != "hello") {
+				throw new Error("wrong response: " + res.status + " " + res.body);
+			}
+			if (res.request.body != body) { throw new Error("wrong request body: " + res.request.body); }
+		`)
+		assert.NoError(t, err)
+	})
+	t.Run("VURPSLimit", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer srv.Close()
+		rt.Set("vuLimitedServerURL", srv.URL)
+
+		oldLimit := state.VURPSLimit
+		defer func() { state.VURPSLimit = oldLimit }()
+		state.VURPSLimit = rate.NewLimiter(rate.Limit(10), 1)
+
+		startTime := time.Now()
+		_, err := common.RunString(rt, `
+			for (let i = 0; i < 3; i++) { http.get(vuLimitedServerURL); }
+		`)
+		assert.NoError(t, err)
+		assert.True(t, time.Since(startTime) >= 200*time.Millisecond, "requests weren't throttled")
+	})
+	t.Run("LimiterWaitMetric", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer srv.Close()
+		rt.Set("limiterWaitServerURL", srv.URL)
+
+		oldLimit, oldOpts := state.VURPSLimit, state.Options
+		defer func() { state.VURPSLimit, state.Options = oldLimit, oldOpts }()
+		state.VURPSLimit = rate.NewLimiter(rate.Limit(10), 1)
+		state.Options.LimiterWaitMetric = null.BoolFrom(true)
+
+		state.Samples = nil
+		_, err := common.RunString(rt, `
+			for (let i = 0; i < 2; i++) { http.get(limiterWaitServerURL); }
+		`)
+		assert.NoError(t, err)
+
+		var waits []float64
+		for _, sample := range state.Samples {
+			if sample.Metric == metrics.HTTPReqLimiterWaiting {
+				waits = append(waits, sample.Value)
+			}
+		}
+		if assert.Len(t, waits, 2) {
+			assert.True(t, waits[1] >= 50, "second request only waited %fms", waits[1])
+		}
+	})
+	t.Run("MethodRPSLimits", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer srv.Close()
+		rt.Set("limitedServerURL", srv.URL)
+
+		oldLimits := state.MethodRPSLimits
+		defer func() { state.MethodRPSLimits = oldLimits }()
+		state.MethodRPSLimits = map[string]*rate.Limiter{"POST": rate.NewLimiter(rate.Limit(10), 1)}
+
+		startTime := time.Now()
+		_, err := common.RunString(rt, `
+			for (let i = 0; i < 5; i++) { http.get(limitedServerURL); }
+		`)
+		assert.NoError(t, err)
+		assert.True(t, time.Since(startTime) < 200*time.Millisecond, "GETs were throttled")
+
+		startTime = time.Now()
+		_, err = common.RunString(rt, `
+			for (let i = 0; i < 3; i++) { http.post(limitedServerURL); }
+		`)
+		assert.NoError(t, err)
+		assert.True(t, time.Since(startTime) >= 200*time.Millisecond, "POSTs weren't throttled")
+	})
+	t.Run("ConnPoolMetrics", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer srv.Close()
+		rt.Set("poolServerURL", srv.URL)
+
+		dialer := netext.NewDialer(net.Dialer{})
+		dialer.ConnPool = netext.NewConnPool()
+		oldDialer, oldTransport := state.Dialer, state.HTTPTransport
+		defer func() { state.Dialer, state.HTTPTransport = oldDialer, oldTransport }()
+		state.Dialer = dialer
+		state.HTTPTransport = &http.Transport{DialContext: dialer.DialContext}
+
+		state.Samples = nil
+		_, err := common.RunString(rt, `http.get(poolServerURL);`)
+		assert.NoError(t, err)
+
+		host := strings.TrimPrefix(srv.URL, "http://")
+		var active, idle []stats.Sample
+		for _, sample := range state.Samples {
+			switch sample.Metric {
+			case metrics.HTTPConnsActive:
+				active = append(active, sample)
+			case metrics.HTTPConnsIdle:
+				idle = append(idle, sample)
+			}
+		}
+		if assert.Len(t, active, 1) && assert.Len(t, idle, 1) {
+			assert.Equal(t, map[string]string{"host": host}, active[0].Tags)
+			assert.Equal(t, float64(1), active[0].Value)
+			assert.Equal(t, float64(0), idle[0].Value)
+		}
+	})
+	t.Run("ConnResetRetries", func(t *testing.T) {
+		var mutex sync.Mutex
+		resets := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			mutex.Lock()
+			reset := resets < 2
+			if reset {
+				resets++
+			}
+			mutex.Unlock()
+			if !reset {
+				_, _ = w.Write(body)
+				return
+			}
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if assert.NoError(t, err) {
+				_ = conn.(*net.TCPConn).SetLinger(0)
+				_ = conn.Close()
+			}
+		}))
+		defer srv.Close()
+		rt.Set("resetServerURL", srv.URL)
+
+		oldOpts, oldTransport := state.Options, state.HTTPTransport
+		defer func() { state.Options, state.HTTPTransport = oldOpts, oldTransport }()
+		state.HTTPTransport = &http.Transport{DisableKeepAlives: true}
+
+		countResets := func() (n int) {
+			for _, sample := range state.Samples {
+				if sample.Metric == metrics.HTTPConnResets {
+					n++
+				}
+			}
+			return n
+		}
+
+		t.Run("Retried", func(t *testing.T) {
+			state.Options.ConnResetRetries = null.IntFrom(2)
+			state.Samples = nil
+			_, err := common.RunString(rt, `
+			let res = http.post(resetServerURL, "hello");
+			if (res.body != "hello") { throw new Error("wrong body: " + res.body); }
+			`)
+			assert.NoError(t, err)
+			assert.Equal(t, 2, countResets())
+		})
+		t.Run("Exhausted", func(t *testing.T) {
+			mutex.Lock()
+			resets = 0
+			mutex.Unlock()
+			state.Options.ConnResetRetries = null.IntFrom(1)
+			state.Options.Throw = null.BoolFrom(false)
+			state.Samples = nil
+			_, err := common.RunString(rt, `
+			let res = http.post(resetServerURL, "hello");
+			if (!res.error) { throw new Error("expected an error"); }
+			`)
+			assert.NoError(t, err)
+			assert.Equal(t, 2, countResets())
+		})
+		t.Run("RateLimited", func(t *testing.T) {
+			mutex.Lock()
+			resets = 0
+			mutex.Unlock()
+			oldLimit := state.RPSLimit
+			defer func() { state.RPSLimit = oldLimit }()
+			state.RPSLimit = rate.NewLimiter(rate.Limit(10), 1)
+			state.Options.ConnResetRetries = null.IntFrom(2)
+			state.Samples = nil
+
+			// Each of the three attempts takes a token, so the retries wait ~100ms apiece.
+			start := time.Now()
+			_, err := common.RunString(rt, `
+			let res = http.post(resetServerURL, "hello");
+			if (res.body != "hello") { throw new Error("wrong body: " + res.body); }
+			`)
+			assert.NoError(t, err)
+			assert.Equal(t, 2, countResets())
+			assert.True(t, time.Since(start) >= 200*time.Millisecond, "retries weren't throttled")
+		})
+	})
+	t.Run("TestEndDeadline", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}))
+		defer srv.Close()
+		rt.Set("slowServerURL", srv.URL)
+
+		oldOpts, oldCtx := state.Options, *ctx
+		defer func() { state.Options, *ctx = oldOpts, oldCtx }()
+		state.Options.TestEndDeadline = null.BoolFrom(true)
+		state.Options.Throw = null.BoolFrom(false)
+
+		countExceeded := func() (n int) {
+			for _, sample := range state.Samples {
+				if sample.Metric == metrics.HTTPReqDeadlineExceeded {
+					n++
+				}
+			}
+			return n
+		}
+
+		for name, data := range map[string]struct {
+			left     time.Duration
+			exceeded int
+		}{
+			"Plenty": {10 * time.Second, 0},
+			"Capped": {50 * time.Millisecond, 1},
+			"None":   {0, 1},
+		} {
+			t.Run(name, func(t *testing.T) {
+				*ctx = lib.WithRunClock(oldCtx, fixedRunClock(data.left))
+				state.Samples = nil
+				_, err := common.RunString(rt, `http.get(slowServerURL);`)
+				assert.NoError(t, err)
+				assert.Equal(t, data.exceeded, countExceeded())
+			})
+		}
+	})
+	t.Run("BodyData", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(w, r.Body)
+		}))
+		defer srv.Close()
+		rt.Set("echoServerURL", srv.URL)
+
+		oldOpts := state.Options
+		defer func() { state.Options = oldOpts }()
+		state.Options.BodyData = []string{"alice", "bob"}
+
+		oldIter := state.Iteration
+		defer func() { state.Iteration = oldIter }()
+		state.Iteration = 1
+
+		_, err := common.RunString(rt, `
+			let res = http.post(echoServerURL, '{"name":"{{data}}"}');
+			if (res.body != '{"name":"bob"}') { throw new Error("wrong body: " + res.body); }
+		`)
+		assert.NoError(t, err)
+	})
+	t.Run("ExpectedStatuses", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer srv.Close()
+		rt.Set("unauthorizedServerURL", srv.URL)
+
+		for name, data := range map[string]struct {
+			expected []lib.StatusRange
+			failed   float64
+		}{
+			"default": {nil, 1},
+			"custom":  {[]lib.StatusRange{{Min: 200, Max: 399}, {Min: 401, Max: 401}}, 0},
+		} {
+			t.Run(name, func(t *testing.T) {
+				oldStatuses := state.ExpectedStatuses
+				defer func() { state.ExpectedStatuses = oldStatuses }()
+				state.ExpectedStatuses = data.expected
+
+				state.Samples = nil
+				_, err := common.RunString(rt, `http.get(unauthorizedServerURL);`)
+				assert.NoError(t, err)
+
+				seen := false
+				for _, sample := range state.Samples {
+					if sample.Metric == metrics.HTTPReqFailed {
+						seen = true
+						assert.Equal(t, data.failed, sample.Value)
+					}
+				}
+				assert.True(t, seen, "http_req_failed wasn't emitted")
+			})
+		}
+	})
+	t.Run("Trailers", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Trailer", "Grpc-Status")
+			_, _ = w.Write([]byte("hi"))
+			w.Header().Set("Grpc-Status", "0")
+		}))
+		defer srv.Close()
+		rt.Set("trailerServerURL", srv.URL)
+
+		oldOpts := state.Options
+		defer func() { state.Options = oldOpts }()
+		state.Options.CaptureTrailers = null.BoolFrom(true)
+		state.Options.TrailerTags = []string{"Grpc-Status"}
+
+		state.Samples = nil
+		_, err := common.RunString(rt, `
+			let res = http.get(trailerServerURL);
+			if (res.body != "hi") { throw new Error("wrong body: " + res.body); }
+			if (res.trailers["Grpc-Status"] != "0") {
+				throw new Error("wrong trailer: " + res.trailers["Grpc-Status"]);
+			}
+		`)
+		assert.NoError(t, err)
+		for _, sample := range state.Samples {
+			assert.Equal(t, "0", sample.Tags["grpc-status"])
+		}
+	})
+	t.Run("KeepCompressedBody", func(t *testing.T) {
+		oldOpts := state.Options
+		defer func() { state.Options = oldOpts }()
+		state.Options.KeepCompressedBody = null.BoolFrom(true)
+
+		state.Samples = nil
+		_, err := common.RunString(rt, `
+			let res = http.get("http://httpbin.org/gzip", { headers: { "Accept-Encoding": "gzip" } });
+			if (res.json()['gzipped'] != true) {
+				throw new Error("unexpected body data: " + res.json()['gzipped'])
+			}
+			if (res.compressed_body.length == 0 || res.compressed_body == res.body) {
+				throw new Error("compressed body wasn't kept");
+			}
+		`)
+		assert.NoError(t, err)
+
+		seenCompressedSize := false
+		for _, sample := range state.Samples {
+			if sample.Metric == metrics.HTTPRespCompressedSize {
+				seenCompressedSize = true
+				assert.True(t, sample.Value > 0)
+			}
+		}
+		assert.True(t, seenCompressedSize, "didn't emit the compressed size")
+	})
+	t.Run("ResponseSchemas", func(t *testing.T) {
+		oldOpts := state.Options
+		defer func() { state.Options = oldOpts }()
+		var schemas []lib.ResponseSchema
+		assert.NoError(t, json.Unmarshal([]byte(`[
+			{"match": "/json$", "schema": {"type": "object", "required": ["slideshow"]}},
+			{"match": "/get$", "schema": {"type": "object", "required": ["nope"]}}
+		]`), &schemas))
+		state.Options.ResponseSchemas = schemas
+
+		testdata := []struct {
+			url, schemaErr string
+			invalid        float64
+		}{
+			{"http://httpbin.org/json", "", 0},
+			{"http://httpbin.org/get", `$: missing required property "nope"`, 1},
+		}
+		for _, data := range testdata {
+			t.Run(data.url, func(t *testing.T) {
+				state.Samples = nil
+				_, err := common.RunString(rt, fmt.Sprintf(`
+					let res = http.get(%q);
+					if (res.schema_error != %q) {
+						throw new Error("unexpected schema error: " + res.schema_error);
+					}
+				`, data.url, data.schemaErr))
+				assert.NoError(t, err)
+
+				seen := false
+				for _, sample := range state.Samples {
+					if sample.Metric == metrics.HTTPRespSchemaFailed {
+						seen = true
+						assert.Equal(t, data.invalid, sample.Value)
+					}
+				}
+				assert.True(t, seen, "didn't emit http_resp_schema_failed")
+			})
+		}
+	})
+	t.Run("ConnReuseMetrics", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer srv.Close()
+		rt.Set("reuseServerURL", srv.URL)
+
+		oldOpts, oldTransport := state.Options, state.HTTPTransport
+		defer func() { state.Options, state.HTTPTransport = oldOpts, oldTransport }()
+		state.Options.ConnReuseMetrics = null.BoolFrom(true)
+		state.HTTPTransport = &http.Transport{}
+
+		state.Samples = nil
+		_, err := common.RunString(rt, `http.get(reuseServerURL); http.get(reuseServerURL);`)
+		assert.NoError(t, err)
+
+		var values []float64
+		for _, sample := range state.Samples {
+			if sample.Metric == metrics.HTTPReqConnReused {
+				values = append(values, sample.Value)
+				assert.Equal(t, "127.0.0.1", sample.Tags["host"])
+			}
+		}
+		assert.Equal(t, []float64{0, 1}, values)
+	})
+	t.Run("ResponseSizeAlert", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(strings.Repeat("x", 100)))
+		}))
+		defer srv.Close()
+		rt.Set("largeServerURL", srv.URL)
+
+		oldOpts := state.Options
+		defer func() { state.Options = oldOpts }()
+
+		for limit, oversized := range map[int64]bool{0: false, 99: true, 100: false} {
+			t.Run(strconv.FormatInt(limit, 10), func(t *testing.T) {
+				state.Options.ResponseSizeAlert = null.IntFrom(limit)
+
+				state.Samples = nil
+				_, err := common.RunString(rt, `http.get(largeServerURL);`)
+				assert.NoError(t, err)
+
+				seen := false
+				for _, sample := range state.Samples {
+					if sample.Metric == metrics.HTTPRespOversized {
+						seen = true
+						assert.Contains(t, sample.Tags["url"], srv.URL)
+					}
+				}
+				assert.Equal(t, oversized, seen)
+			})
+		}
+	})
 	t.Run("CompressionWithAcceptEncodingHeader", func(t *testing.T) {
 		t.Run("gzip", func(t *testing.T) {
 			_, err := common.RunString(rt, `
@@ -340,6 +1022,34 @@ func TestRequestAndBatch(t *testing.T) {
 				assertRequestMetricsEmitted(t, state.Samples, "GET", cipherSuiteTest.URL, "", 200, "")
 			})
 		}
+		t.Run("peer_certificates", func(t *testing.T) {
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			defer srv.Close()
+			rt.Set("tlsServerURL", srv.URL)
+
+			oldOpts, oldTransport := state.Options, state.HTTPTransport
+			defer func() { state.Options, state.HTTPTransport = oldOpts, oldTransport }()
+			state.HTTPTransport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+
+			_, err := common.RunString(rt, `
+			let res = http.get(tlsServerURL);
+			if (res.tls_peer_certificates && res.tls_peer_certificates.length != 0) { throw new Error("captured certificates without captureTLSDetails"); }
+			`)
+			assert.NoError(t, err)
+
+			state.Options.CaptureTLSDetails = null.BoolFrom(true)
+			fingerprint := sha256.Sum256(srv.TLS.Certificates[0].Certificate[0])
+			_, err = common.RunString(rt, fmt.Sprintf(`
+			let res = http.get(tlsServerURL);
+			let certs = res.tls_peer_certificates;
+			if (certs.length != 1) { throw new Error("wrong number of certificates: " + certs.length); }
+			if (certs[0].issuer != "O=Acme Co") { throw new Error("wrong issuer: " + certs[0].issuer); }
+			if (certs[0].fingerprint != "%x") { throw new Error("wrong fingerprint: " + certs[0].fingerprint); }
+			if (certs[0].dns_names.indexOf("example.com") < 0) { throw new Error("wrong DNS names: " + certs[0].dns_names); }
+			if (certs[0].not_after * 1000 < Date.now()) { throw new Error("wrong expiry: " + certs[0].not_after); }
+			`, fingerprint))
+			assert.NoError(t, err)
+		})
 		t.Run("ocsp_stapled_good", func(t *testing.T) {
 			state.Samples = nil
 			_, err := common.RunString(rt, `
@@ -400,6 +1110,17 @@ func TestRequestAndBatch(t *testing.T) {
 			})
 		}
 
+		t.Run("expectContinue", func(t *testing.T) {
+			state.Samples = nil
+			_, err := common.RunString(rt, `
+			let res = http.request("POST", "https://httpbin.org/post", "data", { expectContinue: true });
+			if (res.status != 200) { throw new Error("wrong status: " + res.status); }
+			if (res.request.headers["Expect"][0] != "100-continue") { throw new Error("wrong Expect header: " + res.request.headers["Expect"]); }
+			`)
+			assert.NoError(t, err)
+			assertRequestMetricsEmitted(t, state.Samples, "POST", "https://httpbin.org/post", "", 200, "")
+		})
+
 		t.Run("cookies", func(t *testing.T) {
 			t.Run("access", func(t *testing.T) {
 				cookieJar, err := cookiejar.New(nil)
@@ -415,6 +1136,30 @@ func TestRequestAndBatch(t *testing.T) {
 				assertRequestMetricsEmitted(t, state.Samples, "GET", "https://httpbin.org/cookies/set?key=value", "", 302, "")
 			})
 
+			t.Run("excludeHosts", func(t *testing.T) {
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					http.SetCookie(w, &http.Cookie{Name: "key", Value: "value"})
+					_, _ = w.Write([]byte(r.Header.Get("Cookie")))
+				}))
+				defer srv.Close()
+				rt.Set("cookieServerURL", srv.URL)
+
+				oldOpts := state.Options
+				defer func() { state.Options = oldOpts }()
+				state.Options.CookieJarExcludeHosts = []string{"127.0.0.1"}
+
+				cookieJar, err := cookiejar.New(nil)
+				assert.NoError(t, err)
+				state.CookieJar = cookieJar
+				_, err = common.RunString(rt, `
+				http.get(cookieServerURL);
+				let res = http.get(cookieServerURL, { cookies: { key2: "value2" } });
+				if (res.body != "key2=value2") { throw new Error("wrong cookies sent: " + res.body); }
+				`)
+				assert.NoError(t, err)
+				assert.Empty(t, cookieJar.Cookies(httptest.NewRequest("GET", srv.URL, nil).URL))
+			})
+
 			t.Run("vuJar", func(t *testing.T) {
 				cookieJar, err := cookiejar.New(nil)
 				assert.NoError(t, err)
@@ -657,6 +1402,31 @@ func TestRequestAndBatch(t *testing.T) {
 				}
 			})
 		})
+
+		t.Run("TagRules", func(t *testing.T) {
+			state.Options.TagRules = []lib.TagRule{
+				{Match: regexp.MustCompile(`^https://httpbin\.org/anything/\d+$`), Name: "https://httpbin.org/anything/{id}"},
+			}
+			defer func() { state.Options.TagRules = nil }()
+
+			state.Samples = nil
+			_, err := common.RunString(rt, `
+			let res = http.get("https://httpbin.org/anything/1234");
+			if (res.status != 200) { throw new Error("wrong status: " + res.status); }
+			`)
+			assert.NoError(t, err)
+			assertRequestMetricsEmitted(t, state.Samples, "GET", "https://httpbin.org/anything/1234", "https://httpbin.org/anything/{id}", 200, "")
+
+			t.Run("Named", func(t *testing.T) {
+				state.Samples = nil
+				_, err := common.RunString(rt, `
+				let res = http.get("https://httpbin.org/anything/1234", { tags: { name: "mine" } });
+				if (res.status != 200) { throw new Error("wrong status: " + res.status); }
+				`)
+				assert.NoError(t, err)
+				assertRequestMetricsEmitted(t, state.Samples, "GET", "https://httpbin.org/anything/1234", "mine", 200, "")
+			})
+		})
 	})
 
 	t.Run("GET", func(t *testing.T) {
@@ -0,0 +1,95 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2018 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// Content types of parts that are compressed already, and don't shrink when gzipped again.
+var compressedContentTypes = map[string]bool{
+	"application/gzip":    true,
+	"application/x-gzip":  true,
+	"application/zip":     true,
+	"application/x-bzip2": true,
+	"application/x-xz":    true,
+	"application/zstd":    true,
+}
+
+// Gzips a multipart request body for the gzipMultipart option, returning false if it was left
+// alone: if it's not multipart, if it already has a Content-Encoding, if it doesn't parse with
+// the boundary from its Content-Type, or if all of its parts are compressed already. The boundary
+// itself is unaffected, as it describes the body before it's encoded.
+func gzipMultipartBody(header http.Header, body []byte) ([]byte, bool, error) {
+	if header.Get("Content-Encoding") != "" {
+		return nil, false, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return nil, false, nil
+	}
+	if compressed, ok := multipartCompressed(body, params["boundary"]); !ok || compressed {
+		return nil, false, nil
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(body); err != nil {
+		return nil, false, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
+
+// Returns whether every part of a multipart body is compressed, and false if it doesn't parse.
+func multipartCompressed(body []byte, boundary string) (compressed, ok bool) {
+	r := multipart.NewReader(bytes.NewReader(body), boundary)
+	parts := 0
+	compressed = true
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, false
+		}
+		parts++
+
+		mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if part.Header.Get("Content-Encoding") == "" && !compressedContentTypes[mediaType] {
+			compressed = false
+		}
+		if _, err := io.Copy(ioutil.Discard, part); err != nil {
+			return false, false
+		}
+	}
+	return compressed && parts > 0, parts > 0
+}
@@ -0,0 +1,68 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2018 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGzipMultipartBody(t *testing.T) {
+	plain := "--b\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nhello\r\n--b--\r\n"
+	compressed := "--b\r\nContent-Disposition: form-data; name=\"f\"\r\nContent-Type: application/gzip\r\n\r\nxyz\r\n--b--\r\n"
+	testdata := map[string]struct {
+		header http.Header
+		body   string
+		ok     bool
+	}{
+		"Multipart":  {http.Header{"Content-Type": {"multipart/form-data; boundary=b"}}, plain, true},
+		"NotMulti":   {http.Header{"Content-Type": {"text/plain"}}, plain, false},
+		"NoBoundary": {http.Header{"Content-Type": {"multipart/form-data"}}, plain, false},
+		"Malformed":  {http.Header{"Content-Type": {"multipart/form-data; boundary=x"}}, plain, false},
+		"Compressed": {http.Header{"Content-Type": {"multipart/form-data; boundary=b"}}, compressed, false},
+		"Encoded": {http.Header{
+			"Content-Type":     {"multipart/form-data; boundary=b"},
+			"Content-Encoding": {"br"},
+		}, plain, false},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			body, ok, err := gzipMultipartBody(data.header, []byte(data.body))
+			assert.NoError(t, err)
+			assert.Equal(t, data.ok, ok)
+			if !ok {
+				return
+			}
+			r, err := gzip.NewReader(bytes.NewReader(body))
+			if !assert.NoError(t, err) {
+				return
+			}
+			decompressed, err := ioutil.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, data.body, string(decompressed))
+		})
+	}
+}
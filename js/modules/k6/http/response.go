@@ -22,7 +22,10 @@ package http
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 
 	"fmt"
@@ -42,6 +45,16 @@ type OCSP struct {
 	Status                                        string
 }
 
+// A certificate the server presented, leaf first, with the captureTLSDetails option.
+// NotBefore and NotAfter are Unix timestamps; Fingerprint is the hex SHA-256 of the certificate.
+type TLSCertificate struct {
+	Subject, Issuer     string
+	SerialNumber        string
+	NotBefore, NotAfter int64
+	DNSNames            []string `js:"dns_names"`
+	Fingerprint         string
+}
+
 type HTTPResponseTimings struct {
 	Duration, Blocked, LookingUp, Connecting, TLSHandshaking, Sending, Waiting, Receiving float64
 }
@@ -55,15 +68,24 @@ type HTTPResponse struct {
 	Status         int
 	Proto          string
 	Headers        map[string]string
+	Trailers       map[string]string
 	Cookies        map[string][]*HTTPCookie
 	Body           string
+	CompressedBody string
 	Timings        HTTPResponseTimings
 	TLSVersion     string
 	TLSCipherSuite string
 	OCSP           OCSP `js:"ocsp"`
 	Error          string
+	TraceID        string
 	Request        HTTPRequest
 
+	// Only filled in with the captureTLSDetails option.
+	TLSPeerCertificates []TLSCertificate
+
+	// Why the body didn't validate against the URL's schema from the responseSchemas option.
+	SchemaError string
+
 	cachedJSON goja.Value
 }
 
@@ -124,6 +146,22 @@ func (res *HTTPResponse) setTLSInfo(tlsState *tls.ConnectionState) {
 	res.OCSP = ocspStapledRes
 }
 
+func (res *HTTPResponse) setTLSPeerCertificates(certs []*x509.Certificate) {
+	res.TLSPeerCertificates = make([]TLSCertificate, len(certs))
+	for i, cert := range certs {
+		fingerprint := sha256.Sum256(cert.Raw)
+		res.TLSPeerCertificates[i] = TLSCertificate{
+			Subject:      cert.Subject.String(),
+			Issuer:       cert.Issuer.String(),
+			SerialNumber: cert.SerialNumber.String(),
+			NotBefore:    cert.NotBefore.Unix(),
+			NotAfter:     cert.NotAfter.Unix(),
+			DNSNames:     cert.DNSNames,
+			Fingerprint:  hex.EncodeToString(fingerprint[:]),
+		}
+	}
+}
+
 func (res *HTTPResponse) Json() goja.Value {
 	if res.cachedJSON == nil {
 		var v interface{}
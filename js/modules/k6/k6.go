@@ -28,6 +28,7 @@ import (
 
 	"github.com/dop251/goja"
 	"github.com/loadimpact/k6/js/common"
+	"github.com/loadimpact/k6/lib"
 	"github.com/loadimpact/k6/lib/metrics"
 	"github.com/loadimpact/k6/stats"
 	"github.com/pkg/errors"
@@ -67,15 +68,20 @@ func (*K6) Group(ctx context.Context, name string, fn goja.Callable) (goja.Value
 	startTime := time.Now()
 	ret, err := fn(goja.Undefined())
 	t := time.Now()
+	tags := map[string]string{
+		"iter": strconv.FormatInt(state.Iteration, 10)}
+	if state.Options.HasSystemTag(lib.SystemTagGroup) {
+		tags["group"] = g.Path
+	}
+	if state.Options.HasSystemTag(lib.SystemTagVU) {
+		tags["vu"] = strconv.FormatInt(state.Vu, 10)
+	}
 	state.Samples = append(state.Samples,
 		stats.Sample{
 			Time:   t,
 			Metric: metrics.GroupDuration,
-			Tags: map[string]string{
-				"group": g.Path,
-				"vu":    strconv.FormatInt(state.Vu, 10),
-				"iter":  strconv.FormatInt(state.Iteration, 10)},
-			Value: stats.D(t.Sub(startTime)),
+			Tags:   tags,
+			Value:  stats.D(t.Sub(startTime)),
 		},
 	)
 	return ret, err
@@ -86,6 +92,11 @@ func (*K6) Check(ctx context.Context, arg0, checks goja.Value, extras ...goja.Va
 	rt := common.GetRuntime(ctx)
 	t := time.Now()
 
+	// With noChecks, just evaluate them; skip tagging, tallying and emitting samples.
+	if state.Options.NoChecks.Bool {
+		return evaluateChecks(rt, arg0, checks)
+	}
+
 	// Prepare tags, make sure the `group` tag can't be overwritten.
 	commonTags := make(map[string]string)
 	if len(extras) > 0 {
@@ -94,7 +105,9 @@ func (*K6) Check(ctx context.Context, arg0, checks goja.Value, extras ...goja.Va
 			commonTags[k] = obj.Get(k).String()
 		}
 	}
-	commonTags["group"] = state.Group.Path
+	if state.Options.HasSystemTag(lib.SystemTagGroup) {
+		commonTags["group"] = state.Group.Path
+	}
 
 	succ := true
 	obj := checks.ToObject(rt)
@@ -112,7 +125,9 @@ func (*K6) Check(ctx context.Context, arg0, checks goja.Value, extras ...goja.Va
 			return false, err
 		}
 		tags["check"] = check.Name
-		tags["vu"] = strconv.FormatInt(state.Vu, 10)
+		if state.Options.HasSystemTag(lib.SystemTagVU) {
+			tags["vu"] = strconv.FormatInt(state.Vu, 10)
+		}
 		tags["iter"] = strconv.FormatInt(state.Iteration, 10)
 
 		// Resolve callables into values.
@@ -148,3 +163,23 @@ func (*K6) Check(ctx context.Context, arg0, checks goja.Value, extras ...goja.Va
 
 	return succ, nil
 }
+
+// Resolves every check to a value, without recording them; returns true if they all passed.
+func evaluateChecks(rt *goja.Runtime, arg0, checks goja.Value) (bool, error) {
+	succ := true
+	obj := checks.ToObject(rt)
+	for _, name := range obj.Keys() {
+		val := obj.Get(name)
+		if fn, ok := goja.AssertFunction(val); ok {
+			val_, err := fn(goja.Undefined(), arg0)
+			if err != nil {
+				return false, err
+			}
+			val = val_
+		}
+		if !val.ToBoolean() {
+			succ = false
+		}
+	}
+	return succ, nil
+}
@@ -32,6 +32,7 @@ import (
 	"github.com/loadimpact/k6/lib"
 	"github.com/loadimpact/k6/lib/metrics"
 	"github.com/stretchr/testify/assert"
+	null "gopkg.in/guregu/null.v3"
 )
 
 func TestFail(t *testing.T) {
@@ -136,7 +137,6 @@ func TestCheck(t *testing.T) {
 			assert.Equal(t, map[string]string{
 				"group": "",
 				"check": "check",
-				"vu":    "0",
 				"iter":  "0",
 			}, state.Samples[0].Tags)
 		}
@@ -167,6 +167,44 @@ func TestCheck(t *testing.T) {
 			assert.True(t, foundB, "missing 'b'")
 		})
 
+		t.Run("SystemTagVU", func(t *testing.T) {
+			state := &common.State{Group: root, Vu: 5, Options: lib.Options{SystemTags: []string{"vu"}}}
+			*ctx = common.WithState(baseCtx, state)
+
+			_, err := common.RunString(rt, `k6.check(null, { "check": true })`)
+			assert.NoError(t, err)
+			if assert.Len(t, state.Samples, 1) {
+				assert.Equal(t, "5", state.Samples[0].Tags["vu"])
+			}
+		})
+
+		t.Run("NoSystemTagGroup", func(t *testing.T) {
+			state := &common.State{Group: root, Options: lib.Options{SystemTags: []string{}}}
+			*ctx = common.WithState(baseCtx, state)
+
+			_, err := common.RunString(rt, `k6.check(null, { "check": true })`)
+			assert.NoError(t, err)
+			if assert.Len(t, state.Samples, 1) {
+				assert.NotContains(t, state.Samples[0].Tags, "group")
+			}
+		})
+
+		t.Run("NoChecks", func(t *testing.T) {
+			state := &common.State{Group: root, Options: lib.Options{NoChecks: null.BoolFrom(true)}}
+			*ctx = common.WithState(baseCtx, state)
+
+			v, err := common.RunString(rt, `k6.check(null, { "unrecorded": true })`)
+			if assert.NoError(t, err) {
+				assert.True(t, v.Export().(bool))
+			}
+			v, err = common.RunString(rt, `k6.check(null, { "unrecorded": true, "failing": function() { return false } })`)
+			if assert.NoError(t, err) {
+				assert.False(t, v.Export().(bool))
+			}
+			assert.Empty(t, state.Samples)
+			assert.NotContains(t, root.Checks, "unrecorded")
+		})
+
 		t.Run("Invalid", func(t *testing.T) {
 			_, err := common.RunString(rt, `k6.check(null, { "::": true })`)
 			assert.EqualError(t, err, "GoError: group and check names may not contain '::'")
@@ -186,7 +224,6 @@ func TestCheck(t *testing.T) {
 			assert.Equal(t, map[string]string{
 				"group": "",
 				"check": "0",
-				"vu":    "0",
 				"iter":  "0",
 			}, state.Samples[0].Tags)
 		}
@@ -251,7 +288,6 @@ func TestCheck(t *testing.T) {
 							assert.Equal(t, map[string]string{
 								"group": "",
 								"check": "check",
-								"vu":    "0",
 								"iter":  "0",
 							}, state.Samples[0].Tags)
 						}
@@ -307,7 +343,6 @@ func TestCheck(t *testing.T) {
 				"check": "check",
 				"a":     "1",
 				"b":     "2",
-				"vu":    "0",
 				"iter":  "0",
 			}, state.Samples[0].Tags)
 		}
@@ -27,6 +27,7 @@ import (
 
 	"github.com/dop251/goja"
 	"github.com/loadimpact/k6/js/common"
+	"github.com/loadimpact/k6/lib"
 	"github.com/loadimpact/k6/stats"
 )
 
@@ -51,8 +52,9 @@ func newMetric(ctxPtr *context.Context, name string, t stats.MetricType, isTime
 func (m Metric) Add(ctx context.Context, v goja.Value, addTags ...map[string]string) {
 	state := common.GetState(ctx)
 
-	tags := map[string]string{
-		"group": state.Group.Path,
+	tags := map[string]string{}
+	if state.Options.HasSystemTag(lib.SystemTagGroup) {
+		tags["group"] = state.Group.Path
 	}
 	for _, ts := range addTags {
 		for k, v := range ts {
@@ -34,6 +34,7 @@ import (
 	"github.com/dop251/goja"
 	"github.com/gorilla/websocket"
 	"github.com/loadimpact/k6/js/common"
+	"github.com/loadimpact/k6/lib"
 	"github.com/loadimpact/k6/lib/metrics"
 	"github.com/loadimpact/k6/stats"
 )
@@ -103,10 +104,12 @@ func (*WS) Connect(ctx context.Context, url string, args ...goja.Value) (*WSHTTP
 
 	tags := map[string]string{
 		"url":         url,
-		"group":       state.Group.Path,
 		"status":      "0",
 		"subprotocol": "",
 	}
+	if state.Options.HasSystemTag(lib.SystemTagGroup) {
+		tags["group"] = state.Group.Path
+	}
 
 	// Parse the optional second argument (params)
 	if !goja.IsUndefined(paramsV) && !goja.IsNull(paramsV) {
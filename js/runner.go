@@ -27,10 +27,13 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dop251/goja"
 	"github.com/loadimpact/k6/js/common"
+	k6http "github.com/loadimpact/k6/js/modules/k6/http"
 	"github.com/loadimpact/k6/lib"
 	"github.com/loadimpact/k6/lib/metrics"
 	"github.com/loadimpact/k6/lib/netext"
@@ -54,6 +57,42 @@ type Runner struct {
 	BaseDialer net.Dialer
 	Resolver   *dnscache.Resolver
 	RPSLimit   *rate.Limiter
+	ConnLimit  *rate.Limiter
+
+	// Shared by all VUs' dialers, if connPoolMetrics is enabled.
+	ConnPool *netext.ConnPool
+
+	// Shared by all VUs' dialers, if networkConditions is set.
+	NetConditions *netext.NetConditions
+
+	// Shared by all VUs' dialers and transports, if proxyTLS is set.
+	ProxyTLS *netext.ProxyTLS
+
+	// Shared by all VUs' dialers, if localPortRange is set.
+	LocalPorts *netext.PortRange
+
+	// The addresses of the bindInterface option's interface, looked up in SetOptions.
+	LocalIPs []net.IP
+
+	// Records all VUs' requests, if harExport is set.
+	HAR *lib.HARRecorder
+
+	// The CRL from the tlsCRL option, loaded when the first VU is created.
+	crl     *lib.CRL
+	crlErr  error
+	crlOnce *sync.Once
+
+	// Shared by all VUs' TLS configs with prewarmTLS, so they resume the prewarmed sessions.
+	tlsSessions tls.ClientSessionCache
+
+	// Hands out requests to VUs instead of running the default function, if replay is set.
+	replay *lib.ReplaySchedule
+
+	// Per-method rate limits, by uppercased method name.
+	MethodRPSLimits map[string]*rate.Limiter
+
+	// Parsed from the expectedStatuses option.
+	ExpectedStatuses []lib.StatusRange
 }
 
 func New(src *lib.SourceData, fs afero.Fs) (*Runner, error) {
@@ -112,51 +151,44 @@ func (r *Runner) newVU() (*VU, error) {
 		return nil, err
 	}
 
-	var cipherSuites []uint16
-	if r.Bundle.Options.TLSCipherSuites != nil {
-		cipherSuites = *r.Bundle.Options.TLSCipherSuites
+	for _, auth := range r.Bundle.Options.TLSAuth {
+		if _, err := auth.Certificate(); err != nil {
+			return nil, err
+		}
 	}
-
-	var tlsVersions lib.TLSVersions
-	if r.Bundle.Options.TLSVersion != nil {
-		tlsVersions = *r.Bundle.Options.TLSVersion
+	for _, auth := range r.Bundle.Options.TLSAuthByHost {
+		if _, err := auth.Certificate(); err != nil {
+			return nil, err
+		}
 	}
-
-	tlsAuth := r.Bundle.Options.TLSAuth
-	certs := make([]tls.Certificate, len(tlsAuth))
-	nameToCert := make(map[string]*tls.Certificate)
-	for i, auth := range tlsAuth {
-		for _, name := range auth.Domains {
-			cert, err := auth.Certificate()
-			if err != nil {
+	for _, hostTLS := range r.Bundle.Options.PerHostTLS {
+		if hostTLS.Auth != nil {
+			if _, err := hostTLS.Auth.Certificate(); err != nil {
 				return nil, err
 			}
-			certs[i] = *cert
-			nameToCert[name] = &certs[i]
 		}
 	}
 
-	dialer := &netext.Dialer{
-		Dialer:    r.BaseDialer,
-		Resolver:  r.Resolver,
-		Blacklist: r.Bundle.Options.BlacklistIPs,
-		Hosts:     r.Bundle.Options.Hosts,
+	var warmup goja.Callable
+	if w := r.Bundle.Options.VUWarmup; w != nil && w.Exec != "" {
+		exports := bi.Runtime.Get("exports").ToObject(bi.Runtime)
+		fn, ok := goja.AssertFunction(exports.Get(w.Exec))
+		if !ok {
+			return nil, errors.Errorf("warmup function %s isn't exported", w.Exec)
+		}
+		warmup = fn
 	}
-	transport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: r.Bundle.Options.InsecureSkipTLSVerify.Bool,
-			CipherSuites:       cipherSuites,
-			MinVersion:         uint16(tlsVersions.Min),
-			MaxVersion:         uint16(tlsVersions.Max),
-			Certificates:       certs,
-			NameToCertificate:  nameToCert,
-			Renegotiation:      tls.RenegotiateFreelyAsClient,
-		},
-		DialContext:        dialer.DialContext,
-		DisableCompression: true,
+
+	dialer := r.newDialer()
+	options := r.Bundle.Options
+	transport, err := r.newTransport(dialer, options.TLSAuthForPattern(""), nil, options.HTTPVersion.String)
+	if err != nil {
+		return nil, err
+	}
+	var transports *hostTransport
+	if len(options.HostHTTPVersions) > 0 || len(options.PerHostTLS) > 0 || len(options.TLSAuthPatterns()) > 0 {
+		transports = newHostTransport(r, dialer, transport)
 	}
-	_ = http2.ConfigureTransport(transport)
 
 	vu := &VU{
 		BundleInstance: *bi,
@@ -165,6 +197,11 @@ func (r *Runner) newVU() (*VU, error) {
 		Dialer:         dialer,
 		Console:        NewConsole(),
 		BPool:          bpool.NewBufferPool(100),
+		transports:     transports,
+		warmup:         warmup,
+	}
+	if rps := r.Bundle.Options.RPSPerVU; rps.Valid && rps.Int64 > 0 {
+		vu.RPSLimit = rate.NewLimiter(rate.Limit(rps.Int64), 1)
 	}
 	vu.Runtime.Set("console", common.Bind(vu.Runtime, vu.Console, vu.Context))
 
@@ -180,6 +217,10 @@ func (r *Runner) GetDefaultGroup() *lib.Group {
 	return r.defaultGroup
 }
 
+func (r *Runner) GetHARRecorder() *lib.HARRecorder {
+	return r.HAR
+}
+
 func (r *Runner) GetOptions() lib.Options {
 	return r.Bundle.Options
 }
@@ -191,6 +232,253 @@ func (r *Runner) SetOptions(opts lib.Options) {
 	if rps := opts.RPS; rps.Valid {
 		r.RPSLimit = rate.NewLimiter(rate.Limit(rps.Int64), 1)
 	}
+
+	r.MethodRPSLimits = nil
+	for method, rps := range opts.MethodRPS {
+		if r.MethodRPSLimits == nil {
+			r.MethodRPSLimits = make(map[string]*rate.Limiter, len(opts.MethodRPS))
+		}
+		r.MethodRPSLimits[strings.ToUpper(method)] = rate.NewLimiter(rate.Limit(rps), 1)
+	}
+
+	r.ConnLimit = nil
+	if connRate := opts.ConnRatePerSec; connRate.Valid && connRate.Int64 > 0 {
+		r.ConnLimit = rate.NewLimiter(rate.Limit(connRate.Int64), 1)
+	}
+
+	r.ConnPool = nil
+	if opts.ConnPoolMetrics.Bool {
+		r.ConnPool = netext.NewConnPool()
+	}
+
+	r.NetConditions = nil
+	if nc := opts.NetworkConditions; nc != nil {
+		r.NetConditions = netext.NewNetConditions(time.Duration(nc.Latency), time.Duration(nc.Jitter), nc.Loss, nc.Seed)
+	}
+
+	r.ProxyTLS = nil
+	if opts.ProxyTLS != nil {
+		r.ProxyTLS = netext.NewProxyTLS(opts.ProxyTLS.Config())
+	}
+
+	// Invalid options are also rejected by Options.Validate() before a test starts, but not every
+	// command runs one; the defaults are used instead.
+	r.ExpectedStatuses = nil
+	if ranges, err := lib.ParseStatusRanges(opts.ExpectedStatuses); err != nil {
+		r.Logger.WithError(err).Warn("Ignoring expectedStatuses")
+	} else {
+		r.ExpectedStatuses = ranges
+	}
+
+	r.LocalPorts = nil
+	if opts.LocalPortRange.Valid {
+		if min, max, err := lib.ParsePortRange(opts.LocalPortRange.String); err != nil {
+			r.Logger.WithError(err).Warn("Ignoring localPortRange")
+		} else {
+			r.LocalPorts = netext.NewPortRange(min, max)
+		}
+	}
+
+	r.LocalIPs = nil
+	if opts.BindInterface.Valid {
+		if ips, err := lib.InterfaceIPs(opts.BindInterface.String); err != nil {
+			r.Logger.WithError(err).Warn("Ignoring bindInterface")
+		} else {
+			r.LocalIPs = ips
+		}
+	}
+
+	r.tlsSessions = nil
+	if opts.PrewarmTLS.Bool {
+		r.tlsSessions = tls.NewLRUClientSessionCache(0)
+	}
+
+	r.HAR = nil
+	if opts.HARExport != nil {
+		r.HAR = lib.NewHARRecorder(*opts.HARExport)
+		r.HAR.Logger = r.Logger
+	}
+
+	r.replay = nil
+	if len(opts.Replay) > 0 {
+		r.replay = lib.NewReplaySchedule(opts.Replay)
+	}
+
+	r.crl, r.crlErr = nil, nil
+	r.crlOnce = new(sync.Once)
+}
+
+// Resolves and dials every host in the prewarm option, with a dialer and TLS configs like the
+// ones VUs get, so that the DNS cache and TLS sessions it leaves behind are the ones VUs will
+// use. No VU is made for it, so the script's init code doesn't run an extra time. Each host
+// gets as long as a request to it would. Hosts that can't be reached are logged and skipped.
+func (r *Runner) Prewarm(ctx context.Context) error {
+	opts := r.Bundle.Options
+	addrs := opts.PrewarmAddrs()
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	dialer := r.newDialer()
+	for _, addr := range addrs {
+		host, _, _ := net.SplitHostPort(addr)
+		var tlsConfig *tls.Config
+		if opts.PrewarmTLS.Bool {
+			var hostTLS *lib.HostTLSConfig
+			if pattern, ok := opts.HostTLSPattern(host); ok {
+				config := opts.PerHostTLS[pattern]
+				hostTLS = &config
+			}
+			var err error
+			if tlsConfig, err = r.newTLSConfig(opts.TLSAuthFor(host), hostTLS); err != nil {
+				return err
+			}
+		}
+		hostCtx, cancel := context.WithTimeout(ctx, opts.RequestTimeoutFor(host))
+		err := dialer.Prewarm(hostCtx, addr, tlsConfig)
+		cancel()
+		if err != nil {
+			r.Logger.WithError(err).WithField("addr", addr).Warn("Couldn't prewarm host")
+		}
+	}
+	return nil
+}
+
+// Returns a dialer for a VU's connections, sharing the runner's resolver, limits and pools.
+func (r *Runner) newDialer() *netext.Dialer {
+	dialer := &netext.Dialer{
+		Dialer:    r.BaseDialer,
+		Resolver:  r.Resolver,
+		Blacklist: r.Bundle.Options.BlacklistIPs,
+		Hosts:     r.Bundle.Options.Hosts,
+
+		DNSRetries:      int(r.Bundle.Options.DNSRetries.Int64),
+		DNSRetryBackoff: netext.DefaultDNSRetryBackoff,
+		ConnLimit:       r.ConnLimit,
+		ConnPool:        r.ConnPool,
+		NetConditions:   r.NetConditions,
+		ProxyTLS:        r.ProxyTLS,
+		LocalPorts:      r.LocalPorts,
+		LocalIPs:        r.LocalIPs,
+	}
+	if r.Bundle.Options.DNSRetryBackoff.Valid {
+		dialer.DNSRetryBackoff = time.Duration(r.Bundle.Options.DNSRetryBackoff.Duration)
+	}
+	return dialer
+}
+
+// Returns a transport for a VU's HTTP requests that speaks the given HTTP version; an empty one
+// negotiates HTTP/2 with servers that offer it. A non-nil hostTLS overrides the global TLS options
+// for the hosts it's configured for; see lib.Options.PerHostTLS.
+func (r *Runner) newTransport(dialer *netext.Dialer, tlsAuth []*lib.TLSAuth, hostTLS *lib.HostTLSConfig, version string) (*http.Transport, error) {
+	tlsConfig, err := r.newTLSConfig(tlsAuth, hostTLS)
+	if err != nil {
+		return nil, err
+	}
+	nextProtos := r.Bundle.Options.TLSNextProtos
+	if hostTLS != nil && hostTLS.NextProtos != nil {
+		nextProtos = hostTLS.NextProtos
+	}
+
+	transport := &http.Transport{
+		Proxy:                  http.ProxyFromEnvironment,
+		TLSClientConfig:        tlsConfig,
+		DialContext:            dialer.DialContext,
+		DisableCompression:     true,
+		ExpectContinueTimeout:  time.Duration(r.Bundle.Options.ExpectContinueTimeout.Duration),
+		IdleConnTimeout:        time.Duration(r.Bundle.Options.IdleConnTimeout.Duration),
+		MaxResponseHeaderBytes: r.Bundle.Options.MaxResponseHeaderBytes.Int64,
+	}
+	if r.ProxyTLS != nil {
+		transport.Proxy = r.ProxyTLS.Proxy(transport.Proxy)
+	}
+
+	switch version {
+	case lib.HTTPVersion11:
+		// A non-nil TLSNextProto keeps the transport from switching to HTTP/2 on its own.
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	case lib.HTTPVersion2:
+		_ = http2.ConfigureTransport(transport)
+		transport.TLSClientConfig.NextProtos = []string{"h2"}
+	default:
+		// HTTP/2 isn't held to MaxResponseHeaderBytes, so don't offer it with a limit;
+		// Options.Validate() rejects options asking for both.
+		if r.Bundle.Options.MaxResponseHeaderBytes.Valid {
+			transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+			transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+		} else {
+			_ = http2.ConfigureTransport(transport)
+		}
+
+		// ConfigureTransport adds h2 and http/1.1 to NextProtos; an explicit list takes priority.
+		if nextProtos != nil {
+			if err := lib.ValidateTLSNextProtos(nextProtos); err != nil {
+				return nil, err
+			}
+			transport.TLSClientConfig.NextProtos = nextProtos
+		}
+	}
+	return transport, nil
+}
+
+// Returns the TLS config for a VU's connections, presenting the given client certificates unless
+// a non-nil hostTLS has its own, like newTransport(), but without ALPN.
+func (r *Runner) newTLSConfig(tlsAuth []*lib.TLSAuth, hostTLS *lib.HostTLSConfig) (*tls.Config, error) {
+	var cipherSuites []uint16
+	if r.Bundle.Options.TLSCipherSuites != nil {
+		cipherSuites = *r.Bundle.Options.TLSCipherSuites
+	}
+
+	var tlsVersions lib.TLSVersions
+	if r.Bundle.Options.TLSVersion != nil {
+		tlsVersions = *r.Bundle.Options.TLSVersion
+	}
+	insecureSkipVerify := r.Bundle.Options.InsecureSkipTLSVerify.Bool
+	var serverName string
+	if hostTLS != nil {
+		if hostTLS.Version != nil {
+			tlsVersions = *hostTLS.Version
+		}
+		if hostTLS.InsecureSkipVerify.Valid {
+			insecureSkipVerify = hostTLS.InsecureSkipVerify.Bool
+		}
+		if hostTLS.Auth != nil {
+			tlsAuth = []*lib.TLSAuth{hostTLS.Auth}
+		}
+		serverName = hostTLS.ServerName.String
+	}
+
+	config := &tls.Config{
+		InsecureSkipVerify: insecureSkipVerify,
+		ServerName:         serverName,
+		CipherSuites:       cipherSuites,
+		MinVersion:         uint16(tlsVersions.Min),
+		MaxVersion:         uint16(tlsVersions.Max),
+		Renegotiation:      tls.RenegotiateFreelyAsClient,
+		ClientSessionCache: r.tlsSessions,
+	}
+	if len(tlsAuth) > 0 {
+		config.GetClientCertificate = lib.GetClientCertificateFunc(tlsAuth, r.Bundle.Options.TLSAuthWatch.Bool)
+	}
+	crl, err := r.getCRL()
+	if err != nil {
+		return nil, err
+	}
+	if crl != nil {
+		config.VerifyPeerCertificate = crl.VerifyPeerCertificate
+	}
+	return config, nil
+}
+
+// Returns the CRL given by the tlsCRL option, if any, loading it the first time it's needed.
+func (r *Runner) getCRL() (*lib.CRL, error) {
+	r.crlOnce.Do(func() {
+		if src := r.Bundle.Options.TLSCRL; src.Valid && src.String != "" {
+			r.crl, r.crlErr = lib.LoadCRL(src.String)
+		}
+	})
+	return r.crl, r.crlErr
 }
 
 type VU struct {
@@ -205,6 +493,17 @@ type VU struct {
 	Console *Console
 	BPool   *bpool.BufferPool
 
+	// This VU's own request rate limit, from the rpsPerVU option; nil if unlimited.
+	RPSLimit *rate.Limiter
+
+	// Picks a transport per host if hosts can need other HTTP versions or TLS settings than
+	// HTTPTransport's; nil otherwise.
+	transports *hostTransport
+
+	// The VUWarmup option's exported function, if any, and whether it's been run.
+	warmup   goja.Callable
+	warmedUp bool
+
 	// A VU will track the last context it was called with for cancellation.
 	// Note that interruptTrackedCtx is the context that is currently being tracked, while
 	// interruptCancel cancels an unrelated context that terminates the tracking goroutine
@@ -239,17 +538,26 @@ func (u *VU) RunOnce(ctx context.Context) ([]stats.Sample, error) {
 		return nil, err
 	}
 
+	var transport http.RoundTripper = u.HTTPTransport
+	if u.transports != nil {
+		transport = u.transports
+	}
+
 	state := &common.State{
-		Logger:        u.Runner.Logger,
-		Options:       u.Runner.Bundle.Options,
-		Group:         u.Runner.defaultGroup,
-		HTTPTransport: u.HTTPTransport,
-		Dialer:        u.Dialer,
-		CookieJar:     cookieJar,
-		RPSLimit:      u.Runner.RPSLimit,
-		BPool:         u.BPool,
-		Vu:            u.ID,
-		Iteration:     u.Iteration,
+		Logger:           u.Runner.Logger,
+		Options:          u.Runner.Bundle.Options,
+		Group:            u.Runner.defaultGroup,
+		HTTPTransport:    transport,
+		Dialer:           u.Dialer,
+		CookieJar:        cookieJar,
+		RPSLimit:         u.Runner.RPSLimit,
+		VURPSLimit:       u.RPSLimit,
+		MethodRPSLimits:  u.Runner.MethodRPSLimits,
+		ExpectedStatuses: u.Runner.ExpectedStatuses,
+		HAR:              u.Runner.HAR,
+		BPool:            u.BPool,
+		Vu:               u.ID,
+		Iteration:        u.Iteration,
 	}
 	u.Dialer.BytesRead = &state.BytesRead
 	u.Dialer.BytesWritten = &state.BytesWritten
@@ -258,30 +566,193 @@ func (u *VU) RunOnce(ctx context.Context) ([]stats.Sample, error) {
 	ctx = common.WithState(ctx, state)
 	*u.Context = ctx
 
+	if !u.warmedUp && u.Runner.Bundle.Options.VUWarmup != nil {
+		u.warmedUp = true
+		err := u.runWarmup(ctx)
+		state.Samples = nil
+		state.BytesRead, state.BytesWritten = 0, 0
+		if err != nil {
+			return nil, errors.Wrap(err, "vu warmup")
+		}
+	}
+
 	u.Runtime.Set("__ITER", u.Iteration)
 	iter := u.Iteration
 	u.Iteration++
 
+	var replayReq lib.ReplayRequest
+	if u.Runner.replay != nil {
+		var ok bool
+		if replayReq, ok = u.Runner.replay.Wait(ctx); !ok {
+			return nil, nil
+		}
+	}
+
 	startTime := time.Now()
-	_, err = u.Default(goja.Undefined())
+	if u.Runner.replay != nil {
+		err = u.runReplayRequest(ctx, replayReq)
+	} else {
+		_, err = u.Default(goja.Undefined())
+	}
 
 	t := time.Now()
 	tags := map[string]string{
-		"vu":   strconv.FormatInt(u.ID, 10),
 		"iter": strconv.FormatInt(iter, 10)}
+	if u.Runner.Bundle.Options.HasSystemTag(lib.SystemTagVU) {
+		tags["vu"] = strconv.FormatInt(u.ID, 10)
+	}
 
 	samples := append(state.Samples,
 		stats.Sample{Time: t, Metric: metrics.DataSent, Value: float64(state.BytesWritten), Tags: tags},
 		stats.Sample{Time: t, Metric: metrics.DataReceived, Value: float64(state.BytesRead), Tags: tags},
 		stats.Sample{Time: t, Metric: metrics.IterationDuration, Value: stats.D(t.Sub(startTime)), Tags: tags},
 	)
+	if dataTags := u.Runner.Bundle.Options.TagDataFor(iter); len(dataTags) > 0 {
+		samples = addDataTags(samples, dataTags)
+	}
+	if gaugeReset := u.Runner.Bundle.Options.GaugeReset; len(gaugeReset) > 0 {
+		samples = append(samples, stats.ZeroGauges(state.Samples, t, func(m *stats.Metric) bool {
+			return gaugeReset[m.Name] == stats.GaugeResetIteration
+		})...)
+	}
 
 	if u.Runner.Bundle.Options.NoConnectionReuse.Bool {
-		u.HTTPTransport.CloseIdleConnections()
+		if u.transports != nil {
+			u.transports.closeIdleConnections()
+		} else {
+			u.HTTPTransport.CloseIdleConnections()
+		}
 	}
 	return samples, err
 }
 
+// Adds an iteration's TagData tags to its samples, without overriding ones they already have.
+// Samples often share tag maps, so each one gets a copy.
+func addDataTags(samples []stats.Sample, dataTags map[string]string) []stats.Sample {
+	for i, sample := range samples {
+		tags := make(map[string]string, len(sample.Tags)+len(dataTags))
+		for k, v := range dataTags {
+			tags[k] = v
+		}
+		for k, v := range sample.Tags {
+			tags[k] = v
+		}
+		samples[i].Tags = tags
+	}
+	return samples
+}
+
+// Sends each request through the transport for the HTTP version and TLS settings configured for
+// its host. Transports are made the first time a host needs them, then kept for the VU's lifetime;
+// requests in a batch may need one at the same time, hence the lock.
+type hostTransport struct {
+	runner *Runner
+	dialer *netext.Dialer
+
+	lock       sync.Mutex
+	transports map[transportKey]*http.Transport
+}
+
+type transportKey struct {
+	tlsKey
+	version string
+}
+
+// Makes a hostTransport that starts out with the given transport for hosts without settings of
+// their own.
+func newHostTransport(r *Runner, dialer *netext.Dialer, transport *http.Transport) *hostTransport {
+	key := transportKey{version: r.Bundle.Options.HTTPVersion.String}
+	return &hostTransport{
+		runner:     r,
+		dialer:     dialer,
+		transports: map[transportKey]*http.Transport{key: transport},
+	}
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport, err := t.transportFor(req.URL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	return transport.RoundTrip(req)
+}
+
+// Returns the transport with the HTTP version and TLS settings for the given hostname.
+func (t *hostTransport) transportFor(host string) (*http.Transport, error) {
+	options := t.runner.Bundle.Options
+	key := transportKey{tlsKeyFor(options, host), options.HTTPVersionFor(host)}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if transport := t.transports[key]; transport != nil {
+		return transport, nil
+	}
+
+	var hostTLS *lib.HostTLSConfig
+	if key.hostTLS != "" {
+		config := options.PerHostTLS[key.hostTLS]
+		hostTLS = &config
+	}
+	transport, err := t.runner.newTransport(t.dialer, options.TLSAuthForPattern(key.auth), hostTLS, key.version)
+	if err != nil {
+		return nil, err
+	}
+	t.transports[key] = transport
+	return transport, nil
+}
+
+func (t *hostTransport) closeIdleConnections() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, transport := range t.transports {
+		transport.CloseIdleConnections()
+	}
+}
+
+// Identifies the TLS settings for a host: the lib.Options.PerHostTLS pattern that applies to it,
+// and the TLSAuth domain pattern picking its client certificates, unless the former comes with
+// its own. Either may be empty; see lib.Options.TLSAuthFor().
+type tlsKey struct {
+	hostTLS, auth string
+}
+
+func tlsKeyFor(options lib.Options, host string) tlsKey {
+	var key tlsKey
+	key.hostTLS, _ = options.HostTLSPattern(host)
+	if key.hostTLS == "" || options.PerHostTLS[key.hostTLS].Auth == nil {
+		key.auth, _ = options.TLSAuthPattern(host)
+	}
+	return key
+}
+
+// Calls the VUWarmup option's exported function, then sends its requests.
+func (u *VU) runWarmup(ctx context.Context) error {
+	if u.warmup != nil {
+		if _, err := u.warmup(goja.Undefined()); err != nil {
+			return err
+		}
+	}
+	for _, req := range u.Runner.Bundle.Options.VUWarmup.Requests {
+		if req.Method == "" {
+			req.Method = "GET"
+		}
+		if err := u.runReplayRequest(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sends a recorded request the same way http.request() would from the script.
+func (u *VU) runReplayRequest(ctx context.Context, req lib.ReplayRequest) error {
+	body := goja.Undefined()
+	if req.Body != "" {
+		body = u.Runtime.ToValue(req.Body)
+	}
+	_, err := k6http.New().Request(ctx, req.Method, u.Runtime.ToValue(req.URL), body)
+	return err
+}
+
 func (u *VU) Reconfigure(id int64) error {
 	u.ID = id
 	u.Iteration = 0
@@ -22,13 +22,22 @@ package js
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	stdlog "log"
+	"math/big"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -39,6 +48,8 @@ import (
 	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/time/rate"
 	"gopkg.in/guregu/null.v3"
 )
 
@@ -647,6 +658,485 @@ func TestVUIntegrationHTTP2(t *testing.T) {
 	}
 }
 
+func TestVUIntegrationTLSNextProtos(t *testing.T) {
+	r, err := New(&lib.SourceData{
+		Filename: "/script.js",
+		Data: []byte(`
+			import http from "k6/http";
+			export default function() {
+				let res = http.request("GET", "https://http2.akamai.com/demo");
+				if (res.proto != "HTTP/1.1") { throw new Error("wrong proto: " + res.proto) }
+			}
+		`),
+	}, afero.NewMemMapFs())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	t.Run("HTTP/1.1", func(t *testing.T) {
+		r.SetOptions(lib.Options{Throw: null.BoolFrom(true), TLSNextProtos: []string{"http/1.1"}})
+		vu, err := r.NewVU()
+		if !assert.NoError(t, err) {
+			return
+		}
+		_, err = vu.RunOnce(context.Background())
+		assert.NoError(t, err)
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		r.SetOptions(lib.Options{TLSNextProtos: []string{""}})
+		_, err := r.NewVU()
+		assert.EqualError(t, err, `invalid ALPN protocol #0: ""`)
+	})
+}
+
+func TestVUIntegrationIdleConnTimeout(t *testing.T) {
+	r, err := New(&lib.SourceData{
+		Filename: "/script.js",
+		Data:     []byte(`export default function() {}`),
+	}, afero.NewMemMapFs())
+	if !assert.NoError(t, err) {
+		return
+	}
+	r.SetOptions(lib.Options{IdleConnTimeout: lib.NullDurationFrom(30 * time.Second)})
+
+	vu, err := r.newVU()
+	if assert.NoError(t, err) {
+		assert.Equal(t, 30*time.Second, vu.HTTPTransport.IdleConnTimeout)
+	}
+}
+
+func TestVUIntegrationTagData(t *testing.T) {
+	r, err := New(&lib.SourceData{
+		Filename: "/script.js",
+		Data:     []byte(`export default function() {}`),
+	}, afero.NewMemMapFs())
+	if !assert.NoError(t, err) {
+		return
+	}
+	r.SetOptions(lib.Options{
+		TagData:     []map[string]string{{"customer_tier": "gold", "id": "1"}, {"customer_tier": "free"}},
+		TagDataTags: []string{"customer_tier"},
+	})
+
+	vu, err := r.newVU()
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, tier := range []string{"gold", "free", "gold"} {
+		samples, err := vu.RunOnce(context.Background())
+		assert.NoError(t, err)
+		for _, s := range samples {
+			assert.Equal(t, tier, s.Tags["customer_tier"], s.Metric.Name)
+			assert.NotContains(t, s.Tags, "id")
+			assert.Contains(t, s.Tags, "iter")
+		}
+	}
+}
+
+func TestVUIntegrationMaxResponseHeaderBytes(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Padding", strings.Repeat("x", 2048))
+	})
+	newRunner := func(t *testing.T, url string) *Runner {
+		r, err := New(&lib.SourceData{
+			Filename: "/script.js",
+			Data: []byte(fmt.Sprintf(`
+				import http from "k6/http";
+				export default function() {
+					let res = http.get("%s");
+					if (res.error.indexOf("server response headers exceeded 1024 bytes") == -1) {
+						throw new Error("unexpected error: " + res.error);
+					}
+				}
+			`, url)),
+		}, afero.NewMemMapFs())
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		r.SetOptions(lib.Options{
+			MaxResponseHeaderBytes: null.IntFrom(1024),
+			InsecureSkipTLSVerify:  null.BoolFrom(true),
+		})
+		return r
+	}
+
+	t.Run("HTTP1", func(t *testing.T) {
+		srv := httptest.NewServer(handler)
+		defer srv.Close()
+
+		vu, err := newRunner(t, srv.URL).newVU()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, int64(1024), vu.HTTPTransport.MaxResponseHeaderBytes)
+		_, err = vu.RunOnce(context.Background())
+		assert.NoError(t, err)
+	})
+	t.Run("HTTP2", func(t *testing.T) {
+		// The server would pick HTTP/2 if it was offered, which isn't held to the limit.
+		srv := httptest.NewUnstartedServer(handler)
+		if !assert.NoError(t, http2.ConfigureServer(srv.Config, nil)) {
+			return
+		}
+		srv.TLS = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+		srv.StartTLS()
+		defer srv.Close()
+
+		vu, err := newRunner(t, srv.URL).newVU()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, []string{"http/1.1"}, vu.HTTPTransport.TLSClientConfig.NextProtos)
+		_, err = vu.RunOnce(context.Background())
+		assert.NoError(t, err)
+	})
+}
+
+func TestVUIntegrationHostHTTPVersions(t *testing.T) {
+	r, err := New(&lib.SourceData{
+		Filename: "/script.js",
+		Data:     []byte(`export default function() {}`),
+	}, afero.NewMemMapFs())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	t.Run("Unset", func(t *testing.T) {
+		vu, err := r.newVU()
+		if assert.NoError(t, err) {
+			assert.Nil(t, vu.transports)
+			assert.Equal(t, []string{"h2", "http/1.1"}, vu.HTTPTransport.TLSClientConfig.NextProtos)
+		}
+	})
+	t.Run("Set", func(t *testing.T) {
+		r.SetOptions(lib.Options{
+			HTTPVersion:      null.StringFrom("2"),
+			HostHTTPVersions: map[string]string{"*.example.com": "1.1", "api.example.com": "2"},
+		})
+		vu, err := r.newVU()
+		if !assert.NoError(t, err) {
+			return
+		}
+		if !assert.NotNil(t, vu.transports) {
+			return
+		}
+		assert.Len(t, vu.transports.transports, 1)
+
+		api, err := vu.transports.transportFor("api.example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, vu.HTTPTransport, api)
+		assert.Equal(t, []string{"h2"}, api.TLSClientConfig.NextProtos)
+
+		www, err := vu.transports.transportFor("www.example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"http/1.1"}, www.TLSClientConfig.NextProtos)
+		assert.NotNil(t, www.TLSNextProto)
+		assert.Empty(t, www.TLSNextProto)
+
+		cdn, err := vu.transports.transportFor("cdn.example.com")
+		assert.NoError(t, err)
+		assert.True(t, www == cdn, "transports aren't shared between hosts")
+		assert.Len(t, vu.transports.transports, 2)
+	})
+}
+
+func TestVUIntegrationPerHostTLS(t *testing.T) {
+	r, err := New(&lib.SourceData{
+		Filename: "/script.js",
+		Data:     []byte(`export default function() {}`),
+	}, afero.NewMemMapFs())
+	if !assert.NoError(t, err) {
+		return
+	}
+	r.SetOptions(lib.Options{
+		InsecureSkipTLSVerify: null.BoolFrom(false),
+		TLSVersion:            &lib.TLSVersions{Min: tls.VersionTLS10},
+		HostHTTPVersions:      map[string]string{"legacy.example.com": "1.1"},
+		PerHostTLS: map[string]lib.HostTLSConfig{"*.example.com": {
+			Version:            &lib.TLSVersions{Min: tls.VersionTLS12},
+			InsecureSkipVerify: null.BoolFrom(true),
+			ServerName:         null.StringFrom("origin.example.com"),
+			NextProtos:         []string{"http/1.1"},
+		}},
+	})
+	vu, err := r.newVU()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.False(t, vu.HTTPTransport.TLSClientConfig.InsecureSkipVerify)
+	assert.Equal(t, uint16(tls.VersionTLS10), vu.HTTPTransport.TLSClientConfig.MinVersion)
+
+	if !assert.NotNil(t, vu.transports) {
+		return
+	}
+	assert.Len(t, vu.transports.transports, 1)
+
+	transport, err := vu.transports.transportFor("www.example.com")
+	if assert.NoError(t, err) {
+		config := transport.TLSClientConfig
+		assert.True(t, config.InsecureSkipVerify)
+		assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+		assert.Equal(t, "origin.example.com", config.ServerName)
+		assert.Equal(t, []string{"http/1.1"}, config.NextProtos)
+	}
+	legacy, err := vu.transports.transportFor("legacy.example.com")
+	if assert.NoError(t, err) {
+		assert.True(t, legacy.TLSClientConfig.InsecureSkipVerify)
+		assert.False(t, legacy == transport)
+	}
+	other, err := vu.transports.transportFor("example.org")
+	if assert.NoError(t, err) {
+		assert.Equal(t, vu.HTTPTransport, other)
+	}
+	assert.Len(t, vu.transports.transports, 3)
+}
+
+func TestVUIntegrationTLSCRL(t *testing.T) {
+	r, err := New(&lib.SourceData{
+		Filename: "/script.js",
+		Data:     []byte(`export default function() {}`),
+	}, afero.NewMemMapFs())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	r.SetOptions(lib.Options{TLSCRL: null.StringFrom("/nonexistent/ca.crl")})
+	_, err = r.NewVU()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "couldn't load CRL from /nonexistent/ca.crl")
+	}
+}
+
+func TestVUIntegrationRPSPerVU(t *testing.T) {
+	r, err := New(&lib.SourceData{
+		Filename: "/script.js",
+		Data:     []byte(`export default function() {}`),
+	}, afero.NewMemMapFs())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	t.Run("Unset", func(t *testing.T) {
+		vu, err := r.newVU()
+		if assert.NoError(t, err) {
+			assert.Nil(t, vu.RPSLimit)
+		}
+	})
+	t.Run("Set", func(t *testing.T) {
+		r.SetOptions(lib.Options{RPSPerVU: null.IntFrom(5)})
+		vu1, err := r.newVU()
+		if !assert.NoError(t, err) {
+			return
+		}
+		vu2, err := r.newVU()
+		if !assert.NoError(t, err) {
+			return
+		}
+		if assert.NotNil(t, vu1.RPSLimit) && assert.NotNil(t, vu2.RPSLimit) {
+			assert.Equal(t, rate.Limit(5), vu1.RPSLimit.Limit())
+			assert.True(t, vu1.RPSLimit != vu2.RPSLimit, "VUs share a limiter")
+		}
+	})
+}
+
+func TestVUIntegrationReplay(t *testing.T) {
+	var lock sync.Mutex
+	var seen []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		lock.Lock()
+		seen = append(seen, req.Method+" "+req.URL.Path+" "+string(body))
+		lock.Unlock()
+	}))
+	defer srv.Close()
+
+	r, err := New(&lib.SourceData{
+		Filename: "/script.js",
+		Data:     []byte(`export default function() { throw new Error("default function called"); }`),
+	}, afero.NewMemMapFs())
+	if !assert.NoError(t, err) {
+		return
+	}
+	r.SetOptions(lib.Options{Replay: []lib.ReplayRequest{
+		{Method: "GET", URL: srv.URL + "/a"},
+		{Offset: lib.Duration(50 * time.Millisecond), Method: "POST", URL: srv.URL + "/b", Body: "hi"},
+	}})
+
+	vu, err := r.NewVU()
+	if !assert.NoError(t, err) {
+		return
+	}
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		samples, err := vu.RunOnce(context.Background())
+		assert.NoError(t, err)
+		reqs := 0
+		for _, sample := range samples {
+			if sample.Metric == metrics.HTTPReqs {
+				reqs++
+			}
+		}
+		assert.Equal(t, 1, reqs)
+	}
+	assert.True(t, time.Since(start) >= 50*time.Millisecond, "request wasn't sent at its offset")
+	assert.Equal(t, []string{"GET /a ", "POST /b hi"}, seen)
+
+	// Nothing's left to replay, so the VU idles until the test ends.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	samples, err := vu.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, samples)
+}
+
+func TestVUIntegrationWarmup(t *testing.T) {
+	var lock sync.Mutex
+	var seen []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		seen = append(seen, req.Method+" "+req.URL.Path)
+		lock.Unlock()
+	}))
+	defer srv.Close()
+
+	r, err := New(&lib.SourceData{
+		Filename: "/script.js",
+		Data: []byte(fmt.Sprintf(`
+			import http from "k6/http";
+			export function warmup() { http.get("%[1]s/login"); }
+			export default function() { http.get("%[1]s/iter"); }
+		`, srv.URL)),
+	}, afero.NewMemMapFs())
+	if !assert.NoError(t, err) {
+		return
+	}
+	r.SetOptions(lib.Options{VUWarmup: &lib.VUWarmup{
+		Exec:     "warmup",
+		Requests: []lib.ReplayRequest{{URL: srv.URL + "/prime"}},
+	}})
+
+	vu, err := r.NewVU()
+	if !assert.NoError(t, err) {
+		return
+	}
+	for i := 0; i < 2; i++ {
+		samples, err := vu.RunOnce(context.Background())
+		assert.NoError(t, err)
+		reqs := 0
+		for _, sample := range samples {
+			if sample.Metric == metrics.HTTPReqs {
+				reqs++
+			}
+		}
+		assert.Equal(t, 1, reqs)
+	}
+	assert.Equal(t, []string{"GET /login", "GET /prime", "GET /iter", "GET /iter"}, seen)
+
+	t.Run("Unexported", func(t *testing.T) {
+		r.SetOptions(lib.Options{VUWarmup: &lib.VUWarmup{Exec: "login"}})
+		_, err := r.NewVU()
+		assert.EqualError(t, err, "warmup function login isn't exported")
+	})
+}
+
+func TestRunnerPrewarm(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	r, err := New(&lib.SourceData{
+		Filename: "/script.js",
+		Data:     []byte(`export default function() {}`),
+	}, afero.NewMemMapFs())
+	if !assert.NoError(t, err) {
+		return
+	}
+	r.SetOptions(lib.Options{
+		Hosts:                 map[string]net.IP{"k6.test": net.ParseIP("127.0.0.1")},
+		Prewarm:               []string{"k6.test:" + port, "k6.invalid"},
+		PrewarmTLS:            null.BoolFrom(true),
+		InsecureSkipTLSVerify: null.BoolFrom(true),
+		TLSVersion:            &lib.TLSVersions{Max: tls.VersionTLS12},
+	})
+
+	assert.NoError(t, r.Prewarm(context.Background()))
+	_, ok := r.tlsSessions.Get("k6.test")
+	assert.True(t, ok, "no session was cached")
+
+	t.Run("PerHostTLS", func(t *testing.T) {
+		// Only the per-host config skips verifying the test server's certificate.
+		r.SetOptions(lib.Options{
+			Hosts:      map[string]net.IP{"k6.test": net.ParseIP("127.0.0.1")},
+			Prewarm:    []string{"k6.test:" + port},
+			PrewarmTLS: null.BoolFrom(true),
+			TLSVersion: &lib.TLSVersions{Max: tls.VersionTLS12},
+			PerHostTLS: map[string]lib.HostTLSConfig{"k6.test": {InsecureSkipVerify: null.BoolFrom(true)}},
+		})
+		assert.NoError(t, r.Prewarm(context.Background()))
+		_, ok := r.tlsSessions.Get("k6.test")
+		assert.True(t, ok, "no session was cached")
+	})
+	t.Run("Timeout", func(t *testing.T) {
+		// Accepts connections, but never answers the handshake.
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer func() { _ = l.Close() }()
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				defer func() { _ = conn.Close() }()
+			}
+		}()
+		_, port, _ := net.SplitHostPort(l.Addr().String())
+
+		r.SetOptions(lib.Options{
+			Hosts:          map[string]net.IP{"k6.test": net.ParseIP("127.0.0.1")},
+			Prewarm:        []string{"k6.test:" + port},
+			PrewarmTLS:     null.BoolFrom(true),
+			RequestTimeout: lib.NullDurationFrom(100 * time.Millisecond),
+		})
+		start := time.Now()
+		assert.NoError(t, r.Prewarm(context.Background()))
+		assert.True(t, time.Since(start) < 10*time.Second, "prewarming didn't time out")
+	})
+}
+
+func TestVUIntegrationGaugeReset(t *testing.T) {
+	r, err := New(&lib.SourceData{
+		Filename: "/script.js",
+		Data: []byte(`
+			import { Gauge } from "k6/metrics";
+			let reset = new Gauge("my_reset_gauge");
+			let kept = new Gauge("my_kept_gauge");
+			export default function() { reset.add(7); kept.add(7); }
+		`),
+	}, afero.NewMemMapFs())
+	if !assert.NoError(t, err) {
+		return
+	}
+	r.SetOptions(lib.Options{GaugeReset: map[string]string{"my_reset_gauge": "iteration"}})
+
+	vu, err := r.NewVU()
+	if !assert.NoError(t, err) {
+		return
+	}
+	samples, err := vu.RunOnce(context.Background())
+	assert.NoError(t, err)
+
+	values := map[string][]float64{}
+	for _, sample := range samples {
+		if sample.Metric.Type == stats.Gauge {
+			values[sample.Metric.Name] = append(values[sample.Metric.Name], sample.Value)
+		}
+	}
+	assert.Equal(t, map[string][]float64{"my_reset_gauge": {7, 0}, "my_kept_gauge": {7}}, values)
+}
+
 func TestVUIntegrationCookies(t *testing.T) {
 	r1, err := New(&lib.SourceData{
 		Filename: "/script.js",
@@ -870,3 +1360,98 @@ func TestVUIntegrationClientCerts(t *testing.T) {
 		}
 	})
 }
+
+// Makes a self-signed client certificate with the given common name.
+func makeTestClientAuth(t *testing.T, name string) *lib.TLSAuth {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return &lib.TLSAuth{TLSAuthFields: lib.TLSAuthFields{
+		Cert: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		Key:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}}
+}
+
+func TestVUIntegrationTLSAuthByHost(t *testing.T) {
+	// Responds with the common name of the client certificate it got, if any.
+	newServer := func() *httptest.Server {
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if certs := req.TLS.PeerCertificates; len(certs) > 0 {
+				_, _ = fmt.Fprint(w, certs[0].Subject.CommonName)
+			}
+		}))
+		srv.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+		srv.Config.ErrorLog = stdlog.New(ioutil.Discard, "", 0)
+		srv.StartTLS()
+		return srv
+	}
+	srvA, srvB := newServer(), newServer()
+	defer srvA.Close()
+	defer srvB.Close()
+	_, portA, _ := net.SplitHostPort(srvA.Listener.Addr().String())
+	_, portB, _ := net.SplitHostPort(srvB.Listener.Addr().String())
+
+	r1, err := New(&lib.SourceData{
+		Filename: "/script.js",
+		Data: []byte(fmt.Sprintf(`
+			import http from "k6/http";
+			export default function() {
+				let a = http.get("https://a.example.com:%[1]s/").body;
+				let b = http.get("https://b.example.com:%[2]s/").body;
+				let none = http.get("https://127.0.0.1:%[1]s/").body;
+				if (a !== "client A" || b !== "client B" || none !== "") {
+					throw new Error("a got " + a + ", b got " + b + ", unmapped got " + none);
+				}
+			}
+		`, portA, portB)),
+	}, afero.NewMemMapFs())
+	if !assert.NoError(t, err) {
+		return
+	}
+	r1.SetOptions(lib.Options{
+		Throw:                 null.BoolFrom(true),
+		InsecureSkipTLSVerify: null.BoolFrom(true),
+		Hosts: map[string]net.IP{
+			"a.example.com": net.ParseIP("127.0.0.1"),
+			"b.example.com": net.ParseIP("127.0.0.1"),
+		},
+		TLSAuthByHost: map[string]*lib.TLSAuth{
+			"a.example.com": makeTestClientAuth(t, "client A"),
+			"*.example.com": makeTestClientAuth(t, "client B"),
+			"example.org":   makeTestClientAuth(t, "client C"),
+		},
+	})
+
+	r2, err := NewFromArchive(r1.MakeArchive())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	runners := map[string]*Runner{"Source": r1, "Archive": r2}
+	for name, r := range runners {
+		t.Run(name, func(t *testing.T) {
+			vu, err := r.NewVU()
+			if assert.NoError(t, err) {
+				_, err := vu.RunOnce(context.Background())
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"context"
+	"time"
+)
+
+type ctxKey int
+
+const (
+	ctxKeyRunClock ctxKey = iota
+)
+
+// Tells how much longer a test is going to run; executors put themselves in VUs' contexts as one.
+type RunClock interface {
+	// Returns how long it is until the test ends, and false if there's no known end.
+	GetTimeLeft() (time.Duration, bool)
+}
+
+func WithRunClock(ctx context.Context, clock RunClock) context.Context {
+	return context.WithValue(ctx, ctxKeyRunClock, clock)
+}
+
+// Returns the RunClock in ctx, or nil if there isn't one.
+func GetRunClock(ctx context.Context) RunClock {
+	v := ctx.Value(ctxKeyRunClock)
+	if v == nil {
+		return nil
+	}
+	return v.(RunClock)
+}
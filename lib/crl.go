@@ -0,0 +1,143 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// How long to wait for a CRL given as a URL to download.
+const CRLFetchTimeout = 30 * time.Second
+
+// A certificate revocation list, for checking server certificates against.
+type CRL struct {
+	list    *pkix.CertificateList
+	issuer  string
+	revoked map[string]bool
+}
+
+// Returned when a server presents a certificate that's on the CRL.
+type CertificateRevokedError struct {
+	Subject string
+	Serial  *big.Int
+}
+
+func (e CertificateRevokedError) Error() string {
+	return fmt.Sprintf("certificate revoked: %s (serial %s)", e.Subject, e.Serial)
+}
+
+// Parses a CRL, either PEM or DER encoded.
+func ParseCRL(data []byte) (*CRL, error) {
+	list, err := x509.ParseCRL(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid CRL")
+	}
+	var issuer pkix.Name
+	issuer.FillFromRDNSequence(&list.TBSCertList.Issuer)
+
+	crl := &CRL{
+		list:    list,
+		issuer:  issuer.String(),
+		revoked: make(map[string]bool, len(list.TBSCertList.RevokedCertificates)),
+	}
+	for _, rc := range list.TBSCertList.RevokedCertificates {
+		crl.revoked[rc.SerialNumber.String()] = true
+	}
+	return crl, nil
+}
+
+// Loads a CRL from a file, or downloads it if given an http(s) URL.
+func LoadCRL(src string) (*CRL, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		data, err = fetchCRL(src)
+	} else {
+		data, err = ioutil.ReadFile(src)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't load CRL from %s", src)
+	}
+	crl, err := ParseCRL(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't load CRL from %s", src)
+	}
+	return crl, nil
+}
+
+func fetchCRL(url string) ([]byte, error) {
+	client := http.Client{Timeout: CRLFetchTimeout}
+	res, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status %s", res.Status)
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+// Checks a server's certificate against the CRL, for use as tls.Config.VerifyPeerCertificate.
+// Certificates from other issuers aren't covered by the CRL, and pass. If the chain was
+// verified, the CRL's signature is checked against the certificate's issuer as well.
+func (c *CRL) VerifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	var leaf, issuer *x509.Certificate
+	if len(verifiedChains) > 0 && len(verifiedChains[0]) > 0 {
+		leaf = verifiedChains[0][0]
+		if len(verifiedChains[0]) > 1 {
+			issuer = verifiedChains[0][1]
+		}
+	} else if len(rawCerts) > 0 {
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		leaf = cert
+	} else {
+		return nil
+	}
+
+	if leaf.Issuer.String() != c.issuer {
+		return nil
+	}
+	if issuer != nil {
+		if err := issuer.CheckCRLSignature(c.list); err != nil {
+			return errors.Wrap(err, "CRL isn't signed by the certificate's issuer")
+		}
+	}
+	if next := c.list.TBSCertList.NextUpdate; !next.IsZero() && c.list.HasExpired(time.Now()) {
+		return errors.Errorf("CRL for %s expired at %s", c.issuer, next)
+	}
+	if c.revoked[leaf.SerialNumber.String()] {
+		return CertificateRevokedError{Subject: leaf.Subject.String(), Serial: leaf.SerialNumber}
+	}
+	return nil
+}
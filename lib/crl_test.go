@@ -0,0 +1,232 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(1 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCA{cert, key}
+}
+
+func (ca testCA) issue(t *testing.T, serial int64) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "k6.test"},
+		DNSNames:     []string{"k6.test"},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func (ca testCA) crl(t *testing.T, nextUpdate time.Time, serials ...int64) []byte {
+	revoked := make([]pkix.RevokedCertificate, len(serials))
+	for i, serial := range serials {
+		revoked[i] = pkix.RevokedCertificate{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()}
+	}
+	der, err := ca.cert.CreateCRL(rand.Reader, ca.key, revoked, time.Now().Add(-1*time.Hour), nextUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestCRL(t *testing.T) {
+	ca := newTestCA(t, "k6 test CA")
+	good, revoked := ca.issue(t, 100), ca.issue(t, 101)
+	crl, err := ParseCRL(ca.crl(t, time.Now().Add(1*time.Hour), 101))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := func(cert tls.Certificate) [][]*x509.Certificate {
+		return [][]*x509.Certificate{{cert.Leaf, ca.cert}}
+	}
+
+	t.Run("Good", func(t *testing.T) {
+		assert.NoError(t, crl.VerifyPeerCertificate(good.Certificate, chain(good)))
+	})
+	t.Run("Revoked", func(t *testing.T) {
+		err := crl.VerifyPeerCertificate(revoked.Certificate, chain(revoked))
+		assert.Equal(t, CertificateRevokedError{Subject: "CN=k6.test", Serial: big.NewInt(101)}, err)
+		assert.EqualError(t, err, "certificate revoked: CN=k6.test (serial 101)")
+	})
+	t.Run("Unverified", func(t *testing.T) {
+		assert.Error(t, crl.VerifyPeerCertificate(revoked.Certificate, nil))
+		assert.NoError(t, crl.VerifyPeerCertificate(good.Certificate, nil))
+	})
+	t.Run("Other Issuer", func(t *testing.T) {
+		other := newTestCA(t, "some other CA")
+		cert := other.issue(t, 101)
+		assert.NoError(t, crl.VerifyPeerCertificate(cert.Certificate, [][]*x509.Certificate{{cert.Leaf, other.cert}}))
+	})
+	t.Run("Bad Signature", func(t *testing.T) {
+		impostor := newTestCA(t, "k6 test CA")
+		crl, err := ParseCRL(impostor.crl(t, time.Now().Add(1*time.Hour)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = crl.VerifyPeerCertificate(good.Certificate, chain(good))
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "CRL isn't signed by the certificate's issuer")
+		}
+	})
+	t.Run("Expired", func(t *testing.T) {
+		crl, err := ParseCRL(ca.crl(t, time.Now().Add(-1*time.Minute)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = crl.VerifyPeerCertificate(good.Certificate, chain(good))
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "CRL for CN=k6 test CA expired at")
+		}
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := ParseCRL([]byte("not a CRL"))
+		assert.Error(t, err)
+	})
+
+	t.Run("Handshake", func(t *testing.T) {
+		pool := x509.NewCertPool()
+		pool.AddCert(ca.cert)
+		for name, cert := range map[string]tls.Certificate{"good": good, "revoked": revoked} {
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+			srv.StartTLS()
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				RootCAs:               pool,
+				ServerName:            "k6.test",
+				VerifyPeerCertificate: crl.VerifyPeerCertificate,
+			}}}
+			res, err := client.Get(srv.URL)
+			if name == "good" {
+				if assert.NoError(t, err, name) {
+					_ = res.Body.Close()
+				}
+			} else if assert.Error(t, err, name) {
+				assert.Contains(t, err.Error(), "certificate revoked: CN=k6.test (serial 101)")
+			}
+			srv.Close()
+		}
+	})
+}
+
+func TestLoadCRL(t *testing.T) {
+	ca := newTestCA(t, "k6 test CA")
+	data := ca.crl(t, time.Now().Add(1*time.Hour), 101)
+
+	t.Run("File", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "k6-crl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		path := filepath.Join(dir, "test.crl")
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		crl, err := LoadCRL(path)
+		if assert.NoError(t, err) {
+			assert.True(t, crl.revoked["101"])
+		}
+
+		_, err = LoadCRL(filepath.Join(dir, "missing.crl"))
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "couldn't load CRL from ")
+		}
+	})
+	t.Run("URL", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/test.crl" {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write(data)
+		}))
+		defer srv.Close()
+
+		crl, err := LoadCRL(srv.URL + "/test.crl")
+		if assert.NoError(t, err) {
+			assert.True(t, crl.revoked["101"])
+		}
+
+		_, err = LoadCRL(srv.URL + "/missing.crl")
+		assert.EqualError(t, err, "couldn't load CRL from "+srv.URL+"/missing.crl: unexpected status 404 Not Found")
+	})
+}
@@ -52,27 +52,71 @@ type Executor interface {
 	GetStages() []Stage
 	SetStages(s []Stage)
 
+	// Get and set the random delay applied when starting VUs. May be nil.
+	GetVUStartJitter() *VUStartJitter
+	SetVUStartJitter(j *VUStartJitter)
+
+	// Get and set the random pause VUs take between iterations. May be nil.
+	GetThinkTime() *ThinkTime
+	SetThinkTime(t *ThinkTime)
+
+	// Get and set how VUs react to the test being stopped. See Options.VUCancellation.
+	GetVUCancellation() string
+	SetVUCancellation(mode string)
+
+	// Get and set what happens when a stage targets more VUs than the cap. See
+	// Options.VUsMaxPolicy.
+	GetVUsMaxPolicy() string
+	SetVUsMaxPolicy(policy string)
+
 	// Get iterations executed so far, get and set how many to end the test after.
 	GetIterations() int64
 	GetEndIterations() null.Int
 	SetEndIterations(i null.Int)
 
+	// Get and set how many iterations each VU may run before it stops. Other VUs keep going.
+	GetMaxIterationsPerVU() null.Int
+	SetMaxIterationsPerVU(i null.Int)
+
+	// Get and set whether to spread the end iterations evenly up to the end time.
+	GetPaceIterations() bool
+	SetPaceIterations(pace bool)
+
 	// Get time elapsed so far, accounting for pauses, get and set at what point to end the test.
 	GetTime() time.Duration
 	GetEndTime() NullDuration
 	SetEndTime(t NullDuration)
 
+	// Get and set the wall-clock time to end the test at, if it hasn't ended before then.
+	GetDeadline() NullTime
+	SetDeadline(t NullTime)
+
 	// Check whether the test is paused, or pause it. A paused won't start any new iterations (but
 	// will allow currently in progress ones to finish), and will not increment the value returned
 	// by GetTime().
 	IsPaused() bool
 	SetPaused(paused bool)
 
+	// Get and set how many VUs to initialise up front when raising the VU cap; any others are
+	// initialised the first time they're activated. Must be set before SetVUsMax() to apply.
+	GetPreAllocatedVUs() null.Int
+	SetPreAllocatedVUs(n null.Int)
+
+	// Get and set how many VUs may be initialised at the same time by SetVUsMax(); unset means
+	// one at a time.
+	GetVUInitConcurrency() null.Int
+	SetVUInitConcurrency(n null.Int)
+
 	// Get and set the number of currently active VUs.
 	// It is an error to try to set this higher than MaxVUs.
 	GetVUs() int64
 	SetVUs(vus int64) error
 
+	// Get and set a limit on active VUs, eg. to shed load; SetVUs() and stages are held to it, and
+	// setting it lowers the active VUs if there are more. Unset means no limit.
+	GetVULimit() null.Int
+	SetVULimit(n null.Int) error
+
 	// Get and set the number of allocated, available VUs.
 	// Please note that initialising new VUs is a very expensive operation, and doing it during a
 	// running test may skew metrics; if you're not sure how many you will need, it's generally
@@ -0,0 +1,70 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"github.com/pkg/errors"
+)
+
+// File descriptors needed regardless of the VU count; the script, collectors, the API, etc.
+const fdLimitBaseline = 64
+
+// Returns a rough estimate of how many file descriptors a test with the given options needs.
+func EstimateFDs(opts Options) int64 {
+	vus := opts.VUs.Int64
+	if opts.VUsMax.Int64 > vus {
+		vus = opts.VUsMax.Int64
+	}
+	for _, stage := range opts.Stages {
+		if stage.Target.Int64 > vus {
+			vus = stage.Target.Int64
+		}
+	}
+
+	// Every VU keeps at least one connection open, more if it's batching requests. Without
+	// connection reuse, connections from the last iteration may not be closed yet either.
+	perVU := int64(1)
+	if opts.Batch.Int64 > perVU {
+		perVU = opts.Batch.Int64
+	}
+	if opts.NoConnectionReuse.Bool {
+		perVU *= 2
+	}
+	return fdLimitBaseline + vus*perVU
+}
+
+// Returns an error if the process' open file limit is likely too low for the given options.
+// Does nothing on platforms where the limit can't be determined.
+func CheckFDLimit(opts Options) error {
+	limit, err := getFDLimit()
+	if err != nil {
+		return errors.Wrap(err, "couldn't get open file limit")
+	}
+	if limit <= 0 {
+		return nil
+	}
+	if needed := EstimateFDs(opts); needed > limit {
+		return errors.Errorf(
+			"open file limit (%d) is likely too low for this test, which may need around %d; "+
+				"raise it with eg. 'ulimit -n %d'", limit, needed, needed)
+	}
+	return nil
+}
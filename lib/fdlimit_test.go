@@ -0,0 +1,60 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	null "gopkg.in/guregu/null.v3"
+)
+
+func TestEstimateFDs(t *testing.T) {
+	testdata := map[string]struct {
+		opts     Options
+		expected int64
+	}{
+		"empty":   {Options{}, fdLimitBaseline},
+		"vus":     {Options{VUs: null.IntFrom(10)}, fdLimitBaseline + 10},
+		"vus max": {Options{VUs: null.IntFrom(10), VUsMax: null.IntFrom(100)}, fdLimitBaseline + 100},
+		"stages": {Options{VUs: null.IntFrom(10), Stages: []Stage{
+			{Target: null.IntFrom(50)}, {Target: null.IntFrom(20)},
+		}}, fdLimitBaseline + 50},
+		"batch":    {Options{VUs: null.IntFrom(10), Batch: null.IntFrom(5)}, fdLimitBaseline + 50},
+		"no reuse": {Options{VUs: null.IntFrom(10), NoConnectionReuse: null.BoolFrom(true)}, fdLimitBaseline + 20},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, data.expected, EstimateFDs(data.opts))
+		})
+	}
+}
+
+func TestCheckFDLimit(t *testing.T) {
+	limit, err := getFDLimit()
+	assert.NoError(t, err)
+	if limit <= 0 {
+		t.Skip("no open file limit")
+	}
+
+	assert.NoError(t, CheckFDLimit(Options{}))
+	assert.Error(t, CheckFDLimit(Options{VUs: null.IntFrom(limit)}))
+}
@@ -0,0 +1,216 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A HTTP Archive, as described by http://www.softwareishard.com/blog/har-12-spec/. Only the parts
+// k6 can fill in are included.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// A recorded request and its response. Error is a custom field, set for failed requests.
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+// Timings in milliseconds; -1 for ones that don't apply.
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// Roughly how much memory an entry takes up: the length of its strings, where the bulk of it is.
+func (e HAREntry) size() int64 {
+	n := len(e.Request.URL) + len(e.Response.Content.Text) + len(e.Response.RedirectURL) + len(e.Error)
+	if e.Request.PostData != nil {
+		n += len(e.Request.PostData.Text)
+	}
+	for _, values := range [][]HARNameValue{
+		e.Request.Cookies, e.Request.Headers, e.Request.QueryString,
+		e.Response.Cookies, e.Response.Headers,
+	} {
+		for _, v := range values {
+			n += len(v.Name) + len(v.Value)
+		}
+	}
+	return int64(n)
+}
+
+// Collects HAR entries for the harExport option, from any number of VUs at once, up to the
+// HARExport's MaxBytes.
+type HARRecorder struct {
+	Options HARExport
+
+	// Warned the first time an entry doesn't fit; nil to not log.
+	Logger *log.Logger
+
+	mutex   sync.Mutex
+	rand    *rand.Rand
+	entries []HAREntry
+	size    int64
+	dropped int64
+}
+
+func NewHARRecorder(opts HARExport) *HARRecorder {
+	return &HARRecorder{Options: opts, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Decides whether to record a request, according to the sampling rate.
+func (r *HARRecorder) Sample() bool {
+	if r.Options.Sampling >= 1 {
+		return true
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.rand.Float64() < r.Options.Sampling
+}
+
+// Records an entry, unless it'd take the recorder over its MaxBytes.
+func (r *HARRecorder) Add(entry HAREntry) {
+	max := r.Options.MaxBytes
+	if max <= 0 {
+		max = DefaultHARMaxBytes
+	}
+	size := entry.size()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.size+size > max {
+		if r.dropped == 0 && r.Logger != nil {
+			r.Logger.WithField("maxBytes", max).Warn("HAR export is full; further requests won't be recorded")
+		}
+		r.dropped++
+		return
+	}
+	r.size += size
+	r.entries = append(r.entries, entry)
+}
+
+// Returns how many entries were left out for lack of room.
+func (r *HARRecorder) Dropped() int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.dropped
+}
+
+// Returns everything recorded so far, in the order requests were started. The entries aren't
+// copied, so they shouldn't be modified.
+func (r *HARRecorder) HAR(creatorVersion string) HAR {
+	r.mutex.Lock()
+	sort.SliceStable(r.entries, func(i, j int) bool {
+		return r.entries[i].StartedDateTime.Before(r.entries[j].StartedDateTime)
+	})
+	entries := r.entries[:len(r.entries):len(r.entries)]
+	r.mutex.Unlock()
+
+	if entries == nil {
+		entries = []HAREntry{}
+	}
+	return HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "k6", Version: creatorVersion},
+		Entries: entries,
+	}}
+}
+
+// Writes everything recorded so far as a HAR document.
+func (r *HARRecorder) Write(w io.Writer, creatorVersion string) error {
+	data, err := json.MarshalIndent(r.HAR(creatorVersion), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
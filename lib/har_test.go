@@ -0,0 +1,101 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHARExport(t *testing.T) {
+	var h HARExport
+	assert.NoError(t, json.Unmarshal([]byte(`{"path":"out.har"}`), &h))
+	assert.Equal(t, HARExport{Path: "out.har", Sampling: 1}, h)
+
+	assert.NoError(t, json.Unmarshal([]byte(`{"path":"out.har","sampling":0.1,"includeBodies":true}`), &h))
+	assert.Equal(t, HARExport{Path: "out.har", Sampling: 0.1, IncludeBodies: true}, h)
+
+	assert.EqualError(t, json.Unmarshal([]byte(`{"sampling":0.1}`), &h), "harExport needs a path")
+	assert.EqualError(t, json.Unmarshal([]byte(`{"path":"out.har","sampling":2}`), &h),
+		"harExport sampling must be between 0 and 1, not 2")
+	assert.EqualError(t, json.Unmarshal([]byte(`{"path":"out.har","maxBytes":-1}`), &h),
+		"harExport maxBytes can't be negative")
+}
+
+func TestHARRecorder(t *testing.T) {
+	t.Run("Sampling", func(t *testing.T) {
+		assert.True(t, NewHARRecorder(HARExport{Sampling: 1}).Sample())
+		assert.False(t, NewHARRecorder(HARExport{Sampling: 0}).Sample())
+	})
+	t.Run("Write", func(t *testing.T) {
+		r := NewHARRecorder(HARExport{Path: "out.har", Sampling: 1})
+		now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+		r.Add(HAREntry{StartedDateTime: now.Add(time.Second), Request: HARRequest{URL: "http://example.com/2"}})
+		r.Add(HAREntry{StartedDateTime: now, Request: HARRequest{URL: "http://example.com/1"}})
+
+		var buf bytes.Buffer
+		if !assert.NoError(t, r.Write(&buf, "1.2.3")) {
+			return
+		}
+		var har HAR
+		if !assert.NoError(t, json.Unmarshal(buf.Bytes(), &har)) {
+			return
+		}
+		assert.Equal(t, "1.2", har.Log.Version)
+		assert.Equal(t, HARCreator{Name: "k6", Version: "1.2.3"}, har.Log.Creator)
+		if assert.Len(t, har.Log.Entries, 2) {
+			assert.Equal(t, "http://example.com/1", har.Log.Entries[0].Request.URL)
+			assert.Equal(t, "http://example.com/2", har.Log.Entries[1].Request.URL)
+		}
+	})
+	t.Run("MaxBytes", func(t *testing.T) {
+		logger, hook := logtest.NewNullLogger()
+		r := NewHARRecorder(HARExport{Sampling: 1, MaxBytes: 100})
+		r.Logger = logger
+		entry := HAREntry{Request: HARRequest{URL: "http://example.com/" + strings.Repeat("x", 20)}}
+		for i := 0; i < 5; i++ {
+			r.Add(entry)
+		}
+		assert.Len(t, r.HAR("").Log.Entries, 2)
+		assert.Equal(t, int64(3), r.Dropped())
+		if assert.Len(t, hook.Entries, 1) {
+			assert.Equal(t, log.WarnLevel, hook.LastEntry().Level)
+		}
+
+		r = NewHARRecorder(HARExport{Sampling: 1})
+		r.Add(HAREntry{Response: HARResponse{Content: HARContent{Text: strings.Repeat("x", DefaultHARMaxBytes)}}})
+		r.Add(entry)
+		assert.Len(t, r.HAR("").Log.Entries, 1)
+		assert.Equal(t, int64(1), r.Dropped())
+	})
+	t.Run("Empty", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, NewHARRecorder(HARExport{Sampling: 1}).Write(&buf, ""))
+		assert.Contains(t, buf.String(), `"entries": []`)
+	})
+}
@@ -0,0 +1,373 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2018 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// A JSON Schema, supporting the subset of draft 7 that's useful for checking API responses:
+// type, enum, the numeric, string, array and object keywords, and allOf, anyOf, oneOf and not.
+// Unknown keywords, including $ref and format, are ignored.
+type JSONSchema struct {
+	Type jsonSchemaTypes `json:"type,omitempty"`
+	Enum []interface{}   `json:"enum,omitempty"`
+
+	Minimum          *float64 `json:"minimum,omitempty"`
+	Maximum          *float64 `json:"maximum,omitempty"`
+	ExclusiveMinimum *float64 `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum *float64 `json:"exclusiveMaximum,omitempty"`
+	MultipleOf       *float64 `json:"multipleOf,omitempty"`
+
+	MinLength *int   `json:"minLength,omitempty"`
+	MaxLength *int   `json:"maxLength,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+
+	Items       *JSONSchema `json:"items,omitempty"`
+	MinItems    *int        `json:"minItems,omitempty"`
+	MaxItems    *int        `json:"maxItems,omitempty"`
+	UniqueItems bool        `json:"uniqueItems,omitempty"`
+
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+	MinProperties        *int                   `json:"minProperties,omitempty"`
+	MaxProperties        *int                   `json:"maxProperties,omitempty"`
+
+	AllOf []*JSONSchema `json:"allOf,omitempty"`
+	AnyOf []*JSONSchema `json:"anyOf,omitempty"`
+	OneOf []*JSONSchema `json:"oneOf,omitempty"`
+	Not   *JSONSchema   `json:"not,omitempty"`
+
+	never   bool // The "false" schema, which nothing matches.
+	pattern *regexp.Regexp
+}
+
+type jsonSchemaTypes []string
+
+func (t jsonSchemaTypes) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t *jsonSchemaTypes) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*t = jsonSchemaTypes{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return errors.New("schema type must be a string or a list of strings")
+	}
+	*t = many
+	return nil
+}
+
+func (s JSONSchema) MarshalJSON() ([]byte, error) {
+	if s.never {
+		return []byte("false"), nil
+	}
+	type schema JSONSchema
+	return json.Marshal(schema(s))
+}
+
+func (s *JSONSchema) UnmarshalJSON(data []byte) error {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		*s = JSONSchema{never: !b}
+		return nil
+	}
+	type schema JSONSchema
+	var raw schema
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for _, name := range raw.Type {
+		switch name {
+		case "null", "boolean", "integer", "number", "string", "array", "object":
+		default:
+			return errors.Errorf("unknown schema type: %s", name)
+		}
+	}
+	if raw.Pattern != "" {
+		re, err := regexp.Compile(raw.Pattern)
+		if err != nil {
+			return errors.Errorf("invalid schema pattern %q: %s", raw.Pattern, err)
+		}
+		raw.pattern = re
+	}
+	*s = JSONSchema(raw)
+	return nil
+}
+
+// Validates a JSON document against the schema, returning the first violation found.
+func (s *JSONSchema) ValidateJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return errors.Wrap(err, "invalid JSON")
+	}
+	return s.Validate(v)
+}
+
+// Validates a decoded JSON value against the schema, returning the first violation found,
+// prefixed with the path to the offending value, eg. "$.items[2].id: expected integer, got
+// string".
+func (s *JSONSchema) Validate(v interface{}) error {
+	return s.validate("$", v)
+}
+
+func (s *JSONSchema) validate(path string, v interface{}) error {
+	if s == nil {
+		return nil
+	}
+	if s.never {
+		return errors.Errorf("%s: not allowed", path)
+	}
+
+	if len(s.Type) > 0 {
+		ok := false
+		for _, name := range s.Type {
+			if jsonTypeMatches(name, v) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return errors.Errorf("%s: expected %s, got %s", path, strings.Join(s.Type, " or "), jsonTypeOf(v))
+		}
+	}
+	if s.Enum != nil {
+		ok := false
+		for _, e := range s.Enum {
+			if jsonEqual(e, v) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return errors.Errorf("%s: value isn't one of the allowed values", path)
+		}
+	}
+
+	switch val := v.(type) {
+	case float64:
+		if err := s.validateNumber(path, val); err != nil {
+			return err
+		}
+	case string:
+		if err := s.validateString(path, val); err != nil {
+			return err
+		}
+	case []interface{}:
+		if err := s.validateArray(path, val); err != nil {
+			return err
+		}
+	case map[string]interface{}:
+		if err := s.validateObject(path, val); err != nil {
+			return err
+		}
+	}
+
+	for _, sub := range s.AllOf {
+		if err := sub.validate(path, v); err != nil {
+			return err
+		}
+	}
+	if len(s.AnyOf) > 0 {
+		ok := false
+		for _, sub := range s.AnyOf {
+			if sub.validate(path, v) == nil {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return errors.Errorf("%s: value doesn't match any of the anyOf schemas", path)
+		}
+	}
+	if len(s.OneOf) > 0 {
+		matches := 0
+		for _, sub := range s.OneOf {
+			if sub.validate(path, v) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return errors.Errorf("%s: value matches %d of the oneOf schemas, not 1", path, matches)
+		}
+	}
+	if s.Not != nil && s.Not.validate(path, v) == nil {
+		return errors.Errorf("%s: value matches a schema it mustn't", path)
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateNumber(path string, v float64) error {
+	if s.Minimum != nil && v < *s.Minimum {
+		return errors.Errorf("%s: %v is less than the minimum of %v", path, v, *s.Minimum)
+	}
+	if s.Maximum != nil && v > *s.Maximum {
+		return errors.Errorf("%s: %v is greater than the maximum of %v", path, v, *s.Maximum)
+	}
+	if s.ExclusiveMinimum != nil && v <= *s.ExclusiveMinimum {
+		return errors.Errorf("%s: %v isn't greater than %v", path, v, *s.ExclusiveMinimum)
+	}
+	if s.ExclusiveMaximum != nil && v >= *s.ExclusiveMaximum {
+		return errors.Errorf("%s: %v isn't less than %v", path, v, *s.ExclusiveMaximum)
+	}
+	if s.MultipleOf != nil && *s.MultipleOf > 0 {
+		// Decimal multiples like 0.01 aren't exact in binary, so 19.99 / 0.01 is 1998.9999999999998.
+		if q := v / *s.MultipleOf; math.Abs(q-math.Floor(q+0.5)) > multipleOfEpsilon*math.Max(1, math.Abs(q)) {
+			return errors.Errorf("%s: %v isn't a multiple of %v", path, v, *s.MultipleOf)
+		}
+	}
+	return nil
+}
+
+// How far from a whole number a value divided by multipleOf can be, relative to the quotient,
+// and still count as a multiple of it.
+const multipleOfEpsilon = 1e-9
+
+func (s *JSONSchema) validateString(path string, v string) error {
+	n := utf8.RuneCountInString(v)
+	if s.MinLength != nil && n < *s.MinLength {
+		return errors.Errorf("%s: string is shorter than %d characters", path, *s.MinLength)
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		return errors.Errorf("%s: string is longer than %d characters", path, *s.MaxLength)
+	}
+	if s.Pattern != "" {
+		// Patterns are compiled when unmarshalling, but not for schemas built in code.
+		re := s.pattern
+		if re == nil {
+			var err error
+			if re, err = regexp.Compile(s.Pattern); err != nil {
+				return errors.Errorf("%s: invalid schema pattern %q: %s", path, s.Pattern, err)
+			}
+		}
+		if !re.MatchString(v) {
+			return errors.Errorf("%s: string doesn't match pattern %q", path, s.Pattern)
+		}
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateArray(path string, v []interface{}) error {
+	if s.MinItems != nil && len(v) < *s.MinItems {
+		return errors.Errorf("%s: array has fewer than %d items", path, *s.MinItems)
+	}
+	if s.MaxItems != nil && len(v) > *s.MaxItems {
+		return errors.Errorf("%s: array has more than %d items", path, *s.MaxItems)
+	}
+	if s.UniqueItems {
+		for i := range v {
+			for j := 0; j < i; j++ {
+				if jsonEqual(v[i], v[j]) {
+					return errors.Errorf("%s: items %d and %d are equal", path, j, i)
+				}
+			}
+		}
+	}
+	if s.Items != nil {
+		for i, item := range v {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateObject(path string, v map[string]interface{}) error {
+	if s.MinProperties != nil && len(v) < *s.MinProperties {
+		return errors.Errorf("%s: object has fewer than %d properties", path, *s.MinProperties)
+	}
+	if s.MaxProperties != nil && len(v) > *s.MaxProperties {
+		return errors.Errorf("%s: object has more than %d properties", path, *s.MaxProperties)
+	}
+	for _, name := range s.Required {
+		if _, ok := v[name]; !ok {
+			return errors.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+
+	// Sorted, so the same document always reports the same violation first.
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		sub, ok := s.Properties[k]
+		if !ok {
+			sub = s.AdditionalProperties
+		}
+		if err := sub.validate(path+"."+k, v[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func jsonTypeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func jsonTypeMatches(name string, v interface{}) bool {
+	if name == "integer" {
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	}
+	return jsonTypeOf(v) == name
+}
+
+// Decoded JSON values are nil, bools, float64s, strings, slices and maps, which DeepEqual
+// compares the way JSON Schema does.
+func jsonEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,99 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2018 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONSchema(t *testing.T) {
+	testdata := map[string]struct {
+		schema, doc string
+		err         string
+	}{
+		"Type":                 {`{"type": "object"}`, `{}`, ""},
+		"TypeMismatch":         {`{"type": "object"}`, `[]`, "$: expected object, got array"},
+		"TypeList":             {`{"type": ["string", "null"]}`, `null`, ""},
+		"Integer":              {`{"type": "integer"}`, `3`, ""},
+		"IntegerFraction":      {`{"type": "integer"}`, `3.5`, "$: expected integer, got number"},
+		"Enum":                 {`{"enum": ["a", 1]}`, `1`, ""},
+		"EnumMismatch":         {`{"enum": ["a", 1]}`, `"b"`, "$: value isn't one of the allowed values"},
+		"Minimum":              {`{"minimum": 1}`, `0`, "$: 0 is less than the minimum of 1"},
+		"Maximum":              {`{"maximum": 1}`, `1`, ""},
+		"ExclusiveMaximum":     {`{"exclusiveMaximum": 1}`, `1`, "$: 1 isn't less than 1"},
+		"MultipleOf":           {`{"multipleOf": 0.5}`, `1.25`, "$: 1.25 isn't a multiple of 0.5"},
+		"MultipleOfDecimal":    {`{"multipleOf": 0.01}`, `19.99`, ""},
+		"MultipleOfNegative":   {`{"multipleOf": 0.1}`, `-0.3`, ""},
+		"MultipleOfNearMiss":   {`{"multipleOf": 0.01}`, `19.995`, "$: 19.995 isn't a multiple of 0.01"},
+		"MinLength":            {`{"minLength": 2}`, `"ü"`, "$: string is shorter than 2 characters"},
+		"MaxLength":            {`{"maxLength": 2}`, `"üü"`, ""},
+		"Pattern":              {`{"pattern": "^\\d+$"}`, `"12a"`, `$: string doesn't match pattern "^\\d+$"`},
+		"Items":                {`{"items": {"type": "number"}}`, `[1, "2"]`, "$[1]: expected number, got string"},
+		"MinItems":             {`{"minItems": 1}`, `[]`, "$: array has fewer than 1 items"},
+		"UniqueItems":          {`{"uniqueItems": true}`, `[{"a": 1}, {"a": 1}]`, "$: items 0 and 1 are equal"},
+		"Required":             {`{"required": ["id"]}`, `{"name": "x"}`, `$: missing required property "id"`},
+		"Properties":           {`{"properties": {"id": {"type": "integer"}}}`, `{"id": "1"}`, "$.id: expected integer, got string"},
+		"AdditionalProperties": {`{"properties": {"id": {}}, "additionalProperties": false}`, `{"id": 1, "x": 2}`, "$.x: not allowed"},
+		"Nested": {
+			`{"properties": {"users": {"items": {"required": ["id"]}}}}`,
+			`{"users": [{"id": 1}, {}]}`,
+			`$.users[1]: missing required property "id"`,
+		},
+		"AllOf":      {`{"allOf": [{"minimum": 1}, {"maximum": 2}]}`, `3`, "$: 3 is greater than the maximum of 2"},
+		"AnyOf":      {`{"anyOf": [{"type": "string"}, {"type": "null"}]}`, `1`, "$: value doesn't match any of the anyOf schemas"},
+		"OneOf":      {`{"oneOf": [{"type": "number"}, {"type": "integer"}]}`, `1`, "$: value matches 2 of the oneOf schemas, not 1"},
+		"Not":        {`{"not": {"type": "null"}}`, `null`, "$: value matches a schema it mustn't"},
+		"True":       {`true`, `"anything"`, ""},
+		"InvalidDoc": {`{}`, `{"truncated": `, "invalid JSON: unexpected end of JSON input"},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			var schema JSONSchema
+			assert.NoError(t, json.Unmarshal([]byte(data.schema), &schema))
+			err := schema.ValidateJSON([]byte(data.doc))
+			if data.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, data.err)
+			}
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		var schema JSONSchema
+		assert.EqualError(t, json.Unmarshal([]byte(`{"type": "float"}`), &schema), "unknown schema type: float")
+		assert.EqualError(t, json.Unmarshal([]byte(`{"type": 1}`), &schema),
+			"schema type must be a string or a list of strings")
+		assert.EqualError(t, json.Unmarshal([]byte(`{"pattern": "("}`), &schema),
+			"invalid schema pattern \"(\": error parsing regexp: missing closing ): `(`")
+	})
+	t.Run("JSON", func(t *testing.T) {
+		var schema JSONSchema
+		jsonStr := `{"type":"object","properties":{"id":{"type":"integer"}},"additionalProperties":false}`
+		assert.NoError(t, json.Unmarshal([]byte(jsonStr), &schema))
+		data, err := json.Marshal(schema)
+		assert.NoError(t, err)
+		assert.JSONEq(t, jsonStr, string(data))
+	})
+}
@@ -31,12 +31,22 @@ var (
 	Iterations        = stats.New("iterations", stats.Counter)
 	IterationDuration = stats.New("iteration_duration", stats.Trend, stats.Time)
 	Errors            = stats.New("errors", stats.Counter)
+	DroppedSamples    = stats.New("dropped_samples", stats.Counter)
+
+	// Emitted each time the memoryLimitPolicy acts on memory use exceeding the memoryLimit option,
+	// whichever policy it is, tagged with it.
+	MemoryLimitExceeded = stats.New("memory_limit_exceeded", stats.Counter)
+
+	// Only emitted with the runMarkers option, once each.
+	RunStart = stats.New("run_start", stats.Counter)
+	RunEnd   = stats.New("run_end", stats.Counter)
 
 	// Runner-emitted.
 	Checks        = stats.New("checks", stats.Rate)
 	GroupDuration = stats.New("group_duration", stats.Trend, stats.Time)
 
-	// HTTP-related.
+	// HTTP-related. http_req_waiting is the time to first byte; like the other trends it's
+	// emitted for every request and can be targeted by thresholds, eg. "p(95)<500".
 	HTTPReqs              = stats.New("http_reqs", stats.Counter)
 	HTTPReqDuration       = stats.New("http_req_duration", stats.Trend, stats.Time)
 	HTTPReqBlocked        = stats.New("http_req_blocked", stats.Trend, stats.Time)
@@ -46,6 +56,39 @@ var (
 	HTTPReqReceiving      = stats.New("http_req_receiving", stats.Trend, stats.Time)
 	HTTPReqTLSHandshaking = stats.New("http_req_tls_handshaking", stats.Trend, stats.Time)
 
+	// 1 for requests that errored or got a status outside of the expectedStatuses option.
+	HTTPReqFailed = stats.New("http_req_failed", stats.Rate)
+
+	// Requests whose TLS handshake failed, tagged with a tls_error reason, with tlsFailureMetric.
+	HTTPReqTLSFailures = stats.New("http_req_tls_failures", stats.Counter)
+
+	// Connections to the request's host (tagged) in use and sitting idle, with connPoolMetrics.
+	HTTPConnsActive = stats.New("http_conns_active", stats.Gauge)
+	HTTPConnsIdle   = stats.New("http_conns_idle", stats.Gauge)
+
+	// 1 for requests sent over a reused connection, 0 for new ones, with connReuseMetrics.
+	HTTPReqConnReused = stats.New("http_req_conn_reused", stats.Rate)
+
+	// Requests that failed with a connection reset, including ones retried with connResetRetries.
+	HTTPConnResets = stats.New("http_conn_resets", stats.Counter)
+
+	// Requests cut short by the testEndDeadline option, whether they were sent or not.
+	HTTPReqDeadlineExceeded = stats.New("http_req_deadline_exceeded", stats.Counter)
+
+	// Time requests spent waiting for the rps, rpsPerVU and methodRPS limits before being sent,
+	// with the limiterWaitMetric option. It's not part of http_req_duration.
+	HTTPReqLimiterWaiting = stats.New("http_req_limiter_waiting", stats.Trend, stats.Time)
+
+	// Only emitted for compressed responses, with the keepCompressedBody option.
+	HTTPRespCompressedSize = stats.New("http_resp_compressed_size", stats.Trend, stats.Data)
+
+	// Only emitted for responses to URLs with a schema in the responseSchemas option; 1 if the
+	// body didn't validate against it.
+	HTTPRespSchemaFailed = stats.New("http_resp_schema_failed", stats.Rate)
+
+	// Responses with bodies larger than the responseSizeAlert option allows.
+	HTTPRespOversized = stats.New("http_resp_oversized", stats.Counter)
+
 	// Websocket-related
 	WSSessions         = stats.New("ws_sessions", stats.Counter)
 	WSMessagesSent     = stats.New("ws_msgs_sent", stats.Counter)
@@ -21,9 +21,12 @@
 package lib
 
 import (
+	"bufio"
 	"crypto/md5"
+	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
+	"io"
 	"strconv"
 	"strings"
 	"sync"
@@ -93,6 +96,100 @@ func (s *Stage) UnmarshalText(b []byte) error {
 	return nil
 }
 
+// ReadBodyData reads BodyData values from a file, one per line. Blank lines are skipped.
+func ReadBodyData(r io.Reader) ([]string, error) {
+	var values []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); line != "" {
+			values = append(values, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, errors.New("no body data defined")
+	}
+	return values, nil
+}
+
+// ReadTagDataCSV reads TagData rows from CSV data. The first row names the columns, and every
+// following row becomes a map of those names to its fields.
+func ReadTagDataCSV(r io.Reader) ([]map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("no tag data defined")
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range header {
+		header[i] = strings.TrimSpace(name)
+	}
+
+	var rows []map[string]string
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(record))
+		for i, field := range record {
+			row[header[i]] = strings.TrimSpace(field)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("no tag data defined")
+	}
+	return rows, nil
+}
+
+// ReadStagesCSV reads a list of stages from CSV data, one "duration,target" row per stage.
+// Either column may be left blank, and a header row naming the columns is skipped.
+func ReadStagesCSV(r io.Reader) ([]Stage, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var stages []Stage
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) > 2 {
+			return nil, errors.Errorf("line %d: expected at most 2 columns, got %d", line, len(record))
+		}
+		for i, field := range record {
+			record[i] = strings.TrimSpace(field)
+		}
+		if line == 1 && strings.EqualFold(record[0], "duration") {
+			continue
+		}
+
+		var stage Stage
+		if err := stage.UnmarshalText([]byte(strings.Join(record, ":"))); err != nil {
+			return nil, errors.Wrapf(err, "line %d", line)
+		}
+		stages = append(stages, stage)
+	}
+	if len(stages) == 0 {
+		return nil, errors.New("no stages defined")
+	}
+	return stages, nil
+}
+
 // A Group is an organisational block, that samples and checks may be tagged with.
 //
 // For more information, refer to the js/modules/k6.K6.Group() function.
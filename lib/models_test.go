@@ -22,6 +22,7 @@ package lib
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 
@@ -40,3 +41,65 @@ func TestStageJSON(t *testing.T) {
 	assert.NoError(t, json.Unmarshal(data, &s2))
 	assert.Equal(t, s, s2)
 }
+
+func TestReadBodyData(t *testing.T) {
+	data, err := ReadBodyData(strings.NewReader("alice\r\n\nbob\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, data)
+
+	_, err = ReadBodyData(strings.NewReader(""))
+	assert.EqualError(t, err, "no body data defined")
+}
+
+func TestReadTagDataCSV(t *testing.T) {
+	rows, err := ReadTagDataCSV(strings.NewReader("id, customer_tier\n1,gold\n2, free\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{
+		{"id": "1", "customer_tier": "gold"},
+		{"id": "2", "customer_tier": "free"},
+	}, rows)
+
+	_, err = ReadTagDataCSV(strings.NewReader(""))
+	assert.EqualError(t, err, "no tag data defined")
+	_, err = ReadTagDataCSV(strings.NewReader("id,customer_tier\n"))
+	assert.EqualError(t, err, "no tag data defined")
+	_, err = ReadTagDataCSV(strings.NewReader("id,customer_tier\n1\n"))
+	assert.Error(t, err)
+}
+
+func TestReadStagesCSV(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		stages, err := ReadStagesCSV(strings.NewReader("duration,target\n10s,100\n1m, 50\n30s,\n,0\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, []Stage{
+			{Duration: NullDurationFrom(10 * time.Second), Target: null.IntFrom(100)},
+			{Duration: NullDurationFrom(1 * time.Minute), Target: null.IntFrom(50)},
+			{Duration: NullDurationFrom(30 * time.Second)},
+			{Target: null.IntFrom(0)},
+		}, stages)
+	})
+	t.Run("NoHeader", func(t *testing.T) {
+		stages, err := ReadStagesCSV(strings.NewReader("10s,100\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, []Stage{
+			{Duration: NullDurationFrom(10 * time.Second), Target: null.IntFrom(100)},
+		}, stages)
+	})
+	t.Run("Empty", func(t *testing.T) {
+		_, err := ReadStagesCSV(strings.NewReader(""))
+		assert.EqualError(t, err, "no stages defined")
+	})
+	t.Run("InvalidDuration", func(t *testing.T) {
+		_, err := ReadStagesCSV(strings.NewReader("10s,100\nten,100\n"))
+		assert.EqualError(t, err, "line 2: time: invalid duration \"ten\"")
+	})
+	t.Run("InvalidTarget", func(t *testing.T) {
+		_, err := ReadStagesCSV(strings.NewReader("10s,lots\n"))
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "line 1: ")
+	})
+	t.Run("TooManyColumns", func(t *testing.T) {
+		_, err := ReadStagesCSV(strings.NewReader("10s,100,1\n"))
+		assert.EqualError(t, err, "line 1: expected at most 2 columns, got 3")
+	})
+}
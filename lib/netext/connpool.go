@@ -0,0 +1,150 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"net"
+	"net/http/httptrace"
+	"sync"
+)
+
+// Counts open and in-use connections per address ("host:port"), across every Dialer and request
+// that shares it. A connection is in use from when a request picks it until its response has
+// been read; the rest of the open connections are idle.
+type ConnPool struct {
+	lock   sync.Mutex
+	open   map[string]int64
+	active map[string]int64
+}
+
+// Connection counts for an address.
+type ConnPoolStats struct {
+	Active, Idle int64
+}
+
+func NewConnPool() *ConnPool {
+	return &ConnPool{
+		open:   make(map[string]int64),
+		active: make(map[string]int64),
+	}
+}
+
+// Returns the connection counts for an address.
+func (p *ConnPool) Stats(addr string) ConnPoolStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.stats(addr)
+}
+
+func (p *ConnPool) stats(addr string) ConnPoolStats {
+	active := p.active[addr]
+	idle := p.open[addr] - active
+	if idle < 0 {
+		idle = 0
+	}
+	return ConnPoolStats{Active: active, Idle: idle}
+}
+
+func (p *ConnPool) add(counts map[string]int64, addr string, delta int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if counts[addr] += delta; counts[addr] <= 0 {
+		delete(counts, addr)
+	}
+}
+
+// Wraps a newly dialed connection, so it's counted as open until it's closed.
+func (p *ConnPool) wrap(conn net.Conn, addr string) net.Conn {
+	p.add(p.open, addr, 1)
+	return &poolConn{Conn: conn, pool: p, addr: addr}
+}
+
+// Returns a tracker for a single request, see ConnPoolTracker.
+func (p *ConnPool) Tracker() *ConnPoolTracker {
+	return &ConnPoolTracker{pool: p}
+}
+
+type poolConn struct {
+	net.Conn
+
+	pool *ConnPool
+	addr string
+	once sync.Once
+}
+
+func (c *poolConn) Close() error {
+	c.once.Do(func() { c.pool.add(c.pool.open, c.addr, -1) })
+	return c.Conn.Close()
+}
+
+// Tracks which connections a request takes from a ConnPool, including ones for redirects, so
+// they're counted as in use until Release() is called.
+type ConnPoolTracker struct {
+	pool *ConnPool
+
+	lock  sync.Mutex
+	addr  string
+	addrs []string
+}
+
+// Returns hooks to attach to the request with httptrace.WithClientTrace().
+func (t *ConnPoolTracker) Trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: t.getConn,
+		GotConn: t.gotConn,
+	}
+}
+
+func (t *ConnPoolTracker) getConn(hostPort string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.addr = hostPort
+}
+
+func (t *ConnPoolTracker) gotConn(httptrace.GotConnInfo) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.pool.add(t.pool.active, t.addr, 1)
+	t.addrs = append(t.addrs, t.addr)
+}
+
+// Marks the request's connections as no longer in use. Returns the pool's counts for their
+// addresses as they were just before, while the request still held them.
+func (t *ConnPoolTracker) Release() map[string]ConnPoolStats {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.addrs) == 0 {
+		return nil
+	}
+	stats := make(map[string]ConnPoolStats, len(t.addrs))
+	t.pool.lock.Lock()
+	for _, addr := range t.addrs {
+		stats[addr] = t.pool.stats(addr)
+	}
+	t.pool.lock.Unlock()
+
+	for _, addr := range t.addrs {
+		t.pool.add(t.pool.active, addr, -1)
+	}
+	t.addrs = nil
+	return stats
+}
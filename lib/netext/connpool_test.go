@@ -0,0 +1,105 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnPool(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	addr := "k6.test:" + u.Port()
+
+	pool := NewConnPool()
+	d := NewDialer(net.Dialer{})
+	d.Hosts = map[string]net.IP{"k6.test": net.ParseIP("127.0.0.1")}
+	d.ConnPool = pool
+	transport := &http.Transport{DialContext: d.DialContext}
+	client := &http.Client{Transport: transport}
+
+	get := func(path string) map[string]ConnPoolStats {
+		tracker := pool.Tracker()
+		ctx := httptrace.WithClientTrace(context.Background(), tracker.Trace())
+		req, err := http.NewRequest("GET", "http://"+addr+path, nil)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		res, err := client.Do(req.WithContext(ctx))
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		_, _ = ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+		return tracker.Release()
+	}
+
+	// Two requests at once need two connections, both of which are in use.
+	var wg sync.WaitGroup
+	results := make(chan map[string]ConnPoolStats, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- get("/slow")
+		}()
+	}
+	for i := 0; i < 1000 && pool.Stats(addr).Active < 2; i++ {
+		time.Sleep(1 * time.Millisecond)
+	}
+	assert.Equal(t, ConnPoolStats{Active: 2, Idle: 0}, pool.Stats(addr))
+	close(release)
+	wg.Wait()
+	close(results)
+	for stats := range results {
+		assert.Equal(t, int64(2), stats[addr].Active+stats[addr].Idle)
+	}
+
+	// Afterwards, both are idle; a new request reuses one of them.
+	assert.Equal(t, ConnPoolStats{Active: 0, Idle: 2}, pool.Stats(addr))
+	assert.Equal(t, map[string]ConnPoolStats{addr: {Active: 1, Idle: 1}}, get("/"))
+	assert.Equal(t, ConnPoolStats{Active: 0, Idle: 2}, pool.Stats(addr))
+
+	// Closed connections are no longer counted.
+	transport.CloseIdleConnections()
+	assert.Equal(t, ConnPoolStats{}, pool.Stats(addr))
+}
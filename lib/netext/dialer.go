@@ -25,11 +25,16 @@ import (
 	"net"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/viki-org/dnscache"
+	"golang.org/x/time/rate"
 )
 
+// How long to wait before retrying a failed DNS lookup, if not specified.
+const DefaultDNSRetryBackoff = 100 * time.Millisecond
+
 type Dialer struct {
 	net.Dialer
 
@@ -37,6 +42,30 @@ type Dialer struct {
 	Blacklist []*net.IPNet
 	Hosts     map[string]net.IP
 
+	// How many times to retry failed DNS lookups, and how long to wait before the first retry;
+	// the wait doubles with each subsequent retry.
+	DNSRetries      int
+	DNSRetryBackoff time.Duration
+
+	// Limits the rate at which new connections are opened. May be nil, and may be shared.
+	ConnLimit *rate.Limiter
+
+	// Counts the connections this dialer opens, by address. May be nil, and may be shared.
+	ConnPool *ConnPool
+
+	// Simulated latency and loss applied to every connection. May be nil, and may be shared.
+	NetConditions *NetConditions
+
+	// Makes the TLS connections to HTTPS proxies. May be nil, and may be shared.
+	ProxyTLS *ProxyTLS
+
+	// Local ports to make TCP connections from. May be nil, and may be shared.
+	LocalPorts *PortRange
+
+	// Local addresses to make TCP connections from; the first of the same family (IPv4 or IPv6)
+	// as the remote address is used. May be nil.
+	LocalIPs []net.IP
+
 	BytesRead    *int64
 	BytesWritten *int64
 }
@@ -56,7 +85,7 @@ func (d *Dialer) DialContext(ctx context.Context, proto, addr string) (net.Conn,
 	ip, ok := d.Hosts[host]
 	if !ok {
 		var err error
-		ip, err = d.Resolver.FetchOne(host)
+		ip, err = d.resolve(ctx, host)
 		if err != nil {
 			return nil, err
 		}
@@ -67,20 +96,95 @@ func (d *Dialer) DialContext(ctx context.Context, proto, addr string) (net.Conn,
 			return nil, errors.Errorf("IP (%s) is in a blacklisted range (%s)", ip, net)
 		}
 	}
+	if d.ConnLimit != nil {
+		if err := d.ConnLimit.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
 	ipStr := ip.String()
 	if strings.ContainsRune(ipStr, ':') {
 		ipStr = "[" + ipStr + "]"
 	}
-	conn, err := d.Dialer.DialContext(ctx, proto, ipStr+":"+addr[delimiter+1:])
+	conn, err := d.dial(ctx, proto, ip, ipStr+":"+addr[delimiter+1:])
 	if err != nil {
 		return nil, err
 	}
 	if d.BytesRead != nil && d.BytesWritten != nil {
 		conn = &Conn{conn, d.BytesRead, d.BytesWritten}
 	}
+	if d.NetConditions != nil {
+		conn = d.NetConditions.wrap(conn)
+	}
+	if d.ConnPool != nil {
+		conn = d.ConnPool.wrap(conn, addr)
+	}
+	if d.ProxyTLS != nil {
+		if conn, err = d.ProxyTLS.wrap(ctx, conn, addr); err != nil {
+			return nil, err
+		}
+	}
 	return conn, err
 }
 
+func (d *Dialer) dial(ctx context.Context, proto string, ip net.IP, addr string) (net.Conn, error) {
+	if (d.LocalPorts == nil && d.LocalIPs == nil) || !strings.HasPrefix(proto, "tcp") {
+		return d.Dialer.DialContext(ctx, proto, addr)
+	}
+	localIP := d.localIP(ip)
+	if d.LocalPorts == nil {
+		dialer := d.Dialer
+		dialer.LocalAddr = &net.TCPAddr{IP: localIP}
+		return dialer.DialContext(ctx, proto, addr)
+	}
+
+	// Try each port in the range at most once; ones still in use (or in TIME_WAIT towards the
+	// same address) fail to bind, and we move on to the next.
+	var err error
+	for i := 0; i < d.LocalPorts.Size(); i++ {
+		dialer := d.Dialer
+		dialer.LocalAddr = &net.TCPAddr{IP: localIP, Port: d.LocalPorts.Next()}
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, proto, addr); err == nil {
+			return conn, nil
+		}
+		if !isAddrInUse(err) {
+			return nil, err
+		}
+	}
+	return nil, errors.Wrap(err, "no free local port in range")
+}
+
+// Returns which of LocalIPs to connect to the given remote IP from, or nil to leave it to the OS,
+// if none is of the same family.
+func (d *Dialer) localIP(remote net.IP) net.IP {
+	remote4 := remote.To4() != nil
+	for _, ip := range d.LocalIPs {
+		if (ip.To4() != nil) == remote4 {
+			return ip
+		}
+	}
+	return nil
+}
+
+func (d *Dialer) resolve(ctx context.Context, host string) (net.IP, error) {
+	backoff := d.DNSRetryBackoff
+	for i := 0; ; i++ {
+		ip, err := d.Resolver.FetchOne(host)
+		if err == nil || i >= d.DNSRetries {
+			return ip, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
 type Conn struct {
 	net.Conn
 
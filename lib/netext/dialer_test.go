@@ -0,0 +1,183 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func TestDialerDNSRetries(t *testing.T) {
+	d := NewDialer(net.Dialer{})
+	d.DNSRetries = 2
+	d.DNSRetryBackoff = 20 * time.Millisecond
+
+	t.Run("Retry", func(t *testing.T) {
+		startTime := time.Now()
+		_, err := d.DialContext(context.Background(), "tcp", "k6.invalid:80")
+		assert.Error(t, err)
+		assert.True(t, time.Since(startTime) >= 60*time.Millisecond, "didn't back off between retries")
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		d := *d
+		d.DNSRetryBackoff = 1 * time.Hour
+		_, err := d.DialContext(ctx, "tcp", "k6.invalid:80")
+		assert.Error(t, err)
+	})
+}
+
+func TestDialerConnLimit(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = listener.Close() }()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	d := NewDialer(net.Dialer{})
+	d.Hosts = map[string]net.IP{"k6.test": net.ParseIP("127.0.0.1")}
+	d.ConnLimit = rate.NewLimiter(rate.Limit(10), 1)
+
+	startTime := time.Now()
+	for i := 0; i < 3; i++ {
+		conn, err := d.DialContext(context.Background(), "tcp", "k6.test:"+port)
+		if assert.NoError(t, err) {
+			_ = conn.Close()
+		}
+	}
+	assert.True(t, time.Since(startTime) >= 200*time.Millisecond, "connections weren't throttled")
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		d := *d
+		d.ConnLimit = rate.NewLimiter(rate.Limit(1), 0)
+		_, err := d.DialContext(ctx, "tcp", "k6.test:"+port)
+		assert.Error(t, err)
+	})
+}
+
+func TestDialerLocalPorts(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = listener.Close() }()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	addr := listener.Addr().(*net.TCPAddr)
+
+	// Find a port nothing is using, by briefly listening on it.
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	freePort := free.Addr().(*net.TCPAddr).Port
+	_ = free.Close()
+
+	t.Run("Free", func(t *testing.T) {
+		d := NewDialer(net.Dialer{})
+		d.LocalPorts = NewPortRange(freePort, freePort)
+		conn, err := d.DialContext(context.Background(), "tcp", addr.String())
+		if assert.NoError(t, err) {
+			assert.Equal(t, freePort, conn.LocalAddr().(*net.TCPAddr).Port)
+			_ = conn.Close()
+		}
+	})
+	t.Run("InUse", func(t *testing.T) {
+		d := NewDialer(net.Dialer{})
+		d.LocalPorts = NewPortRange(addr.Port, addr.Port)
+		_, err := d.DialContext(context.Background(), "tcp", addr.String())
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "no free local port in range")
+		}
+	})
+}
+
+func TestDialerLocalIPs(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = listener.Close() }()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	addr := listener.Addr().String()
+
+	// Any address in 127.0.0.0/8 is local on Linux, but only 127.0.0.1 is elsewhere.
+	d := NewDialer(net.Dialer{})
+	d.LocalIPs = []net.IP{net.ParseIP("::1"), net.ParseIP("127.0.0.1")}
+	conn, err := d.DialContext(context.Background(), "tcp", addr)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "127.0.0.1", conn.LocalAddr().(*net.TCPAddr).IP.String())
+		_ = conn.Close()
+	}
+
+	t.Run("NoneOfFamily", func(t *testing.T) {
+		d := NewDialer(net.Dialer{})
+		d.LocalIPs = []net.IP{net.ParseIP("::1")}
+		assert.Nil(t, d.localIP(net.ParseIP("127.0.0.1")))
+		assert.Equal(t, "::1", d.localIP(net.ParseIP("::2")).String())
+	})
+}
+
+func TestPortRange(t *testing.T) {
+	r := NewPortRange(40000, 40002)
+	assert.Equal(t, 3, r.Size())
+	var ports []int
+	for i := 0; i < 5; i++ {
+		ports = append(ports, r.Next())
+	}
+	assert.Equal(t, []int{40000, 40001, 40002, 40000, 40001}, ports)
+}
@@ -0,0 +1,153 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// How long a lost write waits before it's "retransmitted"; doubles with each consecutive
+	// loss, up to MaxRetransmitTimeout.
+	DefaultRetransmitTimeout = 200 * time.Millisecond
+	MaxRetransmitTimeout     = 60 * time.Second
+
+	// How many times in a row a write can be lost; Linux gives up on a connection after this many
+	// retransmissions by default, too (net.ipv4.tcp_retries2).
+	MaxRetransmits = 15
+)
+
+// Simulates degraded network conditions on the connections a dialer opens. Safe to share; each
+// connection gets its own random number generator, derived from the seed.
+type NetConditions struct {
+	Latency time.Duration
+	Jitter  time.Duration
+	Loss    float64
+
+	seed  int64
+	conns int64 // Connections wrapped so far. Use `sync/atomic`.
+}
+
+// Returns new network conditions. A seed of 0 seeds the random number generators from the clock.
+func NewNetConditions(latency, jitter time.Duration, loss float64, seed int64) *NetConditions {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &NetConditions{
+		Latency: latency,
+		Jitter:  jitter,
+		Loss:    loss,
+		seed:    seed,
+	}
+}
+
+// Returns a random number generator for the next connection. The nth connection always gets
+// the same one for a given seed, so runs are reproducible as far as connections are opened in
+// the same order.
+func (nc *NetConditions) newRand() *rand.Rand {
+	n := atomic.AddInt64(&nc.conns, 1)
+	return rand.New(rand.NewSource(nc.seed + n))
+}
+
+// Returns how long the next write should be held back for: the latency, a random amount of
+// jitter, and a retransmission timeout for every time it's lost along the way.
+func (nc *NetConditions) Delay(r *rand.Rand) time.Duration {
+	d := nc.Latency
+	if nc.Jitter > 0 {
+		d += time.Duration(r.Int63n(int64(nc.Jitter) + 1))
+	}
+	rto := DefaultRetransmitTimeout
+	for i := 0; i < MaxRetransmits && nc.Loss > 0 && r.Float64() < nc.Loss; i++ {
+		d += rto
+		if rto *= 2; rto > MaxRetransmitTimeout {
+			rto = MaxRetransmitTimeout
+		}
+	}
+	return d
+}
+
+func (nc *NetConditions) wrap(conn net.Conn) net.Conn {
+	return &netConditionsConn{Conn: conn, conditions: nc, rand: nc.newRand(), closed: make(chan struct{})}
+}
+
+type netConditionsConn struct {
+	net.Conn
+
+	conditions *NetConditions
+
+	// Guards the RNG, in case of concurrent writes, and the write deadline.
+	mutex         sync.Mutex
+	rand          *rand.Rand
+	writeDeadline time.Time
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Holds the write back for a delay, but no longer than the write deadline, if any; a write
+// that's still held back then fails with the underlying connection's timeout error.
+func (c *netConditionsConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	d := c.conditions.Delay(c.rand)
+	deadline := c.writeDeadline
+	c.mutex.Unlock()
+
+	if !deadline.IsZero() {
+		if left := time.Until(deadline); left < d {
+			d = left
+		}
+	}
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-c.closed:
+		}
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *netConditionsConn) SetDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.writeDeadline = t
+	c.mutex.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *netConditionsConn) SetWriteDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.writeDeadline = t
+	c.mutex.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// Closing the connection lets go of writes that are being held back; they fail like any write
+// to a closed connection.
+func (c *netConditionsConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() { close(c.closed) })
+	return err
+}
@@ -0,0 +1,159 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetConditions(t *testing.T) {
+	t.Run("Delay", func(t *testing.T) {
+		t.Run("Latency", func(t *testing.T) {
+			nc := NewNetConditions(50*time.Millisecond, 0, 0, 1)
+			r := nc.newRand()
+			for i := 0; i < 10; i++ {
+				assert.Equal(t, 50*time.Millisecond, nc.Delay(r))
+			}
+		})
+		t.Run("Jitter", func(t *testing.T) {
+			nc1 := NewNetConditions(50*time.Millisecond, 10*time.Millisecond, 0, 1)
+			nc2 := NewNetConditions(50*time.Millisecond, 10*time.Millisecond, 0, 1)
+			r1, r2 := nc1.newRand(), nc2.newRand()
+			for i := 0; i < 100; i++ {
+				d := nc1.Delay(r1)
+				assert.True(t, d >= 50*time.Millisecond && d <= 60*time.Millisecond, "delay out of range: %s", d)
+				assert.Equal(t, d, nc2.Delay(r2), "delays aren't reproducible")
+			}
+		})
+		t.Run("Loss", func(t *testing.T) {
+			nc := NewNetConditions(0, 0, 0.5, 1)
+			r := nc.newRand()
+			lost := 0
+			for i := 0; i < 1000; i++ {
+				d := nc.Delay(r)
+				if d > 0 {
+					lost++
+					assert.True(t, d >= DefaultRetransmitTimeout, "delay too short for a loss: %s", d)
+				}
+			}
+			assert.InDelta(t, 500, lost, 100)
+		})
+		t.Run("Capped", func(t *testing.T) {
+			var max time.Duration
+			for i, rto := 0, DefaultRetransmitTimeout; i < MaxRetransmits; i++ {
+				max += rto
+				if rto *= 2; rto > MaxRetransmitTimeout {
+					rto = MaxRetransmitTimeout
+				}
+			}
+			nc := NewNetConditions(0, 0, 0.999999, 1)
+			r := nc.newRand()
+			for i := 0; i < 100; i++ {
+				assert.Equal(t, max, nc.Delay(r))
+			}
+		})
+		t.Run("PerConn", func(t *testing.T) {
+			nc := NewNetConditions(0, time.Second, 0, 1)
+			r1, r2 := nc.newRand(), nc.newRand()
+			same := true
+			for i := 0; i < 10; i++ {
+				same = same && nc.Delay(r1) == nc.Delay(r2)
+			}
+			assert.False(t, same, "connections share delays")
+		})
+	})
+	t.Run("Dialer", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer func() { _ = l.Close() }()
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 4)
+			_, _ = conn.Read(buf)
+			_, _ = conn.Write(buf)
+			_ = conn.Close()
+		}()
+
+		d := NewDialer(net.Dialer{})
+		d.Hosts = map[string]net.IP{"k6.test": net.ParseIP("127.0.0.1")}
+		d.NetConditions = NewNetConditions(100*time.Millisecond, 0, 0, 1)
+		_, port, _ := net.SplitHostPort(l.Addr().String())
+		conn, err := d.DialContext(context.Background(), "tcp", "k6.test:"+port)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		start := time.Now()
+		_, err = conn.Write([]byte("ping"))
+		assert.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = conn.Read(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, "ping", string(buf))
+		assert.True(t, time.Since(start) >= 100*time.Millisecond, "write wasn't delayed")
+	})
+	// Returns a loopback connection held back by a minute-long latency.
+	slowConn := func(t *testing.T) net.Conn {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		defer func() { _ = l.Close() }()
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return NewNetConditions(time.Minute, 0, 0, 1).wrap(conn)
+	}
+	t.Run("WriteDeadline", func(t *testing.T) {
+		conn := slowConn(t)
+		defer func() { _ = conn.Close() }()
+
+		start := time.Now()
+		assert.NoError(t, conn.SetWriteDeadline(start.Add(50*time.Millisecond)))
+		_, err := conn.Write([]byte("ping"))
+		assert.Error(t, err)
+		assert.True(t, time.Since(start) < 10*time.Second, "write outlived its deadline")
+	})
+	t.Run("Close", func(t *testing.T) {
+		conn := slowConn(t)
+
+		start := time.Now()
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			_ = conn.Close()
+		}()
+		_, err := conn.Write([]byte("ping"))
+		assert.Error(t, err)
+		assert.True(t, time.Since(start) < 10*time.Second, "write outlived the connection")
+	})
+}
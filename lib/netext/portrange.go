@@ -0,0 +1,59 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+)
+
+// Hands out local ports from an inclusive range in turn, wrapping around at the end.
+type PortRange struct {
+	min, max int
+	next     uint64
+}
+
+func NewPortRange(min, max int) *PortRange {
+	return &PortRange{min: min, max: max}
+}
+
+// Returns how many ports are in the range.
+func (r *PortRange) Size() int {
+	return r.max - r.min + 1
+}
+
+// Returns the next port to try.
+func (r *PortRange) Next() int {
+	n := atomic.AddUint64(&r.next, 1) - 1
+	return r.min + int(n%uint64(r.Size()))
+}
+
+func isAddrInUse(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.EADDRINUSE || err == syscall.EADDRNOTAVAIL
+}
@@ -0,0 +1,59 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialerPrewarm(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	d := NewDialer(net.Dialer{})
+	d.Hosts = map[string]net.IP{"k6.test": net.ParseIP("127.0.0.1")}
+
+	t.Run("TCP", func(t *testing.T) {
+		assert.NoError(t, d.Prewarm(context.Background(), "k6.test:"+port, nil))
+	})
+	t.Run("TLS", func(t *testing.T) {
+		cache := tls.NewLRUClientSessionCache(1)
+		config := &tls.Config{
+			InsecureSkipVerify: true,
+			MaxVersion:         tls.VersionTLS12,
+			ClientSessionCache: cache,
+		}
+		assert.NoError(t, d.Prewarm(context.Background(), "k6.test:"+port, config))
+		_, ok := cache.Get("k6.test")
+		assert.True(t, ok, "no session was cached")
+	})
+	t.Run("Unreachable", func(t *testing.T) {
+		assert.Error(t, d.Prewarm(context.Background(), "k6.invalid:80", nil))
+	})
+}
@@ -0,0 +1,103 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Makes the TLS connections to HTTPS proxies itself, with a config of its own; an http.Transport
+// would use its TLSClientConfig for both the proxy and the origin behind it.
+//
+// Wrap the transport's Proxy func with Proxy(), which hands HTTPS proxies back to the transport
+// as plain HTTP ones, and set it on the transport's Dialer, which adds the TLS.
+type ProxyTLS struct {
+	Config *tls.Config
+
+	mutex   sync.RWMutex
+	proxies map[string]bool
+}
+
+func NewProxyTLS(config *tls.Config) *ProxyTLS {
+	return &ProxyTLS{Config: config, proxies: make(map[string]bool)}
+}
+
+// Wraps a transport's Proxy func; see ProxyTLS.
+func (p *ProxyTLS) Proxy(proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := proxy(req)
+		if err != nil || u == nil || u.Scheme != "https" {
+			return u, err
+		}
+
+		port := u.Port()
+		if port == "" {
+			port = "443"
+		}
+		addr := net.JoinHostPort(u.Hostname(), port)
+		p.mutex.Lock()
+		p.proxies[addr] = true
+		p.mutex.Unlock()
+
+		plain := *u
+		plain.Scheme = "http"
+		plain.Host = addr
+		return &plain, nil
+	}
+}
+
+func (p *ProxyTLS) isProxy(addr string) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.proxies[addr]
+}
+
+// Makes a TLS handshake over conn if addr is an HTTPS proxy's address.
+func (p *ProxyTLS) wrap(ctx context.Context, conn net.Conn, addr string) (net.Conn, error) {
+	if !p.isProxy(addr) {
+		return conn, nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	config := p.Config.Clone()
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
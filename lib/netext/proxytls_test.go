@@ -0,0 +1,67 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyTLS(t *testing.T) {
+	proxy := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("proxied " + r.URL.String()))
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for name, skipVerify := range map[string]bool{"SkipVerify": true, "Verify": false} {
+		t.Run(name, func(t *testing.T) {
+			p := NewProxyTLS(&tls.Config{InsecureSkipVerify: skipVerify})
+			d := NewDialer(net.Dialer{})
+			d.ProxyTLS = p
+			client := &http.Client{Transport: &http.Transport{
+				Proxy:       p.Proxy(http.ProxyURL(proxyURL)),
+				DialContext: d.DialContext,
+			}}
+
+			res, err := client.Get("http://example.com/hi")
+			if !skipVerify {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				body, _ := ioutil.ReadAll(res.Body)
+				_ = res.Body.Close()
+				assert.Equal(t, "proxied http://example.com/hi", string(body))
+			}
+		})
+	}
+}
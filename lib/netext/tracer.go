@@ -49,6 +49,9 @@ type Trail struct {
 	// Detailed connection information.
 	ConnReused     bool
 	ConnRemoteAddr net.Addr
+
+	// The error the TLS handshake failed with, if it did.
+	TLSHandshakeError error
 }
 
 func (tr Trail) Samples(tags map[string]string) []stats.Sample {
@@ -82,7 +85,8 @@ type Tracer struct {
 	connReused     bool
 	connRemoteAddr net.Addr
 
-	protoError error
+	protoError      error
+	tlsHandshakeErr error
 }
 
 // Trace() returns a premade ClientTrace that calls all of the Tracer's hooks.
@@ -104,8 +108,9 @@ func (t *Tracer) Done() Trail {
 	done := time.Now()
 
 	trail := Trail{
-		ConnReused:     t.connReused,
-		ConnRemoteAddr: t.connRemoteAddr,
+		ConnReused:        t.connReused,
+		ConnRemoteAddr:    t.connRemoteAddr,
+		TLSHandshakeError: t.tlsHandshakeErr,
 	}
 
 	if !t.gotConn.IsZero() && !t.getConn.IsZero() {
@@ -201,6 +206,7 @@ func (t *Tracer) TLSHandshakeDone(state tls.ConnectionState, err error) {
 
 	if err != nil {
 		t.protoError = err
+		t.tlsHandshakeErr = err
 	}
 }
 
@@ -21,9 +21,23 @@
 package lib
 
 import (
+	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
 	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/loadimpact/k6/stats"
 	"github.com/pkg/errors"
@@ -77,11 +91,84 @@ func (v *TLSVersions) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// The errors a TLS handshake fails with if the server picks a version we've disabled, or if it
+// doesn't speak any of the ones we offered; the latter doesn't say which version it wanted.
+var (
+	tlsUnsupportedVersionRegexp = regexp.MustCompile(`tls: server selected unsupported protocol version ([0-9a-f]+)`)
+	tlsVersionAlert             = "tls: protocol version not supported"
+)
+
+// If err is a failed handshake with a server that negotiated a TLS version below min, returns
+// an error saying so in plain words, for tlsMinVersionStrict; otherwise returns nil.
+func TLSDowngradeError(err error, min TLSVersion) error {
+	if err == nil || min == 0 {
+		return nil
+	}
+	minName := SupportedTLSVersionsToString[min]
+	m := tlsUnsupportedVersionRegexp.FindStringSubmatch(err.Error())
+	if m == nil {
+		if strings.Contains(err.Error(), tlsVersionAlert) {
+			return errors.Errorf("server only supports versions below configured minimum %s", minName)
+		}
+		return nil
+	}
+	ver, perr := strconv.ParseUint(m[1], 16, 16)
+	if perr != nil || TLSVersion(ver) >= min {
+		return nil
+	}
+	name, ok := SupportedTLSVersionsToString[TLSVersion(ver)]
+	if !ok {
+		name = "0x" + m[1]
+	}
+	return errors.Errorf("server negotiated %s below configured minimum %s", name, minName)
+}
+
+// Reasons a TLS handshake failed for, as reported by tlsFailureMetric.
+const (
+	TLSFailureCertificate = "certificate"
+	TLSFailureVersion     = "version"
+	TLSFailureCipher      = "cipher"
+	TLSFailureHandshake   = "handshake"
+)
+
+// Sorts a failed TLS handshake's error into one of the TLSFailure* reasons; anything that isn't
+// recognizably about certificates, versions or cipher suites is just a "handshake" failure. That
+// includes the generic handshake_failure alert, which servers send for anything from a missing
+// client certificate to a rejected server name, as well as for cipher suite mismatches.
+func TLSFailureReason(err error) string {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "x509:"),
+		strings.Contains(msg, "bad certificate"),
+		strings.Contains(msg, "unknown certificate authority"),
+		strings.Contains(msg, "certificate required"),
+		strings.Contains(msg, "certificate expired"):
+		return TLSFailureCertificate
+	case strings.Contains(msg, "protocol version"):
+		return TLSFailureVersion
+	case strings.Contains(msg, "cipher suite"),
+		strings.Contains(msg, "insufficient security"):
+		return TLSFailureCipher
+	}
+	return TLSFailureHandshake
+}
+
 // A list of TLS cipher suites.
 // Marshals and unmarshals from a list of names, eg. "TLS_ECDHE_RSA_WITH_RC4_128_SHA".
-// BUG: This currently doesn't marshal back to JSON properly!!
 type TLSCipherSuites []uint16
 
+func (s TLSCipherSuites) MarshalJSON() ([]byte, error) {
+	suiteNames := make([]string, len(s))
+	for i, suiteID := range s {
+		suiteName, ok := SupportedTLSCipherSuitesToString[suiteID]
+		if !ok {
+			return nil, errors.Errorf("Unknown cipher suite: %d", suiteID)
+		}
+		suiteNames[i] = suiteName
+	}
+	return json.Marshal(suiteNames)
+}
+
 func (s *TLSCipherSuites) UnmarshalJSON(data []byte) error {
 	var suiteNames []string
 	if err := json.Unmarshal(data, &suiteNames); err != nil {
@@ -102,20 +189,88 @@ func (s *TLSCipherSuites) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// TLS settings for connections to HTTPS proxies; see Options.ProxyTLS. Unset versions or cipher
+// suites mean Go's defaults, not those of the tlsVersion and tlsCipherSuites options.
+type ProxyTLS struct {
+	InsecureSkipVerify bool             `json:"insecureSkipVerify"`
+	Version            *TLSVersions     `json:"version"`
+	CipherSuites       *TLSCipherSuites `json:"cipherSuites"`
+}
+
+// Returns a tls.Config for connecting to a proxy with these settings.
+func (p ProxyTLS) Config() *tls.Config {
+	config := &tls.Config{InsecureSkipVerify: p.InsecureSkipVerify}
+	if p.Version != nil {
+		config.MinVersion = uint16(p.Version.Min)
+		config.MaxVersion = uint16(p.Version.Max)
+	}
+	if p.CipherSuites != nil {
+		config.CipherSuites = *p.CipherSuites
+	}
+	return config
+}
+
+// TLS settings for connections to hosts matching a pattern; see Options.PerHostTLS. Unset fields
+// fall back to the global options: tlsVersion, insecureSkipTLSVerify, tlsAuth and tlsAuthByHost,
+// and tlsNextProtos. The server name, if set, is sent as SNI and verified against instead of the
+// hostname.
+type HostTLSConfig struct {
+	Version            *TLSVersions `json:"version"`
+	InsecureSkipVerify null.Bool    `json:"insecureSkipVerify"`
+	Auth               *TLSAuth     `json:"auth"`
+	ServerName         null.String  `json:"serverName"`
+	NextProtos         []string     `json:"nextProtos"`
+}
+
+// Returns c with every field that's set in other overridden.
+func (c HostTLSConfig) Apply(other HostTLSConfig) HostTLSConfig {
+	if other.Version != nil {
+		c.Version = other.Version
+	}
+	if other.InsecureSkipVerify.Valid {
+		c.InsecureSkipVerify = other.InsecureSkipVerify
+	}
+	if other.Auth != nil {
+		c.Auth = other.Auth
+	}
+	if other.ServerName.Valid {
+		c.ServerName = other.ServerName
+	}
+	if other.NextProtos != nil {
+		c.NextProtos = other.NextProtos
+	}
+	return c
+}
+
 // Fields for TLSAuth. Unmarshalling hack.
 type TLSAuthFields struct {
 	// Certificate and key as a PEM-encoded string, including "-----BEGIN CERTIFICATE-----".
 	Cert string `json:"cert"`
 	Key  string `json:"key"`
 
-	// Domains to present the certificate to. May contain wildcards, eg. "*.example.com".
+	// Alternatively, paths to PEM files to read the certificate and key from. These can be
+	// reloaded whenever they change, with the tlsAuthWatch option.
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+
+	// Domains to present the certificate to. May contain wildcards, eg. "*.example.com"; a host
+	// only gets the certificates listing the most specific of them that matches it. Certificates
+	// without any are presented to every host. See Options.TLSAuthFor().
 	Domains []string `json:"domains"`
 }
 
+// How often to check CertFile and KeyFile for changes, with the tlsAuthWatch option.
+const TLSAuthWatchInterval = 1 * time.Second
+
 // Defines a TLS client certificate to present to certain hosts.
 type TLSAuth struct {
 	TLSAuthFields
+
+	// The certificate may be swapped out by Reload() while handshakes are using it.
+	lock        sync.RWMutex
 	certificate *tls.Certificate
+	modTime     time.Time // Latest modification time of the files when they were loaded.
+	checkTime   time.Time // When the files were last checked for changes.
 }
 
 func (c *TLSAuth) UnmarshalJSON(data []byte) error {
@@ -129,154 +284,2471 @@ func (c *TLSAuth) UnmarshalJSON(data []byte) error {
 }
 
 func (c *TLSAuth) Certificate() (*tls.Certificate, error) {
+	c.lock.RLock()
+	cert := c.certificate
+	c.lock.RUnlock()
+	if cert != nil {
+		return cert, nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	if c.certificate == nil {
-		cert, err := tls.X509KeyPair([]byte(c.Cert), []byte(c.Key))
+		cert, modTime, err := c.load()
 		if err != nil {
 			return nil, err
 		}
-		c.certificate = &cert
+		c.certificate, c.modTime = cert, modTime
 	}
 	return c.certificate, nil
 }
 
-type Options struct {
-	// Should the test start in a paused state?
-	Paused null.Bool `json:"paused" envconfig:"paused"`
+// Reloads the certificate if CertFile or KeyFile have changed since they were loaded, checking
+// at most once per TLSAuthWatchInterval. If the new files can't be loaded, eg. because they're
+// only partially written, the previous certificate is kept and an error is returned.
+func (c *TLSAuth) Reload() error {
+	if c.CertFile == "" && c.KeyFile == "" {
+		return nil
+	}
 
-	// Initial values for VUs, max VUs, duration cap, iteration cap, and stages.
-	// See the Runner or Executor interfaces for more information.
-	VUs        null.Int     `json:"vus" envconfig:"vus"`
-	VUsMax     null.Int     `json:"vusMax" envconfig:"vus_max"`
-	Duration   NullDuration `json:"duration" envconfig:"duration"`
-	Iterations null.Int     `json:"iterations" envconfig:"iterations"`
-	Stages     []Stage      `json:"stages" envconfig:"stages"`
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.certificate != nil && time.Since(c.checkTime) < TLSAuthWatchInterval {
+		return nil
+	}
+	c.checkTime = time.Now()
 
-	// Limit HTTP requests per second.
-	RPS null.Int `json:"rps" envconfig:"rps"`
+	modTime, err := c.filesModTime()
+	if err != nil {
+		return err
+	}
+	if c.certificate != nil && !modTime.After(c.modTime) {
+		return nil
+	}
+	cert, modTime, err := c.load()
+	if err != nil {
+		return err
+	}
+	c.certificate, c.modTime = cert, modTime
+	return nil
+}
 
-	// How many HTTP redirects do we follow?
-	MaxRedirects null.Int `json:"maxRedirects" envconfig:"max_redirects"`
+// Returns the latest modification time of CertFile and KeyFile.
+func (c *TLSAuth) filesModTime() (time.Time, error) {
+	var modTime time.Time
+	for _, filename := range []string{c.CertFile, c.KeyFile} {
+		if filename == "" {
+			continue
+		}
+		info, err := os.Stat(filename)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime, nil
+}
 
-	// Default User Agent string for HTTP requests.
-	UserAgent null.String `json:"userAgent" envconfig:"user_agent"`
+// Parses the certificate and key, reading them from CertFile and KeyFile if those are set.
+func (c *TLSAuth) load() (*tls.Certificate, time.Time, error) {
+	modTime, err := c.filesModTime()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	certPEM, keyPEM := []byte(c.Cert), []byte(c.Key)
+	if c.CertFile != "" {
+		if certPEM, err = ioutil.ReadFile(c.CertFile); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	if c.KeyFile != "" {
+		if keyPEM, err = ioutil.ReadFile(c.KeyFile); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
 
-	// How many batch requests are allowed in parallel, in total and per host?
-	Batch        null.Int `json:"batch" envconfig:"batch"`
-	BatchPerHost null.Int `json:"batchPerHost" envconfig:"batch_per_host"`
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, time.Time{}, err
+	}
+	return &cert, modTime, nil
+}
 
-	// Should all HTTP requests and responses be logged (excluding body)?
-	HttpDebug null.String `json:"httpDebug" envconfig:"http_debug"`
+// Returns whether the certificate was issued by one of the given CAs, as listed by the server
+// in a CertificateRequest. An empty list means the server accepts any CA.
+func (c *TLSAuth) IssuedBy(acceptableCAs [][]byte) bool {
+	if len(acceptableCAs) == 0 {
+		return true
+	}
+	cert, err := c.Certificate()
+	if err != nil {
+		return false
+	}
+	for _, ca := range acceptableCAs {
+		if bytes.Equal(cert.Leaf.RawIssuer, ca) {
+			return true
+		}
+	}
+	return false
+}
 
-	// Accept invalid or untrusted TLS certificates.
-	InsecureSkipTLSVerify null.Bool `json:"insecureSkipTLSVerify" envconfig:"insecure_skip_tls_verify"`
+// Stands in for secrets in options that are printed or written out.
+const RedactedSecret = "<redacted>"
 
-	// Specify TLS versions and cipher suites, and present client certificates.
-	TLSCipherSuites *TLSCipherSuites `json:"tlsCipherSuites" envconfig:"tls_cipher_suites"`
-	TLSVersion      *TLSVersions     `json:"tlsVersion" envconfig:"tls_version"`
-	TLSAuth         []*TLSAuth       `json:"tlsAuth" envconfig:"tlsauth"`
+// Returns a copy of c with the inline certificate and key, if any, replaced by RedactedSecret.
+// Paths to files are kept, since they're no secret.
+func (c *TLSAuth) Redacted() *TLSAuth {
+	fields := c.TLSAuthFields
+	if fields.Cert != "" {
+		fields.Cert = RedactedSecret
+	}
+	if fields.Key != "" {
+		fields.Key = RedactedSecret
+	}
+	return &TLSAuth{TLSAuthFields: fields}
+}
 
-	// Throw warnings (eg. failed HTTP requests) as errors instead of simply logging them.
-	Throw null.Bool `json:"throw" envconfig:"throw"`
+// Returns a function for tls.Config.GetClientCertificate, which presents the first client
+// certificate that was issued by one of the CAs the server asks for. If the server doesn't list
+// any, the first certificate is used; if none match, no certificate is sent. With watch, any
+// certificates loaded from files are reloaded when the files change.
+//
+// The handshake doesn't say which host it's for, so auths should already be narrowed down to
+// the ones for the host being connected to, eg. by Options.TLSAuthFor().
+func GetClientCertificateFunc(auths []*TLSAuth, watch bool) func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return func(req *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		for _, auth := range auths {
+			if watch {
+				// Keep using the old certificate if the new one can't be loaded (yet).
+				_ = auth.Reload()
+			}
+			if auth.IssuedBy(req.AcceptableCAs) {
+				return auth.Certificate()
+			}
+		}
+		return &tls.Certificate{}, nil
+	}
+}
 
-	// Define thresholds; these take the form of 'metric=["snippet1", "snippet2"]'.
-	// To create a threshold on a derived metric based on tag queries ("submetrics"), create a
-	// metric on a nonexistent metric named 'real_metric{tagA:valueA,tagB:valueB}'.
-	Thresholds map[string]stats.Thresholds `json:"thresholds" envconfig:"thresholds"`
+// Distributions for VUStartJitter delays.
+const (
+	JitterUniform     = "uniform"
+	JitterExponential = "exponential"
+)
 
-	// Blacklist IP ranges that tests may not contact. Mainly useful in hosted setups.
-	BlacklistIPs []*net.IPNet `json:"blacklistIPs" envconfig:"blacklist_ips"`
+// Fields for VUStartJitter. Unmarshalling hack.
+type VUStartJitterFields struct {
+	// Distribution of the delays; "uniform" (default) or "exponential".
+	Distribution string `json:"distribution"`
 
-	// Hosts overrides dns entries for given hosts
-	Hosts map[string]net.IP `json:"hosts" envconfig:"hosts"`
+	// Upper bound for uniform delays, or the mean delay for exponential ones.
+	Duration Duration `json:"duration"`
 
-	// Do not reuse connections between VU iterations. This gives more realistic results (depending
-	// on what you're looking for), but you need to raise various kernel limits or you'll get
-	// errors about running out of file handles or sockets, or being unable to bind addresses.
-	NoConnectionReuse null.Bool `json:"noConnectionReuse" envconfig:"no_connection_reuse"`
+	// Seed for the random number generator, for reproducible runs. 0 = seed from the clock.
+	Seed int64 `json:"seed"`
+}
 
-	// These values are for third party collectors' benefit.
-	// Can't be set through env vars.
-	External map[string]interface{} `json:"ext" ignored:"true"`
+// Describes a random delay applied to each VU as it's started, to spread out VU arrivals
+// rather than having every VU added by a ramp start in lockstep.
+type VUStartJitter VUStartJitterFields
 
-	// Summary trend stats for trend metrics (response times) in CLI output
-	SummaryTrendStats []string `json:"SummaryTrendStats" envconfig:"summary_trend_stats"`
+func (j *VUStartJitter) UnmarshalJSON(data []byte) error {
+	var fields VUStartJitterFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	switch fields.Distribution {
+	case "", JitterUniform, JitterExponential:
+	default:
+		return errors.Errorf("unknown jitter distribution: %s", fields.Distribution)
+	}
+	if fields.Duration < 0 {
+		return errors.New("jitter duration can't be negative")
+	}
+	*j = VUStartJitter(fields)
+	return nil
 }
 
-// Returns the result of overwriting any fields with any that are set on the argument.
-//
-// Example:
-//   a := Options{VUs: null.IntFrom(10), VUsMax: null.IntFrom(10)}
-//   b := Options{VUs: null.IntFrom(5)}
-//   a.Apply(b) // Options{VUs: null.IntFrom(5), VUsMax: null.IntFrom(10)}
-func (o Options) Apply(opts Options) Options {
-	if opts.Paused.Valid {
-		o.Paused = opts.Paused
+// Returns a new random number generator seeded according to the jitter's Seed.
+func (j VUStartJitter) NewRand() *rand.Rand {
+	seed := j.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
 	}
-	if opts.VUs.Valid {
-		o.VUs = opts.VUs
+	return rand.New(rand.NewSource(seed))
+}
+
+// Returns a random delay drawn from the jitter's distribution.
+func (j VUStartJitter) Delay(r *rand.Rand) time.Duration {
+	switch j.Distribution {
+	case JitterExponential:
+		return time.Duration(r.ExpFloat64() * float64(j.Duration))
+	default:
+		return time.Duration(r.Float64() * float64(j.Duration))
 	}
-	if opts.VUsMax.Valid {
-		o.VUsMax = opts.VUsMax
+}
+
+// Distributions for ThinkTime delays.
+const (
+	ThinkTimeUniform     = "uniform"
+	ThinkTimeNormal      = "normal"
+	ThinkTimeExponential = "exponential"
+)
+
+// Fields for ThinkTime. Unmarshalling hack.
+type ThinkTimeFields struct {
+	// Distribution of the delays; "uniform" (default), "normal" or "exponential".
+	Distribution string `json:"distribution"`
+
+	// Bounds for uniform delays. Normal and exponential delays are clamped to at least Min,
+	// and to at most Max if it's set.
+	Min Duration `json:"min"`
+	Max Duration `json:"max"`
+
+	// Mean delay for normal and exponential delays, and the standard deviation for normal ones.
+	Mean   Duration `json:"mean"`
+	StdDev Duration `json:"stdDev"`
+
+	// Seed for the random number generator, for reproducible runs. 0 = seed from the clock.
+	Seed int64 `json:"seed"`
+}
+
+// Describes a random pause each VU takes between iterations, to model human pacing.
+type ThinkTime ThinkTimeFields
+
+func (t *ThinkTime) UnmarshalJSON(data []byte) error {
+	var fields ThinkTimeFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
 	}
-	if opts.Duration.Valid {
-		o.Duration = opts.Duration
+	switch fields.Distribution {
+	case "", ThinkTimeUniform, ThinkTimeNormal, ThinkTimeExponential:
+	default:
+		return errors.Errorf("unknown think time distribution: %s", fields.Distribution)
 	}
-	if opts.Iterations.Valid {
-		o.Iterations = opts.Iterations
+	if fields.Min < 0 || fields.Max < 0 || fields.Mean < 0 || fields.StdDev < 0 {
+		return errors.New("think time parameters can't be negative")
 	}
-	if opts.Stages != nil {
-		o.Stages = opts.Stages
+	if fields.Max > 0 && fields.Max < fields.Min {
+		return errors.New("think time max can't be less than min")
 	}
-	if opts.RPS.Valid {
-		o.RPS = opts.RPS
+	*t = ThinkTime(fields)
+	return nil
+}
+
+// Returns a new random number generator seeded according to the think time's Seed.
+func (t ThinkTime) NewRand() *rand.Rand {
+	seed := t.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
 	}
-	if opts.MaxRedirects.Valid {
-		o.MaxRedirects = opts.MaxRedirects
+	return rand.New(rand.NewSource(seed))
+}
+
+// Returns a random delay drawn from the think time's distribution.
+func (t ThinkTime) Delay(r *rand.Rand) time.Duration {
+	var d time.Duration
+	switch t.Distribution {
+	case ThinkTimeNormal:
+		d = time.Duration(r.NormFloat64()*float64(t.StdDev) + float64(t.Mean))
+	case ThinkTimeExponential:
+		d = time.Duration(r.ExpFloat64() * float64(t.Mean))
+	default:
+		return time.Duration(t.Min) + time.Duration(r.Float64()*float64(t.Max-t.Min))
 	}
-	if opts.UserAgent.Valid {
-		o.UserAgent = opts.UserAgent
+	if d < time.Duration(t.Min) {
+		d = time.Duration(t.Min)
 	}
-	if opts.Batch.Valid {
-		o.Batch = opts.Batch
+	if t.Max > 0 && d > time.Duration(t.Max) {
+		d = time.Duration(t.Max)
 	}
-	if opts.BatchPerHost.Valid {
-		o.BatchPerHost = opts.BatchPerHost
+	return d
+}
+
+// Returns the average delay drawn from the think time's distribution, disregarding the clamping
+// of normal and exponential delays to Min and Max.
+func (t ThinkTime) MeanDelay() time.Duration {
+	switch t.Distribution {
+	case ThinkTimeNormal, ThinkTimeExponential:
+		return time.Duration(t.Mean)
+	default:
+		return time.Duration(t.Min+t.Max) / 2
 	}
-	if opts.HttpDebug.Valid {
-		o.HttpDebug = opts.HttpDebug
+}
+
+// Fields for NetworkConditions. Unmarshalling hack.
+type NetworkConditionsFields struct {
+	// Fixed delay added to every write, plus a random extra delay of up to Jitter.
+	Latency Duration `json:"latency"`
+	Jitter  Duration `json:"jitter"`
+
+	// Probability, from 0 to 1 (exclusive), that a write is lost and has to be retransmitted;
+	// a write is retransmitted at most 15 times, with the timeout doubling up to 60s.
+	Loss float64 `json:"loss"`
+
+	// Seed for each connection's random number generator, for reproducible runs. 0 = seed from
+	// the clock.
+	Seed int64 `json:"seed"`
+}
+
+// Describes degraded network conditions to simulate on every connection k6 opens.
+type NetworkConditions NetworkConditionsFields
+
+func (c *NetworkConditions) UnmarshalJSON(data []byte) error {
+	var fields NetworkConditionsFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
 	}
-	if opts.InsecureSkipTLSVerify.Valid {
-		o.InsecureSkipTLSVerify = opts.InsecureSkipTLSVerify
+	if fields.Latency < 0 || fields.Jitter < 0 {
+		return errors.New("network latency and jitter can't be negative")
 	}
-	if opts.TLSCipherSuites != nil {
-		o.TLSCipherSuites = opts.TLSCipherSuites
+	if fields.Loss < 0 || fields.Loss >= 1 {
+		return errors.Errorf("network loss must be at least 0 and less than 1, not %v", fields.Loss)
 	}
-	if opts.TLSVersion != nil {
-		o.TLSVersion = opts.TLSVersion
+	*c = NetworkConditions(fields)
+	return nil
+}
+
+// Replaces the "name" tag of requests whose URL matches Match with Name, to group URLs that
+// only differ by IDs and the like, eg. {"match": "^https://example.com/users/\\d+$",
+// "name": "https://example.com/users/{id}"}.
+type TagRule struct {
+	Match *regexp.Regexp
+	Name  string
+}
+
+type tagRuleJSON struct {
+	Match string `json:"match"`
+	Name  string `json:"name"`
+}
+
+func (r TagRule) MarshalJSON() ([]byte, error) {
+	var match string
+	if r.Match != nil {
+		match = r.Match.String()
 	}
-	if opts.TLSAuth != nil {
-		o.TLSAuth = opts.TLSAuth
+	return json.Marshal(tagRuleJSON{match, r.Name})
+}
+
+func (r *TagRule) UnmarshalJSON(data []byte) error {
+	var raw tagRuleJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
 	}
-	if opts.Throw.Valid {
-		o.Throw = opts.Throw
+	re, err := regexp.Compile(raw.Match)
+	if err != nil {
+		return errors.Errorf("invalid tag rule pattern %q: %s", raw.Match, err)
 	}
-	if opts.Thresholds != nil {
-		o.Thresholds = opts.Thresholds
+	if raw.Name == "" {
+		return errors.Errorf("tag rule %q has no name", raw.Match)
 	}
-	if opts.BlacklistIPs != nil {
-		o.BlacklistIPs = opts.BlacklistIPs
+	*r = TagRule{re, raw.Name}
+	return nil
+}
+
+// Validates the JSON bodies of responses to requests whose URL matches Match against Schema, eg.
+// {"match": "^https://example.com/users/\\d+$", "schema": {"type": "object", "required": ["id"]}}.
+type ResponseSchema struct {
+	Match  *regexp.Regexp
+	Schema *JSONSchema
+}
+
+type responseSchemaJSON struct {
+	Match  string      `json:"match"`
+	Schema *JSONSchema `json:"schema"`
+}
+
+func (r ResponseSchema) MarshalJSON() ([]byte, error) {
+	var match string
+	if r.Match != nil {
+		match = r.Match.String()
 	}
-	if opts.Hosts != nil {
-		o.Hosts = opts.Hosts
+	return json.Marshal(responseSchemaJSON{match, r.Schema})
+}
+
+func (r *ResponseSchema) UnmarshalJSON(data []byte) error {
+	var raw responseSchemaJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
 	}
-	if opts.NoConnectionReuse.Valid {
-		o.NoConnectionReuse = opts.NoConnectionReuse
+	re, err := regexp.Compile(raw.Match)
+	if err != nil {
+		return errors.Errorf("invalid response schema pattern %q: %s", raw.Match, err)
 	}
-	if opts.External != nil {
-		o.External = opts.External
+	if raw.Schema == nil {
+		return errors.Errorf("response schema %q has no schema", raw.Match)
 	}
-	if opts.SummaryTrendStats != nil {
-		o.SummaryTrendStats = opts.SummaryTrendStats
+	*r = ResponseSchema{re, raw.Schema}
+	return nil
+}
+
+// Propagation formats for Tracing headers.
+const (
+	TracingW3C = "w3c"
+	TracingB3  = "b3"
+)
+
+// Fields for Tracing. Unmarshalling hack.
+type TracingFields struct {
+	// Header formats to inject; "w3c" (traceparent/tracestate) and/or "b3". Default: ["w3c"].
+	Propagators []string `json:"propagators"`
+
+	// Fraction of traces to mark as sampled, from 0 to 1. Default: 1 when unmarshalled.
+	Sampling float64 `json:"sampling"`
+
+	// Static tracestate header value to send along with traceparent, if any.
+	TraceState string `json:"traceState"`
+
+	// Tag the metrics of sampled requests with their trace IDs, as "trace_id".
+	TagMetrics bool `json:"tagMetrics"`
+
+	// Attach the trace IDs of sampled requests to their trend samples as exemplars, which outputs
+	// record alongside the value rather than as a tag, eg. to jump from slow requests to traces.
+	Exemplars bool `json:"exemplars"`
+}
+
+// Injects distributed tracing headers with freshly generated trace IDs into every HTTP request.
+type Tracing TracingFields
+
+func (t *Tracing) UnmarshalJSON(data []byte) error {
+	fields := TracingFields{Sampling: 1}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for _, p := range fields.Propagators {
+		if p != TracingW3C && p != TracingB3 {
+			return errors.Errorf("unknown tracing propagator: %s", p)
+		}
+	}
+	if fields.Sampling < 0 || fields.Sampling > 1 {
+		return errors.Errorf("tracing sampling must be between 0 and 1, not %g", fields.Sampling)
+	}
+	*t = Tracing(fields)
+	return nil
+}
+
+// Algorithms for RequestSigning.
+const (
+	RequestSigningAWSSigV4 = "aws-sigv4"
+)
+
+// Credential sources for RequestSigning.
+const (
+	// The AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and (optional) AWS_SESSION_TOKEN env vars.
+	RequestSigningCredentialsEnv = "env"
+)
+
+// Fields for RequestSigning. Unmarshalling hack.
+type RequestSigningFields struct {
+	// Signing algorithm; currently only "aws-sigv4".
+	Algorithm string `json:"algorithm"`
+
+	// Where credentials are read from when signing; "env" by default. Credentials themselves are
+	// never part of the options, so they can't end up in archives.
+	Credentials string `json:"credentials"`
+
+	// Region and service to sign requests for, eg. "us-east-1" and "execute-api".
+	Region  string `json:"region"`
+	Service string `json:"service"`
+
+	// Only sign requests to these hosts, by host pattern (see MatchHost); all by default.
+	Hosts []string `json:"hosts"`
+}
+
+// Signs outgoing HTTP requests, eg. with AWS Signature Version 4.
+type RequestSigning RequestSigningFields
+
+func (s *RequestSigning) UnmarshalJSON(data []byte) error {
+	var fields RequestSigningFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if fields.Algorithm != RequestSigningAWSSigV4 {
+		return errors.Errorf("unknown request signing algorithm: %s", fields.Algorithm)
+	}
+	switch fields.Credentials {
+	case "", RequestSigningCredentialsEnv:
+	default:
+		return errors.Errorf("unknown request signing credentials source: %s", fields.Credentials)
+	}
+	if fields.Region == "" || fields.Service == "" {
+		return errors.New("request signing needs a region and a service")
+	}
+	*s = RequestSigning(fields)
+	return nil
+}
+
+// Fields for HARExport. Unmarshalling hack.
+type HARExportFields struct {
+	// File to write the HAR to at the end of the test.
+	Path string `json:"path"`
+
+	// Fraction of requests to record, from 0 to 1. Default: 1 when unmarshalled.
+	Sampling float64 `json:"sampling"`
+
+	// Record request and response bodies as well, as text.
+	IncludeBodies bool `json:"includeBodies"`
+
+	// Stop recording once the entries take up roughly this many bytes, since they're held in
+	// memory until the end of the test; 0 = DefaultHARMaxBytes. Requests that don't fit are
+	// counted and logged, but left out of the HAR.
+	MaxBytes int64 `json:"maxBytes"`
+}
+
+// How much HAR data is kept by default; see HARExport.MaxBytes.
+const DefaultHARMaxBytes = 100 << 20
+
+// Records HTTP requests and responses, with their headers and timings, to a HAR file.
+type HARExport HARExportFields
+
+func (h *HARExport) UnmarshalJSON(data []byte) error {
+	fields := HARExportFields{Sampling: 1}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if fields.Path == "" {
+		return errors.New("harExport needs a path")
+	}
+	if fields.Sampling < 0 || fields.Sampling > 1 {
+		return errors.Errorf("harExport sampling must be between 0 and 1, not %g", fields.Sampling)
+	}
+	if fields.MaxBytes < 0 {
+		return errors.New("harExport maxBytes can't be negative")
+	}
+	*h = HARExport(fields)
+	return nil
+}
+
+// Precisions for sample timestamps.
+const (
+	TimestampPrecisionNanoseconds  = "ns"
+	TimestampPrecisionMicroseconds = "us"
+	TimestampPrecisionMilliseconds = "ms"
+)
+
+// Returns the resolution sample timestamps are truncated to for the given precision.
+func ParseTimestampPrecision(s string) (time.Duration, error) {
+	switch s {
+	case TimestampPrecisionNanoseconds:
+		return time.Nanosecond, nil
+	case TimestampPrecisionMicroseconds:
+		return time.Microsecond, nil
+	case TimestampPrecisionMilliseconds:
+		return time.Millisecond, nil
+	default:
+		return 0, errors.Errorf("unknown timestamp precision: %s", s)
+	}
+}
+
+// Metric name prefixes may contain letters, digits and underscores, and can't start with a digit.
+var metricPrefixRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// A parsed threshold from Options.Thresholds, for introspection.
+type ThresholdDefinition struct {
+	// The key the threshold was defined under, eg. "http_req_duration{status:200}", and the
+	// metric it applies to, eg. "http_req_duration".
+	Name   string `json:"name"`
+	Metric string `json:"metric"`
+
+	// Tags a sample must have to count towards the threshold, for submetrics.
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// The raw source, eg. "p(95)<500".
+	Source string `json:"source"`
+
+	// For simple sources, the aggregation (eg. "p(95)", "avg"), operator and value compared
+	// against. Expression is set instead for sources that can only be evaluated as JS.
+	Aggregation string  `json:"aggregation,omitempty"`
+	Operator    string  `json:"operator,omitempty"`
+	Value       float64 `json:"value,omitempty"`
+	Expression  bool    `json:"expression,omitempty"`
+}
+
+// Returns the thresholds in the options broken down into their parts, sorted by name.
+func (o Options) ThresholdDefinitions() []ThresholdDefinition {
+	names := make([]string, 0, len(o.Thresholds))
+	for name := range o.Thresholds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var defs []ThresholdDefinition
+	for _, name := range names {
+		parent, sm := stats.NewSubmetric(name)
+		for _, th := range o.Thresholds[name].Thresholds {
+			def := ThresholdDefinition{Name: name, Metric: parent, Tags: sm.Tags, Source: th.Source}
+			if cond, ok := stats.ParseThresholdCondition(th.Source); ok {
+				def.Aggregation, def.Operator, def.Value = cond.Aggregation, cond.Operator, cond.Value
+			} else {
+				def.Expression = true
+			}
+			defs = append(defs, def)
+		}
+	}
+	return defs
+}
+
+// The outcome of a threshold at the end of a test, as written out for the thresholdResults option.
+type ThresholdResult struct {
+	ThresholdDefinition
+
+	// Whether the threshold holds at the end of the test, and for simple sources, the value its
+	// aggregation was compared against the limit with; nil if the metric got no samples.
+	Passed bool     `json:"passed"`
+	Actual *float64 `json:"actual"`
+}
+
+// Evaluates thresholds against the metrics they were last run on, by the engine. Thresholds of
+// metrics that never got any samples pass, like they do when deciding the exit code.
+func ThresholdResults(defs []ThresholdDefinition, metrics map[string]*stats.Metric) []ThresholdResult {
+	results := make([]ThresholdResult, 0, len(defs))
+	index := make(map[string]int)
+	for _, def := range defs {
+		i := index[def.Name]
+		index[def.Name]++
+
+		result := ThresholdResult{ThresholdDefinition: def, Passed: true}
+		if m, ok := metrics[def.Name]; ok && i < len(m.Thresholds.Thresholds) {
+			passed, err := m.Thresholds.Thresholds[i].RunNoTaint()
+			result.Passed = passed && err == nil
+			if !def.Expression {
+				if v, err := m.Thresholds.Value(def.Aggregation); err == nil {
+					result.Actual = &v
+				}
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Metrics broken down by stage in the end-of-test summary if SummaryPerStage is enabled.
+var StageSummaryMetrics = []string{"http_reqs", "http_req_duration"}
+
+// Returns the name of a metric's submetric for the given stage, eg. "http_reqs{stage:0}".
+func StageSubmetricName(metric string, stage int) string {
+	return metric + "{stage:" + strconv.Itoa(stage) + "}"
+}
+
+// Shuffles the order of stages, for chaos testing; see Options.StageShuffle.
+type StageShuffle struct {
+	// Seed for the random number generator, for reproducible runs. 0 = seed from the clock.
+	Seed int64 `json:"seed"`
+
+	// Keep the first or last stage in place, eg. to always start with a ramp-up from zero.
+	PinFirst bool `json:"pinFirst"`
+	PinLast  bool `json:"pinLast"`
+}
+
+// Returns a new random number generator seeded according to the shuffle's Seed.
+func (s StageShuffle) NewRand() *rand.Rand {
+	seed := s.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+// Returns a shuffled copy of the given stages, leaving pinned ones in place.
+func (s StageShuffle) Shuffle(stages []Stage) []Stage {
+	shuffled := make([]Stage, len(stages))
+	copy(shuffled, stages)
+
+	start, end := 0, len(shuffled)
+	if s.PinFirst && start < end {
+		start++
+	}
+	if s.PinLast && start < end {
+		end--
+	}
+	r := s.NewRand()
+	for i := end - 1; i > start; i-- {
+		j := start + r.Intn(i-start+1)
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	}
+	return shuffled
+}
+
+// Returns the names of thresholds whose metrics aren't among the given ones, eg. because the
+// script never emitted them, sorted and without duplicates.
+func UnknownThresholdMetrics(defs []ThresholdDefinition, metrics map[string]*stats.Metric) []string {
+	var names []string
+	for _, def := range defs {
+		if _, ok := metrics[def.Metric]; ok {
+			continue
+		}
+		if len(names) == 0 || names[len(names)-1] != def.Name {
+			names = append(names, def.Name)
+		}
+	}
+	return names
+}
+
+// Returns an error if the given string isn't a valid APIAddress. An empty one is allowed, and
+// disables the API.
+func ValidateAPIAddress(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return errors.Errorf("invalid API address: %q", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return errors.Errorf("invalid API address: %q", addr)
+	}
+	return nil
+}
+
+// Returns an error if the given string can't be used to prefix metric names.
+func ValidateMetricPrefix(prefix string) error {
+	if !metricPrefixRegexp.MatchString(prefix) {
+		return errors.Errorf("invalid metric prefix: %q", prefix)
+	}
+	return nil
+}
+
+var variantRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// Returns an error if the given string can't be used as a Variant.
+func ValidateVariant(variant string) error {
+	if !variantRegexp.MatchString(variant) {
+		return errors.Errorf("invalid variant: %q", variant)
+	}
+	return nil
+}
+
+type Options struct {
+	// Should the test start in a paused state?
+	Paused null.Bool `json:"paused" envconfig:"paused"`
+
+	// Pause and resume the test each time k6 receives SIGUSR1, eg. to hold the load steady while
+	// something external happens. VUs are kept as they are, and the test's clock stops while it's
+	// paused. Not supported on Windows.
+	PauseSignal null.Bool `json:"pauseSignal" envconfig:"pause_signal"`
+
+	// Validate the script and options and initialize the VUs, then exit without running any
+	// iterations, and so without generating any load.
+	DryRun null.Bool `json:"dryRun" envconfig:"dry_run"`
+
+	// Address for the REST API (for pausing, scaling, etc.) to listen on, as "host:port". An empty
+	// string disables the API. The global --address flag takes precedence if given.
+	APIAddress null.String `json:"apiAddress" envconfig:"api_address"`
+
+	// Initial values for VUs, max VUs, duration cap, iteration cap, and stages.
+	// See the Runner or Executor interfaces for more information.
+	VUs        null.Int     `json:"vus" envconfig:"vus"`
+	VUsMax     null.Int     `json:"vusMax" envconfig:"vus_max"`
+	Duration   NullDuration `json:"duration" envconfig:"duration"`
+	Iterations null.Int     `json:"iterations" envconfig:"iterations"`
+	Stages     []Stage      `json:"stages" envconfig:"stages"`
+
+	// What to do about stages that target more VUs than VUsMax: "clamp" holds the VUs to VUsMax,
+	// with a warning, and "error" (default) rejects the stages before the test starts; see
+	// Validate().
+	VUsMaxPolicy null.String `json:"vusMaxPolicy" envconfig:"vus_max_policy"`
+
+	// Stop the test at this wall-clock time, if it hasn't ended already due to Duration,
+	// Iterations or Stages. Time spent paused counts towards it.
+	EndTime NullTime `json:"endTime" envconfig:"end_time"`
+
+	// Read stages from a CSV file with one "duration,target" row per stage, rather than listing
+	// them inline. Relative paths are resolved against the config file they're specified in.
+	StagesFile null.String `json:"stagesFile" envconfig:"stages_file"`
+
+	// Run the stages in a random order, eg. to test autoscalers against load that doesn't only
+	// ramp one way. The seed used is logged, and can be set to repeat an order. Can't be set
+	// through env vars.
+	StageShuffle *StageShuffle `json:"stageShuffle" ignored:"true"`
+
+	// Number of VUs to initialise before the test starts; the rest, up to VUsMax, are only
+	// initialised once they're first needed. Unset = all of them. Can't exceed VUsMax.
+	// Initialising a VU compiles its own copy of the script, and VU counts can't change while
+	// that's going on, so a big script can hold up stages ramping past the preallocated ones.
+	PreAllocatedVUs null.Int `json:"preAllocatedVUs" envconfig:"pre_allocated_vus"`
+
+	// How many VUs may be initialised at the same time when they're preallocated; the rest wait
+	// their turn. Unset = 1, one after the other. Raising it speeds up the start of large tests
+	// on machines with cores to spare, at the cost of a bigger CPU and memory spike.
+	VUInitConcurrency null.Int `json:"vuInitConcurrency" envconfig:"vu_init_concurrency"`
+
+	// Keep an eye on memory use, in bytes of heap and stacks in use, and act according to the
+	// MemoryLimitPolicy when it goes over this limit: "shed-vus" (the default) caps the active VUs
+	// at 10% below the current count, "warn" only logs and emits memory_limit_exceeded, and "abort"
+	// ends the test. Policies act at most once every 10s, to give memory use time to drop. Shed VUs
+	// are given back 10% at a time once memory use is 10% under the limit.
+	MemoryLimit       null.Int    `json:"memoryLimit" envconfig:"memory_limit"`
+	MemoryLimitPolicy null.String `json:"memoryLimitPolicy" envconfig:"memory_limit_policy"`
+
+	// Values substituted for "{{data}}" in string request bodies, cycling through them by
+	// iteration number, so similar requests can vary without building bodies in the script.
+	// BodyDataFile reads them from a file instead, one value per line; relative paths are
+	// resolved the same way as for StagesFile.
+	BodyData     []string    `json:"bodyData" envconfig:"body_data"`
+	BodyDataFile null.String `json:"bodyDataFile" envconfig:"body_data_file"`
+
+	// File to stream as the body of POST, PUT and PATCH requests sent without one, like the
+	// bodyFile request param, instead of reading it into memory; eg. for large uploads. Relative
+	// paths are resolved the same way as for StagesFile. Can't be used with RequestSigning.
+	BodyFile null.String `json:"bodyFile" envconfig:"body_file"`
+
+	// Rows of metadata to tag each iteration's metrics with, cycling through them by iteration
+	// number like BodyData, eg. [{"customer_tier": "gold"}]. Only the fields named in TagDataTags
+	// become tags, to keep their cardinality in check, and tags set by the script take priority.
+	// TagDataFile reads the rows from a CSV file with a header row instead, resolved the same way
+	// as StagesFile. Can't be set through env vars.
+	TagData     []map[string]string `json:"tagData" ignored:"true"`
+	TagDataFile null.String         `json:"tagDataFile" envconfig:"tag_data_file"`
+	TagDataTags []string            `json:"tagDataTags" envconfig:"tag_data_tags"`
+
+	// Recorded requests to replay at their offsets instead of running the script's default
+	// function; each VU iteration sends the next one that's due, so there need to be enough VUs
+	// to keep up. ReplayFile reads them from a file (see ReadReplaySchedule), with relative paths
+	// resolved the same way as for StagesFile. Replay can't be set through env vars.
+	Replay     []ReplayRequest `json:"replay" ignored:"true"`
+	ReplayFile null.String     `json:"replayFile" envconfig:"replay_file"`
+
+	// Let up to this many samples queue up between VUs and the engine, rather than having VUs
+	// wait for each batch to be processed. When the queue is full, inFlightSamplesPolicy either
+	// makes VUs wait ("block", the default) or drops samples no threshold depends on ("drop"),
+	// counting them in the dropped_samples metric.
+	MaxInFlightSamples    null.Int    `json:"maxInFlightSamples" envconfig:"max_in_flight_samples"`
+	InFlightSamplesPolicy null.String `json:"inFlightSamplesPolicy" envconfig:"in_flight_samples_policy"`
+
+	// Stop each VU after it has run this many iterations, while other VUs keep going. The test
+	// still ends as soon as Iterations or Duration is reached, whichever comes first; without
+	// stages, it also ends once every VU has hit this limit.
+	MaxIterationsPerVU null.Int `json:"maxIterationsPerVU" envconfig:"max_iterations_per_vu"`
+
+	// Spread Iterations evenly over Duration, rather than running them as fast as the VUs can:
+	// iteration n doesn't start before n*Duration/Iterations, on whichever VU is free. The test
+	// still ends at Duration if the VUs can't keep up. Needs both Iterations and Duration.
+	PaceIterations null.Bool `json:"paceIterations" envconfig:"pace_iterations"`
+
+	// How VUs react to the test being stopped: "immediate" (the default) aborts whatever they're
+	// doing, "finish-request" lets an in-flight request complete first. Either way, samples
+	// already collected by a VU are flushed; with "finish-request", this includes ones collected
+	// after the test was stopped.
+	VUCancellation null.String `json:"vuCancellation" envconfig:"vu_cancellation"`
+
+	// Samples collected during this initial warmup period are tagged with "warmup": "true" and
+	// passed on to collectors, but are left out of thresholds and the end-of-test summary.
+	WarmupDuration NullDuration `json:"warmupDuration" envconfig:"warmup_duration"`
+
+	// Have each VU call an exported function and/or send a list of requests once, before its
+	// first iteration, without counting any of it in metrics; the iteration duration and data
+	// sent and received don't include it either. Can't be set through env vars.
+	VUWarmup *VUWarmup `json:"vuWarmup" ignored:"true"`
+
+	// Delay each VU's start by a random amount, to better approximate random arrivals.
+	// Can't be set through env vars.
+	VUStartJitter *VUStartJitter `json:"vuStartJitter" ignored:"true"`
+
+	// Pause each VU for a random amount of time between iterations.
+	// Can't be set through env vars.
+	ThinkTime *ThinkTime `json:"thinkTime" ignored:"true"`
+
+	// Simulate added latency and packet loss on every connection.
+	// Can't be set through env vars.
+	NetworkConditions *NetworkConditions `json:"networkConditions" ignored:"true"`
+
+	// Limit the number of CPUs (GOMAXPROCS) k6 can use at the same time. 0 or unset = all of them.
+	MaxCPUs null.Int `json:"maxCPUs" envconfig:"max_cpus"`
+
+	// Limit HTTP requests per second.
+	RPS null.Int `json:"rps" envconfig:"rps"`
+
+	// Limit HTTP requests per second for each VU on its own, like a client that throttles itself.
+	// If RPS is set as well, both apply; a request waits for its VU's limit first, then for the
+	// global one.
+	RPSPerVU null.Int `json:"rpsPerVU" envconfig:"rps_per_vu"`
+
+	// Limit HTTP requests per second for specific methods, eg. {"GET": 1000, "POST": 100}, on
+	// top of the global RPS limit. Method names are case insensitive.
+	MethodRPS map[string]int64 `json:"methodRPS" envconfig:"method_rps"`
+
+	// Limit how many new connections are opened per second, across all VUs. Requests that need
+	// a new connection wait for their turn.
+	ConnRatePerSec null.Int `json:"connRatePerSec" envconfig:"conn_rate_per_sec"`
+
+	// Emit how long each request waited for the rps, rpsPerVU and methodRPS limits as the
+	// http_req_limiter_waiting trend, to tell throttling by the test itself apart from a slow
+	// target. Only requests subject to a limit get one.
+	LimiterWaitMetric null.Bool `json:"limiterWaitMetric" envconfig:"limiter_wait_metric"`
+
+	// Only use local ports in this range for outbound connections, eg. "40000-40999", for
+	// firewalls that only allow some source ports, or to spread connections over a known range.
+	// Ports are handed out in turn, skipping ones that are still in use.
+	LocalPortRange null.String `json:"localPortRange" envconfig:"local_port_range"`
+
+	// Name of the network interface (eg. "eth1") to make TCP connections from, using whichever of
+	// its addresses is of the same family as the remote one. Its addresses are looked up once,
+	// when the test starts.
+	BindInterface null.String `json:"bindInterface" envconfig:"bind_interface"`
+
+	// After each request, emit how many connections to its host are in use and how many are idle,
+	// counted across all VUs, as the http_conns_active and http_conns_idle gauges.
+	ConnPoolMetrics null.Bool `json:"connPoolMetrics" envconfig:"conn_pool_metrics"`
+
+	// Record whether each request reused a kept-alive connection in http_req_conn_reused, tagged
+	// with the request's host, and break it down by host in the end-of-test summary. Past 100
+	// hosts, or the host tag's TagCardinalityLimits entry, the rest share one "__other__" line.
+	ConnReuseMetrics null.Bool `json:"connReuseMetrics" envconfig:"conn_reuse_metrics"`
+
+	// How many HTTP redirects do we follow?
+	MaxRedirects null.Int `json:"maxRedirects" envconfig:"max_redirects"`
+
+	// Inject distributed tracing headers into HTTP requests.
+	// Can't be set through env vars.
+	Tracing *Tracing `json:"tracing" ignored:"true"`
+
+	// Record HTTP traffic to a HAR file; see HARExport.
+	// Can't be set through env vars.
+	HARExport *HARExport `json:"harExport" ignored:"true"`
+
+	// Sign HTTP requests, eg. for AWS APIs; see RequestSigning.
+	// Can't be set through env vars.
+	RequestSigning *RequestSigning `json:"requestSigning" ignored:"true"`
+
+	// Restrict which redirects are followed, eg. ["https://*.example.com", "example.org"]. Each
+	// entry is a host pattern (see MatchHost), optionally prefixed with a scheme; a bare scheme
+	// such as "https://" allows any host. If unset, any redirect is followed.
+	RedirectAllowlist []string `json:"redirectAllowlist" envconfig:"redirect_allowlist"`
+
+	// Keep sending Authorization and other sensitive headers when following redirects to other
+	// hosts, which strips them by default. Cookie headers are only kept if no cookie jar is in
+	// use; otherwise the jar decides which cookies the new host gets.
+	RedirectSensitiveHeaders null.Bool `json:"redirectSensitiveHeaders" envconfig:"redirect_sensitive_headers"`
+
+	// Restrict which hosts cookie jars store and send cookies for, by host pattern (see
+	// MatchHost). If CookieJarHosts is set, only matching hosts use the jar; hosts matching
+	// CookieJarExcludeHosts never do. Cookies given in a request's params are still sent.
+	CookieJarHosts        []string `json:"cookieJarHosts" envconfig:"cookie_jar_hosts"`
+	CookieJarExcludeHosts []string `json:"cookieJarExcludeHosts" envconfig:"cookie_jar_exclude_hosts"`
+
+	// Default User Agent string for HTTP requests.
+	UserAgent null.String `json:"userAgent" envconfig:"user_agent"`
+
+	// User Agent strings for specific hosts, by host pattern (see MatchHost), overriding UserAgent.
+	HostUserAgents map[string]string `json:"hostUserAgents" envconfig:"host_user_agents"`
+
+	// How many batch requests are allowed in parallel, in total and per host?
+	Batch        null.Int `json:"batch" envconfig:"batch"`
+	BatchPerHost null.Int `json:"batchPerHost" envconfig:"batch_per_host"`
+
+	// Should all HTTP requests and responses be logged (excluding body)?
+	HttpDebug null.String `json:"httpDebug" envconfig:"http_debug"`
+
+	// Keep the raw body of compressed responses around as compressed_body, next to the decompressed
+	// body, and record its size in the http_resp_compressed_size metric.
+	KeepCompressedBody null.Bool `json:"keepCompressedBody" envconfig:"keep_compressed_body"`
+
+	// Gzip the bodies of multipart requests (eg. "multipart/form-data") and send them with a
+	// "Content-Encoding: gzip" header. Bodies that already have a Content-Encoding, or whose parts
+	// are all compressed already, are sent as they are.
+	GzipMultipart null.Bool `json:"gzipMultipart" envconfig:"gzip_multipart"`
+
+	// Expose HTTP response trailers to scripts as trailers, eg. for gRPC's grpc-status.
+	CaptureTrailers null.Bool `json:"captureTrailers" envconfig:"capture_trailers"`
+
+	// Expose the certificate chain a server presented to scripts as tls_peer_certificates, eg.
+	// to check issuers or expiry dates. The negotiated TLS version and cipher suite are always
+	// available as tls_version and tls_cipher_suite, and samples are tagged with tls_version.
+	// Off by default, as it's extra work and garbage for every request.
+	CaptureTLSDetails null.Bool `json:"captureTLSDetails" envconfig:"capture_tls_details"`
+
+	// Tag HTTP metrics with the values of these response trailers, if present. The tag names
+	// are the trailer names in lower case, eg. "grpc-status".
+	TrailerTags []string `json:"trailerTags" envconfig:"trailer_tags"`
+
+	// How long to wait for a "100 Continue" response to requests that send an "Expect:
+	// 100-continue" header before sending the body anyway. A zero value sends it immediately.
+	ExpectContinueTimeout NullDuration `json:"expectContinueTimeout" envconfig:"expect_continue_timeout"`
+
+	// How long idle connections are kept around for reuse before they're closed. Setting it just
+	// below the servers' keep-alive timeout avoids reusing connections they've already closed.
+	// A zero value keeps them forever, which is the default.
+	IdleConnTimeout NullDuration `json:"idleConnTimeout" envconfig:"idle_conn_timeout"`
+
+	// Fail requests whose response headers are larger than this many bytes, with a "server
+	// response headers exceeded n bytes" error, instead of Go's default limit of 1MB. HTTP/2 has
+	// no such limit, so this keeps connections on HTTP/1.1, and can't be combined with options
+	// that ask for HTTP/2.
+	MaxResponseHeaderBytes null.Int `json:"maxResponseHeaderBytes" envconfig:"max_response_header_bytes"`
+
+	// Default timeout for HTTP requests, instead of 60s; a request's own timeout param wins.
+	RequestTimeout NullDuration `json:"requestTimeout" envconfig:"request_timeout"`
+
+	// Request timeouts for specific hosts, by host pattern (see MatchHost), overriding
+	// RequestTimeout, eg. {"analytics.example.com": "2m", "*.api.example.com": "5s"}.
+	HostRequestTimeouts map[string]Duration `json:"hostRequestTimeouts" envconfig:"host_request_timeouts"`
+
+	// Accept invalid or untrusted TLS certificates.
+	InsecureSkipTLSVerify null.Bool `json:"insecureSkipTLSVerify" envconfig:"insecure_skip_tls_verify"`
+
+	// Specify TLS versions and cipher suites, and present client certificates.
+	TLSCipherSuites *TLSCipherSuites `json:"tlsCipherSuites" envconfig:"tls_cipher_suites"`
+	TLSVersion      *TLSVersions     `json:"tlsVersion" envconfig:"tls_version"`
+	TLSAuth         []*TLSAuth       `json:"tlsAuth" envconfig:"tlsauth"`
+
+	// Fail requests to servers that negotiate a version below tlsVersion's min with an error that
+	// says so, eg. "server negotiated tls1.0 below configured minimum tls1.2", instead of a generic
+	// handshake failure, and always throw it, whether or not throw is enabled. Servers that refuse
+	// every version offered fail with "server only supports versions below configured minimum".
+	TLSMinVersionStrict null.Bool `json:"tlsMinVersionStrict" envconfig:"tls_min_version_strict"`
+
+	// Count requests whose TLS handshake failed in http_req_tls_failures, and tag them and their
+	// other samples with tls_error: certificate, version, cipher or handshake.
+	TLSFailureMetric null.Bool `json:"tlsFailureMetric" envconfig:"tls_failure_metric"`
+
+	// Client certificates by host pattern, as an alternative to listing domains in TLSAuth; easier
+	// to maintain for many hosts, and merged per pattern when options are combined. Each entry is
+	// treated as if it was in TLSAuth with its pattern as its only domain; see TLSAuthFor(). Can't
+	// be set through env vars.
+	TLSAuthByHost map[string]*TLSAuth `json:"tlsAuthByHost" ignored:"true"`
+
+	// TLS settings for specific hosts, by host pattern (see MatchHost), overriding the global TLS
+	// options field by field; only the most specific matching pattern applies. Entries are merged
+	// field by field when options are combined. Can't be set through env vars.
+	PerHostTLS map[string]HostTLSConfig `json:"perHostTLS" ignored:"true"`
+
+	// TLS settings for connections to HTTPS proxies, eg. to skip verification of an intercepting
+	// proxy's certificate while still verifying the origin's. The options above then only apply
+	// to origins, including ones tunnelled to through the proxy. Can't be set through env vars.
+	ProxyTLS *ProxyTLS `json:"proxyTLS" ignored:"true"`
+
+	// Reload client certificates read from files (TLSAuth certFile/keyFile) when they change,
+	// for long running tests using short-lived certificates.
+	TLSAuthWatch null.Bool `json:"tlsAuthWatch" envconfig:"tls_auth_watch"`
+
+	// Check server certificates against a certificate revocation list, read from a file or
+	// downloaded from an http(s) URL once, before the test starts. Requests to servers with a
+	// revoked certificate fail with a "certificate revoked" error.
+	TLSCRL null.String `json:"tlsCRL" envconfig:"tls_crl"`
+
+	// Offer exactly these ALPN protocols in TLS handshakes, eg. ["h2", "http/1.1"], instead of
+	// the defaults. Entries can't be empty or longer than 255 bytes.
+	TLSNextProtos []string `json:"tlsNextProtos" envconfig:"tls_next_protos"`
+
+	// HTTP version to use for requests, "1.1" or "2". If unset, HTTP/2 is used with servers that
+	// offer it over TLS, and HTTP/1.1 otherwise. "2" only applies to https URLs.
+	HTTPVersion null.String `json:"httpVersion" envconfig:"http_version"`
+
+	// HTTP versions for specific hosts, by host pattern (see MatchHost), overriding HTTPVersion.
+	HostHTTPVersions map[string]string `json:"hostHTTPVersions" envconfig:"host_http_versions"`
+
+	// System tags to attach to samples: "group", the path of the group they were emitted in, and
+	// "vu", the high cardinality ID of the VU that emitted them. If unset, only "group" is
+	// attached; a list replaces that default, so [] attaches neither.
+	SystemTags []string `json:"systemTags" envconfig:"system_tags"`
+
+	// Rules for naming requests by URL; the first matching rule's name replaces the URL in the
+	// "name" tag, unless the script names the request itself. Can't be set through env vars.
+	TagRules []TagRule `json:"tagRules" ignored:"true"`
+
+	// Schemas to validate JSON response bodies against, by URL; the first matching one is used,
+	// and the outcome is recorded in http_resp_schema_failed. Can't be set through env vars.
+	ResponseSchemas []ResponseSchema `json:"responseSchemas" ignored:"true"`
+
+	// Count responses with bodies larger than this many bytes, after decompression, in
+	// http_resp_oversized, eg. to catch endpoints that stopped paginating. 0 disables it.
+	ResponseSizeAlert null.Int `json:"responseSizeAlert" envconfig:"response_size_alert"`
+
+	// Response statuses that don't count as failures for http_req_failed, as single statuses
+	// ("401") or inclusive ranges ("200-399"). Unset = 200-399.
+	ExpectedStatuses []string `json:"expectedStatuses" envconfig:"expected_statuses"`
+
+	// Throw warnings (eg. failed HTTP requests) as errors instead of simply logging them.
+	Throw null.Bool `json:"throw" envconfig:"throw"`
+
+	// Exit with a nonzero status if any checks failed, as if a threshold had failed.
+	FailOnCheckFailure null.Bool `json:"failOnCheckFailure" envconfig:"fail_on_check_failure"`
+
+	// Exit with a nonzero status, as if a threshold had failed, if fewer than this fraction of
+	// checks passed, eg. 0.99 to allow up to 1% of them to fail. Thresholds still apply, including
+	// ones on the checks metric. Can't be combined with NoChecks either.
+	MinCheckPassRate null.Float `json:"minCheckPassRate" envconfig:"min_check_pass_rate"`
+
+	// Don't record checks, which is mostly useful when benchmarking maximum throughput. check()
+	// still evaluates its conditions and returns the result, but doesn't build tags for them,
+	// tally them in the group tree or emit samples for the checks metric. This leaves the
+	// end-of-test summary without checks, and can't be combined with FailOnCheckFailure or
+	// MinCheckPassRate.
+	NoChecks null.Bool `json:"noChecks" envconfig:"no_checks"`
+
+	// Define thresholds; these take the form of 'metric=["snippet1", "snippet2"]'.
+	// To create a threshold on a derived metric based on tag queries ("submetrics"), create a
+	// metric on a nonexistent metric named 'real_metric{tagA:valueA,tagB:valueB}'.
+	// Rate-of-change thresholds can be written with delta(), eg. 'delta("rate", "1m") < 0.05'.
+	Thresholds map[string]stats.Thresholds `json:"thresholds" envconfig:"thresholds"`
+
+	// Don't evaluate thresholds, neither during the test nor at the end. Without them, the engine
+	// also doesn't set up submetrics, so samples don't have to be matched against their tags; with
+	// a few thresholds on submetrics, this makes processing each sample several times cheaper
+	// (see BenchmarkEngineProcessSamples).
+	NoThresholds null.Bool `json:"noThresholds" envconfig:"no_thresholds"`
+
+	// Blacklist IP ranges that tests may not contact. Mainly useful in hosted setups.
+	BlacklistIPs []*net.IPNet `json:"blacklistIPs" envconfig:"blacklist_ips"`
+
+	// Hosts overrides dns entries for given hosts
+	Hosts map[string]net.IP `json:"hosts" envconfig:"hosts"`
+
+	// Retry failed DNS lookups this many times before failing the request; 0 or unset fails
+	// immediately. The backoff (default 100ms) is how long to wait before the first retry, and
+	// doubles with each retry after that.
+	DNSRetries      null.Int     `json:"dnsRetries" envconfig:"dns_retries"`
+	DNSRetryBackoff NullDuration `json:"dnsRetryBackoff" envconfig:"dns_retry_backoff"`
+
+	// Retry requests that fail because the connection was reset (ECONNRESET) this many times
+	// before failing the request; 0 or unset fails immediately. Every reset is counted in
+	// http_conn_resets, whether it was retried or not, and every retry waits on the rps limits
+	// like a new request would.
+	ConnResetRetries null.Int `json:"connResetRetries" envconfig:"conn_reset_retries"`
+
+	// Cap each request's timeout at the time left in the test, so requests don't run past its
+	// end. Requests made with no time left aren't sent at all; both those and ones that time out
+	// because of the cap are counted in http_req_deadline_exceeded.
+	TestEndDeadline null.Bool `json:"testEndDeadline" envconfig:"test_end_deadline"`
+
+	// Hosts to resolve and dial before the test starts, as "host" or "host:port" (the port
+	// defaults to 443 with prewarmTLS, 80 otherwise); "*" stands for every host in the hosts
+	// option. With prewarmTLS, a TLS handshake is made too, with the TLS settings VUs would use
+	// for the host, and VUs resume those sessions. Each host gets the request timeout for it.
+	Prewarm    []string  `json:"prewarm" envconfig:"prewarm"`
+	PrewarmTLS null.Bool `json:"prewarmTLS" envconfig:"prewarm_tls"`
+
+	// Do not reuse connections between VU iterations. This gives more realistic results (depending
+	// on what you're looking for), but you need to raise various kernel limits or you'll get
+	// errors about running out of file handles or sockets, or being unable to bind addresses.
+	NoConnectionReuse null.Bool `json:"noConnectionReuse" envconfig:"no_connection_reuse"`
+
+	// Check the open file limit against the VUs needed before starting. By default a limit that
+	// looks too low logs a warning; if true it's an error instead, and if false it's not checked.
+	CheckFDLimit null.Bool `json:"checkFDLimit" envconfig:"check_fd_limit"`
+
+	// Additional outputs to send samples to, alongside --out, each in its own right.
+	// Can't be set through env vars.
+	Outputs []OutputConfig `json:"outputs" ignored:"true"`
+
+	// Emit a run_start sample when the test starts and a run_end one when it ends, both tagged
+	// with run_id and with options, a JSON summary of the VUs, duration, iterations and stages.
+	// The run ID is a random one unless set here.
+	RunMarkers null.Bool   `json:"runMarkers" envconfig:"run_markers"`
+	RunID      null.String `json:"runID" envconfig:"run_id"`
+
+	// What to write to stdout: "text" (the default) is the banner, progress and end-of-test
+	// summary; "ndjson" is every metric and sample as a line of JSON, in the same format as the
+	// json output, with the human-readable output moved to stderr.
+	StdoutFormat null.String `json:"stdoutFormat" envconfig:"stdout_format"`
+
+	// These values are for third party collectors' benefit.
+	// Can't be set through env vars.
+	External map[string]interface{} `json:"ext" ignored:"true"`
+
+	// Summary trend stats for trend metrics (response times) in CLI output
+	SummaryTrendStats []string `json:"SummaryTrendStats" envconfig:"summary_trend_stats"`
+
+	// Decimal places to show trend stats with in CLI output, in the unit each one is shown in, eg.
+	// 2 for "12.35ms". If unset, the precision depends on the value.
+	SummaryTrendPrecision null.Int `json:"summaryTrendPrecision" envconfig:"summary_trend_precision"`
+
+	// Only show these metrics in the end-of-test summary, along with their submetrics, eg. the
+	// ones thresholds are defined on, instead of every metric. Trend metrics are still shown with
+	// SummaryTrendStats.
+	SummaryMetrics []string `json:"summaryMetrics" envconfig:"summary_metrics"`
+
+	// Tag samples with the index of the stage they were collected in, as "stage", and break
+	// request throughput and latency down by stage in the end-of-test summary.
+	SummaryPerStage null.Bool `json:"summaryPerStage" envconfig:"summary_per_stage"`
+
+	// Every this often, send the PeriodicPercentiles of each trend metric to collectors as gauges
+	// named after it, eg. http_req_duration_p95, for live dashboards; they're not part of the
+	// end-of-test summary. percentileWindow picks whether they're over the whole test so far
+	// ("cumulative", the default) or only the samples since they were last sent ("interval").
+	PercentileInterval NullDuration `json:"percentileInterval" envconfig:"percentile_interval"`
+	PercentileWindow   null.String  `json:"percentileWindow" envconfig:"percentile_window"`
+
+	// Write histograms of all trend metrics to this file as JSON at the end of the test (see
+	// stats.Histogram), eg. to compute percentiles across several instances.
+	HistogramExport null.String `json:"histogramExport" envconfig:"histogram_export"`
+
+	// Write the outcome of every threshold to this file as JSON at the end of the test (see
+	// ThresholdResult), with the values they were compared against, eg. for CI annotations.
+	ThresholdResults null.String `json:"thresholdResults" envconfig:"threshold_results"`
+
+	// Write the effective options and final metrics and checks to this file at the end of the
+	// test, as a RunExport (see ExportRun), eg. to diff against another run.
+	RunExport null.String `json:"runExport" envconfig:"run_export"`
+
+	// Prefix the names of all metrics passed on to collectors, eg. "checkout_" turns http_reqs into
+	// checkout_http_reqs. Thresholds and the end-of-test summary still use the unprefixed names.
+	MetricPrefix null.String `json:"metricPrefix" envconfig:"metric_prefix"`
+
+	// Comparison group for A/B or canary runs, eg. "control" or "canary". Unlike tags set on
+	// individual requests, it's attached as a "variant" tag to every sample of the run, engine
+	// metrics included, and is recorded at the top level of run exports, so that tooling can
+	// line up runs without knowing anything about the script.
+	Variant null.String `json:"variant" envconfig:"variant"`
+
+	// Precision of sample timestamps passed on to collectors; "ns", "us" or "ms".
+	// If unset, timestamps are passed on untouched.
+	TimestampPrecision null.String `json:"timestampPrecision" envconfig:"timestamp_precision"`
+
+	// Have the JSON and InfluxDB outputs write counter values as integers (rounded to the nearest
+	// one) and all other values as floats, even whole ones, for backends with typed fields.
+	TypedMetricValues null.Bool `json:"typedMetricValues" envconfig:"typed_metric_values"`
+
+	// Custom gauges to set back to 0 on their own, by metric name: "iteration" at the end of
+	// every iteration that set them, or "interval" once a metrics interval (1s) passes without
+	// them being set. Others keep their last value.
+	GaugeReset map[string]string `json:"gaugeReset" envconfig:"gauge_reset"`
+
+	// Caps on the number of distinct values of tags, by tag name; once a tag has had that many
+	// values, samples with any new value are tagged with TagOverflowValue instead.
+	TagCardinalityLimits map[string]int64 `json:"tagCardinalityLimits" envconfig:"tag_cardinality_limits"`
+}
+
+// Returns the result of overwriting any fields with any that are set on the argument.
+//
+// Example:
+//   a := Options{VUs: null.IntFrom(10), VUsMax: null.IntFrom(10)}
+//   b := Options{VUs: null.IntFrom(5)}
+//   a.Apply(b) // Options{VUs: null.IntFrom(5), VUsMax: null.IntFrom(10)}
+func (o Options) Apply(opts Options) Options {
+	if opts.Paused.Valid {
+		o.Paused = opts.Paused
+	}
+	if opts.PauseSignal.Valid {
+		o.PauseSignal = opts.PauseSignal
+	}
+	if opts.DryRun.Valid {
+		o.DryRun = opts.DryRun
+	}
+	if opts.APIAddress.Valid {
+		o.APIAddress = opts.APIAddress
+	}
+	if opts.VUs.Valid {
+		o.VUs = opts.VUs
+	}
+	if opts.VUsMax.Valid {
+		o.VUsMax = opts.VUsMax
+	}
+	if opts.VUsMaxPolicy.Valid {
+		o.VUsMaxPolicy = opts.VUsMaxPolicy
+	}
+	if opts.PreAllocatedVUs.Valid {
+		o.PreAllocatedVUs = opts.PreAllocatedVUs
+	}
+	if opts.VUInitConcurrency.Valid {
+		o.VUInitConcurrency = opts.VUInitConcurrency
+	}
+	if opts.MemoryLimit.Valid {
+		o.MemoryLimit = opts.MemoryLimit
+	}
+	if opts.MemoryLimitPolicy.Valid {
+		o.MemoryLimitPolicy = opts.MemoryLimitPolicy
+	}
+	if opts.Duration.Valid {
+		o.Duration = opts.Duration
+	}
+	if opts.Iterations.Valid {
+		o.Iterations = opts.Iterations
+	}
+	if opts.EndTime.Valid {
+		o.EndTime = opts.EndTime
+	}
+	if opts.Stages != nil {
+		o.Stages = opts.Stages
+		o.StagesFile = null.String{}
+	}
+	if opts.StagesFile.Valid {
+		o.StagesFile = opts.StagesFile
+		o.Stages = nil
+	}
+	if opts.StageShuffle != nil {
+		o.StageShuffle = opts.StageShuffle
+	}
+	if opts.BodyData != nil {
+		o.BodyData = opts.BodyData
+		o.BodyDataFile = null.String{}
+	}
+	if opts.BodyDataFile.Valid {
+		o.BodyDataFile = opts.BodyDataFile
+		o.BodyData = nil
+	}
+	if opts.BodyFile.Valid {
+		o.BodyFile = opts.BodyFile
+	}
+	if opts.TagData != nil {
+		o.TagData = opts.TagData
+		o.TagDataFile = null.String{}
+	}
+	if opts.TagDataFile.Valid {
+		o.TagDataFile = opts.TagDataFile
+		o.TagData = nil
+	}
+	if opts.TagDataTags != nil {
+		o.TagDataTags = opts.TagDataTags
+	}
+	if opts.Replay != nil {
+		o.Replay = opts.Replay
+		o.ReplayFile = null.String{}
+	}
+	if opts.ReplayFile.Valid {
+		o.ReplayFile = opts.ReplayFile
+		o.Replay = nil
+	}
+	if opts.MaxInFlightSamples.Valid {
+		o.MaxInFlightSamples = opts.MaxInFlightSamples
+	}
+	if opts.InFlightSamplesPolicy.Valid {
+		o.InFlightSamplesPolicy = opts.InFlightSamplesPolicy
+	}
+	if opts.MaxIterationsPerVU.Valid {
+		o.MaxIterationsPerVU = opts.MaxIterationsPerVU
+	}
+	if opts.PaceIterations.Valid {
+		o.PaceIterations = opts.PaceIterations
+	}
+	if opts.VUCancellation.Valid {
+		o.VUCancellation = opts.VUCancellation
+	}
+	if opts.VUWarmup != nil {
+		o.VUWarmup = opts.VUWarmup
+	}
+	if opts.WarmupDuration.Valid {
+		o.WarmupDuration = opts.WarmupDuration
+	}
+	if opts.VUStartJitter != nil {
+		o.VUStartJitter = opts.VUStartJitter
+	}
+	if opts.ThinkTime != nil {
+		o.ThinkTime = opts.ThinkTime
+	}
+	if opts.NetworkConditions != nil {
+		o.NetworkConditions = opts.NetworkConditions
+	}
+	if opts.MaxCPUs.Valid {
+		o.MaxCPUs = opts.MaxCPUs
+	}
+	if opts.RPS.Valid {
+		o.RPS = opts.RPS
+	}
+	if opts.RPSPerVU.Valid {
+		o.RPSPerVU = opts.RPSPerVU
+	}
+	if opts.MethodRPS != nil {
+		o.MethodRPS = opts.MethodRPS
+	}
+	if opts.ConnRatePerSec.Valid {
+		o.ConnRatePerSec = opts.ConnRatePerSec
+	}
+	if opts.LimiterWaitMetric.Valid {
+		o.LimiterWaitMetric = opts.LimiterWaitMetric
+	}
+	if opts.LocalPortRange.Valid {
+		o.LocalPortRange = opts.LocalPortRange
+	}
+	if opts.BindInterface.Valid {
+		o.BindInterface = opts.BindInterface
+	}
+	if opts.ConnPoolMetrics.Valid {
+		o.ConnPoolMetrics = opts.ConnPoolMetrics
+	}
+	if opts.ConnReuseMetrics.Valid {
+		o.ConnReuseMetrics = opts.ConnReuseMetrics
+	}
+	if opts.MaxRedirects.Valid {
+		o.MaxRedirects = opts.MaxRedirects
+	}
+	if opts.Tracing != nil {
+		o.Tracing = opts.Tracing
+	}
+	if opts.RequestSigning != nil {
+		o.RequestSigning = opts.RequestSigning
+	}
+	if opts.HARExport != nil {
+		o.HARExport = opts.HARExport
+	}
+	if opts.RedirectAllowlist != nil {
+		o.RedirectAllowlist = opts.RedirectAllowlist
+	}
+	if opts.RedirectSensitiveHeaders.Valid {
+		o.RedirectSensitiveHeaders = opts.RedirectSensitiveHeaders
+	}
+	if opts.CookieJarHosts != nil {
+		o.CookieJarHosts = opts.CookieJarHosts
+	}
+	if opts.CookieJarExcludeHosts != nil {
+		o.CookieJarExcludeHosts = opts.CookieJarExcludeHosts
+	}
+	if opts.UserAgent.Valid {
+		o.UserAgent = opts.UserAgent
+	}
+	if opts.HostUserAgents != nil {
+		o.HostUserAgents = opts.HostUserAgents
+	}
+	if opts.HTTPVersion.Valid {
+		o.HTTPVersion = opts.HTTPVersion
+	}
+	if opts.HostHTTPVersions != nil {
+		o.HostHTTPVersions = opts.HostHTTPVersions
+	}
+	if opts.Batch.Valid {
+		o.Batch = opts.Batch
+	}
+	if opts.BatchPerHost.Valid {
+		o.BatchPerHost = opts.BatchPerHost
+	}
+	if opts.HttpDebug.Valid {
+		o.HttpDebug = opts.HttpDebug
+	}
+	if opts.KeepCompressedBody.Valid {
+		o.KeepCompressedBody = opts.KeepCompressedBody
+	}
+	if opts.GzipMultipart.Valid {
+		o.GzipMultipart = opts.GzipMultipart
+	}
+	if opts.CaptureTrailers.Valid {
+		o.CaptureTrailers = opts.CaptureTrailers
+	}
+	if opts.CaptureTLSDetails.Valid {
+		o.CaptureTLSDetails = opts.CaptureTLSDetails
+	}
+	if opts.TrailerTags != nil {
+		o.TrailerTags = opts.TrailerTags
+	}
+	if opts.ExpectContinueTimeout.Valid {
+		o.ExpectContinueTimeout = opts.ExpectContinueTimeout
+	}
+	if opts.IdleConnTimeout.Valid {
+		o.IdleConnTimeout = opts.IdleConnTimeout
+	}
+	if opts.MaxResponseHeaderBytes.Valid {
+		o.MaxResponseHeaderBytes = opts.MaxResponseHeaderBytes
+	}
+	if opts.RequestTimeout.Valid {
+		o.RequestTimeout = opts.RequestTimeout
+	}
+	if opts.HostRequestTimeouts != nil {
+		o.HostRequestTimeouts = opts.HostRequestTimeouts
+	}
+	if opts.InsecureSkipTLSVerify.Valid {
+		o.InsecureSkipTLSVerify = opts.InsecureSkipTLSVerify
+	}
+	if opts.TLSCipherSuites != nil {
+		o.TLSCipherSuites = opts.TLSCipherSuites
+	}
+	if opts.TLSVersion != nil {
+		o.TLSVersion = opts.TLSVersion
+	}
+	if opts.TLSMinVersionStrict.Valid {
+		o.TLSMinVersionStrict = opts.TLSMinVersionStrict
+	}
+	if opts.TLSFailureMetric.Valid {
+		o.TLSFailureMetric = opts.TLSFailureMetric
+	}
+	if opts.ProxyTLS != nil {
+		o.ProxyTLS = opts.ProxyTLS
+	}
+	if opts.TLSAuth != nil {
+		o.TLSAuth = opts.TLSAuth
+	}
+	if opts.TLSAuthByHost != nil {
+		merged := make(map[string]*TLSAuth, len(o.TLSAuthByHost)+len(opts.TLSAuthByHost))
+		for pattern, auth := range o.TLSAuthByHost {
+			merged[pattern] = auth
+		}
+		for pattern, auth := range opts.TLSAuthByHost {
+			merged[pattern] = auth
+		}
+		o.TLSAuthByHost = merged
+	}
+	if opts.PerHostTLS != nil {
+		merged := make(map[string]HostTLSConfig, len(o.PerHostTLS)+len(opts.PerHostTLS))
+		for pattern, config := range o.PerHostTLS {
+			merged[pattern] = config
+		}
+		for pattern, config := range opts.PerHostTLS {
+			merged[pattern] = merged[pattern].Apply(config)
+		}
+		o.PerHostTLS = merged
+	}
+	if opts.TLSAuthWatch.Valid {
+		o.TLSAuthWatch = opts.TLSAuthWatch
+	}
+	if opts.TLSCRL.Valid {
+		o.TLSCRL = opts.TLSCRL
+	}
+	if opts.TLSNextProtos != nil {
+		o.TLSNextProtos = opts.TLSNextProtos
+	}
+	if opts.SystemTags != nil {
+		o.SystemTags = opts.SystemTags
+	}
+	if opts.TagRules != nil {
+		o.TagRules = opts.TagRules
+	}
+	if opts.ResponseSchemas != nil {
+		o.ResponseSchemas = opts.ResponseSchemas
+	}
+	if opts.ResponseSizeAlert.Valid {
+		o.ResponseSizeAlert = opts.ResponseSizeAlert
+	}
+	if opts.ExpectedStatuses != nil {
+		o.ExpectedStatuses = opts.ExpectedStatuses
+	}
+	if opts.Throw.Valid {
+		o.Throw = opts.Throw
+	}
+	if opts.FailOnCheckFailure.Valid {
+		o.FailOnCheckFailure = opts.FailOnCheckFailure
+	}
+	if opts.MinCheckPassRate.Valid {
+		o.MinCheckPassRate = opts.MinCheckPassRate
+	}
+	if opts.NoChecks.Valid {
+		o.NoChecks = opts.NoChecks
+	}
+	if opts.Thresholds != nil {
+		o.Thresholds = opts.Thresholds
+	}
+	if opts.NoThresholds.Valid {
+		o.NoThresholds = opts.NoThresholds
+	}
+	if opts.BlacklistIPs != nil {
+		o.BlacklistIPs = opts.BlacklistIPs
+	}
+	if opts.Hosts != nil {
+		o.Hosts = opts.Hosts
+	}
+	if opts.DNSRetries.Valid {
+		o.DNSRetries = opts.DNSRetries
+	}
+	if opts.DNSRetryBackoff.Valid {
+		o.DNSRetryBackoff = opts.DNSRetryBackoff
+	}
+	if opts.ConnResetRetries.Valid {
+		o.ConnResetRetries = opts.ConnResetRetries
+	}
+	if opts.TestEndDeadline.Valid {
+		o.TestEndDeadline = opts.TestEndDeadline
+	}
+	if opts.Prewarm != nil {
+		o.Prewarm = opts.Prewarm
+	}
+	if opts.PrewarmTLS.Valid {
+		o.PrewarmTLS = opts.PrewarmTLS
+	}
+	if opts.NoConnectionReuse.Valid {
+		o.NoConnectionReuse = opts.NoConnectionReuse
+	}
+	if opts.CheckFDLimit.Valid {
+		o.CheckFDLimit = opts.CheckFDLimit
+	}
+	if opts.Outputs != nil {
+		o.Outputs = opts.Outputs
+	}
+	if opts.RunMarkers.Valid {
+		o.RunMarkers = opts.RunMarkers
+	}
+	if opts.RunID.Valid {
+		o.RunID = opts.RunID
+	}
+	if opts.StdoutFormat.Valid {
+		o.StdoutFormat = opts.StdoutFormat
+	}
+	if opts.External != nil {
+		o.External = opts.External
+	}
+	if opts.SummaryTrendStats != nil {
+		o.SummaryTrendStats = opts.SummaryTrendStats
+	}
+	if opts.SummaryTrendPrecision.Valid {
+		o.SummaryTrendPrecision = opts.SummaryTrendPrecision
+	}
+	if opts.SummaryMetrics != nil {
+		o.SummaryMetrics = opts.SummaryMetrics
+	}
+	if opts.SummaryPerStage.Valid {
+		o.SummaryPerStage = opts.SummaryPerStage
+	}
+	if opts.PercentileInterval.Valid {
+		o.PercentileInterval = opts.PercentileInterval
+	}
+	if opts.PercentileWindow.Valid {
+		o.PercentileWindow = opts.PercentileWindow
+	}
+	if opts.HistogramExport.Valid {
+		o.HistogramExport = opts.HistogramExport
+	}
+	if opts.ThresholdResults.Valid {
+		o.ThresholdResults = opts.ThresholdResults
+	}
+	if opts.RunExport.Valid {
+		o.RunExport = opts.RunExport
+	}
+	if opts.MetricPrefix.Valid {
+		o.MetricPrefix = opts.MetricPrefix
+	}
+	if opts.Variant.Valid {
+		o.Variant = opts.Variant
+	}
+	if opts.TimestampPrecision.Valid {
+		o.TimestampPrecision = opts.TimestampPrecision
+	}
+	if opts.TypedMetricValues.Valid {
+		o.TypedMetricValues = opts.TypedMetricValues
+	}
+	if opts.GaugeReset != nil {
+		o.GaugeReset = opts.GaugeReset
+	}
+	if opts.TagCardinalityLimits != nil {
+		o.TagCardinalityLimits = opts.TagCardinalityLimits
+	}
+	return o
+}
+
+// Returns an error for the first invalid option, or option that doesn't go with others. Options
+// that are only checked here are rejected before the test starts, and before any VU is made.
+func (o Options) Validate() error {
+	if o.PreAllocatedVUs.Valid {
+		if o.PreAllocatedVUs.Int64 < 0 {
+			return errors.New("preallocated vus can't be negative")
+		}
+		if o.PreAllocatedVUs.Int64 > o.VUsMax.Int64 {
+			return errors.Errorf(
+				"can't preallocate more vus (%d) than the vu cap (%d)",
+				o.PreAllocatedVUs.Int64, o.VUsMax.Int64,
+			)
+		}
+	}
+	if o.MemoryLimit.Valid && o.MemoryLimit.Int64 <= 0 {
+		return errors.New("memory limit must be positive")
+	}
+	if err := ValidateMemoryLimitPolicy(o.MemoryLimitPolicy.String); err != nil {
+		return err
+	}
+	if o.VUInitConcurrency.Valid && o.VUInitConcurrency.Int64 <= 0 {
+		return errors.New("vu init concurrency must be positive")
+	}
+	if err := ValidateVUsMaxPolicy(o.VUsMaxPolicy.String); err != nil {
+		return err
+	}
+	if o.VUsMax.Valid && o.VUsMaxPolicy.String != VUsMaxClamp {
+		for i, stage := range o.Stages {
+			if stage.Target.Valid && stage.Target.Int64 > o.VUsMax.Int64 {
+				return errors.Errorf(
+					"stage %d targets more vus (%d) than the vu cap (%d)", i, stage.Target.Int64, o.VUsMax.Int64,
+				)
+			}
+		}
+	}
+	if o.EndTime.Valid && !o.EndTime.After(time.Now()) {
+		return errors.Errorf("end time %s is in the past", o.EndTime.Format(time.RFC3339))
+	}
+	if o.PaceIterations.Bool && (!o.Iterations.Valid || !o.Duration.Valid) {
+		return errors.New("paceIterations needs both iterations and duration")
+	}
+	if err := ValidateVUCancellation(o.VUCancellation.String); err != nil {
+		return err
+	}
+
+	if o.TimestampPrecision.Valid {
+		if _, err := ParseTimestampPrecision(o.TimestampPrecision.String); err != nil {
+			return err
+		}
+	}
+	if o.MetricPrefix.Valid {
+		if err := ValidateMetricPrefix(o.MetricPrefix.String); err != nil {
+			return err
+		}
+	}
+	for method, rps := range o.MethodRPS {
+		if rps <= 0 {
+			return errors.Errorf("methodRPS for %s must be positive", method)
+		}
+	}
+	if o.Variant.Valid {
+		if err := ValidateVariant(o.Variant.String); err != nil {
+			return err
+		}
+	}
+	if o.IdleConnTimeout.Valid && o.IdleConnTimeout.Duration < 0 {
+		return errors.New("idle connection timeout can't be negative")
+	}
+	if o.MaxResponseHeaderBytes.Valid && o.MaxResponseHeaderBytes.Int64 <= 0 {
+		return errors.New("max response header bytes must be positive")
+	}
+	if o.MaxResponseHeaderBytes.Valid && o.WantsHTTP2() {
+		return errors.New("maxResponseHeaderBytes can't be used with HTTP/2")
+	}
+	if o.RequestTimeout.Valid && o.RequestTimeout.Duration <= 0 {
+		return errors.New("request timeout must be positive")
+	}
+	for pattern, timeout := range o.HostRequestTimeouts {
+		if timeout <= 0 {
+			return errors.Errorf("request timeout for %s must be positive", pattern)
+		}
+	}
+	if o.SummaryTrendPrecision.Valid && o.SummaryTrendPrecision.Int64 < 0 {
+		return errors.New("summary trend precision can't be negative")
+	}
+	if o.ResponseSizeAlert.Valid && o.ResponseSizeAlert.Int64 < 0 {
+		return errors.New("response size alert can't be negative")
+	}
+	if err := ValidateHTTPVersion(o.HTTPVersion.String); err != nil {
+		return err
+	}
+	for pattern, version := range o.HostHTTPVersions {
+		if err := ValidateHTTPVersion(version); err != nil {
+			return errors.Wrapf(err, "hostHTTPVersions[%s]", pattern)
+		}
+	}
+	if err := ValidateSystemTags(o.SystemTags); err != nil {
+		return err
+	}
+	if len(o.TagData) > 0 && len(o.TagDataTags) == 0 {
+		return errors.New("tagData needs tagDataTags to pick which fields become tags")
+	}
+	if o.TLSMinVersionStrict.Bool && (o.TLSVersion == nil || o.TLSVersion.Min == 0) {
+		return errors.New("tlsMinVersionStrict needs a minimum tlsVersion")
+	}
+	if o.TLSNextProtos != nil {
+		if err := ValidateTLSNextProtos(o.TLSNextProtos); err != nil {
+			return err
+		}
+	}
+	for pattern, hostTLS := range o.PerHostTLS {
+		if hostTLS.NextProtos != nil {
+			if err := ValidateTLSNextProtos(hostTLS.NextProtos); err != nil {
+				return errors.Wrapf(err, "perHostTLS[%s]", pattern)
+			}
+		}
+	}
+	if o.LocalPortRange.Valid {
+		if _, _, err := ParsePortRange(o.LocalPortRange.String); err != nil {
+			return err
+		}
+	}
+	if o.BodyFile.Valid && o.RequestSigning != nil {
+		return errors.New("bodyFile can't be used with requestSigning")
+	}
+	if o.BindInterface.Valid {
+		if _, err := InterfaceIPs(o.BindInterface.String); err != nil {
+			return err
+		}
+	}
+	if _, err := ParseStatusRanges(o.ExpectedStatuses); err != nil {
+		return err
+	}
+	if err := ValidateGaugeReset(o.GaugeReset); err != nil {
+		return err
+	}
+	for tag, limit := range o.TagCardinalityLimits {
+		if limit <= 0 {
+			return errors.Errorf("tag cardinality limit for %s must be positive", tag)
+		}
+	}
+	if o.PercentileInterval.Valid && o.PercentileInterval.Duration <= 0 {
+		return errors.New("percentile interval must be positive")
+	}
+	if err := ValidatePercentileWindow(o.PercentileWindow.String); err != nil {
+		return err
+	}
+	if o.NoChecks.Bool && o.FailOnCheckFailure.Bool {
+		return errors.New("failOnCheckFailure can't be used with noChecks")
+	}
+	if rate := o.MinCheckPassRate; rate.Valid {
+		if rate.Float64 < 0 || rate.Float64 > 1 {
+			return errors.New("min check pass rate must be between 0 and 1")
+		}
+		if o.NoChecks.Bool {
+			return errors.New("minCheckPassRate can't be used with noChecks")
+		}
+	}
+	if o.MaxInFlightSamples.Valid {
+		if o.MaxInFlightSamples.Int64 <= 0 {
+			return errors.New("maxInFlightSamples must be positive")
+		}
+		switch o.InFlightSamplesPolicy.String {
+		case "", InFlightSamplesBlock, InFlightSamplesDrop:
+		default:
+			return errors.Errorf("unknown in-flight samples policy: %s", o.InFlightSamplesPolicy.String)
+		}
+	}
+	return nil
+}
+
+// Returns the most specific domain pattern listed by a TLSAuth certificate or a TLSAuthByHost
+// entry that matches the given hostname, if any; see BestHostMatch.
+func (o Options) TLSAuthPattern(host string) (string, bool) {
+	m := newHostMatcher(host)
+	for _, auth := range o.TLSAuth {
+		for _, domain := range auth.Domains {
+			m.add(domain)
+		}
+	}
+	for pattern := range o.TLSAuthByHost {
+		m.add(pattern)
+	}
+	return m.result()
+}
+
+// Returns every domain pattern listed by TLSAuth certificates, once each, followed by the
+// TLSAuthByHost patterns they don't list, sorted.
+func (o Options) TLSAuthPatterns() []string {
+	var patterns []string
+	seen := make(map[string]bool)
+	for _, auth := range o.TLSAuth {
+		for _, domain := range auth.Domains {
+			if !seen[domain] {
+				seen[domain] = true
+				patterns = append(patterns, domain)
+			}
+		}
+	}
+	var byHost []string
+	for pattern := range o.TLSAuthByHost {
+		if !seen[pattern] {
+			byHost = append(byHost, pattern)
+		}
+	}
+	sort.Strings(byHost)
+	return append(patterns, byHost...)
+}
+
+// Returns the client certificates to offer to hosts whose TLSAuth domain pattern, as picked by
+// TLSAuthPattern(), is the given one: the TLSAuth ones listing it in their Domains and its
+// TLSAuthByHost entry, followed by the TLSAuth ones without any Domains. Hosts matching no
+// pattern, for an empty one, only get the latter.
+func (o Options) TLSAuthForPattern(pattern string) []*TLSAuth {
+	var auths, unscoped []*TLSAuth
+	for _, auth := range o.TLSAuth {
+		if len(auth.Domains) == 0 {
+			unscoped = append(unscoped, auth)
+			continue
+		}
+		for _, domain := range auth.Domains {
+			if pattern != "" && domain == pattern {
+				auths = append(auths, auth)
+				break
+			}
+		}
+	}
+	if auth := o.TLSAuthByHost[pattern]; pattern != "" && auth != nil {
+		auths = append(auths, auth)
+	}
+	return append(auths, unscoped...)
+}
+
+// Returns the client certificates to offer to the given hostname: the auth from its PerHostTLS
+// settings if they have one, or else the ones for its TLSAuth domain pattern.
+func (o Options) TLSAuthFor(host string) []*TLSAuth {
+	if pattern, ok := o.HostTLSPattern(host); ok && o.PerHostTLS[pattern].Auth != nil {
+		return []*TLSAuth{o.PerHostTLS[pattern].Auth}
+	}
+	pattern, _ := o.TLSAuthPattern(host)
+	return o.TLSAuthForPattern(pattern)
+}
+
+// Returns a copy of the options that's safe to print, with the certificates and keys in
+// TLSAuth, TLSAuthByHost and PerHostTLS redacted; see TLSAuth.Redacted().
+func (o Options) Redacted() Options {
+	if o.TLSAuth != nil {
+		auths := make([]*TLSAuth, len(o.TLSAuth))
+		for i, auth := range o.TLSAuth {
+			auths[i] = auth.Redacted()
+		}
+		o.TLSAuth = auths
+	}
+	if o.TLSAuthByHost != nil {
+		auths := make(map[string]*TLSAuth, len(o.TLSAuthByHost))
+		for pattern, auth := range o.TLSAuthByHost {
+			auths[pattern] = auth.Redacted()
+		}
+		o.TLSAuthByHost = auths
+	}
+	if o.PerHostTLS != nil {
+		configs := make(map[string]HostTLSConfig, len(o.PerHostTLS))
+		for pattern, config := range o.PerHostTLS {
+			if config.Auth != nil {
+				config.Auth = config.Auth.Redacted()
+			}
+			configs[pattern] = config
+		}
+		o.PerHostTLS = configs
+	}
+	return o
+}
+
+// Estimates how many VUs it takes to start rate iterations per second, if each one takes
+// avgIterationDuration, by Little's law: the VUs busy at any one time are the rate times how long
+// each VU is kept busy, which includes the think time between iterations, if any. Rounds up, so
+// that any positive rate needs at least one VU. This doesn't leave any headroom for latency
+// spikes; add some to VUsMax on top of it.
+func (o Options) EstimateVUsForRate(rate float64, avgIterationDuration time.Duration) int64 {
+	busy := avgIterationDuration
+	if o.ThinkTime != nil {
+		busy += o.ThinkTime.MeanDelay()
+	}
+	if rate <= 0 || busy <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(rate * float64(busy) / float64(time.Second)))
+}
+
+// Returns the addresses to dial for the prewarm option, with "*" expanded and default ports
+// filled in. Duplicates are only returned once.
+func (o Options) PrewarmAddrs() []string {
+	port := "80"
+	if o.PrewarmTLS.Bool {
+		port = "443"
+	}
+
+	var hosts []string
+	for _, host := range o.Prewarm {
+		if host != "*" {
+			hosts = append(hosts, host)
+			continue
+		}
+		names := make([]string, 0, len(o.Hosts))
+		for name := range o.Hosts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		hosts = append(hosts, names...)
+	}
+
+	seen := make(map[string]bool, len(hosts))
+	addrs := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		addr := host
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			addr = net.JoinHostPort(strings.Trim(host, "[]"), port)
+		}
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// Returns the User Agent string to use for requests to the given hostname.
+func (o Options) UserAgentFor(host string) null.String {
+	m := newHostMatcher(host)
+	for pattern := range o.HostUserAgents {
+		m.add(pattern)
+	}
+	if pattern, ok := m.result(); ok {
+		return null.StringFrom(o.HostUserAgents[pattern])
+	}
+	return o.UserAgent
+}
+
+// The timeout for HTTP requests, if no option or param sets one.
+const DefaultRequestTimeout = 60 * time.Second
+
+// Returns the timeout for requests to the given hostname.
+func (o Options) RequestTimeoutFor(host string) time.Duration {
+	m := newHostMatcher(host)
+	for pattern := range o.HostRequestTimeouts {
+		m.add(pattern)
+	}
+	if pattern, ok := m.result(); ok {
+		return time.Duration(o.HostRequestTimeouts[pattern])
+	}
+	if o.RequestTimeout.Valid {
+		return time.Duration(o.RequestTimeout.Duration)
+	}
+	return DefaultRequestTimeout
+}
+
+// Returns the HTTP version to use for requests to the given hostname; empty for the default.
+func (o Options) HTTPVersionFor(host string) string {
+	m := newHostMatcher(host)
+	for pattern := range o.HostHTTPVersions {
+		m.add(pattern)
+	}
+	if pattern, ok := m.result(); ok {
+		return o.HostHTTPVersions[pattern]
+	}
+	return o.HTTPVersion.String
+}
+
+// Returns whether HTTP/2 is asked for anywhere: by HTTPVersion or HostHTTPVersions, or by
+// offering "h2" in TLSNextProtos, globally or in PerHostTLS.
+func (o Options) WantsHTTP2() bool {
+	if o.HTTPVersion.String == HTTPVersion2 {
+		return true
+	}
+	for _, version := range o.HostHTTPVersions {
+		if version == HTTPVersion2 {
+			return true
+		}
+	}
+	offersH2 := func(protos []string) bool {
+		for _, proto := range protos {
+			if proto == "h2" {
+				return true
+			}
+		}
+		return false
+	}
+	if offersH2(o.TLSNextProtos) {
+		return true
+	}
+	for _, config := range o.PerHostTLS {
+		if offersH2(config.NextProtos) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the PerHostTLS pattern whose settings apply to the given hostname, if any.
+func (o Options) HostTLSPattern(host string) (string, bool) {
+	m := newHostMatcher(host)
+	for pattern := range o.PerHostTLS {
+		m.add(pattern)
+	}
+	return m.result()
+}
+
+// Returns the TLS versions to allow for connections to the given hostname; nil for the defaults.
+func (o Options) TLSVersionFor(host string) *TLSVersions {
+	if pattern, ok := o.HostTLSPattern(host); ok && o.PerHostTLS[pattern].Version != nil {
+		return o.PerHostTLS[pattern].Version
+	}
+	return o.TLSVersion
+}
+
+// Returns the name of the first TagRule matching the given URL, if any.
+func (o Options) TagNameFor(url string) (string, bool) {
+	for _, rule := range o.TagRules {
+		if rule.Match != nil && rule.Match.MatchString(url) {
+			return rule.Name, true
+		}
+	}
+	return "", false
+}
+
+// Returns the schema of the first ResponseSchema matching the given URL, if any.
+func (o Options) ResponseSchemaFor(url string) *JSONSchema {
+	for _, rs := range o.ResponseSchemas {
+		if rs.Match != nil && rs.Match.MatchString(url) {
+			return rs.Schema
+		}
+	}
+	return nil
+}
+
+// Value that tags over their TagCardinalityLimits are bucketed into.
+const TagOverflowValue = "__other__"
+
+// Returns an error if any of the modes in a GaugeReset option is unknown.
+func ValidateGaugeReset(modes map[string]string) error {
+	for name, mode := range modes {
+		switch mode {
+		case stats.GaugeResetIteration, stats.GaugeResetInterval:
+		default:
+			return errors.Errorf("unknown gauge reset mode for %s: %s", name, mode)
+		}
+	}
+	return nil
+}
+
+// Returns an error if the given list of ALPN protocols can't be offered in a TLS handshake.
+func ValidateTLSNextProtos(protos []string) error {
+	for i, proto := range protos {
+		if proto == "" || len(proto) > 255 {
+			return errors.Errorf("invalid ALPN protocol #%d: %q", i, proto)
+		}
+	}
+	return nil
+}
+
+// Versions for the HTTPVersion and HostHTTPVersions options.
+const (
+	HTTPVersion11 = "1.1"
+	HTTPVersion2  = "2"
+)
+
+// Returns an error if the given HTTP version is unknown. An empty one means the default.
+func ValidateHTTPVersion(version string) error {
+	switch version {
+	case "", HTTPVersion11, HTTPVersion2:
+		return nil
+	default:
+		return errors.Errorf("unknown HTTP version: %s", version)
+	}
+}
+
+// Policies for when the in-flight sample queue is full.
+const (
+	InFlightSamplesBlock = "block"
+	InFlightSamplesDrop  = "drop"
+)
+
+// Policies for the MemoryLimitPolicy option.
+const (
+	MemoryLimitShedVUs = "shed-vus"
+	MemoryLimitWarn    = "warn"
+	MemoryLimitAbort   = "abort"
+)
+
+// Returns an error if the given MemoryLimitPolicy is unknown. An empty one means the default.
+func ValidateMemoryLimitPolicy(policy string) error {
+	switch policy {
+	case "", MemoryLimitShedVUs, MemoryLimitWarn, MemoryLimitAbort:
+		return nil
+	default:
+		return errors.Errorf("unknown memory limit policy: %s", policy)
+	}
+}
+
+// Windows for the PercentileWindow option.
+const (
+	PercentileWindowCumulative = "cumulative"
+	PercentileWindowInterval   = "interval"
+)
+
+// Returns an error if the given PercentileWindow is unknown. An empty one means the default.
+func ValidatePercentileWindow(window string) error {
+	switch window {
+	case "", PercentileWindowCumulative, PercentileWindowInterval:
+		return nil
+	default:
+		return errors.Errorf("unknown percentile window: %s", window)
+	}
+}
+
+// Percentiles sent every PercentileInterval, and the suffixes of the gauges they're sent as.
+var PeriodicPercentiles = []struct {
+	Pct    float64
+	Suffix string
+}{
+	{0.50, "_p50"},
+	{0.90, "_p90"},
+	{0.95, "_p95"},
+	{0.99, "_p99"},
+}
+
+// Policies for the VUsMaxPolicy option.
+const (
+	VUsMaxClamp = "clamp"
+	VUsMaxError = "error"
+)
+
+// Returns an error if the given VUsMaxPolicy is unknown. An empty one means VUsMaxError.
+func ValidateVUsMaxPolicy(policy string) error {
+	switch policy {
+	case "", VUsMaxClamp, VUsMaxError:
+		return nil
+	default:
+		return errors.Errorf("unknown vus max policy: %s", policy)
+	}
+}
+
+// Modes for the VUCancellation option.
+const (
+	VUCancellationImmediate     = "immediate"
+	VUCancellationFinishRequest = "finish-request"
+)
+
+// Returns an error if the given VUCancellation mode is unknown. An empty one means the default.
+func ValidateVUCancellation(mode string) error {
+	switch mode {
+	case "", VUCancellationImmediate, VUCancellationFinishRequest:
+		return nil
+	default:
+		return errors.Errorf("unknown vu cancellation mode: %s", mode)
+	}
+}
+
+// Formats for the StdoutFormat option.
+const (
+	StdoutFormatText   = "text"
+	StdoutFormatNDJSON = "ndjson"
+)
+
+// Returns an error if the given StdoutFormat is unknown. An empty one means the default.
+func ValidateStdoutFormat(format string) error {
+	switch format {
+	case "", StdoutFormatText, StdoutFormatNDJSON:
+		return nil
+	default:
+		return errors.Errorf("unknown stdout format: %s", format)
+	}
+}
+
+// An output for samples, as given to --out: a type, eg. "influxdb", and its argument, whose
+// meaning depends on the type; eg. a file name or URL.
+type OutputConfig struct {
+	Type string `json:"type"`
+	Arg  string `json:"arg"`
+}
+
+// Returns the output in the same "type=arg" form --out takes.
+func (c OutputConfig) String() string {
+	if c.Arg == "" {
+		return c.Type
+	}
+	return c.Type + "=" + c.Arg
+}
+
+// Placeholder replaced with a BodyData value in request bodies.
+const BodyDataPlaceholder = "{{data}}"
+
+// Substitutes the BodyData value for the given iteration into a request body.
+func (o Options) ExpandBody(body string, iter int64) string {
+	if len(o.BodyData) == 0 || !strings.Contains(body, BodyDataPlaceholder) {
+		return body
+	}
+	value := o.BodyData[iter%int64(len(o.BodyData))]
+	return strings.Replace(body, BodyDataPlaceholder, value, -1)
+}
+
+// Returns the tags from the TagData row for the given iteration, limited to TagDataTags.
+func (o Options) TagDataFor(iter int64) map[string]string {
+	if len(o.TagData) == 0 || len(o.TagDataTags) == 0 {
+		return nil
+	}
+	row := o.TagData[iter%int64(len(o.TagData))]
+	tags := make(map[string]string, len(o.TagDataTags))
+	for _, name := range o.TagDataTags {
+		if v, ok := row[name]; ok {
+			tags[name] = v
+		}
+	}
+	return tags
+}
+
+// System tags that can be turned on and off through the SystemTags option.
+const (
+	SystemTagVU    = "vu"
+	SystemTagGroup = "group"
+)
+
+// System tags attached to samples if the SystemTags option isn't set.
+var DefaultSystemTags = []string{SystemTagGroup}
+
+// Returns an error if any of the given SystemTags is unknown.
+func ValidateSystemTags(tags []string) error {
+	for _, tag := range tags {
+		switch tag {
+		case SystemTagVU, SystemTagGroup:
+		default:
+			return errors.Errorf("unknown system tag: %s", tag)
+		}
+	}
+	return nil
+}
+
+// Checks whether a system tag is enabled, through SystemTags or by default.
+func (o Options) HasSystemTag(tag string) bool {
+	tags := o.SystemTags
+	if tags == nil {
+		tags = DefaultSystemTags
+	}
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// An inclusive range of HTTP response statuses.
+type StatusRange struct {
+	Min, Max int
+}
+
+// Statuses treated as expected when no ExpectedStatuses are given.
+var DefaultExpectedStatuses = []StatusRange{{200, 399}}
+
+// Parses ExpectedStatuses entries, either single statuses ("401") or ranges ("200-399").
+func ParseStatusRanges(specs []string) ([]StatusRange, error) {
+	ranges := make([]StatusRange, 0, len(specs))
+	for _, spec := range specs {
+		minStr, maxStr := spec, spec
+		if i := strings.Index(spec, "-"); i != -1 {
+			minStr, maxStr = spec[:i], spec[i+1:]
+		}
+		min, err := strconv.Atoi(strings.TrimSpace(minStr))
+		if err != nil {
+			return nil, errors.Errorf("invalid status range: %q", spec)
+		}
+		max, err := strconv.Atoi(strings.TrimSpace(maxStr))
+		if err != nil || min < 0 || max < min {
+			return nil, errors.Errorf("invalid status range: %q", spec)
+		}
+		ranges = append(ranges, StatusRange{min, max})
+	}
+	return ranges, nil
+}
+
+// Parses a LocalPortRange, either a single port ("40000") or an inclusive range ("40000-40999").
+func ParsePortRange(spec string) (min, max int, err error) {
+	minStr, maxStr := spec, spec
+	if i := strings.Index(spec, "-"); i != -1 {
+		minStr, maxStr = spec[:i], spec[i+1:]
+	}
+	if min, err = strconv.Atoi(strings.TrimSpace(minStr)); err != nil {
+		return 0, 0, errors.Errorf("invalid port range: %q", spec)
+	}
+	if max, err = strconv.Atoi(strings.TrimSpace(maxStr)); err != nil {
+		return 0, 0, errors.Errorf("invalid port range: %q", spec)
+	}
+	if min < 1 || max > 65535 || max < min {
+		return 0, 0, errors.Errorf("invalid port range: %q", spec)
+	}
+	return min, max, nil
+}
+
+// Looks up the addresses of a BindInterface, ignoring ones that can't be bound to, like
+// link-local IPv6 addresses, which also need a zone.
+func InterfaceIPs(name string) ([]net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, errors.Errorf("unknown network interface %q", name)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't get the addresses of network interface %q", name)
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			ips = append(ips, ip4)
+		} else if !ipNet.IP.IsLinkLocalUnicast() {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, errors.Errorf("network interface %q has no addresses", name)
+	}
+	return ips, nil
+}
+
+// Checks whether a response status is expected according to ranges parsed from ExpectedStatuses,
+// or DefaultExpectedStatuses if there are none.
+func StatusExpected(ranges []StatusRange, status int) bool {
+	if len(ranges) == 0 {
+		ranges = DefaultExpectedStatuses
+	}
+	for _, r := range ranges {
+		if status >= r.Min && status <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks whether a redirect to the given URL is allowed by a RedirectAllowlist.
+func RedirectAllowed(allowlist []string, u *url.URL) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+
+	host := u.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, entry := range allowlist {
+		pattern := entry
+		if i := strings.Index(entry, "://"); i != -1 {
+			if !strings.EqualFold(entry[:i], u.Scheme) {
+				continue
+			}
+			pattern = entry[i+3:]
+		}
+		if pattern == "" || MatchHost(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks whether cookie jars should store and send cookies for the given hostname, according to
+// CookieJarHosts and CookieJarExcludeHosts.
+func (o Options) CookieJarAllowed(host string) bool {
+	if _, ok := BestHostMatch(o.CookieJarExcludeHosts, host); ok {
+		return false
+	}
+	if o.CookieJarHosts == nil {
+		return true
+	}
+	_, ok := BestHostMatch(o.CookieJarHosts, host)
+	return ok
+}
+
+// Options are (de)serialised to/from YAML through their JSON representation, so that field
+// names and custom types (TLS versions, durations, thresholds, etc.) behave the same in both.
+func (o Options) MarshalYAML() (interface{}, error) {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	// Leave out unset fields; they unmarshal to the same thing anyway.
+	for k, v := range fields {
+		if v == nil {
+			delete(fields, k)
+		}
+	}
+	return fields, nil
+}
+
+func (o *Options) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var fields map[string]interface{}
+	if err := unmarshal(&fields); err != nil {
+		return err
+	}
+	data, err := json.Marshal(yamlToJSONValue(fields))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, o)
+}
+
+// YAML decodes mappings as map[interface{}]interface{}, which encoding/json can't marshal.
+func yamlToJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = yamlToJSONValue(val)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[k] = yamlToJSONValue(val)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, val := range v {
+			s[i] = yamlToJSONValue(val)
+		}
+		return s
+	default:
+		return v
 	}
-	return o
 }
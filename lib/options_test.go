@@ -21,18 +21,33 @@
 package lib
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
 	"net"
+	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	"github.com/loadimpact/k6/stats"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/guregu/null.v3"
+	"gopkg.in/yaml.v2"
 )
 
 func TestOptions(t *testing.T) {
@@ -41,6 +56,75 @@ func TestOptions(t *testing.T) {
 		assert.True(t, opts.Paused.Valid)
 		assert.True(t, opts.Paused.Bool)
 	})
+	t.Run("PauseSignal", func(t *testing.T) {
+		opts := Options{}.Apply(Options{PauseSignal: null.BoolFrom(true)})
+		assert.True(t, opts.PauseSignal.Valid)
+		assert.True(t, opts.PauseSignal.Bool)
+	})
+	t.Run("Outputs", func(t *testing.T) {
+		outputs := []OutputConfig{{Type: "json", Arg: "out.json"}, {Type: "influxdb"}}
+		opts := Options{}.Apply(Options{Outputs: outputs})
+		assert.Equal(t, outputs, opts.Outputs)
+		assert.Equal(t, "json=out.json", opts.Outputs[0].String())
+		assert.Equal(t, "influxdb", opts.Outputs[1].String())
+
+		var parsed Options
+		assert.NoError(t, json.Unmarshal([]byte(`{"outputs":[{"type":"json","arg":"out.json"},{"type":"influxdb"}]}`), &parsed))
+		assert.Equal(t, outputs, parsed.Outputs)
+	})
+	t.Run("RunMarkers", func(t *testing.T) {
+		opts := Options{}.Apply(Options{RunMarkers: null.BoolFrom(true), RunID: null.StringFrom("abc")})
+		assert.Equal(t, null.BoolFrom(true), opts.RunMarkers)
+		assert.Equal(t, null.StringFrom("abc"), opts.RunID)
+	})
+	t.Run("StdoutFormat", func(t *testing.T) {
+		opts := Options{}.Apply(Options{StdoutFormat: null.StringFrom(StdoutFormatNDJSON)})
+		assert.Equal(t, null.StringFrom(StdoutFormatNDJSON), opts.StdoutFormat)
+
+		for _, format := range []string{"", StdoutFormatText, StdoutFormatNDJSON} {
+			assert.NoError(t, ValidateStdoutFormat(format), format)
+		}
+		assert.EqualError(t, ValidateStdoutFormat("csv"), "unknown stdout format: csv")
+	})
+	t.Run("VUWarmup", func(t *testing.T) {
+		warmup := &VUWarmup{Exec: "warmup", Requests: []ReplayRequest{{URL: "https://example.com/"}}}
+		opts := Options{}.Apply(Options{VUWarmup: warmup})
+		assert.Equal(t, warmup, opts.VUWarmup)
+
+		var parsed Options
+		assert.NoError(t, json.Unmarshal([]byte(`{"vuWarmup": {
+			"exec": "warmup",
+			"requests": [{"method": "POST", "url": "https://example.com/login", "body": "hi"}]
+		}}`), &parsed))
+		assert.Equal(t, &VUWarmup{Exec: "warmup", Requests: []ReplayRequest{
+			{Method: "POST", URL: "https://example.com/login", Body: "hi"},
+		}}, parsed.VUWarmup)
+	})
+	t.Run("VUCancellation", func(t *testing.T) {
+		opts := Options{}.Apply(Options{VUCancellation: null.StringFrom(VUCancellationFinishRequest)})
+		assert.Equal(t, null.StringFrom(VUCancellationFinishRequest), opts.VUCancellation)
+
+		for _, mode := range []string{"", VUCancellationImmediate, VUCancellationFinishRequest} {
+			assert.NoError(t, ValidateVUCancellation(mode), mode)
+		}
+		assert.EqualError(t, ValidateVUCancellation("never"), "unknown vu cancellation mode: never")
+	})
+	t.Run("APIAddress", func(t *testing.T) {
+		opts := Options{}.Apply(Options{APIAddress: null.StringFrom("127.0.0.1:6566")})
+		assert.Equal(t, null.StringFrom("127.0.0.1:6566"), opts.APIAddress)
+
+		for _, addr := range []string{"", "localhost:6565", ":0", "[::1]:6565"} {
+			assert.NoError(t, ValidateAPIAddress(addr), addr)
+		}
+		for _, addr := range []string{"localhost", "localhost:http", "localhost:70000"} {
+			assert.EqualError(t, ValidateAPIAddress(addr), fmt.Sprintf("invalid API address: %q", addr))
+		}
+	})
+	t.Run("DryRun", func(t *testing.T) {
+		opts := Options{}.Apply(Options{DryRun: null.BoolFrom(true)})
+		assert.True(t, opts.DryRun.Valid)
+		assert.True(t, opts.DryRun.Bool)
+	})
 	t.Run("VUs", func(t *testing.T) {
 		opts := Options{}.Apply(Options{VUs: null.IntFrom(12345)})
 		assert.True(t, opts.VUs.Valid)
@@ -51,11 +135,41 @@ func TestOptions(t *testing.T) {
 		assert.True(t, opts.VUsMax.Valid)
 		assert.Equal(t, int64(12345), opts.VUsMax.Int64)
 	})
+	t.Run("VUsMaxPolicy", func(t *testing.T) {
+		opts := Options{}.Apply(Options{VUsMaxPolicy: null.StringFrom(VUsMaxClamp)})
+		assert.Equal(t, null.StringFrom("clamp"), opts.VUsMaxPolicy)
+		assert.NoError(t, ValidateVUsMaxPolicy(opts.VUsMaxPolicy.String))
+		assert.EqualError(t, ValidateVUsMaxPolicy("ignore"), "unknown vus max policy: ignore")
+	})
+	t.Run("MemoryLimit", func(t *testing.T) {
+		opts := Options{}.Apply(Options{
+			MemoryLimit:       null.IntFrom(1 << 30),
+			MemoryLimitPolicy: null.StringFrom(MemoryLimitWarn),
+		})
+		assert.Equal(t, null.IntFrom(1<<30), opts.MemoryLimit)
+		assert.Equal(t, null.StringFrom("warn"), opts.MemoryLimitPolicy)
+		assert.NoError(t, ValidateMemoryLimitPolicy(opts.MemoryLimitPolicy.String))
+		assert.EqualError(t, ValidateMemoryLimitPolicy("swap"), "unknown memory limit policy: swap")
+	})
+	t.Run("VUInitConcurrency", func(t *testing.T) {
+		opts := Options{}.Apply(Options{VUInitConcurrency: null.IntFrom(4)})
+		assert.Equal(t, null.IntFrom(4), opts.VUInitConcurrency)
+	})
+	t.Run("PreAllocatedVUs", func(t *testing.T) {
+		opts := Options{}.Apply(Options{PreAllocatedVUs: null.IntFrom(123)})
+		assert.True(t, opts.PreAllocatedVUs.Valid)
+		assert.Equal(t, int64(123), opts.PreAllocatedVUs.Int64)
+	})
 	t.Run("Duration", func(t *testing.T) {
 		opts := Options{}.Apply(Options{Duration: NullDurationFrom(2 * time.Minute)})
 		assert.True(t, opts.Duration.Valid)
 		assert.Equal(t, "2m0s", opts.Duration.String())
 	})
+	t.Run("EndTime", func(t *testing.T) {
+		end := time.Date(2018, 3, 1, 12, 30, 0, 0, time.UTC)
+		opts := Options{}.Apply(Options{EndTime: NullTimeFrom(end)})
+		assert.Equal(t, NullTimeFrom(end), opts.EndTime)
+	})
 	t.Run("Iterations", func(t *testing.T) {
 		opts := Options{}.Apply(Options{Iterations: null.IntFrom(1234)})
 		assert.True(t, opts.Iterations.Valid)
@@ -67,11 +181,472 @@ func TestOptions(t *testing.T) {
 		assert.Len(t, opts.Stages, 1)
 		assert.Equal(t, 1*time.Second, time.Duration(opts.Stages[0].Duration.Duration))
 	})
+	t.Run("StagesFile", func(t *testing.T) {
+		opts := Options{}.Apply(Options{StagesFile: null.StringFrom("stages.csv")})
+		assert.True(t, opts.StagesFile.Valid)
+		assert.Equal(t, "stages.csv", opts.StagesFile.String)
+
+		t.Run("Override", func(t *testing.T) {
+			opts := opts.Apply(Options{Stages: []Stage{{Duration: NullDurationFrom(1 * time.Second)}}})
+			assert.Len(t, opts.Stages, 1)
+			assert.False(t, opts.StagesFile.Valid)
+
+			opts = opts.Apply(Options{StagesFile: null.StringFrom("other.csv")})
+			assert.Nil(t, opts.Stages)
+			assert.Equal(t, null.StringFrom("other.csv"), opts.StagesFile)
+		})
+	})
+	t.Run("StageShuffle", func(t *testing.T) {
+		shuffle := &StageShuffle{Seed: 1, PinFirst: true}
+		opts := Options{}.Apply(Options{StageShuffle: shuffle})
+		assert.Equal(t, shuffle, opts.StageShuffle)
+
+		var stages []Stage
+		for i := int64(0); i < 10; i++ {
+			stages = append(stages, Stage{Target: null.IntFrom(i)})
+		}
+		targets := func(stages []Stage) (targets []int64) {
+			for _, stage := range stages {
+				targets = append(targets, stage.Target.Int64)
+			}
+			return targets
+		}
+
+		shuffled := shuffle.Shuffle(stages)
+		assert.Equal(t, targets(shuffled), targets(shuffle.Shuffle(stages)))
+		assert.NotEqual(t, targets(stages), targets(shuffled))
+		assert.ElementsMatch(t, targets(stages), targets(shuffled))
+		assert.Equal(t, int64(0), shuffled[0].Target.Int64)
+		assert.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, targets(stages))
+
+		pinned := StageShuffle{Seed: 1, PinFirst: true, PinLast: true}.Shuffle(stages)
+		assert.Equal(t, int64(0), pinned[0].Target.Int64)
+		assert.Equal(t, int64(9), pinned[9].Target.Int64)
+
+		assert.Empty(t, StageShuffle{PinFirst: true, PinLast: true}.Shuffle(nil))
+		assert.Len(t, StageShuffle{PinFirst: true, PinLast: true}.Shuffle(stages[:1]), 1)
+	})
+	t.Run("BodyFile", func(t *testing.T) {
+		opts := Options{}.Apply(Options{BodyFile: null.StringFrom("upload.bin")})
+		assert.Equal(t, null.StringFrom("upload.bin"), opts.BodyFile)
+	})
+	t.Run("BodyData", func(t *testing.T) {
+		opts := Options{}.Apply(Options{BodyData: []string{"a", "b"}})
+		assert.Equal(t, []string{"a", "b"}, opts.BodyData)
+
+		assert.Equal(t, `{"name":"a"}`, opts.ExpandBody(`{"name":"{{data}}"}`, 0))
+		assert.Equal(t, `{"name":"b"}`, opts.ExpandBody(`{"name":"{{data}}"}`, 1))
+		assert.Equal(t, `{"name":"a","again":"a"}`, opts.ExpandBody(`{"name":"{{data}}","again":"{{data}}"}`, 2))
+		assert.Equal(t, `{"name":"{{data}}"}`, Options{}.ExpandBody(`{"name":"{{data}}"}`, 0))
+
+		t.Run("Override", func(t *testing.T) {
+			opts := opts.Apply(Options{BodyDataFile: null.StringFrom("data.txt")})
+			assert.Nil(t, opts.BodyData)
+			assert.Equal(t, null.StringFrom("data.txt"), opts.BodyDataFile)
+
+			opts = opts.Apply(Options{BodyData: []string{"c"}})
+			assert.Equal(t, []string{"c"}, opts.BodyData)
+			assert.False(t, opts.BodyDataFile.Valid)
+		})
+	})
+	t.Run("TagData", func(t *testing.T) {
+		rows := []map[string]string{{"customer_tier": "gold", "id": "1"}, {"customer_tier": "free", "id": "2"}}
+		opts := Options{}.Apply(Options{TagData: rows, TagDataTags: []string{"customer_tier", "missing"}})
+		assert.Equal(t, rows, opts.TagData)
+		assert.Equal(t, []string{"customer_tier", "missing"}, opts.TagDataTags)
+
+		assert.Equal(t, map[string]string{"customer_tier": "gold"}, opts.TagDataFor(0))
+		assert.Equal(t, map[string]string{"customer_tier": "free"}, opts.TagDataFor(1))
+		assert.Equal(t, map[string]string{"customer_tier": "gold"}, opts.TagDataFor(2))
+		assert.Nil(t, Options{TagData: rows}.TagDataFor(0))
+		assert.Nil(t, Options{}.TagDataFor(0))
+
+		t.Run("Override", func(t *testing.T) {
+			opts := opts.Apply(Options{TagDataFile: null.StringFrom("tags.csv")})
+			assert.Nil(t, opts.TagData)
+			assert.Equal(t, null.StringFrom("tags.csv"), opts.TagDataFile)
+
+			opts = opts.Apply(Options{TagData: rows[:1]})
+			assert.Equal(t, rows[:1], opts.TagData)
+			assert.False(t, opts.TagDataFile.Valid)
+		})
+	})
+	t.Run("Replay", func(t *testing.T) {
+		reqs := []ReplayRequest{{Offset: Duration(1 * time.Second), Method: "GET", URL: "https://example.com/"}}
+		opts := Options{}.Apply(Options{Replay: reqs})
+		assert.Equal(t, reqs, opts.Replay)
+
+		t.Run("Override", func(t *testing.T) {
+			opts := opts.Apply(Options{ReplayFile: null.StringFrom("replay.jsonl")})
+			assert.Nil(t, opts.Replay)
+			assert.Equal(t, null.StringFrom("replay.jsonl"), opts.ReplayFile)
+
+			opts = opts.Apply(Options{Replay: reqs})
+			assert.Equal(t, reqs, opts.Replay)
+			assert.False(t, opts.ReplayFile.Valid)
+		})
+	})
+	t.Run("HostUserAgents", func(t *testing.T) {
+		opts := Options{}.Apply(Options{
+			UserAgent:      null.StringFrom("default"),
+			HostUserAgents: map[string]string{"*.example.com": "wildcard", "api.example.com": "api"},
+		})
+		assert.Equal(t, null.StringFrom("api"), opts.UserAgentFor("api.example.com"))
+		assert.Equal(t, null.StringFrom("wildcard"), opts.UserAgentFor("www.example.com"))
+		assert.Equal(t, null.StringFrom("default"), opts.UserAgentFor("example.org"))
+	})
+	t.Run("HostRequestTimeouts", func(t *testing.T) {
+		assert.Equal(t, DefaultRequestTimeout, Options{}.RequestTimeoutFor("example.com"))
+
+		opts := Options{}.Apply(Options{
+			RequestTimeout:      NullDurationFrom(10 * time.Second),
+			HostRequestTimeouts: map[string]Duration{"*.example.com": Duration(time.Minute)},
+		})
+		assert.Equal(t, time.Minute, opts.RequestTimeoutFor("analytics.example.com"))
+		assert.Equal(t, 10*time.Second, opts.RequestTimeoutFor("example.org"))
+
+		var fromJSON Options
+		assert.NoError(t, json.Unmarshal([]byte(`{"hostRequestTimeouts":{"example.com":"2m"}}`), &fromJSON))
+		assert.Equal(t, 2*time.Minute, fromJSON.RequestTimeoutFor("example.com"))
+	})
+	t.Run("HostHTTPVersions", func(t *testing.T) {
+		opts := Options{}.Apply(Options{
+			HTTPVersion:      null.StringFrom("2"),
+			HostHTTPVersions: map[string]string{"*.example.com": "1.1", "api.example.com": ""},
+		})
+		assert.Equal(t, "", opts.HTTPVersionFor("api.example.com"))
+		assert.Equal(t, "1.1", opts.HTTPVersionFor("www.example.com"))
+		assert.Equal(t, "2", opts.HTTPVersionFor("example.org"))
+		assert.NoError(t, ValidateHTTPVersion(opts.HTTPVersion.String))
+		assert.EqualError(t, ValidateHTTPVersion("h2"), "unknown HTTP version: h2")
+	})
+	t.Run("CookieJarHosts", func(t *testing.T) {
+		opts := Options{}.Apply(Options{
+			CookieJarHosts:        []string{"*.example.com"},
+			CookieJarExcludeHosts: []string{"ads.example.com"},
+		})
+		assert.True(t, opts.CookieJarAllowed("www.example.com"))
+		assert.False(t, opts.CookieJarAllowed("ads.example.com"))
+		assert.False(t, opts.CookieJarAllowed("example.org"))
+		assert.True(t, Options{}.CookieJarAllowed("example.org"))
+	})
+	t.Run("SummaryMetrics", func(t *testing.T) {
+		opts := Options{}.Apply(Options{SummaryMetrics: []string{"http_req_duration", "checks"}})
+		assert.Equal(t, []string{"http_req_duration", "checks"}, opts.SummaryMetrics)
+	})
+	t.Run("SummaryPerStage", func(t *testing.T) {
+		opts := Options{}.Apply(Options{SummaryPerStage: null.BoolFrom(true)})
+		assert.True(t, opts.SummaryPerStage.Valid)
+		assert.True(t, opts.SummaryPerStage.Bool)
+	})
+	t.Run("PercentileInterval", func(t *testing.T) {
+		opts := Options{}.Apply(Options{
+			PercentileInterval: NullDurationFrom(10 * time.Second),
+			PercentileWindow:   null.StringFrom(PercentileWindowInterval),
+		})
+		assert.Equal(t, NullDurationFrom(10*time.Second), opts.PercentileInterval)
+		assert.Equal(t, null.StringFrom("interval"), opts.PercentileWindow)
+		assert.NoError(t, ValidatePercentileWindow(opts.PercentileWindow.String))
+		assert.EqualError(t, ValidatePercentileWindow("sliding"), "unknown percentile window: sliding")
+	})
+	t.Run("SummaryTrendPrecision", func(t *testing.T) {
+		opts := Options{}.Apply(Options{SummaryTrendPrecision: null.IntFrom(3)})
+		assert.Equal(t, null.IntFrom(3), opts.SummaryTrendPrecision)
+	})
+	t.Run("HistogramExport", func(t *testing.T) {
+		opts := Options{}.Apply(Options{HistogramExport: null.StringFrom("histograms.json")})
+		assert.Equal(t, null.StringFrom("histograms.json"), opts.HistogramExport)
+	})
+	t.Run("ConnResetRetries", func(t *testing.T) {
+		opts := Options{}.Apply(Options{ConnResetRetries: null.IntFrom(2)})
+		assert.Equal(t, null.IntFrom(2), opts.ConnResetRetries)
+	})
+	t.Run("TestEndDeadline", func(t *testing.T) {
+		opts := Options{}.Apply(Options{TestEndDeadline: null.BoolFrom(true)})
+		assert.Equal(t, null.BoolFrom(true), opts.TestEndDeadline)
+	})
+	t.Run("Prewarm", func(t *testing.T) {
+		opts := Options{}.Apply(Options{Prewarm: []string{"example.com"}, PrewarmTLS: null.BoolFrom(true)})
+		assert.Equal(t, []string{"example.com"}, opts.Prewarm)
+		assert.Equal(t, null.BoolFrom(true), opts.PrewarmTLS)
+
+		t.Run("Addrs", func(t *testing.T) {
+			opts := Options{
+				Prewarm: []string{"example.com", "*", "example.com:8443", "[::1]"},
+				Hosts: map[string]net.IP{
+					"test.k6.io":  net.ParseIP("127.0.0.1"),
+					"example.com": net.ParseIP("127.0.0.2"),
+				},
+			}
+			assert.Equal(t, []string{"example.com:80", "test.k6.io:80", "example.com:8443", "[::1]:80"}, opts.PrewarmAddrs())
+
+			opts.PrewarmTLS = null.BoolFrom(true)
+			assert.Equal(t, []string{"example.com:443", "test.k6.io:443", "example.com:8443", "[::1]:443"}, opts.PrewarmAddrs())
+		})
+	})
+	t.Run("DNSRetries", func(t *testing.T) {
+		opts := Options{}.Apply(Options{
+			DNSRetries:      null.IntFrom(3),
+			DNSRetryBackoff: NullDurationFrom(1 * time.Second),
+		})
+		assert.Equal(t, null.IntFrom(3), opts.DNSRetries)
+		assert.Equal(t, NullDurationFrom(1*time.Second), opts.DNSRetryBackoff)
+	})
+	t.Run("NetworkConditions", func(t *testing.T) {
+		nc := &NetworkConditions{Latency: Duration(100 * time.Millisecond), Jitter: Duration(20 * time.Millisecond), Loss: 0.01, Seed: 1}
+		opts := Options{}.Apply(Options{NetworkConditions: nc})
+		assert.Equal(t, nc, opts.NetworkConditions)
+
+		t.Run("JSON", func(t *testing.T) {
+			var opts Options
+			jsonStr := `{"networkConditions":{"latency":"100ms","jitter":"20ms","loss":0.01,"seed":1}}`
+			assert.NoError(t, json.Unmarshal([]byte(jsonStr), &opts))
+			assert.Equal(t, nc, opts.NetworkConditions)
+
+			t.Run("Invalid", func(t *testing.T) {
+				var opts Options
+				assert.EqualError(t,
+					json.Unmarshal([]byte(`{"networkConditions":{"jitter":"-1s"}}`), &opts),
+					"network latency and jitter can't be negative",
+				)
+				assert.EqualError(t,
+					json.Unmarshal([]byte(`{"networkConditions":{"loss":1}}`), &opts),
+					"network loss must be at least 0 and less than 1, not 1",
+				)
+			})
+		})
+	})
+	t.Run("MaxCPUs", func(t *testing.T) {
+		opts := Options{}.Apply(Options{MaxCPUs: null.IntFrom(2)})
+		assert.True(t, opts.MaxCPUs.Valid)
+		assert.Equal(t, int64(2), opts.MaxCPUs.Int64)
+	})
+	t.Run("HARExport", func(t *testing.T) {
+		har := &HARExport{Path: "out.har", Sampling: 0.5, IncludeBodies: true}
+		opts := Options{}.Apply(Options{HARExport: har})
+		assert.Equal(t, har, opts.HARExport)
+	})
+	t.Run("RequestSigning", func(t *testing.T) {
+		signing := &RequestSigning{Algorithm: RequestSigningAWSSigV4, Region: "us-east-1", Service: "s3"}
+		opts := Options{}.Apply(Options{RequestSigning: signing})
+		assert.Equal(t, signing, opts.RequestSigning)
+	})
+	t.Run("Tracing", func(t *testing.T) {
+		tracing := &Tracing{Propagators: []string{TracingW3C, TracingB3}, Sampling: 0.5}
+		opts := Options{}.Apply(Options{Tracing: tracing})
+		assert.Equal(t, tracing, opts.Tracing)
+
+		t.Run("JSON", func(t *testing.T) {
+			var tracing Tracing
+			assert.NoError(t, json.Unmarshal([]byte(`{"propagators":["b3"]}`), &tracing))
+			assert.Equal(t, Tracing{Propagators: []string{TracingB3}, Sampling: 1}, tracing)
+
+			assert.NoError(t, json.Unmarshal([]byte(`{"exemplars":true}`), &tracing))
+			assert.Equal(t, Tracing{Sampling: 1, Exemplars: true}, tracing)
+		})
+		t.Run("Invalid", func(t *testing.T) {
+			var tracing Tracing
+			assert.EqualError(t, json.Unmarshal([]byte(`{"propagators":["jaeger"]}`), &tracing), "unknown tracing propagator: jaeger")
+			assert.EqualError(t, json.Unmarshal([]byte(`{"sampling":2}`), &tracing), "tracing sampling must be between 0 and 1, not 2")
+		})
+	})
+	t.Run("RedirectAllowlist", func(t *testing.T) {
+		opts := Options{}.Apply(Options{RedirectAllowlist: []string{"https://example.com"}})
+		assert.Equal(t, []string{"https://example.com"}, opts.RedirectAllowlist)
+	})
+	t.Run("RedirectSensitiveHeaders", func(t *testing.T) {
+		opts := Options{}.Apply(Options{RedirectSensitiveHeaders: null.BoolFrom(true)})
+		assert.True(t, opts.RedirectSensitiveHeaders.Valid)
+		assert.True(t, opts.RedirectSensitiveHeaders.Bool)
+	})
+	t.Run("CaptureTrailers", func(t *testing.T) {
+		opts := Options{}.Apply(Options{CaptureTrailers: null.BoolFrom(true)})
+		assert.True(t, opts.CaptureTrailers.Valid)
+		assert.True(t, opts.CaptureTrailers.Bool)
+	})
+	t.Run("TrailerTags", func(t *testing.T) {
+		opts := Options{}.Apply(Options{TrailerTags: []string{"grpc-status"}})
+		assert.Equal(t, []string{"grpc-status"}, opts.TrailerTags)
+	})
+	t.Run("GzipMultipart", func(t *testing.T) {
+		opts := Options{}.Apply(Options{GzipMultipart: null.BoolFrom(true)})
+		assert.True(t, opts.GzipMultipart.Valid)
+		assert.True(t, opts.GzipMultipart.Bool)
+	})
+	t.Run("KeepCompressedBody", func(t *testing.T) {
+		opts := Options{}.Apply(Options{KeepCompressedBody: null.BoolFrom(true)})
+		assert.True(t, opts.KeepCompressedBody.Valid)
+		assert.True(t, opts.KeepCompressedBody.Bool)
+	})
+	t.Run("MaxIterationsPerVU", func(t *testing.T) {
+		opts := Options{}.Apply(Options{MaxIterationsPerVU: null.IntFrom(10)})
+		assert.True(t, opts.MaxIterationsPerVU.Valid)
+		assert.Equal(t, int64(10), opts.MaxIterationsPerVU.Int64)
+	})
+	t.Run("PaceIterations", func(t *testing.T) {
+		opts := Options{}.Apply(Options{PaceIterations: null.BoolFrom(true)})
+		assert.True(t, opts.PaceIterations.Valid)
+		assert.True(t, opts.PaceIterations.Bool)
+	})
+	t.Run("WarmupDuration", func(t *testing.T) {
+		opts := Options{}.Apply(Options{WarmupDuration: NullDurationFrom(10 * time.Second)})
+		assert.True(t, opts.WarmupDuration.Valid)
+		assert.Equal(t, "10s", opts.WarmupDuration.String())
+	})
+	t.Run("VUStartJitter", func(t *testing.T) {
+		jitter := &VUStartJitter{Distribution: JitterExponential, Duration: Duration(1 * time.Second), Seed: 1}
+		opts := Options{}.Apply(Options{VUStartJitter: jitter})
+		assert.Equal(t, jitter, opts.VUStartJitter)
+
+		t.Run("JSON", func(t *testing.T) {
+			var opts Options
+			jsonStr := `{"vuStartJitter":{"distribution":"exponential","duration":"1s","seed":1}}`
+			assert.NoError(t, json.Unmarshal([]byte(jsonStr), &opts))
+			assert.Equal(t, jitter, opts.VUStartJitter)
+
+			t.Run("Invalid", func(t *testing.T) {
+				var opts Options
+				assert.EqualError(t,
+					json.Unmarshal([]byte(`{"vuStartJitter":{"distribution":"gaussian"}}`), &opts),
+					"unknown jitter distribution: gaussian",
+				)
+				assert.EqualError(t,
+					json.Unmarshal([]byte(`{"vuStartJitter":{"duration":"-1s"}}`), &opts),
+					"jitter duration can't be negative",
+				)
+			})
+		})
+		t.Run("Delay", func(t *testing.T) {
+			uniform := VUStartJitter{Duration: Duration(1 * time.Second), Seed: 1}
+			r1, r2 := uniform.NewRand(), uniform.NewRand()
+			for i := 0; i < 100; i++ {
+				d := uniform.Delay(r1)
+				assert.True(t, d >= 0 && d < 1*time.Second, "delay out of range: %s", d)
+				assert.Equal(t, d, uniform.Delay(r2), "delays aren't reproducible")
+			}
+		})
+	})
+	t.Run("ThinkTime", func(t *testing.T) {
+		think := &ThinkTime{Distribution: ThinkTimeNormal, Mean: Duration(1 * time.Second), StdDev: Duration(200 * time.Millisecond), Seed: 1}
+		opts := Options{}.Apply(Options{ThinkTime: think})
+		assert.Equal(t, think, opts.ThinkTime)
+
+		t.Run("JSON", func(t *testing.T) {
+			var opts Options
+			jsonStr := `{"thinkTime":{"distribution":"normal","mean":"1s","stdDev":"200ms","seed":1}}`
+			assert.NoError(t, json.Unmarshal([]byte(jsonStr), &opts))
+			assert.Equal(t, think, opts.ThinkTime)
+
+			t.Run("Invalid", func(t *testing.T) {
+				var opts Options
+				assert.EqualError(t,
+					json.Unmarshal([]byte(`{"thinkTime":{"distribution":"poisson"}}`), &opts),
+					"unknown think time distribution: poisson",
+				)
+				assert.EqualError(t,
+					json.Unmarshal([]byte(`{"thinkTime":{"mean":"-1s"}}`), &opts),
+					"think time parameters can't be negative",
+				)
+				assert.EqualError(t,
+					json.Unmarshal([]byte(`{"thinkTime":{"min":"2s","max":"1s"}}`), &opts),
+					"think time max can't be less than min",
+				)
+			})
+		})
+		t.Run("Delay", func(t *testing.T) {
+			testdata := map[string]ThinkTime{
+				"uniform":     {Min: Duration(1 * time.Second), Max: Duration(2 * time.Second), Seed: 1},
+				"normal":      {Distribution: ThinkTimeNormal, Min: Duration(1 * time.Second), Max: Duration(2 * time.Second), Mean: Duration(1500 * time.Millisecond), StdDev: Duration(1 * time.Second), Seed: 1},
+				"exponential": {Distribution: ThinkTimeExponential, Min: Duration(1 * time.Second), Max: Duration(2 * time.Second), Mean: Duration(1 * time.Second), Seed: 1},
+			}
+			for name, think := range testdata {
+				t.Run(name, func(t *testing.T) {
+					r1, r2 := think.NewRand(), think.NewRand()
+					for i := 0; i < 100; i++ {
+						d := think.Delay(r1)
+						assert.True(t, d >= 1*time.Second && d <= 2*time.Second, "delay out of range: %s", d)
+						assert.Equal(t, d, think.Delay(r2), "delays aren't reproducible")
+					}
+				})
+			}
+		})
+	})
+	t.Run("MethodRPS", func(t *testing.T) {
+		opts := Options{}.Apply(Options{MethodRPS: map[string]int64{"GET": 1000, "POST": 100}})
+		assert.Equal(t, map[string]int64{"GET": 1000, "POST": 100}, opts.MethodRPS)
+
+		t.Run("JSON", func(t *testing.T) {
+			var opts Options
+			assert.NoError(t, json.Unmarshal([]byte(`{"methodRPS":{"get":10}}`), &opts))
+			assert.Equal(t, map[string]int64{"get": 10}, opts.MethodRPS)
+		})
+	})
+	t.Run("ConnRatePerSec", func(t *testing.T) {
+		opts := Options{}.Apply(Options{ConnRatePerSec: null.IntFrom(50)})
+		assert.True(t, opts.ConnRatePerSec.Valid)
+		assert.Equal(t, int64(50), opts.ConnRatePerSec.Int64)
+	})
+	t.Run("LimiterWaitMetric", func(t *testing.T) {
+		opts := Options{}.Apply(Options{LimiterWaitMetric: null.BoolFrom(true)})
+		assert.True(t, opts.LimiterWaitMetric.Valid)
+		assert.True(t, opts.LimiterWaitMetric.Bool)
+	})
+	t.Run("LocalPortRange", func(t *testing.T) {
+		opts := Options{}.Apply(Options{LocalPortRange: null.StringFrom("40000-40999")})
+		assert.True(t, opts.LocalPortRange.Valid)
+		assert.Equal(t, "40000-40999", opts.LocalPortRange.String)
+
+		min, max, err := ParsePortRange(opts.LocalPortRange.String)
+		assert.NoError(t, err)
+		assert.Equal(t, [2]int{40000, 40999}, [2]int{min, max})
+		min, max, err = ParsePortRange("40000")
+		assert.NoError(t, err)
+		assert.Equal(t, [2]int{40000, 40000}, [2]int{min, max})
+		for _, spec := range []string{"abc", "40000-", "0-100", "40999-40000", "65000-65536"} {
+			_, _, err := ParsePortRange(spec)
+			assert.EqualError(t, err, fmt.Sprintf("invalid port range: %q", spec))
+		}
+	})
+	t.Run("BindInterface", func(t *testing.T) {
+		opts := Options{}.Apply(Options{BindInterface: null.StringFrom("eth1")})
+		assert.True(t, opts.BindInterface.Valid)
+		assert.Equal(t, "eth1", opts.BindInterface.String)
+
+		_, err := InterfaceIPs("nonexistent0")
+		assert.EqualError(t, err, `unknown network interface "nonexistent0"`)
+
+		ifaces, err := net.Interfaces()
+		if !assert.NoError(t, err) {
+			return
+		}
+		for _, iface := range ifaces {
+			if iface.Flags&net.FlagLoopback == 0 {
+				continue
+			}
+			ips, err := InterfaceIPs(iface.Name)
+			if assert.NoError(t, err) {
+				assert.Contains(t, ips, net.IPv4(127, 0, 0, 1).To4())
+			}
+		}
+	})
 	t.Run("MaxRedirects", func(t *testing.T) {
 		opts := Options{}.Apply(Options{MaxRedirects: null.IntFrom(12345)})
 		assert.True(t, opts.MaxRedirects.Valid)
 		assert.Equal(t, int64(12345), opts.MaxRedirects.Int64)
 	})
+	t.Run("ExpectContinueTimeout", func(t *testing.T) {
+		opts := Options{}.Apply(Options{ExpectContinueTimeout: NullDurationFrom(1 * time.Second)})
+		assert.True(t, opts.ExpectContinueTimeout.Valid)
+		assert.Equal(t, "1s", opts.ExpectContinueTimeout.String())
+	})
+	t.Run("IdleConnTimeout", func(t *testing.T) {
+		opts := Options{}.Apply(Options{IdleConnTimeout: NullDurationFrom(30 * time.Second)})
+		assert.Equal(t, NullDurationFrom(30*time.Second), opts.IdleConnTimeout)
+	})
+	t.Run("MaxResponseHeaderBytes", func(t *testing.T) {
+		opts := Options{}.Apply(Options{MaxResponseHeaderBytes: null.IntFrom(4096)})
+		assert.Equal(t, null.IntFrom(4096), opts.MaxResponseHeaderBytes)
+	})
 	t.Run("InsecureSkipTLSVerify", func(t *testing.T) {
 		opts := Options{}.Apply(Options{InsecureSkipTLSVerify: null.BoolFrom(true)})
 		assert.True(t, opts.InsecureSkipTLSVerify.Valid)
@@ -88,6 +663,58 @@ func TestOptions(t *testing.T) {
 			})
 		}
 	})
+	t.Run("ProxyTLS", func(t *testing.T) {
+		versions := TLSVersions{Min: tls.VersionTLS12, Max: tls.VersionTLS12}
+		suites := TLSCipherSuites{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+		proxyTLS := &ProxyTLS{InsecureSkipVerify: true, Version: &versions, CipherSuites: &suites}
+		opts := Options{}.Apply(Options{ProxyTLS: proxyTLS})
+		assert.Equal(t, proxyTLS, opts.ProxyTLS)
+
+		config := opts.ProxyTLS.Config()
+		assert.True(t, config.InsecureSkipVerify)
+		assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+		assert.Equal(t, uint16(tls.VersionTLS12), config.MaxVersion)
+		assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, config.CipherSuites)
+
+		var parsed Options
+		assert.NoError(t, json.Unmarshal([]byte(`{"proxyTLS":{"insecureSkipVerify":true,"version":"tls1.2"}}`), &parsed))
+		if assert.NotNil(t, parsed.ProxyTLS) {
+			assert.True(t, parsed.ProxyTLS.InsecureSkipVerify)
+			assert.Equal(t, &versions, parsed.ProxyTLS.Version)
+		}
+	})
+	t.Run("TLSFailureMetric", func(t *testing.T) {
+		opts := Options{}.Apply(Options{TLSFailureMetric: null.BoolFrom(true)})
+		assert.True(t, opts.TLSFailureMetric.Valid)
+		assert.True(t, opts.TLSFailureMetric.Bool)
+
+		reasons := map[string]string{
+			"x509: certificate signed by unknown authority":            TLSFailureCertificate,
+			"remote error: tls: bad certificate":                       TLSFailureCertificate,
+			"tls: server selected unsupported protocol version 301":    TLSFailureVersion,
+			"remote error: tls: protocol version not supported":        TLSFailureVersion,
+			"tls: no cipher suite supported by both client and server": TLSFailureCipher,
+			"remote error: tls: handshake failure":                     TLSFailureHandshake,
+			"tls: first record does not look like a TLS handshake":     TLSFailureHandshake,
+		}
+		for msg, reason := range reasons {
+			assert.Equal(t, reason, TLSFailureReason(errors.New(msg)), msg)
+		}
+	})
+	t.Run("TLSMinVersionStrict", func(t *testing.T) {
+		opts := Options{}.Apply(Options{TLSMinVersionStrict: null.BoolFrom(true)})
+		assert.True(t, opts.TLSMinVersionStrict.Valid)
+		assert.True(t, opts.TLSMinVersionStrict.Bool)
+
+		handshakeErr := errors.New("remote error: tls: server selected unsupported protocol version 301")
+		assert.EqualError(t, TLSDowngradeError(handshakeErr, tls.VersionTLS12),
+			"server negotiated tls1.0 below configured minimum tls1.2")
+		assert.NoError(t, TLSDowngradeError(handshakeErr, tls.VersionTLS10))
+		assert.NoError(t, TLSDowngradeError(handshakeErr, 0))
+		assert.EqualError(t, TLSDowngradeError(errors.New("remote error: tls: protocol version not supported"), tls.VersionTLS12),
+			"server only supports versions below configured minimum tls1.2")
+		assert.NoError(t, TLSDowngradeError(errors.New("connection refused"), tls.VersionTLS12))
+	})
 	t.Run("TLSVersion", func(t *testing.T) {
 		versions := TLSVersions{Min: tls.VersionSSL30, Max: tls.VersionTLS12}
 		opts := Options{}.Apply(Options{TLSVersion: &versions})
@@ -134,7 +761,7 @@ func TestOptions(t *testing.T) {
 	})
 	t.Run("TLSAuth", func(t *testing.T) {
 		tlsAuth := []*TLSAuth{
-			{TLSAuthFields{
+			{TLSAuthFields: TLSAuthFields{
 				Domains: []string{"example.com", "*.example.com"},
 				Cert: "-----BEGIN CERTIFICATE-----\n" +
 					"MIIBoTCCAUegAwIBAgIUQl0J1Gkd6U2NIMwMDnpfH8c1myEwCgYIKoZIzj0EAwIw\n" +
@@ -152,8 +779,8 @@ func TestOptions(t *testing.T) {
 					"AwEHoUQDQgAEtp/EQ6YEeTNup33/RVlf/f2o7bJCrYbPl9pF2/LfyS4swJX70dit\n" +
 					"8zHoZgJnNNQirqHxBc6uWBhOLG5RV+Ek1Q==\n" +
 					"-----END EC PRIVATE KEY-----",
-			}, nil},
-			{TLSAuthFields{
+			}},
+			{TLSAuthFields: TLSAuthFields{
 				Domains: []string{"sub.example.com"},
 				Cert: "-----BEGIN CERTIFICATE-----\n" +
 					"MIIBojCCAUegAwIBAgIUWMpVQhmGoLUDd2x6XQYoOOV6C9AwCgYIKoZIzj0EAwIw\n" +
@@ -171,7 +798,7 @@ func TestOptions(t *testing.T) {
 					"AwEHoUQDQgAEF8XzmC7x8Ns0Y2Wyu2c77ge+6I/ghcDTjWOMZzMPmRRDxqKFLuGD\n" +
 					"zW1Kss13WODGSS8+j7dNCPOeLKyK6cbeIg==\n" +
 					"-----END EC PRIVATE KEY-----",
-			}, nil},
+			}},
 		}
 		opts := Options{}.Apply(Options{TLSAuth: tlsAuth})
 		assert.Equal(t, tlsAuth, opts.TLSAuth)
@@ -192,6 +819,256 @@ func TestOptions(t *testing.T) {
 			}
 		})
 	})
+	t.Run("TLSAuthByHost", func(t *testing.T) {
+		_, _, authA := makeTestCert(t, "client A", nil, nil)
+		_, _, authB := makeTestCert(t, "client B", nil, nil)
+		_, _, authC := makeTestCert(t, "client C", nil, nil)
+
+		opts := Options{}.Apply(Options{TLSAuthByHost: map[string]*TLSAuth{"a.example.com": authA, "*.example.org": authB}})
+		opts = opts.Apply(Options{TLSAuthByHost: map[string]*TLSAuth{"*.example.org": authC}})
+		assert.Equal(t, map[string]*TLSAuth{"a.example.com": authA, "*.example.org": authC}, opts.TLSAuthByHost)
+
+		t.Run("TLSAuthFor", func(t *testing.T) {
+			_, _, authD := makeTestCert(t, "client D", nil, nil)
+			_, _, authE := makeTestCert(t, "client E", nil, nil)
+			authD.Domains = []string{"*.example.org"}
+			opts := opts.Apply(Options{TLSAuth: []*TLSAuth{authD, authE}})
+
+			assert.Equal(t, []*TLSAuth{authA, authE}, opts.TLSAuthFor("a.example.com"))
+			assert.Equal(t, []*TLSAuth{authE}, opts.TLSAuthFor("b.example.com"))
+			assert.Equal(t, []*TLSAuth{authD, authC, authE}, opts.TLSAuthFor("www.example.org"))
+			assert.Equal(t, []string{"*.example.org", "a.example.com"}, opts.TLSAuthPatterns())
+		})
+		t.Run("JSON", func(t *testing.T) {
+			data, err := json.Marshal(map[string]interface{}{
+				"tlsAuthByHost": map[string]TLSAuthFields{"a.example.com": authA.TLSAuthFields},
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+			var opts Options
+			assert.NoError(t, json.Unmarshal(data, &opts))
+			if assert.Contains(t, opts.TLSAuthByHost, "a.example.com") {
+				cert, err := opts.TLSAuthByHost["a.example.com"].Certificate()
+				assert.NoError(t, err)
+				assert.Equal(t, "client A", cert.Leaf.Subject.CommonName)
+			}
+		})
+	})
+	t.Run("PerHostTLS", func(t *testing.T) {
+		_, _, auth := makeTestCert(t, "client A", nil, nil)
+		tls12 := &TLSVersions{Min: tls.VersionTLS12, Max: tls.VersionTLS12}
+		opts := Options{}.Apply(Options{PerHostTLS: map[string]HostTLSConfig{
+			"*.example.com":   {InsecureSkipVerify: null.BoolFrom(true), Auth: auth},
+			"api.example.com": {Version: tls12},
+		}})
+		opts = opts.Apply(Options{PerHostTLS: map[string]HostTLSConfig{
+			"*.example.com": {ServerName: null.StringFrom("origin.example.com")},
+		}})
+		assert.Equal(t, map[string]HostTLSConfig{
+			"*.example.com": {
+				InsecureSkipVerify: null.BoolFrom(true),
+				Auth:               auth,
+				ServerName:         null.StringFrom("origin.example.com"),
+			},
+			"api.example.com": {Version: tls12},
+		}, opts.PerHostTLS)
+
+		t.Run("HostTLSPattern", func(t *testing.T) {
+			patterns := map[string]string{
+				"api.example.com": "api.example.com",
+				"www.example.com": "*.example.com",
+				"example.org":     "",
+			}
+			for host, expected := range patterns {
+				pattern, ok := opts.HostTLSPattern(host)
+				assert.Equal(t, expected != "", ok, host)
+				assert.Equal(t, expected, pattern, host)
+			}
+		})
+		t.Run("TLSVersionFor", func(t *testing.T) {
+			tls10 := &TLSVersions{Min: tls.VersionTLS10}
+			opts := opts.Apply(Options{TLSVersion: tls10})
+			assert.Equal(t, tls12, opts.TLSVersionFor("api.example.com"))
+			assert.Equal(t, tls10, opts.TLSVersionFor("www.example.com"))
+			assert.Equal(t, tls10, opts.TLSVersionFor("example.org"))
+		})
+		t.Run("JSON", func(t *testing.T) {
+			var opts Options
+			assert.NoError(t, json.Unmarshal([]byte(`{"perHostTLS": {"*.example.com": {
+				"version": "tls1.2",
+				"insecureSkipVerify": true,
+				"serverName": "origin.example.com",
+				"nextProtos": ["http/1.1"]
+			}}}`), &opts))
+			assert.Equal(t, map[string]HostTLSConfig{"*.example.com": {
+				Version:            &TLSVersions{Min: tls.VersionTLS12, Max: tls.VersionTLS12},
+				InsecureSkipVerify: null.BoolFrom(true),
+				ServerName:         null.StringFrom("origin.example.com"),
+				NextProtos:         []string{"http/1.1"},
+			}}, opts.PerHostTLS)
+		})
+	})
+	t.Run("TLSAuthWatch", func(t *testing.T) {
+		opts := Options{}.Apply(Options{TLSAuthWatch: null.BoolFrom(true)})
+		assert.True(t, opts.TLSAuthWatch.Valid)
+		assert.True(t, opts.TLSAuthWatch.Bool)
+	})
+	t.Run("TLSNextProtos", func(t *testing.T) {
+		opts := Options{}.Apply(Options{TLSNextProtos: []string{"h2", "my-proto"}})
+		assert.Equal(t, []string{"h2", "my-proto"}, opts.TLSNextProtos)
+
+		assert.NoError(t, ValidateTLSNextProtos(opts.TLSNextProtos))
+		assert.EqualError(t, ValidateTLSNextProtos([]string{"h2", ""}), `invalid ALPN protocol #1: ""`)
+		assert.Error(t, ValidateTLSNextProtos([]string{strings.Repeat("a", 256)}))
+	})
+	t.Run("SystemTags", func(t *testing.T) {
+		opts := Options{}.Apply(Options{SystemTags: []string{SystemTagVU}})
+		assert.Equal(t, []string{"vu"}, opts.SystemTags)
+		assert.True(t, opts.HasSystemTag(SystemTagVU))
+		assert.False(t, Options{}.HasSystemTag(SystemTagVU))
+		assert.False(t, opts.HasSystemTag(SystemTagGroup))
+		assert.True(t, Options{}.HasSystemTag(SystemTagGroup))
+		assert.False(t, Options{SystemTags: []string{}}.HasSystemTag(SystemTagGroup))
+
+		assert.NoError(t, ValidateSystemTags(opts.SystemTags))
+		assert.EqualError(t, ValidateSystemTags([]string{"vu", "pid"}), "unknown system tag: pid")
+	})
+	t.Run("TagRules", func(t *testing.T) {
+		rules := []TagRule{
+			{Match: regexp.MustCompile(`^https://example\.com/users/\d+$`), Name: "users"},
+			{Match: regexp.MustCompile(`^https://example\.com/`), Name: "other"},
+		}
+		opts := Options{}.Apply(Options{TagRules: rules})
+		assert.Equal(t, rules, opts.TagRules)
+
+		name, ok := opts.TagNameFor("https://example.com/users/1234")
+		assert.True(t, ok)
+		assert.Equal(t, "users", name)
+		name, ok = opts.TagNameFor("https://example.com/posts/1")
+		assert.True(t, ok)
+		assert.Equal(t, "other", name)
+		_, ok = opts.TagNameFor("https://example.org/users/1234")
+		assert.False(t, ok)
+
+		t.Run("JSON", func(t *testing.T) {
+			var opts Options
+			jsonStr := `{"tagRules":[{"match":"^https://example\\.com/users/\\d+$","name":"users"}]}`
+			assert.NoError(t, json.Unmarshal([]byte(jsonStr), &opts))
+			assert.Equal(t, rules[:1], opts.TagRules)
+
+			data, err := json.Marshal(opts.TagRules)
+			assert.NoError(t, err)
+			assert.JSONEq(t, `[{"match":"^https://example\\.com/users/\\d+$","name":"users"}]`, string(data))
+
+			t.Run("Invalid", func(t *testing.T) {
+				var opts Options
+				assert.EqualError(t,
+					json.Unmarshal([]byte(`{"tagRules":[{"match":"(","name":"x"}]}`), &opts),
+					"invalid tag rule pattern \"(\": error parsing regexp: missing closing ): `(`",
+				)
+				assert.EqualError(t,
+					json.Unmarshal([]byte(`{"tagRules":[{"match":"^/"}]}`), &opts),
+					"tag rule \"^/\" has no name",
+				)
+			})
+		})
+	})
+	t.Run("ResponseSchemas", func(t *testing.T) {
+		var schemas []ResponseSchema
+		assert.NoError(t, json.Unmarshal([]byte(`[
+			{"match": "^https://example\\.com/users/\\d+$", "schema": {"required": ["id"]}},
+			{"match": "^https://example\\.com/", "schema": {"type": "object"}}
+		]`), &schemas))
+		opts := Options{}.Apply(Options{ResponseSchemas: schemas})
+		assert.Equal(t, schemas, opts.ResponseSchemas)
+
+		assert.Equal(t, []string{"id"}, opts.ResponseSchemaFor("https://example.com/users/1234").Required)
+		assert.Equal(t, schemas[1].Schema, opts.ResponseSchemaFor("https://example.com/posts/1"))
+		assert.Nil(t, opts.ResponseSchemaFor("https://example.org/users/1234"))
+
+		t.Run("JSON", func(t *testing.T) {
+			data, err := json.Marshal(schemas[:1])
+			assert.NoError(t, err)
+			assert.JSONEq(t, `[{"match":"^https://example\\.com/users/\\d+$","schema":{"required":["id"]}}]`, string(data))
+
+			t.Run("Invalid", func(t *testing.T) {
+				var opts Options
+				assert.EqualError(t,
+					json.Unmarshal([]byte(`{"responseSchemas":[{"match":"(","schema":{}}]}`), &opts),
+					"invalid response schema pattern \"(\": error parsing regexp: missing closing ): `(`",
+				)
+				assert.EqualError(t,
+					json.Unmarshal([]byte(`{"responseSchemas":[{"match":"^/"}]}`), &opts),
+					"response schema \"^/\" has no schema",
+				)
+			})
+		})
+	})
+	t.Run("ResponseSizeAlert", func(t *testing.T) {
+		opts := Options{}.Apply(Options{ResponseSizeAlert: null.IntFrom(1 << 20)})
+		assert.True(t, opts.ResponseSizeAlert.Valid)
+		assert.Equal(t, int64(1<<20), opts.ResponseSizeAlert.Int64)
+	})
+	t.Run("ExpectedStatuses", func(t *testing.T) {
+		opts := Options{}.Apply(Options{ExpectedStatuses: []string{"200-399", "401", "403"}})
+		assert.Equal(t, []string{"200-399", "401", "403"}, opts.ExpectedStatuses)
+
+		ranges, err := ParseStatusRanges(opts.ExpectedStatuses)
+		assert.NoError(t, err)
+		assert.Equal(t, []StatusRange{{200, 399}, {401, 401}, {403, 403}}, ranges)
+		for _, spec := range []string{"abc", "400-", "-1", "399-200"} {
+			_, err := ParseStatusRanges([]string{spec})
+			assert.EqualError(t, err, fmt.Sprintf("invalid status range: %q", spec))
+		}
+
+		testdata := map[int][2]bool{
+			0:   {false, false},
+			200: {true, true},
+			302: {true, true},
+			401: {false, true},
+			404: {false, false},
+			500: {false, false},
+		}
+		for status, expected := range testdata {
+			assert.Equal(t, expected[0], StatusExpected(nil, status), "default, %d", status)
+			assert.Equal(t, expected[1], StatusExpected(ranges, status), "custom, %d", status)
+		}
+	})
+	t.Run("CaptureTLSDetails", func(t *testing.T) {
+		opts := Options{}.Apply(Options{CaptureTLSDetails: null.BoolFrom(true)})
+		assert.True(t, opts.CaptureTLSDetails.Valid)
+		assert.True(t, opts.CaptureTLSDetails.Bool)
+	})
+	t.Run("RPSPerVU", func(t *testing.T) {
+		opts := Options{}.Apply(Options{RPSPerVU: null.IntFrom(5)})
+		assert.True(t, opts.RPSPerVU.Valid)
+		assert.Equal(t, int64(5), opts.RPSPerVU.Int64)
+	})
+	t.Run("TLSCRL", func(t *testing.T) {
+		opts := Options{}.Apply(Options{TLSCRL: null.StringFrom("ca.crl")})
+		assert.Equal(t, null.StringFrom("ca.crl"), opts.TLSCRL)
+	})
+	t.Run("ConnPoolMetrics", func(t *testing.T) {
+		opts := Options{}.Apply(Options{ConnPoolMetrics: null.BoolFrom(true)})
+		assert.True(t, opts.ConnPoolMetrics.Valid)
+		assert.True(t, opts.ConnPoolMetrics.Bool)
+	})
+	t.Run("ConnReuseMetrics", func(t *testing.T) {
+		opts := Options{}.Apply(Options{ConnReuseMetrics: null.BoolFrom(true)})
+		assert.True(t, opts.ConnReuseMetrics.Valid)
+		assert.True(t, opts.ConnReuseMetrics.Bool)
+	})
+	t.Run("NoThresholds", func(t *testing.T) {
+		opts := Options{}.Apply(Options{NoThresholds: null.BoolFrom(true)})
+		assert.True(t, opts.NoThresholds.Valid)
+		assert.True(t, opts.NoThresholds.Bool)
+	})
+	t.Run("NoChecks", func(t *testing.T) {
+		opts := Options{}.Apply(Options{NoChecks: null.BoolFrom(true)})
+		assert.True(t, opts.NoChecks.Valid)
+		assert.True(t, opts.NoChecks.Bool)
+	})
 	t.Run("NoConnectionReuse", func(t *testing.T) {
 		opts := Options{}.Apply(Options{NoConnectionReuse: null.BoolFrom(true)})
 		assert.True(t, opts.NoConnectionReuse.Valid)
@@ -215,11 +1092,149 @@ func TestOptions(t *testing.T) {
 		}})
 		assert.NotNil(t, opts.Thresholds)
 		assert.NotEmpty(t, opts.Thresholds)
+
+		t.Run("Definitions", func(t *testing.T) {
+			var opts Options
+			assert.NoError(t, json.Unmarshal([]byte(`{"thresholds":{
+				"http_req_duration{status:200}": ["p(95)<500"],
+				"checks": ["rate>0.99", "1+1==2"]
+			}}`), &opts))
+
+			defs := opts.ThresholdDefinitions()
+			assert.Equal(t, []ThresholdDefinition{
+				{Name: "checks", Metric: "checks", Source: "rate>0.99", Aggregation: "rate", Operator: ">", Value: 0.99},
+				{Name: "checks", Metric: "checks", Source: "1+1==2", Expression: true},
+				{
+					Name: "http_req_duration{status:200}", Metric: "http_req_duration",
+					Tags:   map[string]string{"status": "200"},
+					Source: "p(95)<500", Aggregation: "p(95)", Operator: "<", Value: 500,
+				},
+			}, defs)
+
+			assert.Equal(t, []string{"checks", "http_req_duration{status:200}"}, UnknownThresholdMetrics(defs, nil))
+			assert.Equal(t, []string{"http_req_duration{status:200}"}, UnknownThresholdMetrics(defs,
+				map[string]*stats.Metric{"checks": stats.New("checks", stats.Rate)},
+			))
+		})
+	})
+	t.Run("RunExport", func(t *testing.T) {
+		opts := Options{}.Apply(Options{RunExport: null.StringFrom("run.json")})
+		assert.Equal(t, null.StringFrom("run.json"), opts.RunExport)
+	})
+	t.Run("ThresholdResults", func(t *testing.T) {
+		opts := Options{}.Apply(Options{ThresholdResults: null.StringFrom("thresholds.json")})
+		assert.Equal(t, null.StringFrom("thresholds.json"), opts.ThresholdResults)
+
+		assert.NoError(t, json.Unmarshal([]byte(`{"thresholds":{
+			"checks": ["rate>0.99", "rate<2"],
+			"http_req_duration": ["1+1==2"],
+			"http_reqs": ["count>0"]
+		}}`), &opts))
+		checks := stats.New("checks", stats.Rate)
+		checks.Thresholds = opts.Thresholds["checks"]
+		checks.Sink = &stats.RateSink{Trues: 9, Total: 10}
+		_, _ = checks.Thresholds.Run(checks.Sink, 0)
+		duration := stats.New("http_req_duration", stats.Trend)
+		duration.Thresholds = opts.Thresholds["http_req_duration"]
+		_, _ = duration.Thresholds.Run(duration.Sink, 0)
+
+		results := ThresholdResults(opts.ThresholdDefinitions(), map[string]*stats.Metric{
+			"checks":            checks,
+			"http_req_duration": duration,
+		})
+		if !assert.Len(t, results, 4) {
+			return
+		}
+		assert.Equal(t, "rate>0.99", results[0].Source)
+		assert.False(t, results[0].Passed)
+		if assert.NotNil(t, results[0].Actual) {
+			assert.InDelta(t, 0.9, *results[0].Actual, 0.0001)
+		}
+		assert.Equal(t, "rate<2", results[1].Source)
+		assert.True(t, results[1].Passed)
+		assert.Equal(t, "1+1==2", results[2].Source)
+		assert.True(t, results[2].Passed)
+		assert.Nil(t, results[2].Actual)
+		assert.Equal(t, "count>0", results[3].Source)
+		assert.True(t, results[3].Passed)
+		assert.Nil(t, results[3].Actual)
+
+		data, err := json.Marshal(results[0])
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{
+			"name": "checks", "metric": "checks", "source": "rate>0.99",
+			"aggregation": "rate", "operator": ">", "value": 0.99,
+			"passed": false, "actual": 0.9
+		}`, string(data))
+	})
+	t.Run("FailOnCheckFailure", func(t *testing.T) {
+		opts := Options{}.Apply(Options{FailOnCheckFailure: null.BoolFrom(true)})
+		assert.True(t, opts.FailOnCheckFailure.Valid)
+		assert.True(t, opts.FailOnCheckFailure.Bool)
+	})
+	t.Run("MinCheckPassRate", func(t *testing.T) {
+		opts := Options{}.Apply(Options{MinCheckPassRate: null.FloatFrom(0.99)})
+		assert.True(t, opts.MinCheckPassRate.Valid)
+		assert.Equal(t, 0.99, opts.MinCheckPassRate.Float64)
 	})
 	t.Run("External", func(t *testing.T) {
 		opts := Options{}.Apply(Options{External: map[string]interface{}{"a": 1}})
 		assert.Equal(t, map[string]interface{}{"a": 1}, opts.External)
 	})
+	t.Run("CheckFDLimit", func(t *testing.T) {
+		opts := Options{}.Apply(Options{CheckFDLimit: null.BoolFrom(true)})
+		assert.True(t, opts.CheckFDLimit.Valid)
+		assert.True(t, opts.CheckFDLimit.Bool)
+	})
+	t.Run("MetricPrefix", func(t *testing.T) {
+		opts := Options{}.Apply(Options{MetricPrefix: null.StringFrom("test_")})
+		assert.Equal(t, null.StringFrom("test_"), opts.MetricPrefix)
+
+		for _, prefix := range []string{"test_", "_test", "Test2_"} {
+			assert.NoError(t, ValidateMetricPrefix(prefix), prefix)
+		}
+		for _, prefix := range []string{"", "2test", "my-test", "my.test", "my test"} {
+			assert.Error(t, ValidateMetricPrefix(prefix), prefix)
+		}
+	})
+	t.Run("Variant", func(t *testing.T) {
+		opts := Options{}.Apply(Options{Variant: null.StringFrom("canary")})
+		assert.Equal(t, null.StringFrom("canary"), opts.Variant)
+
+		for _, variant := range []string{"canary", "control", "v1.2-rc_3"} {
+			assert.NoError(t, ValidateVariant(variant), variant)
+		}
+		for _, variant := range []string{"", "my variant", "a/b", strings.Repeat("a", 65)} {
+			assert.Error(t, ValidateVariant(variant), variant)
+		}
+	})
+	t.Run("TimestampPrecision", func(t *testing.T) {
+		opts := Options{}.Apply(Options{TimestampPrecision: null.StringFrom("ns")})
+		assert.Equal(t, null.StringFrom("ns"), opts.TimestampPrecision)
+
+		for s, d := range map[string]time.Duration{"ns": time.Nanosecond, "us": time.Microsecond, "ms": time.Millisecond} {
+			precision, err := ParseTimestampPrecision(s)
+			assert.NoError(t, err)
+			assert.Equal(t, d, precision)
+		}
+		_, err := ParseTimestampPrecision("s")
+		assert.EqualError(t, err, "unknown timestamp precision: s")
+	})
+	t.Run("GaugeReset", func(t *testing.T) {
+		opts := Options{}.Apply(Options{GaugeReset: map[string]string{"queue_depth": "iteration"}})
+		assert.Equal(t, map[string]string{"queue_depth": "iteration"}, opts.GaugeReset)
+
+		assert.NoError(t, ValidateGaugeReset(map[string]string{"a": "iteration", "b": "interval"}))
+		assert.EqualError(t, ValidateGaugeReset(map[string]string{"a": "sometimes"}), "unknown gauge reset mode for a: sometimes")
+	})
+	t.Run("TagCardinalityLimits", func(t *testing.T) {
+		opts := Options{}.Apply(Options{TagCardinalityLimits: map[string]int64{"url": 100}})
+		assert.Equal(t, map[string]int64{"url": 100}, opts.TagCardinalityLimits)
+	})
+	t.Run("TypedMetricValues", func(t *testing.T) {
+		opts := Options{}.Apply(Options{TypedMetricValues: null.BoolFrom(true)})
+		assert.Equal(t, null.BoolFrom(true), opts.TypedMetricValues)
+	})
 
 	t.Run("JSON", func(t *testing.T) {
 		data, err := json.Marshal(Options{})
@@ -230,6 +1245,328 @@ func TestOptions(t *testing.T) {
 	})
 }
 
+func TestRedirectAllowed(t *testing.T) {
+	testdata := map[string]struct {
+		allowlist []string
+		allowed   []string
+		denied    []string
+	}{
+		"empty":  {nil, []string{"http://example.com/"}, nil},
+		"host":   {[]string{"example.com"}, []string{"http://example.com/", "https://example.com:8443/"}, []string{"https://example.org/"}},
+		"scheme": {[]string{"https://"}, []string{"https://example.org/"}, []string{"http://example.org/"}},
+		"both": {
+			[]string{"https://*.example.com", "http://example.org"},
+			[]string{"https://www.example.com/", "http://example.org/"},
+			[]string{"http://www.example.com/", "https://example.com/", "https://example.org/"},
+		},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			for _, s := range data.allowed {
+				u, err := url.Parse(s)
+				assert.NoError(t, err)
+				assert.True(t, RedirectAllowed(data.allowlist, u), s)
+			}
+			for _, s := range data.denied {
+				u, err := url.Parse(s)
+				assert.NoError(t, err)
+				assert.False(t, RedirectAllowed(data.allowlist, u), s)
+			}
+		})
+	}
+}
+
+func TestOptionsYAML(t *testing.T) {
+	tlsVersion := TLSVersions{Min: tls.VersionTLS10, Max: tls.VersionTLS12}
+	opts := Options{
+		VUs:             null.IntFrom(10),
+		Duration:        NullDurationFrom(10 * time.Second),
+		Paused:          null.BoolFrom(true),
+		UserAgent:       null.StringFrom("k6"),
+		Stages:          []Stage{{Duration: NullDurationFrom(1 * time.Minute), Target: null.IntFrom(20)}},
+		TLSVersion:      &tlsVersion,
+		TLSCipherSuites: &TLSCipherSuites{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		Hosts:           map[string]net.IP{"test.loadimpact.com": net.ParseIP("192.0.2.1")},
+	}
+
+	data, err := yaml.Marshal(opts)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "duration: 10s\n")
+	assert.Contains(t, string(data), "min: tls1.0\n")
+	assert.NotContains(t, string(data), "null")
+
+	var opts2 Options
+	assert.NoError(t, yaml.Unmarshal(data, &opts2))
+	assert.Equal(t, opts, opts2)
+
+	t.Run("Thresholds", func(t *testing.T) {
+		var opts Options
+		assert.NoError(t, yaml.Unmarshal([]byte("thresholds:\n  http_req_duration:\n  - avg<100\n"), &opts))
+		if assert.Len(t, opts.Thresholds["http_req_duration"].Thresholds, 1) {
+			assert.Equal(t, "avg<100", opts.Thresholds["http_req_duration"].Thresholds[0].Source)
+		}
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		var opts Options
+		assert.Error(t, yaml.Unmarshal([]byte("tlsVersion: tls9.9\n"), &opts))
+	})
+}
+
+// Issues a certificate for the given name, signed by parent, or self-signed if parent is nil.
+func makeTestCert(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, *TLSAuth) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(1 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	cert, err := x509.ParseCertificate(der)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return cert, key, &TLSAuth{TLSAuthFields: TLSAuthFields{
+		Cert: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		Key:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}}
+}
+
+func TestTLSAuthReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k6-tlsauth")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert := func(auth *TLSAuth, modTime time.Time) {
+		assert.NoError(t, ioutil.WriteFile(certFile, []byte(auth.Cert), 0644))
+		assert.NoError(t, ioutil.WriteFile(keyFile, []byte(auth.Key), 0600))
+		assert.NoError(t, os.Chtimes(certFile, modTime, modTime))
+		assert.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+	}
+
+	_, _, authA := makeTestCert(t, "client A", nil, nil)
+	_, _, authB := makeTestCert(t, "client B", nil, nil)
+	writeCert(authA, time.Now().Add(-1*time.Hour))
+
+	auth := &TLSAuth{TLSAuthFields: TLSAuthFields{CertFile: certFile, KeyFile: keyFile}}
+	getCert := GetClientCertificateFunc([]*TLSAuth{auth}, true)
+	cert, err := getCert(&tls.CertificateRequestInfo{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "client A", cert.Leaf.Subject.CommonName)
+	}
+
+	t.Run("Rotated", func(t *testing.T) {
+		writeCert(authB, time.Now())
+		auth.checkTime = time.Time{}
+
+		cert, err := getCert(&tls.CertificateRequestInfo{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, "client B", cert.Leaf.Subject.CommonName)
+		}
+	})
+
+	t.Run("Broken", func(t *testing.T) {
+		assert.NoError(t, ioutil.WriteFile(certFile, []byte("garbage"), 0644))
+		future := time.Now().Add(1 * time.Hour)
+		assert.NoError(t, os.Chtimes(certFile, future, future))
+		auth.checkTime = time.Time{}
+
+		assert.Error(t, auth.Reload())
+		cert, err := auth.Certificate()
+		if assert.NoError(t, err) {
+			assert.Equal(t, "client B", cert.Leaf.Subject.CommonName)
+		}
+	})
+}
+
+func TestGetClientCertificateFunc(t *testing.T) {
+	caA, caAKey, _ := makeTestCert(t, "CA A", nil, nil)
+	caB, caBKey, _ := makeTestCert(t, "CA B", nil, nil)
+	_, _, authA := makeTestCert(t, "client A", caA, caAKey)
+	_, _, authB := makeTestCert(t, "client B", caB, caBKey)
+	_, _, server := makeTestCert(t, "server", nil, nil)
+	serverCert, err := server.Certificate()
+	assert.NoError(t, err)
+
+	// Performs a handshake against a server asking for a cert from the given CAs, returns the
+	// common name of the certificate that was presented, if any.
+	handshake := func(t *testing.T, auths []*TLSAuth, cas ...*x509.Certificate) string {
+		pool := x509.NewCertPool()
+		for _, ca := range cas {
+			pool.AddCert(ca)
+		}
+
+		clientConn, serverConn := net.Pipe()
+		srv := tls.Server(serverConn, &tls.Config{
+			Certificates: []tls.Certificate{*serverCert},
+			ClientAuth:   tls.RequestClientCert,
+			ClientCAs:    pool,
+		})
+		cli := tls.Client(clientConn, &tls.Config{
+			InsecureSkipVerify:   true,
+			GetClientCertificate: GetClientCertificateFunc(auths, false),
+		})
+		errC := make(chan error, 1)
+		go func() { errC <- srv.Handshake() }()
+		assert.NoError(t, cli.Handshake())
+		assert.NoError(t, <-errC)
+		_ = clientConn.Close()
+		_ = serverConn.Close()
+
+		peerCerts := srv.ConnectionState().PeerCertificates
+		if len(peerCerts) == 0 {
+			return ""
+		}
+		return peerCerts[0].Subject.CommonName
+	}
+
+	t.Run("Hint A", func(t *testing.T) {
+		assert.Equal(t, "client A", handshake(t, []*TLSAuth{authB, authA}, caA))
+	})
+	t.Run("Hint B", func(t *testing.T) {
+		assert.Equal(t, "client B", handshake(t, []*TLSAuth{authA, authB}, caB))
+	})
+	t.Run("No Match", func(t *testing.T) {
+		assert.Equal(t, "", handshake(t, []*TLSAuth{authA}, caB))
+	})
+	t.Run("No Hints", func(t *testing.T) {
+		assert.Equal(t, "client B", handshake(t, []*TLSAuth{authB, authA}))
+	})
+	t.Run("Domains", func(t *testing.T) {
+		_, _, authAPI := makeTestCert(t, "client api", caA, caAKey)
+		_, _, authWWW := makeTestCert(t, "client www", caA, caAKey)
+		authAPI.Domains = []string{"api.example.com"}
+		authWWW.Domains = []string{"*.example.com"}
+		opts := Options{TLSAuth: []*TLSAuth{authWWW, authAPI, authB}}
+
+		assert.Equal(t, "client api", handshake(t, opts.TLSAuthFor("api.example.com"), caA))
+		assert.Equal(t, "client www", handshake(t, opts.TLSAuthFor("www.example.com"), caA))
+		assert.Equal(t, "", handshake(t, opts.TLSAuthFor("example.org"), caA))
+		assert.Equal(t, "client B", handshake(t, opts.TLSAuthFor("api.example.com"), caB))
+	})
+}
+
+func TestOptionsTLSAuthFor(t *testing.T) {
+	_, _, authA := makeTestCert(t, "client A", nil, nil)
+	_, _, authB := makeTestCert(t, "client B", nil, nil)
+	_, _, authC := makeTestCert(t, "client C", nil, nil)
+	_, _, authHost := makeTestCert(t, "client host", nil, nil)
+	authA.Domains = []string{"a.example.com", "*.example.org"}
+	authB.Domains = []string{"*.example.com"}
+	opts := Options{
+		TLSAuth:    []*TLSAuth{authA, authB, authC},
+		PerHostTLS: map[string]HostTLSConfig{"c.example.com": {Auth: authHost}, "*.example.org": {}},
+	}
+
+	assert.Equal(t, []*TLSAuth{authA, authC}, opts.TLSAuthFor("a.example.com"))
+	assert.Equal(t, []*TLSAuth{authB, authC}, opts.TLSAuthFor("b.example.com"))
+	assert.Equal(t, []*TLSAuth{authHost}, opts.TLSAuthFor("c.example.com"))
+	assert.Equal(t, []*TLSAuth{authA, authC}, opts.TLSAuthFor("www.example.org"))
+	assert.Equal(t, []*TLSAuth{authC}, opts.TLSAuthFor("example.net"))
+	assert.Nil(t, Options{}.TLSAuthFor("example.net"))
+
+	assert.Equal(t, []string{"a.example.com", "*.example.org", "*.example.com"}, opts.TLSAuthPatterns())
+	pattern, ok := opts.TLSAuthPattern("a.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "a.example.com", pattern)
+	_, ok = opts.TLSAuthPattern("example.net")
+	assert.False(t, ok)
+}
+
+func TestOptionsRedacted(t *testing.T) {
+	auth := &TLSAuth{TLSAuthFields: TLSAuthFields{Cert: "cert", Key: "key", Domains: []string{"example.com"}}}
+	fileAuth := &TLSAuth{TLSAuthFields: TLSAuthFields{CertFile: "/path/to/cert.pem", KeyFile: "/path/to/key.pem"}}
+	opts := Options{
+		TLSAuth:       []*TLSAuth{auth, fileAuth},
+		TLSAuthByHost: map[string]*TLSAuth{"*.example.com": auth},
+		PerHostTLS:    map[string]HostTLSConfig{"api.example.com": {Auth: auth, ServerName: null.StringFrom("api")}},
+	}
+	redacted := opts.Redacted()
+
+	redactedFields := TLSAuthFields{Cert: RedactedSecret, Key: RedactedSecret, Domains: []string{"example.com"}}
+	assert.Equal(t, redactedFields, redacted.TLSAuth[0].TLSAuthFields)
+	assert.Equal(t, fileAuth.TLSAuthFields, redacted.TLSAuth[1].TLSAuthFields)
+	assert.Equal(t, redactedFields, redacted.TLSAuthByHost["*.example.com"].TLSAuthFields)
+	assert.Equal(t, redactedFields, redacted.PerHostTLS["api.example.com"].Auth.TLSAuthFields)
+	assert.Equal(t, null.StringFrom("api"), redacted.PerHostTLS["api.example.com"].ServerName)
+
+	assert.Equal(t, "key", auth.Key)
+	assert.Equal(t, auth, opts.PerHostTLS["api.example.com"].Auth)
+}
+
+func TestEstimateVUsForRate(t *testing.T) {
+	testdata := map[string]struct {
+		think    *ThinkTime
+		rate     float64
+		duration time.Duration
+		vus      int64
+	}{
+		"exact":       {nil, 10, 300 * time.Millisecond, 3},
+		"round up":    {nil, 10, 250 * time.Millisecond, 3},
+		"slow rate":   {nil, 0.1, 1 * time.Second, 1},
+		"zero rate":   {nil, 0, 1 * time.Second, 0},
+		"no duration": {nil, 10, 0, 0},
+		"uniform":     {&ThinkTime{Min: Duration(1 * time.Second), Max: Duration(3 * time.Second)}, 5, 1 * time.Second, 15},
+		"normal":      {&ThinkTime{Distribution: ThinkTimeNormal, Mean: Duration(500 * time.Millisecond)}, 4, 500 * time.Millisecond, 4},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			opts := Options{ThinkTime: data.think}
+			assert.Equal(t, data.vus, opts.EstimateVUsForRate(data.rate, data.duration))
+		})
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	assert.NoError(t, Options{}.Validate())
+
+	t.Run("VUsMaxPolicy", func(t *testing.T) {
+		stages := []Stage{{Duration: NullDurationFrom(10 * time.Second), Target: null.IntFrom(20)}}
+		opts := Options{VUsMax: null.IntFrom(10), Stages: stages}
+		assert.EqualError(t, opts.Validate(), "stage 0 targets more vus (20) than the vu cap (10)")
+
+		opts.VUsMaxPolicy = null.StringFrom(VUsMaxError)
+		assert.EqualError(t, opts.Validate(), "stage 0 targets more vus (20) than the vu cap (10)")
+
+		opts.VUsMaxPolicy = null.StringFrom(VUsMaxClamp)
+		assert.NoError(t, opts.Validate())
+
+		opts.VUsMaxPolicy = null.StringFrom("ignore")
+		assert.EqualError(t, opts.Validate(), "unknown vus max policy: ignore")
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		testdata := map[string]Options{
+			`invalid status range: "2xx"`:                        {ExpectedStatuses: []string{"2xx"}},
+			`invalid port range: "0-99"`:                         {LocalPortRange: null.StringFrom("0-99")},
+			"request timeout for *.example.com must be positive": {HostRequestTimeouts: map[string]Duration{"*.example.com": 0}},
+			"failOnCheckFailure can't be used with noChecks":     {NoChecks: null.BoolFrom(true), FailOnCheckFailure: null.BoolFrom(true)},
+		}
+		for msg, opts := range testdata {
+			assert.EqualError(t, opts.Validate(), msg)
+		}
+	})
+}
+
 func TestOptionsEnv(t *testing.T) {
 	testdata := map[struct{ Name, Key string }]map[string]interface{}{
 		{"Paused", "K6_PAUSED"}: {
@@ -237,6 +1574,37 @@ func TestOptionsEnv(t *testing.T) {
 			"true":  null.BoolFrom(true),
 			"false": null.BoolFrom(false),
 		},
+		{"DryRun", "K6_DRY_RUN"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"VUCancellation", "K6_VU_CANCELLATION"}: {
+			"finish-request": null.StringFrom("finish-request"),
+		},
+		{"RequestTimeout", "K6_REQUEST_TIMEOUT"}: {
+			"":    NullDuration{},
+			"10s": NullDurationFrom(10 * time.Second),
+		},
+		{"HTTPVersion", "K6_HTTP_VERSION"}: {
+			"1.1": null.StringFrom("1.1"),
+		},
+		{"SummaryTrendPrecision", "K6_SUMMARY_TREND_PRECISION"}: {
+			"":  null.Int{},
+			"3": null.IntFrom(3),
+		},
+		{"HistogramExport", "K6_HISTOGRAM_EXPORT"}: {
+			"histograms.json": null.StringFrom("histograms.json"),
+		},
+		{"ThresholdResults", "K6_THRESHOLD_RESULTS"}: {
+			"thresholds.json": null.StringFrom("thresholds.json"),
+		},
+		{"RunExport", "K6_RUN_EXPORT"}: {
+			"run.json": null.StringFrom("run.json"),
+		},
+		{"APIAddress", "K6_API_ADDRESS"}: {
+			"localhost:6566": null.StringFrom("localhost:6566"),
+		},
 		{"VUs", "K6_VUS"}: {
 			"":    null.Int{},
 			"123": null.IntFrom(123),
@@ -245,10 +1613,32 @@ func TestOptionsEnv(t *testing.T) {
 			"":    null.Int{},
 			"123": null.IntFrom(123),
 		},
+		{"MemoryLimit", "K6_MEMORY_LIMIT"}: {
+			"":           null.Int{},
+			"1073741824": null.IntFrom(1 << 30),
+		},
+		{"VUsMaxPolicy", "K6_VUS_MAX_POLICY"}: {
+			"clamp": null.StringFrom("clamp"),
+		},
+		{"MemoryLimitPolicy", "K6_MEMORY_LIMIT_POLICY"}: {
+			"abort": null.StringFrom("abort"),
+		},
+		{"VUInitConcurrency", "K6_VU_INIT_CONCURRENCY"}: {
+			"":  null.Int{},
+			"4": null.IntFrom(4),
+		},
+		{"PreAllocatedVUs", "K6_PRE_ALLOCATED_VUS"}: {
+			"":    null.Int{},
+			"123": null.IntFrom(123),
+		},
 		{"Duration", "K6_DURATION"}: {
 			"":    NullDuration{},
 			"10s": NullDurationFrom(10 * time.Second),
 		},
+		{"EndTime", "K6_END_TIME"}: {
+			"":                     NullTime{},
+			"2018-03-01T12:30:00Z": NullTimeFrom(time.Date(2018, 3, 1, 12, 30, 0, 0, time.UTC)),
+		},
 		{"Iterations", "K6_ITERATIONS"}: {
 			"":    null.Int{},
 			"123": null.IntFrom(123),
@@ -266,10 +1656,167 @@ func TestOptionsEnv(t *testing.T) {
 				{Duration: NullDurationFrom(2 * time.Second), Target: null.IntFrom(100)},
 			},
 		},
+		{"StagesFile", "K6_STAGES_FILE"}: {
+			"":           null.String{},
+			"stages.csv": null.StringFrom("stages.csv"),
+		},
+		{"BodyDataFile", "K6_BODY_DATA_FILE"}: {
+			"":         null.String{},
+			"data.txt": null.StringFrom("data.txt"),
+		},
+		{"BodyFile", "K6_BODY_FILE"}: {
+			"":           null.String{},
+			"upload.bin": null.StringFrom("upload.bin"),
+		},
+		{"TagDataFile", "K6_TAG_DATA_FILE"}: {
+			"":         null.String{},
+			"tags.csv": null.StringFrom("tags.csv"),
+		},
+		{"ReplayFile", "K6_REPLAY_FILE"}: {
+			"":             null.String{},
+			"replay.jsonl": null.StringFrom("replay.jsonl"),
+		},
+		{"MaxIterationsPerVU", "K6_MAX_ITERATIONS_PER_VU"}: {
+			"":   null.Int{},
+			"10": null.IntFrom(10),
+		},
+		{"PaceIterations", "K6_PACE_ITERATIONS"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"WarmupDuration", "K6_WARMUP_DURATION"}: {
+			"":    NullDuration{},
+			"10s": NullDurationFrom(10 * time.Second),
+		},
+		{"DNSRetries", "K6_DNS_RETRIES"}: {
+			"":  null.Int{},
+			"3": null.IntFrom(3),
+		},
+		{"DNSRetryBackoff", "K6_DNS_RETRY_BACKOFF"}: {
+			"":   NullDuration{},
+			"1s": NullDurationFrom(1 * time.Second),
+		},
+		{"ConnResetRetries", "K6_CONN_RESET_RETRIES"}: {
+			"":  null.Int{},
+			"2": null.IntFrom(2),
+		},
+		{"TestEndDeadline", "K6_TEST_END_DEADLINE"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"RunMarkers", "K6_RUN_MARKERS"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"RunID", "K6_RUN_ID"}: {
+			"":    null.String{},
+			"abc": null.StringFrom("abc"),
+		},
+		{"StdoutFormat", "K6_STDOUT_FORMAT"}: {
+			"":       null.String{},
+			"ndjson": null.StringFrom("ndjson"),
+		},
+		{"PrewarmTLS", "K6_PREWARM_TLS"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"MaxCPUs", "K6_MAX_CPUS"}: {
+			"":  null.Int{},
+			"2": null.IntFrom(2),
+		},
+		{"ConnRatePerSec", "K6_CONN_RATE_PER_SEC"}: {
+			"":   null.Int{},
+			"50": null.IntFrom(50),
+		},
+		{"LimiterWaitMetric", "K6_LIMITER_WAIT_METRIC"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"LocalPortRange", "K6_LOCAL_PORT_RANGE"}: {
+			"":            null.String{},
+			"40000-40999": null.StringFrom("40000-40999"),
+		},
+		{"BindInterface", "K6_BIND_INTERFACE"}: {
+			"":     null.String{},
+			"eth1": null.StringFrom("eth1"),
+		},
 		{"MaxRedirects", "K6_MAX_REDIRECTS"}: {
 			"":    null.Int{},
 			"123": null.IntFrom(123),
 		},
+		{"ExpectContinueTimeout", "K6_EXPECT_CONTINUE_TIMEOUT"}: {
+			"":   NullDuration{},
+			"1s": NullDurationFrom(1 * time.Second),
+		},
+		{"IdleConnTimeout", "K6_IDLE_CONN_TIMEOUT"}: {
+			"":    NullDuration{},
+			"30s": NullDurationFrom(30 * time.Second),
+		},
+		{"MaxResponseHeaderBytes", "K6_MAX_RESPONSE_HEADER_BYTES"}: {
+			"":     null.Int{},
+			"4096": null.IntFrom(4096),
+		},
+		{"CaptureTrailers", "K6_CAPTURE_TRAILERS"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"SummaryPerStage", "K6_SUMMARY_PER_STAGE"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"PercentileInterval", "K6_PERCENTILE_INTERVAL"}: {
+			"":    NullDuration{},
+			"10s": NullDurationFrom(10 * time.Second),
+		},
+		{"PercentileWindow", "K6_PERCENTILE_WINDOW"}: {
+			"interval": null.StringFrom("interval"),
+		},
+		{"PauseSignal", "K6_PAUSE_SIGNAL"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"RedirectSensitiveHeaders", "K6_REDIRECT_SENSITIVE_HEADERS"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"ResponseSizeAlert", "K6_RESPONSE_SIZE_ALERT"}: {
+			"":        null.Int{},
+			"1048576": null.IntFrom(1048576),
+		},
+		{"TLSFailureMetric", "K6_TLS_FAILURE_METRIC"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"TLSMinVersionStrict", "K6_TLS_MIN_VERSION_STRICT"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"KeepCompressedBody", "K6_KEEP_COMPRESSED_BODY"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"GzipMultipart", "K6_GZIP_MULTIPART"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"TLSAuthWatch", "K6_TLS_AUTH_WATCH"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
 		{"InsecureSkipTLSVerify", "K6_INSECURE_SKIP_TLS_VERIFY"}: {
 			"":      null.Bool{},
 			"true":  null.BoolFrom(true),
@@ -278,11 +1825,49 @@ func TestOptionsEnv(t *testing.T) {
 		// TLSCipherSuites
 		// TLSVersion
 		// TLSAuth
+		{"CaptureTLSDetails", "K6_CAPTURE_TLS_DETAILS"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"RPSPerVU", "K6_RPS_PER_VU"}: {
+			"":  null.Int{},
+			"5": null.IntFrom(5),
+		},
+		{"TLSCRL", "K6_TLS_CRL"}: {
+			"":       null.String{},
+			"ca.crl": null.StringFrom("ca.crl"),
+		},
+		{"ConnPoolMetrics", "K6_CONN_POOL_METRICS"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"ConnReuseMetrics", "K6_CONN_REUSE_METRICS"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"NoThresholds", "K6_NO_THRESHOLDS"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"NoChecks", "K6_NO_CHECKS"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
 		{"NoConnectionReuse", "K6_NO_CONNECTION_REUSE"}: {
 			"":      null.Bool{},
 			"true":  null.BoolFrom(true),
 			"false": null.BoolFrom(false),
 		},
+		{"CheckFDLimit", "K6_CHECK_FD_LIMIT"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
 		{"UserAgent", "K6_USER_AGENT"}: {
 			"":    null.String{},
 			"Hi!": null.StringFrom("Hi!"),
@@ -292,8 +1877,34 @@ func TestOptionsEnv(t *testing.T) {
 			"true":  null.BoolFrom(true),
 			"false": null.BoolFrom(false),
 		},
+		{"FailOnCheckFailure", "K6_FAIL_ON_CHECK_FAILURE"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
+		{"MinCheckPassRate", "K6_MIN_CHECK_PASS_RATE"}: {
+			"":     null.Float{},
+			"0.99": null.FloatFrom(0.99),
+		},
 		// Thresholds
 		// External
+		{"MetricPrefix", "K6_METRIC_PREFIX"}: {
+			"":      null.String{},
+			"test_": null.StringFrom("test_"),
+		},
+		{"Variant", "K6_VARIANT"}: {
+			"":       null.String{},
+			"canary": null.StringFrom("canary"),
+		},
+		{"TimestampPrecision", "K6_TIMESTAMP_PRECISION"}: {
+			"":   null.String{},
+			"ms": null.StringFrom("ms"),
+		},
+		{"TypedMetricValues", "K6_TYPED_METRIC_VALUES"}: {
+			"":      null.Bool{},
+			"true":  null.BoolFrom(true),
+			"false": null.BoolFrom(false),
+		},
 	}
 	for field, data := range testdata {
 		os.Clearenv()
@@ -0,0 +1,134 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Longest line ReadReplaySchedule accepts, to leave room for request bodies.
+const MaxReplayLineSize = 16 * 1024 * 1024
+
+// A recorded request, to be sent Offset after the replay starts.
+type ReplayRequest struct {
+	Offset Duration `json:"offset"`
+	Method string   `json:"method"`
+	URL    string   `json:"url"`
+	Body   string   `json:"body,omitempty"`
+}
+
+// Work for each VU to do once, before its first iteration, eg. logging in or priming caches;
+// see Options.VUWarmup. The exported function is called first, then the requests are sent in
+// order; their offsets are ignored.
+type VUWarmup struct {
+	Exec     string          `json:"exec"`
+	Requests []ReplayRequest `json:"requests"`
+}
+
+// ReadReplaySchedule reads recorded requests from a file with one JSON object per line, eg.
+// {"offset": "1.5s", "method": "POST", "url": "https://example.com/", "body": "hi"}. The method
+// defaults to GET, blank lines are skipped, and the requests are sorted by offset.
+func ReadReplaySchedule(r io.Reader) ([]ReplayRequest, error) {
+	var reqs []ReplayRequest
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, MaxReplayLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var req ReplayRequest
+		if err := json.Unmarshal([]byte(text), &req); err != nil {
+			return nil, errors.Wrapf(err, "line %d", line)
+		}
+		if req.URL == "" {
+			return nil, errors.Errorf("line %d: no url", line)
+		}
+		if req.Offset < 0 {
+			return nil, errors.Errorf("line %d: offset can't be negative", line)
+		}
+		if req.Method == "" {
+			req.Method = "GET"
+		}
+		req.Method = strings.ToUpper(req.Method)
+		reqs = append(reqs, req)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(reqs) == 0 {
+		return nil, errors.New("no requests defined")
+	}
+	sort.SliceStable(reqs, func(i, j int) bool { return reqs[i].Offset < reqs[j].Offset })
+	return reqs, nil
+}
+
+// Hands out the requests in a replay schedule in order, to any number of VUs, each once it's
+// due. Offsets count from the first call to Wait().
+type ReplaySchedule struct {
+	Requests []ReplayRequest
+
+	lock  sync.Mutex
+	next  int
+	start time.Time
+}
+
+func NewReplaySchedule(reqs []ReplayRequest) *ReplaySchedule {
+	return &ReplaySchedule{Requests: reqs}
+}
+
+// Takes the next request and waits until it's due. Returns false if the context is cancelled
+// first; once every request has been handed out, it blocks until then.
+func (s *ReplaySchedule) Wait(ctx context.Context) (ReplayRequest, bool) {
+	s.lock.Lock()
+	if s.start.IsZero() {
+		s.start = time.Now()
+	}
+	if s.next >= len(s.Requests) {
+		s.lock.Unlock()
+		<-ctx.Done()
+		return ReplayRequest{}, false
+	}
+	req := s.Requests[s.next]
+	s.next++
+	due := s.start.Add(time.Duration(req.Offset))
+	s.lock.Unlock()
+
+	if d := time.Until(due); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ReplayRequest{}, false
+		}
+	}
+	return req, true
+}
@@ -0,0 +1,123 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadReplaySchedule(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		reqs, err := ReadReplaySchedule(strings.NewReader(`
+			{"offset":"2s","method":"post","url":"https://example.com/b","body":"hi"}
+
+			{"offset":"1s","url":"https://example.com/a"}
+			{"offset":1000000000,"method":"PUT","url":"https://example.com/c"}
+		`))
+		assert.NoError(t, err)
+		assert.Equal(t, []ReplayRequest{
+			{Offset: Duration(1 * time.Second), Method: "GET", URL: "https://example.com/a"},
+			{Offset: Duration(1 * time.Second), Method: "PUT", URL: "https://example.com/c"},
+			{Offset: Duration(2 * time.Second), Method: "POST", URL: "https://example.com/b", Body: "hi"},
+		}, reqs)
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		testdata := map[string]string{
+			"":                                       "no requests defined",
+			"{\"url\":\"https://example.com/\"}\n{":  "line 2: unexpected end of JSON input",
+			`{"method":"GET"}`:                       "line 1: no url",
+			`{"offset":"-1s","url":"https://a.b/"}`:  "line 1: offset can't be negative",
+			`{"offset":"soon","url":"https://a.b/"}`: `line 1: time: invalid duration "soon"`,
+		}
+		for data, msg := range testdata {
+			t.Run(msg, func(t *testing.T) {
+				_, err := ReadReplaySchedule(strings.NewReader(data))
+				assert.EqualError(t, err, msg)
+			})
+		}
+	})
+}
+
+func TestReplaySchedule(t *testing.T) {
+	t.Run("Offsets", func(t *testing.T) {
+		s := NewReplaySchedule([]ReplayRequest{
+			{Offset: 0, URL: "https://example.com/a"},
+			{Offset: Duration(50 * time.Millisecond), URL: "https://example.com/b"},
+		})
+		start := time.Now()
+		req, ok := s.Wait(context.Background())
+		assert.True(t, ok)
+		assert.Equal(t, "https://example.com/a", req.URL)
+		req, ok = s.Wait(context.Background())
+		assert.True(t, ok)
+		assert.Equal(t, "https://example.com/b", req.URL)
+		assert.True(t, time.Since(start) >= 50*time.Millisecond, "request wasn't delayed to its offset")
+	})
+	t.Run("Concurrent", func(t *testing.T) {
+		reqs := make([]ReplayRequest, 100)
+		for i := range reqs {
+			reqs[i] = ReplayRequest{URL: "https://example.com/"}
+		}
+		s := NewReplaySchedule(reqs)
+
+		var wg sync.WaitGroup
+		var lock sync.Mutex
+		count := 0
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					if _, ok := s.Wait(context.Background()); ok {
+						lock.Lock()
+						count++
+						lock.Unlock()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 100, count)
+	})
+	t.Run("Exhausted", func(t *testing.T) {
+		s := NewReplaySchedule([]ReplayRequest{{URL: "https://example.com/"}})
+		_, ok := s.Wait(context.Background())
+		assert.True(t, ok)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, ok = s.Wait(ctx)
+		assert.False(t, ok)
+	})
+	t.Run("Cancelled", func(t *testing.T) {
+		s := NewReplaySchedule([]ReplayRequest{{Offset: Duration(1 * time.Hour), URL: "https://example.com/"}})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, ok := s.Wait(ctx)
+		assert.False(t, ok)
+	})
+}
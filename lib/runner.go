@@ -55,6 +55,18 @@ type Runner interface {
 	SetOptions(opts Options)
 }
 
+// A Runner that can warm up DNS lookups and connections before a test starts, for the prewarm
+// option. This is optional; cmd/run.go checks for it after the engine is created.
+type Prewarmer interface {
+	Prewarm(ctx context.Context) error
+}
+
+// A Runner that records HTTP traffic for the harExport option, which cmd/run.go writes out at the
+// end of the test. This is optional; the recorder is nil without the option.
+type HARRunner interface {
+	GetHARRecorder() *HARRecorder
+}
+
 // A VU is a Virtual User, that can be scheduled by an Executor.
 type VU interface {
 	// Runs the VU once. The VU is responsible for handling the Halting Problem, eg. making sure
@@ -0,0 +1,98 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignAWSV4(t *testing.T) {
+	// From the AWS Signature Version 4 test suite.
+	creds := AWSCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	testdata := map[string]string{
+		"https://example.amazonaws.com/":                             "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		"https://example.amazonaws.com/?Param2=value2&Param1=value1": "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+	}
+	for u, signature := range testdata {
+		t.Run(u, func(t *testing.T) {
+			req, err := http.NewRequest("GET", u, nil)
+			if !assert.NoError(t, err) {
+				return
+			}
+			SignAWSV4(req, nil, creds, "us-east-1", "service", now)
+			assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+			assert.Equal(t,
+				"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+					"SignedHeaders=host;x-amz-date, Signature="+signature,
+				req.Header.Get("Authorization"),
+			)
+		})
+	}
+}
+
+func TestRequestSigning(t *testing.T) {
+	t.Run("Unmarshal", func(t *testing.T) {
+		var signing RequestSigning
+		assert.NoError(t, json.Unmarshal([]byte(`{"algorithm":"aws-sigv4","region":"eu-west-1","service":"execute-api"}`), &signing))
+		assert.Equal(t, RequestSigning{Algorithm: RequestSigningAWSSigV4, Region: "eu-west-1", Service: "execute-api"}, signing)
+
+		assert.EqualError(t, json.Unmarshal([]byte(`{"algorithm":"hmac"}`), &signing), "unknown request signing algorithm: hmac")
+		assert.EqualError(t, json.Unmarshal([]byte(`{"algorithm":"aws-sigv4","credentials":"file","region":"a","service":"b"}`), &signing),
+			"unknown request signing credentials source: file")
+		assert.EqualError(t, json.Unmarshal([]byte(`{"algorithm":"aws-sigv4","region":"a"}`), &signing),
+			"request signing needs a region and a service")
+	})
+	t.Run("Sign", func(t *testing.T) {
+		for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"} {
+			defer os.Setenv(name, os.Getenv(name))
+		}
+		signing := &RequestSigning{
+			Algorithm: RequestSigningAWSSigV4,
+			Region:    "us-east-1",
+			Service:   "service",
+			Hosts:     []string{"*.amazonaws.com"},
+		}
+
+		_ = os.Unsetenv("AWS_ACCESS_KEY_ID")
+		req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+		assert.EqualError(t, signing.Sign(req, nil, time.Now()),
+			"request signing needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY to be set")
+
+		_ = os.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+		_ = os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+		_ = os.Setenv("AWS_SESSION_TOKEN", "token")
+		assert.NoError(t, signing.Sign(req, nil, time.Now()))
+		assert.Equal(t, "token", req.Header.Get("X-Amz-Security-Token"))
+		assert.Contains(t, req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-date;x-amz-security-token,")
+
+		req, _ = http.NewRequest("GET", "https://example.com/", nil)
+		assert.NoError(t, signing.Sign(req, nil, time.Now()))
+		assert.Empty(t, req.Header.Get("Authorization"))
+	})
+}
@@ -0,0 +1,109 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/loadimpact/k6/stats"
+)
+
+// Version of the RunExport schema. Bump this when making incompatible changes to it.
+const RunExportVersion = 1
+
+// The results of a finished test run.
+type Summary struct {
+	Root    *Group
+	Metrics map[string]*stats.Metric
+	Time    time.Duration
+}
+
+// A metric's final values in a RunExport.
+type RunExportMetric struct {
+	Type     stats.MetricType   `json:"type"`
+	Contains stats.ValueType    `json:"contains"`
+	Values   map[string]float64 `json:"values"`
+
+	// Thresholds, by source, and whether they passed.
+	Thresholds map[string]bool `json:"thresholds,omitempty"`
+}
+
+// A check's final counts in a RunExport.
+type RunExportCheck struct {
+	Passes int64 `json:"passes"`
+	Fails  int64 `json:"fails"`
+}
+
+// The effective options and final results of a run, in a stable format meant to be diffed
+// against other runs. Metrics and checks are keyed by name and path respectively.
+type RunExport struct {
+	Version  int                        `json:"version"`
+	Variant  string                     `json:"variant,omitempty"`
+	Options  Options                    `json:"options"`
+	Duration Duration                   `json:"duration"`
+	Metrics  map[string]RunExportMetric `json:"metrics"`
+	Checks   map[string]RunExportCheck  `json:"checks"`
+}
+
+// Exports a run's options and results as indented JSON, with keys in a stable order. Client
+// certificates and keys in the options are redacted; see Options.Redacted().
+func ExportRun(o Options, summary Summary) ([]byte, error) {
+	export := RunExport{
+		Version:  RunExportVersion,
+		Variant:  o.Variant.String,
+		Options:  o.Redacted(),
+		Duration: Duration(summary.Time),
+		Metrics:  make(map[string]RunExportMetric, len(summary.Metrics)),
+		Checks:   make(map[string]RunExportCheck),
+	}
+
+	for name, m := range summary.Metrics {
+		m.Sink.Calc()
+		em := RunExportMetric{
+			Type:     m.Type,
+			Contains: m.Contains,
+			Values:   m.Sink.Format(summary.Time),
+		}
+		if len(m.Thresholds.Thresholds) > 0 {
+			em.Thresholds = make(map[string]bool, len(m.Thresholds.Thresholds))
+			for _, th := range m.Thresholds.Thresholds {
+				em.Thresholds[th.Source] = !th.Failed
+			}
+		}
+		export.Metrics[name] = em
+	}
+
+	if summary.Root != nil {
+		exportChecks(export.Checks, summary.Root)
+	}
+
+	return json.MarshalIndent(export, "", "  ")
+}
+
+func exportChecks(checks map[string]RunExportCheck, group *Group) {
+	for _, check := range group.Checks {
+		checks[check.Path] = RunExportCheck{Passes: check.Passes, Fails: check.Fails}
+	}
+	for _, g := range group.Groups {
+		exportChecks(checks, g)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/loadimpact/k6/stats"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestExportRun(t *testing.T) {
+	root, err := NewGroup("", nil)
+	assert.NoError(t, err)
+	g, err := root.Group("my group")
+	assert.NoError(t, err)
+	check, err := g.Check("my check")
+	assert.NoError(t, err)
+	check.Passes = 3
+	check.Fails = 1
+
+	trend := stats.New("my_trend", stats.Trend, stats.Time)
+	trend.Sink.Add(stats.Sample{Value: 1})
+	trend.Sink.Add(stats.Sample{Value: 3})
+	ths, err := stats.NewThresholds([]string{"avg<10", "max<2"})
+	assert.NoError(t, err)
+	trend.Thresholds = ths
+	_, err = trend.Thresholds.Run(trend.Sink, 0)
+	assert.NoError(t, err)
+
+	counter := stats.New("my_counter", stats.Counter)
+	counter.Sink.Add(stats.Sample{Value: 5})
+
+	opts := Options{VUs: null.IntFrom(10), Variant: null.StringFrom("canary")}
+	data, err := ExportRun(opts, Summary{
+		Root:    root,
+		Metrics: map[string]*stats.Metric{"my_trend": trend, "my_counter": counter},
+		Time:    10 * time.Second,
+	})
+	assert.NoError(t, err)
+
+	var export RunExport
+	assert.NoError(t, json.Unmarshal(data, &export))
+	assert.Equal(t, RunExportVersion, export.Version)
+	assert.Equal(t, "canary", export.Variant)
+	assert.Equal(t, null.IntFrom(10), export.Options.VUs)
+	assert.Equal(t, Duration(10*time.Second), export.Duration)
+	assert.Equal(t, RunExportCheck{Passes: 3, Fails: 1}, export.Checks["::my group::my check"])
+
+	if assert.Contains(t, export.Metrics, "my_trend") {
+		m := export.Metrics["my_trend"]
+		assert.Equal(t, stats.Trend, m.Type)
+		assert.Equal(t, stats.Time, m.Contains)
+		assert.Equal(t, 2.0, m.Values["avg"])
+		assert.Equal(t, map[string]bool{"avg<10": true, "max<2": false}, m.Thresholds)
+	}
+	if assert.Contains(t, export.Metrics, "my_counter") {
+		assert.Equal(t, 5.0, export.Metrics["my_counter"].Values["count"])
+		assert.Nil(t, export.Metrics["my_counter"].Thresholds)
+	}
+
+	t.Run("Stable", func(t *testing.T) {
+		data2, err := ExportRun(opts, Summary{
+			Root:    root,
+			Metrics: map[string]*stats.Metric{"my_counter": counter, "my_trend": trend},
+			Time:    10 * time.Second,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, string(data), string(data2))
+	})
+
+	t.Run("Redacted", func(t *testing.T) {
+		auth := &TLSAuth{TLSAuthFields: TLSAuthFields{Cert: "my cert", Key: "my key"}}
+		opts := Options{
+			TLSAuth:       []*TLSAuth{auth},
+			TLSAuthByHost: map[string]*TLSAuth{"*.example.com": auth},
+			PerHostTLS:    map[string]HostTLSConfig{"api.example.com": {Auth: auth}},
+		}
+		data, err := ExportRun(opts, Summary{Time: 10 * time.Second})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		var export struct {
+			Options struct {
+				TLSAuth       []TLSAuthFields          `json:"tlsAuth"`
+				TLSAuthByHost map[string]TLSAuthFields `json:"tlsAuthByHost"`
+				PerHostTLS    map[string]struct {
+					Auth TLSAuthFields `json:"auth"`
+				} `json:"perHostTLS"`
+			} `json:"options"`
+		}
+		assert.NoError(t, json.Unmarshal(data, &export))
+		redacted := TLSAuthFields{Cert: RedactedSecret, Key: RedactedSecret}
+		assert.Equal(t, []TLSAuthFields{redacted}, export.Options.TLSAuth)
+		assert.Equal(t, redacted, export.Options.TLSAuthByHost["*.example.com"])
+		assert.Equal(t, redacted, export.Options.PerHostTLS["api.example.com"].Auth)
+		assert.NotContains(t, string(data), "my key")
+		assert.Equal(t, "my key", auth.Key)
+	})
+}
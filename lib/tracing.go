@@ -0,0 +1,81 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"net/http"
+)
+
+// A generated trace, for a single request.
+type Trace struct {
+	TraceID string
+	SpanID  string
+	Sampled bool
+}
+
+// Generates a new trace, sampled according to the Sampling rate.
+func (t Tracing) NewTrace() (Trace, error) {
+	var id [24]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return Trace{}, err
+	}
+
+	// Derive the sampling decision from the trace ID, so it's consistent for a given trace.
+	sampled := t.Sampling >= 1
+	if !sampled && t.Sampling > 0 {
+		sampled = float64(binary.BigEndian.Uint64(id[8:16])) < t.Sampling*math.MaxUint64
+	}
+	return Trace{
+		TraceID: hex.EncodeToString(id[:16]),
+		SpanID:  hex.EncodeToString(id[16:]),
+		Sampled: sampled,
+	}, nil
+}
+
+// Sets headers for the trace in the configured propagation formats.
+func (t Tracing) Inject(h http.Header, tr Trace) {
+	propagators := t.Propagators
+	if len(propagators) == 0 {
+		propagators = []string{TracingW3C}
+	}
+
+	flags, sampled := "00", "0"
+	if tr.Sampled {
+		flags, sampled = "01", "1"
+	}
+	for _, p := range propagators {
+		switch p {
+		case TracingW3C:
+			h.Set("traceparent", "00-"+tr.TraceID+"-"+tr.SpanID+"-"+flags)
+			if t.TraceState != "" {
+				h.Set("tracestate", t.TraceState)
+			}
+		case TracingB3:
+			h.Set("X-B3-TraceId", tr.TraceID)
+			h.Set("X-B3-SpanId", tr.SpanID)
+			h.Set("X-B3-Sampled", sampled)
+		}
+	}
+}
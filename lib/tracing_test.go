@@ -0,0 +1,77 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracingNewTrace(t *testing.T) {
+	tr, err := Tracing{Sampling: 1}.NewTrace()
+	assert.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{32}$`), tr.TraceID)
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{16}$`), tr.SpanID)
+	assert.True(t, tr.Sampled)
+
+	tr2, err := Tracing{Sampling: 1}.NewTrace()
+	assert.NoError(t, err)
+	assert.NotEqual(t, tr.TraceID, tr2.TraceID)
+
+	t.Run("Sampling", func(t *testing.T) {
+		sampled := 0
+		for i := 0; i < 1000; i++ {
+			tr, err := Tracing{Sampling: 0.5}.NewTrace()
+			assert.NoError(t, err)
+			if tr.Sampled {
+				sampled++
+			}
+		}
+		assert.InDelta(t, 500, sampled, 100)
+
+		tr, err := Tracing{Sampling: 0}.NewTrace()
+		assert.NoError(t, err)
+		assert.False(t, tr.Sampled)
+	})
+}
+
+func TestTracingInject(t *testing.T) {
+	tr := Trace{TraceID: "0af7651916cd43dd8448eb211c80319c", SpanID: "b7ad6b7169203331", Sampled: true}
+
+	t.Run("Default", func(t *testing.T) {
+		h := http.Header{}
+		Tracing{TraceState: "k6=1"}.Inject(h, tr)
+		assert.Equal(t, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", h.Get("traceparent"))
+		assert.Equal(t, "k6=1", h.Get("tracestate"))
+		assert.Equal(t, "", h.Get("X-B3-TraceId"))
+	})
+	t.Run("B3", func(t *testing.T) {
+		h := http.Header{}
+		Tracing{Propagators: []string{TracingB3}}.Inject(h, Trace{TraceID: tr.TraceID, SpanID: tr.SpanID})
+		assert.Equal(t, "", h.Get("traceparent"))
+		assert.Equal(t, tr.TraceID, h.Get("X-B3-TraceId"))
+		assert.Equal(t, tr.SpanID, h.Get("X-B3-SpanId"))
+		assert.Equal(t, "0", h.Get("X-B3-Sampled"))
+	})
+}
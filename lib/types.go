@@ -24,6 +24,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 // Duration is an alias for time.Duration that de/serialises to JSON as human-readable strings.
@@ -112,3 +114,47 @@ func (d NullDuration) MarshalJSON() ([]byte, error) {
 	}
 	return d.Duration.MarshalJSON()
 }
+
+// NullTime is a nullable point in time, de/serialised as an RFC3339 timestamp, eg.
+// "2006-01-02T15:04:05Z" or "2006-01-02T15:04:05+02:00".
+type NullTime struct {
+	time.Time
+	Valid bool
+}
+
+// Creates a valid NullTime from a time.Time.
+func NullTimeFrom(t time.Time) NullTime {
+	return NullTime{t, true}
+}
+
+func (t *NullTime) UnmarshalText(data []byte) error {
+	if len(data) == 0 {
+		*t = NullTime{}
+		return nil
+	}
+	v, err := time.Parse(time.RFC3339, string(data))
+	if err != nil {
+		return errors.Errorf("invalid time %q, expected an RFC3339 timestamp like 2006-01-02T15:04:05Z", data)
+	}
+	*t = NullTimeFrom(v)
+	return nil
+}
+
+func (t *NullTime) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte(`null`)) {
+		*t = NullTime{}
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return errors.Errorf("invalid time %s, expected an RFC3339 timestamp like 2006-01-02T15:04:05Z", data)
+	}
+	return t.UnmarshalText([]byte(str))
+}
+
+func (t NullTime) MarshalJSON() ([]byte, error) {
+	if !t.Valid {
+		return []byte(`null`), nil
+	}
+	return json.Marshal(t.Time.Format(time.RFC3339Nano))
+}
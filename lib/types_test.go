@@ -122,3 +122,58 @@ func TestNullDuration(t *testing.T) {
 func TestNullDurationFrom(t *testing.T) {
 	assert.Equal(t, NullDuration{Duration(10 * time.Second), true}, NullDurationFrom(10*time.Second))
 }
+
+func TestNullTime(t *testing.T) {
+	ts := time.Date(2018, 3, 1, 12, 30, 0, 0, time.UTC)
+	t.Run("JSON", func(t *testing.T) {
+		t.Run("Unmarshal", func(t *testing.T) {
+			t.Run("UTC", func(t *testing.T) {
+				var v NullTime
+				assert.NoError(t, json.Unmarshal([]byte(`"2018-03-01T12:30:00Z"`), &v))
+				assert.True(t, v.Valid)
+				assert.True(t, ts.Equal(v.Time))
+			})
+			t.Run("Offset", func(t *testing.T) {
+				var v NullTime
+				assert.NoError(t, json.Unmarshal([]byte(`"2018-03-01T14:30:00+02:00"`), &v))
+				assert.True(t, v.Valid)
+				assert.True(t, ts.Equal(v.Time))
+			})
+			t.Run("Null", func(t *testing.T) {
+				var v NullTime
+				assert.NoError(t, json.Unmarshal([]byte(`null`), &v))
+				assert.Equal(t, NullTime{}, v)
+			})
+			t.Run("Invalid", func(t *testing.T) {
+				var v NullTime
+				assert.EqualError(t, json.Unmarshal([]byte(`"2018-03-01 12:30"`), &v),
+					`invalid time "2018-03-01 12:30", expected an RFC3339 timestamp like 2006-01-02T15:04:05Z`)
+				assert.EqualError(t, json.Unmarshal([]byte(`1519907400`), &v),
+					`invalid time 1519907400, expected an RFC3339 timestamp like 2006-01-02T15:04:05Z`)
+			})
+		})
+		t.Run("Marshal", func(t *testing.T) {
+			t.Run("Valid", func(t *testing.T) {
+				data, err := json.Marshal(NullTimeFrom(ts))
+				assert.NoError(t, err)
+				assert.Equal(t, `"2018-03-01T12:30:00Z"`, string(data))
+			})
+			t.Run("null", func(t *testing.T) {
+				data, err := json.Marshal(NullTime{})
+				assert.NoError(t, err)
+				assert.Equal(t, `null`, string(data))
+			})
+		})
+	})
+	t.Run("Text", func(t *testing.T) {
+		var v NullTime
+		assert.NoError(t, v.UnmarshalText([]byte(`2018-03-01T12:30:00Z`)))
+		assert.Equal(t, NullTimeFrom(ts), v)
+
+		t.Run("Empty", func(t *testing.T) {
+			var v NullTime
+			assert.NoError(t, v.UnmarshalText([]byte(``)))
+			assert.Equal(t, NullTime{}, v)
+		})
+	})
+}
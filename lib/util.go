@@ -22,6 +22,7 @@ package lib
 
 import (
 	"strings"
+	"time"
 )
 
 // Returns the total sum of time taken by the given set of stages.
@@ -35,6 +36,22 @@ func SumStages(stages []Stage) (d NullDuration) {
 	return d
 }
 
+// Returns the index of the stage running at the given point in a test, or -1 if there are no
+// stages. Stages without a duration run forever, and past the end, the last stage is returned.
+func StageIndexAt(stages []Stage, t time.Duration) int {
+	var end time.Duration
+	for i, stage := range stages {
+		if !stage.Duration.Valid {
+			return i
+		}
+		end += time.Duration(stage.Duration.Duration)
+		if t < end {
+			return i
+		}
+	}
+	return len(stages) - 1
+}
+
 // Splits a string in the form "key=value".
 func SplitKV(s string) (key, value string) {
 	parts := strings.SplitN(s, "=", 2)
@@ -62,6 +79,59 @@ func Clampf(val, min, max float64) float64 {
 	}
 }
 
+// Checks whether a hostname matches a pattern, case insensitively. A pattern may be an exact
+// hostname, "*" to match anything, or "*.example.com" to match any subdomain of example.com.
+func MatchHost(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	host = strings.ToLower(host)
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasPrefix(pattern, "*."):
+		return strings.HasSuffix(host, pattern[1:])
+	default:
+		return pattern == host
+	}
+}
+
+// Returns the most specific of the given patterns that matches a hostname: an exact match wins
+// over wildcards, longer wildcards win over shorter ones, and "*" only matches as a last resort.
+func BestHostMatch(patterns []string, host string) (string, bool) {
+	m := newHostMatcher(host)
+	for _, pattern := range patterns {
+		m.add(pattern)
+	}
+	return m.result()
+}
+
+// Finds the best match for a hostname like BestHostMatch, one pattern at a time, for patterns
+// that aren't in a slice. Ties go to the pattern that sorts first, so the order doesn't matter.
+type hostMatcher struct {
+	host, best string
+	score      int
+}
+
+func newHostMatcher(host string) hostMatcher {
+	return hostMatcher{host: host, score: -1}
+}
+
+func (m *hostMatcher) add(pattern string) {
+	if !MatchHost(pattern, m.host) {
+		return
+	}
+	score := len(pattern)
+	if !strings.HasPrefix(pattern, "*") {
+		score += len(m.host) + 1 // Beats any wildcard that could match the same host.
+	}
+	if score > m.score || (score == m.score && pattern < m.best) {
+		m.best, m.score = pattern, score
+	}
+}
+
+func (m hostMatcher) result() (string, bool) {
+	return m.best, m.score >= 0
+}
+
 // Returns the maximum value of a and b.
 func Max(a, b int64) int64 {
 	if a > b {
@@ -59,6 +59,19 @@ func TestSumStages(t *testing.T) {
 	}
 }
 
+func TestStageIndexAt(t *testing.T) {
+	stages := []Stage{
+		{Duration: NullDurationFrom(5 * time.Second)},
+		{Duration: NullDurationFrom(10 * time.Second)},
+	}
+	assert.Equal(t, -1, StageIndexAt(nil, 0))
+	assert.Equal(t, 0, StageIndexAt(stages, 0))
+	assert.Equal(t, 0, StageIndexAt(stages, 4*time.Second))
+	assert.Equal(t, 1, StageIndexAt(stages, 5*time.Second))
+	assert.Equal(t, 1, StageIndexAt(stages, 20*time.Second))
+	assert.Equal(t, 1, StageIndexAt(append(stages[:1], Stage{}), time.Hour))
+}
+
 func TestSplitKV(t *testing.T) {
 	testdata := map[string]struct {
 		k string
@@ -155,6 +168,44 @@ func TestClampf(t *testing.T) {
 	}
 }
 
+func TestMatchHost(t *testing.T) {
+	testdata := []struct {
+		pattern, host string
+		match         bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "EXAMPLE.com", true},
+		{"example.com", "www.example.com", false},
+		{"*", "example.com", true},
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "badexample.com", false},
+	}
+	for _, data := range testdata {
+		assert.Equal(t, data.match, MatchHost(data.pattern, data.host), "%s %s", data.pattern, data.host)
+	}
+}
+
+func TestBestHostMatch(t *testing.T) {
+	patterns := []string{"*", "*.example.com", "*.www.example.com", "example.com", "www.example.com"}
+	testdata := map[string]string{
+		"example.com":       "example.com",
+		"www.example.com":   "www.example.com",
+		"a.www.example.com": "*.www.example.com",
+		"api.example.com":   "*.example.com",
+		"example.org":       "*",
+	}
+	for host, expected := range testdata {
+		pattern, ok := BestHostMatch(patterns, host)
+		assert.True(t, ok, host)
+		assert.Equal(t, expected, pattern, host)
+	}
+
+	_, ok := BestHostMatch([]string{"example.com"}, "example.org")
+	assert.False(t, ok)
+}
+
 func TestMin(t *testing.T) {
 	assert.Equal(t, int64(10), Min(10, 100))
 	assert.Equal(t, int64(10), Min(100, 10))
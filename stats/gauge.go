@@ -0,0 +1,124 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"bytes"
+	"sort"
+	"time"
+)
+
+// Modes for setting gauges back to 0 on their own, rather than having them keep their last value.
+const (
+	GaugeResetIteration = "iteration" // At the end of every iteration that set them.
+	GaugeResetInterval  = "interval"  // Once a metrics interval passes without them being set.
+)
+
+// Returns a key identifying the time series a sample belongs to, by its metric name and tags.
+func SeriesKey(s Sample) string {
+	keys := make([]string, 0, len(s.Tags))
+	for k := range s.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	b.WriteString(s.Metric.Name)
+	for _, k := range keys {
+		b.WriteByte('|')
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(s.Tags[k])
+	}
+	return b.String()
+}
+
+// Returns a sample setting each gauge series in samples back to 0 at time t, for the gauges
+// reset accepts, unless its last value already was 0.
+func ZeroGauges(samples []Sample, t time.Time, reset func(m *Metric) bool) []Sample {
+	var keys []string
+	last := make(map[string]Sample)
+	for _, s := range samples {
+		if s.Metric.Type != Gauge || !reset(s.Metric) {
+			continue
+		}
+		key := SeriesKey(s)
+		if _, ok := last[key]; !ok {
+			keys = append(keys, key)
+		}
+		last[key] = s
+	}
+
+	var zeros []Sample
+	for _, key := range keys {
+		if s := last[key]; s.Value != 0 {
+			zeros = append(zeros, Sample{Metric: s.Metric, Time: t, Tags: s.Tags, Value: 0})
+		}
+	}
+	return zeros
+}
+
+// Tracks gauge series that were set to a nonzero value, to set them back to 0 once they haven't
+// been set for a while. Not safe for concurrent use.
+type GaugeResetter struct {
+	series map[string]*gaugeSeries
+}
+
+type gaugeSeries struct {
+	sample Sample
+	fresh  bool
+}
+
+func NewGaugeResetter() *GaugeResetter {
+	return &GaugeResetter{series: make(map[string]*gaugeSeries)}
+}
+
+// Records a gauge sample. Samples setting a gauge to 0 stop tracking its series.
+func (r *GaugeResetter) Add(s Sample) {
+	key := SeriesKey(s)
+	if s.Value == 0 {
+		delete(r.series, key)
+		return
+	}
+	r.series[key] = &gaugeSeries{sample: s, fresh: true}
+}
+
+// Returns samples setting every series that wasn't set since the last call back to 0 at time t,
+// sorted by series, and stops tracking them.
+func (r *GaugeResetter) Stale(t time.Time) []Sample {
+	var keys []string
+	for key, series := range r.series {
+		if series.fresh {
+			series.fresh = false
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	zeros := make([]Sample, 0, len(keys))
+	for _, key := range keys {
+		s := r.series[key].sample
+		zeros = append(zeros, Sample{Metric: s.Metric, Time: t, Tags: s.Tags, Value: 0})
+		delete(r.series, key)
+	}
+	return zeros
+}
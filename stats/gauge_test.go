@@ -0,0 +1,81 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeriesKey(t *testing.T) {
+	m := New("my_metric", Gauge)
+	assert.Equal(t, "my_metric", SeriesKey(Sample{Metric: m}))
+	assert.Equal(t, "my_metric|a=1|b=2", SeriesKey(Sample{Metric: m, Tags: map[string]string{"b": "2", "a": "1"}}))
+	assert.NotEqual(t,
+		SeriesKey(Sample{Metric: m, Tags: map[string]string{"a": "1"}}),
+		SeriesKey(Sample{Metric: m, Tags: map[string]string{"a": "2"}}),
+	)
+}
+
+func TestZeroGauges(t *testing.T) {
+	reset := New("reset", Gauge)
+	kept := New("kept", Gauge)
+	trend := New("reset_trend", Trend)
+	now := time.Now()
+
+	zeros := ZeroGauges([]Sample{
+		{Metric: reset, Value: 1, Tags: map[string]string{"a": "1"}},
+		{Metric: reset, Value: 2, Tags: map[string]string{"a": "2"}},
+		{Metric: reset, Value: 3, Tags: map[string]string{"a": "1"}},
+		{Metric: reset, Value: 4, Tags: map[string]string{"a": "3"}},
+		{Metric: reset, Value: 0, Tags: map[string]string{"a": "3"}},
+		{Metric: kept, Value: 5},
+		{Metric: trend, Value: 6},
+	}, now, func(m *Metric) bool { return m.Name != "kept" })
+	assert.Equal(t, []Sample{
+		{Metric: reset, Time: now, Tags: map[string]string{"a": "1"}},
+		{Metric: reset, Time: now, Tags: map[string]string{"a": "2"}},
+	}, zeros)
+}
+
+func TestGaugeResetter(t *testing.T) {
+	m := New("my_metric", Gauge)
+	r := NewGaugeResetter()
+	now := time.Now()
+
+	r.Add(Sample{Metric: m, Value: 1, Tags: map[string]string{"a": "1"}})
+	r.Add(Sample{Metric: m, Value: 1, Tags: map[string]string{"a": "2"}})
+	assert.Empty(t, r.Stale(now))
+
+	r.Add(Sample{Metric: m, Value: 2, Tags: map[string]string{"a": "2"}})
+	assert.Equal(t, []Sample{{Metric: m, Time: now, Tags: map[string]string{"a": "1"}}}, r.Stale(now))
+	assert.Equal(t, []Sample{{Metric: m, Time: now, Tags: map[string]string{"a": "2"}}}, r.Stale(now))
+	assert.Empty(t, r.Stale(now))
+
+	t.Run("Zero", func(t *testing.T) {
+		r.Add(Sample{Metric: m, Value: 1})
+		r.Add(Sample{Metric: m, Value: 0})
+		assert.Empty(t, r.Stale(now))
+		assert.Empty(t, r.Stale(now))
+	})
+}
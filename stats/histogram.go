@@ -0,0 +1,112 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// The relative precision of histograms exported at the end of a test; 1%.
+const DefaultHistogramPrecision = 0.01
+
+// A histogram of a trend metric's values, HDR-style: bucket widths grow exponentially, so every
+// value is within a fixed relative precision of its bucket's bounds. Histograms of the same metric
+// with the same precision, eg. from several instances, merge by adding up the counts of buckets
+// with equal bounds, and percentiles can be computed from the result.
+type Histogram struct {
+	Precision float64           `json:"precision"`
+	Count     uint64            `json:"count"`
+	Min       float64           `json:"min"`
+	Max       float64           `json:"max"`
+	Sum       float64           `json:"sum"`
+	Buckets   []HistogramBucket `json:"buckets"`
+}
+
+// A histogram bucket, counting values v where Lower <= v < Upper; Lower < v <= Upper for negative
+// values. Zeroes are counted in a bucket with both bounds set to 0.
+type HistogramBucket struct {
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+	Count uint64  `json:"count"`
+}
+
+// Identifies a histogram bucket by the sign of its values and its index.
+type histogramKey struct {
+	sign  int
+	index int
+}
+
+// Builds a histogram of the given values, with buckets ordered by their bounds. A precision that
+// isn't positive means DefaultHistogramPrecision.
+func NewHistogram(values []float64, precision float64) Histogram {
+	if precision <= 0 {
+		precision = DefaultHistogramPrecision
+	}
+	h := Histogram{Precision: precision, Buckets: []HistogramBucket{}}
+	growth := 1 + precision
+
+	counts := make(map[histogramKey]uint64)
+	for i, v := range values {
+		h.Count++
+		h.Sum += v
+		if v < h.Min || i == 0 {
+			h.Min = v
+		}
+		if v > h.Max || i == 0 {
+			h.Max = v
+		}
+
+		var key histogramKey
+		switch {
+		case v > 0:
+			key.sign = 1
+		case v < 0:
+			key.sign = -1
+		}
+		if key.sign != 0 {
+			abs := math.Abs(v)
+			key.index = int(math.Floor(math.Log(abs) / math.Log(growth)))
+			// Logarithms can be off by a hair at the bucket bounds.
+			if abs < math.Pow(growth, float64(key.index)) {
+				key.index--
+			} else if abs >= math.Pow(growth, float64(key.index+1)) {
+				key.index++
+			}
+		}
+		counts[key]++
+	}
+
+	for key, count := range counts {
+		var b HistogramBucket
+		if key.sign != 0 {
+			lower, upper := math.Pow(growth, float64(key.index)), math.Pow(growth, float64(key.index+1))
+			if key.sign < 0 {
+				lower, upper = -upper, -lower
+			}
+			b.Lower, b.Upper = lower, upper
+		}
+		b.Count = count
+		h.Buckets = append(h.Buckets, b)
+	}
+	sort.Slice(h.Buckets, func(i, j int) bool { return h.Buckets[i].Lower < h.Buckets[j].Lower })
+	return h
+}
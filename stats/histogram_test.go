@@ -0,0 +1,78 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHistogram(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		h := NewHistogram(nil, 0)
+		assert.Equal(t, DefaultHistogramPrecision, h.Precision)
+		assert.Equal(t, uint64(0), h.Count)
+		assert.Empty(t, h.Buckets)
+	})
+	t.Run("Values", func(t *testing.T) {
+		values := []float64{100, 0, 101, -5, 100.5, 0, 200}
+		h := NewHistogram(values, 0.01)
+		assert.Equal(t, uint64(7), h.Count)
+		assert.Equal(t, float64(-5), h.Min)
+		assert.Equal(t, float64(200), h.Max)
+		assert.InDelta(t, 496.5, h.Sum, 0.0001)
+
+		var total uint64
+		for i, b := range h.Buckets {
+			total += b.Count
+			if i > 0 {
+				assert.True(t, h.Buckets[i-1].Upper <= b.Lower, "buckets overlap: %v, %v", h.Buckets[i-1], b)
+			}
+			if b.Lower > 0 {
+				assert.InDelta(t, 1.01, b.Upper/b.Lower, 0.000001)
+			} else if b.Upper < 0 {
+				assert.InDelta(t, 1.01, b.Lower/b.Upper, 0.000001)
+			} else {
+				assert.Equal(t, HistogramBucket{Count: 2}, b)
+			}
+		}
+		assert.Equal(t, h.Count, total)
+
+		for _, v := range values {
+			var found int
+			for _, b := range h.Buckets {
+				if (v == 0 && b.Lower == 0 && b.Upper == 0) ||
+					(v > 0 && b.Lower <= v && v < b.Upper) ||
+					(v < 0 && b.Lower < v && v <= b.Upper) {
+					found++
+				}
+			}
+			assert.Equal(t, 1, found, "buckets for %v", v)
+		}
+	})
+	t.Run("Merge", func(t *testing.T) {
+		a := NewHistogram([]float64{10, 20}, 0.01)
+		b := NewHistogram([]float64{20.01, 30}, 0.01)
+		assert.Equal(t, a.Buckets[1].Lower, b.Buckets[0].Lower)
+		assert.Equal(t, a.Buckets[1].Upper, b.Buckets[0].Upper)
+	})
+}
@@ -42,6 +42,9 @@ type Collector struct {
 	Config    Config
 	BatchConf client.BatchPointsConfig
 
+	// Write counter values as integers and all others as floats, instead of all as floats.
+	TypedValues bool
+
 	buffer     []stats.Sample
 	bufferLock sync.Mutex
 }
@@ -108,10 +111,19 @@ func (c *Collector) commit() {
 	}
 
 	for _, sample := range samples {
+		var value interface{} = sample.Value
+		if c.TypedValues && sample.Metric.Type == stats.Counter {
+			value = sample.IntValue()
+		}
+		fields := map[string]interface{}{"value": value}
+		if sample.TraceID != "" {
+			// A field rather than a tag, so trace IDs don't each make a new series.
+			fields["trace_id"] = sample.TraceID
+		}
 		p, err := client.NewPoint(
 			sample.Metric.Name,
 			sample.Tags,
-			map[string]interface{}{"value": sample.Value},
+			fields,
 			sample.Time,
 		)
 		if err != nil {
@@ -35,6 +35,9 @@ type Collector struct {
 	outfile     io.WriteCloser
 	fname       string
 	seenMetrics []string
+
+	// Write counter values as integers and all others as floats, instead of as-is.
+	TypedValues bool
 }
 
 func (c *Collector) HasSeenMetric(str string) bool {
@@ -100,7 +103,12 @@ func (c *Collector) Collect(samples []stats.Sample) {
 	for _, sample := range samples {
 		c.HandleMetric(sample.Metric)
 
-		env := WrapSample(&sample)
+		var env *Envelope
+		if c.TypedValues {
+			env = WrapTypedSample(&sample)
+		} else {
+			env = WrapSample(&sample)
+		}
 		row, err := json.Marshal(env)
 
 		if err != nil || env == nil {
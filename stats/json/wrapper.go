@@ -26,6 +26,18 @@ import (
 	"github.com/loadimpact/k6/stats"
 )
 
+// Every line the JSON output writes is an Envelope. The first time a metric is seen, it's
+// described by one of type "Metric", whose data is the metric itself (its name, type, and so
+// on); each sample is then one of type "Point", whose data is a JSONSample:
+//
+//	{"type":"Metric","data":{"name":"http_reqs","type":"counter",...},"metric":"http_reqs"}
+//	{"type":"Point","data":{"time":"...","value":1,"tags":{...}},"metric":"http_reqs"}
+//
+// Points of exemplar samples also have an "exemplar" with their trace ID, eg.
+// {"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736"}.
+//
+// This is also what the ndjson stdoutFormat writes, and other tools are expected to parse it;
+// fields may be added, but not removed or changed.
 type Envelope struct {
 	Type   string      `json:"type"`
 	Data   interface{} `json:"data"`
@@ -34,16 +46,35 @@ type Envelope struct {
 
 type JSONSample struct {
 	Time  time.Time         `json:"time"`
-	Value float64           `json:"value"`
+	Value interface{}       `json:"value"`
 	Tags  map[string]string `json:"tags"`
+
+	// Labels of the sample's exemplar, like OpenMetrics ones; only "trace_id" for now.
+	Exemplar map[string]string `json:"exemplar,omitempty"`
 }
 
 func NewJSONSample(sample *stats.Sample) *JSONSample {
-	return &JSONSample{
+	s := &JSONSample{
 		Time:  sample.Time,
 		Value: sample.Value,
 		Tags:  sample.Tags,
 	}
+	if sample.TraceID != "" {
+		s.Exemplar = map[string]string{"trace_id": sample.TraceID}
+	}
+	return s
+}
+
+// Like NewJSONSample, but counter values are integers and all others are floats, even when
+// they're whole numbers.
+func NewTypedJSONSample(sample *stats.Sample) *JSONSample {
+	s := NewJSONSample(sample)
+	if sample.Metric != nil && sample.Metric.Type == stats.Counter {
+		s.Value = sample.IntValue()
+	} else {
+		s.Value = stats.Float(sample.Value)
+	}
+	return s
 }
 
 func WrapSample(sample *stats.Sample) *Envelope {
@@ -57,6 +88,15 @@ func WrapSample(sample *stats.Sample) *Envelope {
 	}
 }
 
+// Like WrapSample, but with a typed value; see NewTypedJSONSample.
+func WrapTypedSample(sample *stats.Sample) *Envelope {
+	env := WrapSample(sample)
+	if env != nil {
+		env.Data = NewTypedJSONSample(sample)
+	}
+	return env
+}
+
 func WrapMetric(metric *stats.Metric) *Envelope {
 	if metric == nil {
 		return nil
@@ -21,7 +21,9 @@
 package json
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/loadimpact/k6/stats"
 	"github.com/stretchr/testify/assert"
@@ -41,6 +43,52 @@ func TestWrapSampleWithSamplePointer(t *testing.T) {
 	assert.NotEqual(t, out, (*Envelope)(nil))
 }
 
+func TestWrapSampleExemplar(t *testing.T) {
+	sample := &stats.Sample{
+		Metric:  &stats.Metric{Name: "http_req_duration", Type: stats.Trend},
+		Time:    time.Unix(0, 0).UTC(),
+		Value:   123,
+		TraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+	}
+	data, err := json.Marshal(WrapSample(sample))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"type":"Point","metric":"http_req_duration","data":{
+		"time":"1970-01-01T00:00:00Z","value":123,"tags":null,
+		"exemplar":{"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736"}
+	}}`, string(data))
+
+	sample.TraceID = ""
+	data, err = json.Marshal(WrapSample(sample).Data)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "exemplar")
+}
+
+func TestWrapTypedSample(t *testing.T) {
+	testdata := map[string]struct {
+		Type     stats.MetricType
+		Value    float64
+		Expected string
+	}{
+		"counter":       {stats.Counter, 5, `5`},
+		"counter/round": {stats.Counter, 4.6, `5`},
+		"trend":         {stats.Trend, 5, `5.0`},
+		"trend/float":   {stats.Trend, 5.25, `5.25`},
+		"gauge":         {stats.Gauge, 3, `3.0`},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			env := WrapTypedSample(&stats.Sample{
+				Metric: &stats.Metric{Name: "my_metric", Type: data.Type},
+				Value:  data.Value,
+			})
+			value, err := json.Marshal(env.Data.(*JSONSample).Value)
+			assert.NoError(t, err)
+			assert.Equal(t, data.Expected, string(value))
+		})
+	}
+	assert.Equal(t, (*Envelope)(nil), WrapTypedSample(nil))
+}
+
 func TestWrapMetricWithMetricPointer(t *testing.T) {
 	out := WrapMetric(&stats.Metric{})
 	assert.NotEqual(t, out, (*Envelope)(nil))
@@ -0,0 +1,137 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package multi
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/loadimpact/k6/lib"
+	"github.com/loadimpact/k6/stats"
+)
+
+var _ lib.Collector = &Collector{}
+
+// A Collector that passes samples on to several others. Each of them is fed from its own queue,
+// so a slow one doesn't hold back the rest.
+type Collector struct {
+	outputs []*output
+}
+
+type output struct {
+	collector lib.Collector
+
+	lock    sync.Mutex
+	cond    *sync.Cond
+	queue   []stats.Sample
+	stopped bool
+}
+
+func New(collectors ...lib.Collector) *Collector {
+	c := &Collector{outputs: make([]*output, len(collectors))}
+	for i, collector := range collectors {
+		o := &output{collector: collector}
+		o.cond = sync.NewCond(&o.lock)
+		c.outputs[i] = o
+	}
+	return c
+}
+
+func (c *Collector) Init() error {
+	for _, o := range c.outputs {
+		if err := o.collector.Init(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Collector) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, o := range c.outputs {
+		wg.Add(1)
+		go func(o *output) {
+			defer wg.Done()
+			o.run(ctx)
+		}(o)
+	}
+	wg.Wait()
+}
+
+func (c *Collector) Collect(samples []stats.Sample) {
+	for _, o := range c.outputs {
+		o.lock.Lock()
+		o.queue = append(o.queue, samples...)
+		o.lock.Unlock()
+		o.cond.Signal()
+	}
+}
+
+// Returns the links of all outputs that have one, comma-separated.
+func (c *Collector) Link() string {
+	var links []string
+	for _, o := range c.outputs {
+		if l := o.collector.Link(); l != "" {
+			links = append(links, l)
+		}
+	}
+	return strings.Join(links, ", ")
+}
+
+// Runs the output's collector, feeding it queued samples until the context is done; anything
+// still queued at that point is flushed before the collector itself is stopped.
+func (o *output) run(ctx context.Context) {
+	subctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		o.collector.Run(subctx)
+		close(done)
+	}()
+
+	go func() {
+		<-ctx.Done()
+		o.lock.Lock()
+		o.stopped = true
+		o.lock.Unlock()
+		o.cond.Signal()
+	}()
+
+	for {
+		o.lock.Lock()
+		for len(o.queue) == 0 && !o.stopped {
+			o.cond.Wait()
+		}
+		samples, stopped := o.queue, o.stopped
+		o.queue = nil
+		o.lock.Unlock()
+
+		if len(samples) > 0 {
+			o.collector.Collect(samples)
+		}
+		if stopped {
+			break
+		}
+	}
+
+	cancel()
+	<-done
+}
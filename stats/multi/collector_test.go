@@ -0,0 +1,101 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package multi
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/loadimpact/k6/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCollector struct {
+	link    string
+	gate    chan struct{}
+	lock    sync.Mutex
+	samples []stats.Sample
+	stopped bool
+}
+
+func (c *testCollector) Init() error { return nil }
+
+func (c *testCollector) Run(ctx context.Context) {
+	<-ctx.Done()
+	c.lock.Lock()
+	c.stopped = true
+	c.lock.Unlock()
+}
+
+func (c *testCollector) Collect(samples []stats.Sample) {
+	if c.gate != nil {
+		<-c.gate
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.stopped {
+		panic("attempted to collect while not running")
+	}
+	c.samples = append(c.samples, samples...)
+}
+
+func (c *testCollector) Link() string { return c.link }
+
+func (c *testCollector) count() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.samples)
+}
+
+func TestCollector(t *testing.T) {
+	fast := &testCollector{link: "http://example.com/"}
+	slow := &testCollector{gate: make(chan struct{})}
+	c := New(fast, slow)
+	assert.NoError(t, c.Init())
+	assert.Equal(t, "http://example.com/", c.Link())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	// The slow collector is stuck, but that doesn't hold up Collect() or the fast one.
+	m := stats.New("my_metric", stats.Counter)
+	for i := 0; i < 3; i++ {
+		c.Collect([]stats.Sample{{Time: time.Now(), Metric: m, Value: 1}})
+	}
+	for i := 0; i < 100 && fast.count() < 3; i++ {
+		time.Sleep(1 * time.Millisecond)
+	}
+	assert.Equal(t, 3, fast.count())
+	assert.Equal(t, 0, slow.count())
+
+	// Once cancelled, everything still queued is flushed before the collectors are stopped.
+	cancel()
+	close(slow.gate)
+	<-done
+	assert.Equal(t, 3, fast.count())
+	assert.Equal(t, 3, slow.count())
+}
@@ -21,7 +21,10 @@
 package stats
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -166,6 +169,27 @@ type Sample struct {
 	Time   time.Time
 	Tags   map[string]string
 	Value  float64
+
+	// Trace ID of the request the sample was measured for, if it's an exemplar (see
+	// lib.Tracing's Exemplars). Unlike a tag, it doesn't add to series cardinality.
+	TraceID string
+}
+
+// Returns the value rounded to the nearest integer; outputs that keep value types consistent
+// report counters like this.
+func (s Sample) IntValue() int64 {
+	return int64(math.Floor(s.Value + 0.5))
+}
+
+// A float64 that always marshals to JSON as a float, ie. 5.0 rather than 5.
+type Float float64
+
+func (f Float) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(float64(f))
+	if err == nil && !bytes.ContainsAny(data, ".eE") {
+		data = append(data, '.', '0')
+	}
+	return data, err
 }
 
 // A Metric defines the shape of a set of data.
@@ -229,6 +253,35 @@ func (m *Metric) HumanizeValue(v float64) string {
 	}
 }
 
+// Like HumanizeValue, but rounds to the given number of decimal places in the unit the value is
+// shown in, rather than to a precision that depends on the value.
+func (m *Metric) HumanizeValuePrecision(v float64, decimals int) string {
+	switch m.Type {
+	case Rate:
+		return strconv.FormatFloat(v*100, 'f', decimals, 64) + "%"
+	default:
+		switch m.Contains {
+		case Time:
+			d := ToD(v)
+			unit, suffix := time.Nanosecond, "ns"
+			switch abs := math.Abs(float64(d)); {
+			case abs >= float64(time.Second):
+				unit, suffix = time.Second, "s"
+			case abs >= float64(time.Millisecond):
+				unit, suffix = time.Millisecond, "ms"
+			case abs >= float64(time.Microsecond):
+				unit, suffix = time.Microsecond, "µs"
+			}
+			return strconv.FormatFloat(float64(d)/float64(unit), 'f', decimals, 64) + suffix
+		case Data:
+			value, prefix := humanize.ComputeSI(v)
+			return strconv.FormatFloat(value, 'f', decimals, 64) + " " + prefix + "B"
+		default:
+			return strconv.FormatFloat(v, 'f', decimals, 64)
+		}
+	}
+}
+
 // A Submetric represents a filtered dataset based on a parent metric.
 type Submetric struct {
 	Name   string            `json:"name"`
@@ -21,6 +21,7 @@
 package stats
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 
@@ -127,6 +128,59 @@ func TestMetricHumanizeValue(t *testing.T) {
 	}
 }
 
+func TestMetricHumanizeValuePrecision(t *testing.T) {
+	data := map[*Metric]map[float64][]string{
+		{Type: Trend, Contains: Default}: {
+			1.0:     {"1", "1.000"},
+			1.54321: {"2", "1.543"},
+		},
+		{Type: Trend, Contains: Time}: {
+			D(123):          {"123ns", "123.000ns"},
+			D(12345):        {"12µs", "12.345µs"},
+			D(12345678):     {"12ms", "12.346ms"},
+			D(123456789012): {"123s", "123.457s"},
+		},
+		{Type: Trend, Contains: Data}: {
+			512:     {"512 B", "512.000 B"},
+			1234567: {"1 MB", "1.235 MB"},
+		},
+		{Type: Rate, Contains: Default}: {
+			1.0 / 3.0: {"33%", "33.333%"},
+		},
+	}
+
+	for m, values := range data {
+		t.Run(fmt.Sprintf("type=%s,contains=%s", m.Type.String(), m.Contains.String()), func(t *testing.T) {
+			for v, s := range values {
+				t.Run(fmt.Sprintf("v=%f", v), func(t *testing.T) {
+					assert.Equal(t, s[0], m.HumanizeValuePrecision(v, 0))
+					assert.Equal(t, s[1], m.HumanizeValuePrecision(v, 3))
+				})
+			}
+		})
+	}
+}
+
+func TestSampleIntValue(t *testing.T) {
+	testdata := map[float64]int64{0: 0, 5: 5, 5.4: 5, 5.5: 6, -2.6: -3}
+	for value, expected := range testdata {
+		t.Run(fmt.Sprint(value), func(t *testing.T) {
+			assert.Equal(t, expected, Sample{Value: value}.IntValue())
+		})
+	}
+}
+
+func TestFloatMarshalJSON(t *testing.T) {
+	testdata := map[float64]string{0: "0.0", 5: "5.0", 5.25: "5.25", -3: "-3.0", 1e21: "1e+21"}
+	for value, expected := range testdata {
+		t.Run(fmt.Sprint(value), func(t *testing.T) {
+			data, err := json.Marshal(Float(value))
+			assert.NoError(t, err)
+			assert.Equal(t, expected, string(data))
+		})
+	}
+}
+
 func TestNew(t *testing.T) {
 	testdata := map[string]struct {
 		Type     MetricType
@@ -22,6 +22,9 @@ package stats
 
 import (
 	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dop251/goja"
@@ -81,9 +84,97 @@ func (t *Threshold) Run() (bool, error) {
 	return b, err
 }
 
+var thresholdSourceRegexp = regexp.MustCompile(
+	`^\s*([a-zA-Z_][a-zA-Z0-9_]*|p\(\s*[0-9.]+\s*\))\s*(===|!==|==|!=|<=|>=|<|>)\s*(-?[0-9.]+(?:[eE][-+]?[0-9]+)?)\s*$`,
+)
+
+// A threshold source of the simple "<aggregation> <operator> <value>" form, eg. "p(95)<500".
+type ThresholdCondition struct {
+	Aggregation string
+	Operator    string
+	Value       float64
+}
+
+// Breaks down a threshold source of the "<aggregation> <operator> <value>" form. Returns false
+// for any other expression, which is still valid, but has to be treated as opaque JS.
+func ParseThresholdCondition(src string) (ThresholdCondition, bool) {
+	m := thresholdSourceRegexp.FindStringSubmatch(src)
+	if m == nil {
+		return ThresholdCondition{}, false
+	}
+	value, err := strconv.ParseFloat(m[3], 64)
+	if err != nil {
+		return ThresholdCondition{}, false
+	}
+	agg := strings.Join(strings.Fields(m[1]), "")
+	return ThresholdCondition{Aggregation: agg, Operator: m[2], Value: value}, true
+}
+
+// A snapshot of a sink's formatted values at a point in time.
+type thresholdSnapshot struct {
+	t      time.Duration
+	values map[string]float64
+}
+
+// Windowed history of a sink's values, used to evaluate rate-of-change thresholds.
+type thresholdHistory struct {
+	snapshots []thresholdSnapshot
+
+	// The largest window asked for so far; older snapshots are discarded.
+	window time.Duration
+}
+
+func (h *thresholdHistory) record(t time.Duration, values map[string]float64) {
+	snap := thresholdSnapshot{t, make(map[string]float64, len(values))}
+	for k, v := range values {
+		snap.values[k] = v
+	}
+	h.snapshots = append(h.snapshots, snap)
+
+	// Keep the newest snapshot that's at least a full window old, and everything after it.
+	start := 0
+	for i, snap := range h.snapshots {
+		if snap.t > t-h.window {
+			break
+		}
+		start = i
+	}
+	h.snapshots = h.snapshots[start:]
+}
+
+// Returns how much a value has changed over the given window, eg. delta("rate", "1m").
+// If less than a full window has elapsed, the change since the first snapshot is returned.
+func (h *thresholdHistory) delta(name, window string) (float64, error) {
+	d, err := time.ParseDuration(window)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, errors.Errorf("window must be positive: %s", window)
+	}
+	if d > h.window {
+		h.window = d
+	}
+	if len(h.snapshots) == 0 {
+		return 0, nil
+	}
+
+	last := h.snapshots[len(h.snapshots)-1]
+	first := h.snapshots[0]
+	for _, snap := range h.snapshots {
+		if snap.t > last.t-d {
+			break
+		}
+		first = snap
+	}
+	return last.values[name] - first.values[name], nil
+}
+
 type Thresholds struct {
 	Runtime    *goja.Runtime
 	Thresholds []*Threshold
+
+	history *thresholdHistory
 }
 
 func NewThresholds(sources []string) (Thresholds, error) {
@@ -91,6 +182,8 @@ func NewThresholds(sources []string) (Thresholds, error) {
 	if _, err := rt.RunProgram(jsEnv); err != nil {
 		return Thresholds{}, errors.Wrap(err, "builtin")
 	}
+	history := &thresholdHistory{}
+	rt.Set("delta", history.delta)
 
 	ts := make([]*Threshold, len(sources))
 	for i, src := range sources {
@@ -100,7 +193,7 @@ func NewThresholds(sources []string) (Thresholds, error) {
 		}
 		ts[i] = t
 	}
-	return Thresholds{rt, ts}, nil
+	return Thresholds{rt, ts, history}, nil
 }
 
 func (ts *Thresholds) UpdateVM(sink Sink, t time.Duration) error {
@@ -109,6 +202,9 @@ func (ts *Thresholds) UpdateVM(sink Sink, t time.Duration) error {
 	for k, v := range f {
 		ts.Runtime.Set(k, v)
 	}
+	if ts.history != nil {
+		ts.history.record(t, f)
+	}
 	return nil
 }
 
@@ -133,6 +229,15 @@ func (ts *Thresholds) Run(sink Sink, t time.Duration) (bool, error) {
 	return ts.RunAll()
 }
 
+// Evaluates an aggregation (eg. "p(99)", "rate") against the sink last passed to UpdateVM.
+func (ts *Thresholds) Value(aggregation string) (float64, error) {
+	v, err := ts.Runtime.RunString(aggregation)
+	if err != nil {
+		return 0, err
+	}
+	return v.ToFloat(), nil
+}
+
 func (ts *Thresholds) UnmarshalJSON(data []byte) error {
 	var sources []string
 	if err := json.Unmarshal(data, &sources); err != nil {
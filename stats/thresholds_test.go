@@ -23,6 +23,7 @@ package stats
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/dop251/goja"
 	"github.com/stretchr/testify/assert"
@@ -165,6 +166,83 @@ func TestThresholdsRun(t *testing.T) {
 	})
 }
 
+func TestThresholdsValue(t *testing.T) {
+	ts, err := NewThresholds([]string{"p(99)<100"})
+	assert.NoError(t, err)
+
+	sink := &TrendSink{}
+	for i := 1; i <= 100; i++ {
+		sink.Add(Sample{Value: float64(i)})
+	}
+	sink.Calc()
+	assert.NoError(t, ts.UpdateVM(sink, 0))
+
+	v, err := ts.Value("p(99)")
+	assert.NoError(t, err)
+	assert.Equal(t, sink.P(0.99), v)
+	v, err = ts.Value("min")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, v)
+
+	_, err = ts.Value("nonexistent")
+	assert.Error(t, err)
+}
+
+func TestThresholdsRunDelta(t *testing.T) {
+	ts, err := NewThresholds([]string{`delta("rate", "1m") < 0.05`})
+	assert.NoError(t, err)
+
+	testdata := []struct {
+		t    time.Duration
+		rate float64
+		pass bool
+	}{
+		{0, 0.01, true},
+		{30 * time.Second, 0.05, true},
+		{60 * time.Second, 0.07, false},
+		{90 * time.Second, 0.07, true},
+		{120 * time.Second, 0.10, true},
+		{150 * time.Second, 0.15, false},
+	}
+	for _, data := range testdata {
+		b, err := ts.Run(DummySink{"rate": data.rate}, data.t)
+		assert.NoError(t, err)
+		assert.Equal(t, data.pass, b, "t=%s", data.t)
+	}
+	assert.Len(t, ts.history.snapshots, 3)
+
+	t.Run("invalid window", func(t *testing.T) {
+		ts, err := NewThresholds([]string{`delta("rate", "abc") < 0.05`})
+		assert.NoError(t, err)
+		_, err = ts.Run(DummySink{"rate": 0}, 0)
+		assert.Error(t, err)
+	})
+}
+
+func TestParseThresholdCondition(t *testing.T) {
+	testdata := map[string]*ThresholdCondition{
+		"p(95)<500":                 {"p(95)", "<", 500},
+		" p( 99.9 ) >= 1":           {"p(99.9)", ">=", 1},
+		"avg<100.5":                 {"avg", "<", 100.5},
+		"rate===0":                  {"rate", "===", 0},
+		"count != -1e3":             {"count", "!=", -1000},
+		"1+1==2":                    nil,
+		"avg<max":                   nil,
+		"delta('rate', '1m') < 0.1": nil,
+	}
+	for src, expected := range testdata {
+		t.Run(src, func(t *testing.T) {
+			cond, ok := ParseThresholdCondition(src)
+			if expected == nil {
+				assert.False(t, ok)
+				return
+			}
+			assert.True(t, ok)
+			assert.Equal(t, *expected, cond)
+		})
+	}
+}
+
 func TestThresholdsJSON(t *testing.T) {
 	testdata := map[string][]string{
 		`[]`:                  {},
@@ -32,6 +32,7 @@ import (
 	"github.com/loadimpact/k6/lib"
 	"github.com/loadimpact/k6/stats"
 	"golang.org/x/text/unicode/norm"
+	"gopkg.in/guregu/null.v3"
 )
 
 const (
@@ -252,6 +253,12 @@ func IndentForMetric(m *stats.Metric) string {
 }
 
 func SummarizeMetrics(w io.Writer, indent string, t time.Duration, metrics map[string]*stats.Metric) {
+	summarizeMetrics(w, indent, t, metrics, null.Int{})
+}
+
+// Summarizes metrics like SummarizeMetrics, with trend stats rounded to trendPrecision decimal
+// places if it's set; see lib.Options.SummaryTrendPrecision.
+func summarizeMetrics(w io.Writer, indent string, t time.Duration, metrics map[string]*stats.Metric, trendPrecision null.Int) {
 	names := []string{}
 	nameLenMax := 0
 
@@ -277,6 +284,9 @@ func SummarizeMetrics(w io.Writer, indent string, t time.Duration, metrics map[s
 			cols := make([]string, len(TrendColumns))
 			for i, col := range TrendColumns {
 				value := m.HumanizeValue(col.Get(sink))
+				if trendPrecision.Valid {
+					value = m.HumanizeValuePrecision(col.Get(sink), int(trendPrecision.Int64))
+				}
 				if l := StrWidth(value); l > trendColMaxLens[i] {
 					trendColMaxLens[i] = l
 				}
@@ -349,10 +359,112 @@ func SummarizeMetrics(w io.Writer, indent string, t time.Duration, metrics map[s
 	}
 }
 
+// Prints a table of request throughput and latency in each stage, from the submetrics the engine
+// keeps for SummaryPerStage. Rates are over the part of each stage that actually ran.
+func SummarizeStages(w io.Writer, indent string, t time.Duration, metrics map[string]*stats.Metric, stages []lib.Stage) {
+	rows := [][]string{{"stage", "target", "duration", "reqs", "rate", "avg", "p(95)"}}
+	var start time.Duration
+	for i, stage := range stages {
+		end := t
+		if stage.Duration.Valid && start+time.Duration(stage.Duration.Duration) < t {
+			end = start + time.Duration(stage.Duration.Duration)
+		}
+		row := []string{strconv.Itoa(i), "-", "-", "0", "-", "-", "-"}
+		if stage.Target.Valid {
+			row[1] = strconv.FormatInt(stage.Target.Int64, 10)
+		}
+		if end > start {
+			row[2] = (end - start).String()
+		}
+		if m := metrics[lib.StageSubmetricName("http_reqs", i)]; m != nil {
+			if sink, ok := m.Sink.(*stats.CounterSink); ok {
+				row[3] = m.HumanizeValue(sink.Value)
+				if end > start {
+					row[4] = fmt.Sprintf("%.2f/s", sink.Value/(end-start).Seconds())
+				}
+			}
+		}
+		if m := metrics[lib.StageSubmetricName("http_req_duration", i)]; m != nil {
+			if sink, ok := m.Sink.(*stats.TrendSink); ok && sink.Count > 0 {
+				sink.Calc()
+				row[5] = m.HumanizeValue(sink.Avg)
+				row[6] = m.HumanizeValue(sink.P(0.95))
+			}
+		}
+		rows = append(rows, row)
+		if stage.Duration.Valid {
+			start += time.Duration(stage.Duration.Duration)
+		}
+	}
+
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, col := range row {
+			if l := StrWidth(col); l > widths[i] {
+				widths[i] = l
+			}
+		}
+	}
+	for r, row := range rows {
+		cols := make([]string, len(row))
+		for i, col := range row {
+			pad := strings.Repeat(" ", widths[i]-StrWidth(col))
+			if r == 0 {
+				cols[i] = GrayColor.Sprint(col) + pad
+			} else {
+				cols[i] = ValueColor.Sprint(col) + pad
+			}
+		}
+		fmt.Fprint(w, indent+strings.TrimRight(strings.Join(cols, "  "), " ")+"\n")
+	}
+}
+
+// Returns whether a metric is one of the submetrics only kept for the per-stage summary.
+func isStageSubmetric(m *stats.Metric, opts lib.Options) bool {
+	if _, ok := opts.Thresholds[m.Name]; ok || m.Sub.Parent == "" || len(m.Sub.Tags) != 1 {
+		return false
+	}
+	_, ok := m.Sub.Tags["stage"]
+	return ok
+}
+
+// Returns whether a metric, or the metric it's a submetric of, is listed in SummaryMetrics.
+func isSummaryMetric(m *stats.Metric, opts lib.Options) bool {
+	name := m.Name
+	if m.Sub.Parent != "" {
+		name = m.Sub.Parent
+	}
+	for _, summaryName := range opts.SummaryMetrics {
+		if summaryName == name {
+			return true
+		}
+	}
+	return false
+}
+
 // Summarizes a dataset and returns whether the test run was considered a success.
 func Summarize(w io.Writer, indent string, data SummaryData) {
 	if data.Root != nil {
 		SummarizeGroup(w, indent+"    ", data.Root)
 	}
-	SummarizeMetrics(w, indent+"  ", data.Time, data.Metrics)
+
+	metrics := data.Metrics
+	if data.Opts.SummaryPerStage.Bool || data.Opts.SummaryMetrics != nil {
+		metrics = make(map[string]*stats.Metric, len(data.Metrics))
+		for name, m := range data.Metrics {
+			if data.Opts.SummaryPerStage.Bool && isStageSubmetric(m, data.Opts) {
+				continue
+			}
+			if data.Opts.SummaryMetrics != nil && !isSummaryMetric(m, data.Opts) {
+				continue
+			}
+			metrics[name] = m
+		}
+	}
+	summarizeMetrics(w, indent+"  ", data.Time, metrics, data.Opts.SummaryTrendPrecision)
+
+	if data.Opts.SummaryPerStage.Bool && len(data.Opts.Stages) > 0 {
+		fmt.Fprint(w, "\n")
+		SummarizeStages(w, indent+"    ", data.Time, data.Metrics, data.Opts.Stages)
+	}
 }
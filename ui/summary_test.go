@@ -21,10 +21,15 @@
 package ui
 
 import (
+	"bytes"
 	"testing"
+	"time"
 
+	"github.com/fatih/color"
+	"github.com/loadimpact/k6/lib"
 	"github.com/loadimpact/k6/stats"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
 )
 
 var verifyTests = []struct {
@@ -158,3 +163,76 @@ func TestGeneratePercentileTrendColumn(t *testing.T) {
 		assert.Exactly(t, err, ErrPercentileStatInvalidValue)
 	})
 }
+
+func TestSummarizeStages(t *testing.T) {
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = true
+
+	stages := []lib.Stage{
+		{Duration: lib.NullDurationFrom(10 * time.Second), Target: null.IntFrom(10)},
+		{Duration: lib.NullDurationFrom(10 * time.Second), Target: null.IntFrom(20)},
+		{Duration: lib.NullDurationFrom(10 * time.Second), Target: null.IntFrom(0)},
+	}
+	reqs := stats.New("http_reqs{stage:0}", stats.Counter)
+	reqs.Sink.Add(stats.Sample{Value: 100})
+	duration := stats.New("http_req_duration{stage:0}", stats.Trend, stats.Time)
+	duration.Sink.Add(stats.Sample{Value: 10})
+	duration.Sink.Add(stats.Sample{Value: 30})
+	reqs1 := stats.New("http_reqs{stage:1}", stats.Counter)
+	reqs1.Sink.Add(stats.Sample{Value: 50})
+	metrics := map[string]*stats.Metric{reqs.Name: reqs, duration.Name: duration, reqs1.Name: reqs1}
+
+	var buf bytes.Buffer
+	SummarizeStages(&buf, "", 15*time.Second, metrics, stages)
+	assert.Equal(t, ""+
+		"stage  target  duration  reqs  rate     avg   p(95)\n"+
+		"0      10      10s       100   10.00/s  20ms  30ms\n"+
+		"1      20      5s        50    10.00/s  -     -\n"+
+		"2      0       -         0     -        -     -\n",
+		buf.String())
+}
+
+func TestSummarizeSummaryMetrics(t *testing.T) {
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = true
+
+	duration := stats.New("http_req_duration", stats.Trend, stats.Time)
+	duration.Sink.Add(stats.Sample{Value: 10})
+	okDuration := stats.New("http_req_duration{status:200}", stats.Trend, stats.Time)
+	okDuration.Sub = stats.Submetric{Name: okDuration.Name, Parent: duration.Name, Suffix: "status:200"}
+	okDuration.Sink.Add(stats.Sample{Value: 10})
+	reqs := stats.New("http_reqs", stats.Counter)
+	reqs.Sink.Add(stats.Sample{Value: 1})
+	metrics := map[string]*stats.Metric{duration.Name: duration, okDuration.Name: okDuration, reqs.Name: reqs}
+
+	var buf bytes.Buffer
+	Summarize(&buf, "", SummaryData{
+		Opts:    lib.Options{SummaryMetrics: []string{"http_req_duration"}},
+		Metrics: metrics,
+		Time:    time.Second,
+	})
+	assert.Contains(t, buf.String(), "http_req_duration")
+	assert.Contains(t, buf.String(), "{ status:200 }")
+	assert.NotContains(t, buf.String(), "http_reqs")
+}
+
+func TestSummarizeTrendPrecision(t *testing.T) {
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = true
+
+	duration := stats.New("http_req_duration", stats.Trend, stats.Time)
+	duration.Sink.Add(stats.Sample{Value: 12.345})
+	metrics := map[string]*stats.Metric{duration.Name: duration}
+
+	var buf bytes.Buffer
+	Summarize(&buf, "", SummaryData{
+		Opts:    lib.Options{SummaryTrendPrecision: null.IntFrom(3)},
+		Metrics: metrics,
+		Time:    time.Second,
+	})
+	assert.Contains(t, buf.String(), "=12.345ms")
+
+	buf.Reset()
+	SummarizeMetrics(&buf, "", time.Second, metrics)
+	assert.NotContains(t, buf.String(), "12.345ms")
+}